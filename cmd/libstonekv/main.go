@@ -0,0 +1,180 @@
+// Command libstonekv builds StoneKV as a C shared library, so Python, Ruby
+// and C applications can embed databases directly:
+//
+//	go build -buildmode=c-shared -o libstonekv.so ./cmd/libstonekv
+//
+// which also writes libstonekv.h, declaring:
+//
+//	int stone_open(char* path, uintptr_t* db, char** errOut);
+//	int stone_get(uintptr_t db, void* key, size_t keyLen, void** value, size_t* valueLen, char** errOut);
+//	int stone_set(uintptr_t db, void* key, size_t keyLen, void* value, size_t valueLen, char** errOut);
+//	int stone_delete(uintptr_t db, void* key, size_t keyLen, char** errOut);
+//	int stone_close(uintptr_t db, char** errOut);
+//	void stone_free(void* p);
+//
+// Functions return STONE_OK, STONE_NOT_FOUND for a stone_get of a missing
+// key, or STONE_ERROR, in which case *errOut, if errOut isn't NULL, is set
+// to the message. Databases are referred to by the handle stone_open sets,
+// valid until stone_close; other handles fail with STONE_ERROR. Values
+// returned by stone_get and error messages are allocated with malloc, and
+// must be freed with stone_free. Keys and values passed in are copied, so
+// they may be freed once the call returns, and may not be 2 GiB or longer.
+package main
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+
+#define STONE_OK 0
+#define STONE_NOT_FOUND 1
+#define STONE_ERROR -1
+*/
+import "C"
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"unsafe"
+
+	"github.com/cryptrunner49/stonekv/stone"
+)
+
+func main() {}
+
+var (
+	errInvalidHandle = errors.New("invalid or closed database handle")
+	errTooLong       = errors.New("key or value too long")
+)
+
+// stores holds the open stores by handle. Handles aren't reused, so a
+// stale one fails rather than reaching another store.
+var (
+	storesMu   sync.Mutex
+	stores     = map[C.uintptr_t]*stone.Store{}
+	nextHandle C.uintptr_t
+)
+
+// fail sets *errOut to the message of err, if errOut isn't nil, and
+// returns STONE_ERROR.
+func fail(err error, errOut **C.char) C.int {
+	if errOut != nil {
+		*errOut = C.CString(err.Error())
+	}
+	return C.STONE_ERROR
+}
+
+// store returns the store of a handle set by stone_open.
+func store(db C.uintptr_t) (*stone.Store, error) {
+	storesMu.Lock()
+	defer storesMu.Unlock()
+	s, ok := stores[db]
+	if !ok {
+		return nil, errInvalidHandle
+	}
+	return s, nil
+}
+
+// goBytes copies n bytes at p, which C.GoBytes can only take up to
+// math.MaxInt32 of.
+func goBytes(p unsafe.Pointer, n C.size_t) ([]byte, error) {
+	if n > math.MaxInt32 {
+		return nil, errTooLong
+	}
+	return C.GoBytes(p, C.int(n)), nil
+}
+
+//export stone_open
+func stone_open(path *C.char, db *C.uintptr_t, errOut **C.char) C.int {
+	s, err := stone.NewStore(C.GoString(path))
+	if err != nil {
+		return fail(err, errOut)
+	}
+	storesMu.Lock()
+	nextHandle++
+	stores[nextHandle] = s
+	*db = nextHandle
+	storesMu.Unlock()
+	return C.STONE_OK
+}
+
+//export stone_get
+func stone_get(db C.uintptr_t, key unsafe.Pointer, keyLen C.size_t, value *unsafe.Pointer, valueLen *C.size_t, errOut **C.char) C.int {
+	s, err := store(db)
+	if err != nil {
+		return fail(err, errOut)
+	}
+	k, err := goBytes(key, keyLen)
+	if err != nil {
+		return fail(err, errOut)
+	}
+	v, err := s.Get(k)
+	if err != nil {
+		if errors.Is(err, stone.ErrKeyNotFound) {
+			return C.STONE_NOT_FOUND
+		}
+		return fail(err, errOut)
+	}
+	*value = C.CBytes(v)
+	*valueLen = C.size_t(len(v))
+	return C.STONE_OK
+}
+
+//export stone_set
+func stone_set(db C.uintptr_t, key unsafe.Pointer, keyLen C.size_t, value unsafe.Pointer, valueLen C.size_t, errOut **C.char) C.int {
+	s, err := store(db)
+	if err != nil {
+		return fail(err, errOut)
+	}
+	k, err := goBytes(key, keyLen)
+	if err != nil {
+		return fail(err, errOut)
+	}
+	v, err := goBytes(value, valueLen)
+	if err != nil {
+		return fail(err, errOut)
+	}
+	err = s.Set(k, v)
+	if err != nil {
+		return fail(err, errOut)
+	}
+	return C.STONE_OK
+}
+
+//export stone_delete
+func stone_delete(db C.uintptr_t, key unsafe.Pointer, keyLen C.size_t, errOut **C.char) C.int {
+	s, err := store(db)
+	if err != nil {
+		return fail(err, errOut)
+	}
+	k, err := goBytes(key, keyLen)
+	if err != nil {
+		return fail(err, errOut)
+	}
+	err = s.Delete(k)
+	if err != nil {
+		return fail(err, errOut)
+	}
+	return C.STONE_OK
+}
+
+//export stone_close
+func stone_close(db C.uintptr_t, errOut **C.char) C.int {
+	storesMu.Lock()
+	s, ok := stores[db]
+	delete(stores, db)
+	storesMu.Unlock()
+	if !ok {
+		return fail(errInvalidHandle, errOut)
+	}
+	err := s.Close()
+	if err != nil {
+		return fail(err, errOut)
+	}
+	return C.STONE_OK
+}
+
+//export stone_free
+func stone_free(p unsafe.Pointer) {
+	C.free(p)
+}
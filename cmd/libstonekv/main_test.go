@@ -0,0 +1,31 @@
+package main
+
+import (
+	"go/build"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildSharedLibrary(t *testing.T) {
+	if !build.Default.CgoEnabled {
+		t.Skip("cgo is disabled")
+	}
+	dir := t.TempDir()
+	out, err := exec.Command("go", "build", "-buildmode=c-shared",
+		"-o", filepath.Join(dir, "libstonekv.so"), ".").CombinedOutput()
+	if err != nil {
+		t.Fatalf("build failed: %v\n%s", err, out)
+	}
+	header, err := os.ReadFile(filepath.Join(dir, "libstonekv.h"))
+	if err != nil {
+		t.Fatalf("expected a header: %v", err)
+	}
+	for _, name := range []string{"stone_open", "stone_get", "stone_set", "stone_delete", "stone_close", "stone_free"} {
+		if !strings.Contains(string(header), name) {
+			t.Errorf("expected the header to declare %s", name)
+		}
+	}
+}
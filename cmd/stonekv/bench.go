@@ -0,0 +1,159 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cryptrunner49/stonekv/stone"
+)
+
+func benchFlags(flags *flag.FlagSet) {
+	flags.Int("keys", 100000, "number of keys to load and operate on")
+	flags.Int("value-size", 100, "size of values in bytes")
+	flags.Int("ops", 1000000, "number of operations of the mixed workload")
+	flags.Float64("reads", 0.9, "share of reads in the mixed workload, from 0 to 1")
+	flags.Int("workers", 8, "goroutines running the mixed workload")
+	flags.Bool("sync", false, "sync every write to disk (Options.SyncWrites)")
+	flags.Bool("mmap", false, "read values through a memory mapping (Options.MMap)")
+	flags.Bool("compact", true, "compact the database after the workload")
+}
+
+// runBench loads keys into a fresh database, runs a mixed workload of
+// random reads and writes against it and compacts it, timing each phase.
+func runBench(env *env, flags *flag.FlagSet) error {
+	keys := flagValue(flags, "keys").(int)
+	valueSize := flagValue(flags, "value-size").(int)
+	ops := flagValue(flags, "ops").(int)
+	reads := flagValue(flags, "reads").(float64)
+	workers := flagValue(flags, "workers").(int)
+	switch {
+	case keys <= 0:
+		return fmt.Errorf("-keys must be positive")
+	case valueSize < 0:
+		return fmt.Errorf("-value-size must not be negative")
+	case ops < 0:
+		return fmt.Errorf("-ops must not be negative")
+	case reads < 0 || reads > 1:
+		return fmt.Errorf("-reads must be between 0 and 1")
+	case workers <= 0:
+		return fmt.Errorf("-workers must be positive")
+	}
+
+	path := flags.Arg(0)
+	if path == "" {
+		dir, err := os.MkdirTemp("", "stonekv-bench-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		path = filepath.Join(dir, "bench.db")
+	} else if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists; bench writes to its database, so give it a new path", path)
+	}
+	store, err := stone.NewStoreWithOptions(path, stone.Options{
+		SyncWrites: flagValue(flags, "sync").(bool),
+		MMap:       flagValue(flags, "mmap").(bool),
+	})
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	value := make([]byte, valueSize)
+	for i := range value {
+		value[i] = byte('a' + i%26)
+	}
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("key%010d", i))
+	}
+
+	start := time.Now()
+	for i := 0; i < keys; i++ {
+		err = store.Set(key(i), value)
+		if err != nil {
+			return err
+		}
+	}
+	elapsed := time.Since(start)
+	fmt.Fprintf(env.stdout, "load:     %d sets in %v (%.0f ops/s)\n", keys, elapsed.Round(time.Millisecond), float64(keys)/elapsed.Seconds())
+
+	// Workers take operations from a shared counter and time each one
+	var next atomic.Int64
+	var mu sync.Mutex
+	var getTimes, setTimes []time.Duration
+	var firstErr error
+	var wg sync.WaitGroup
+	start = time.Now()
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			r := rand.New(rand.NewPCG(uint64(w), uint64(start.UnixNano())))
+			var gets, sets []time.Duration
+			var err error
+			for next.Add(1) <= int64(ops) {
+				k := key(r.IntN(keys))
+				opStart := time.Now()
+				if r.Float64() < reads {
+					_, err = store.Get(k)
+					gets = append(gets, time.Since(opStart))
+				} else {
+					err = store.Set(k, value)
+					sets = append(sets, time.Since(opStart))
+				}
+				if err != nil {
+					break
+				}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			getTimes = append(getTimes, gets...)
+			setTimes = append(setTimes, sets...)
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}(w)
+	}
+	wg.Wait()
+	elapsed = time.Since(start)
+	if firstErr != nil {
+		return firstErr
+	}
+	fmt.Fprintf(env.stdout, "mixed:    %d ops in %v (%.0f ops/s), %.0f%% reads, %d workers\n",
+		ops, elapsed.Round(time.Millisecond), float64(ops)/elapsed.Seconds(), 100*reads, workers)
+	printLatencies(env.stdout, "get", getTimes)
+	printLatencies(env.stdout, "set", setTimes)
+
+	if flagValue(flags, "compact").(bool) {
+		before := store.Stats().FileSize
+		start = time.Now()
+		err = store.Polish()
+		if err != nil {
+			return err
+		}
+		elapsed = time.Since(start)
+		fmt.Fprintf(env.stdout, "compact:  %d -> %d bytes in %v\n", before, store.Stats().FileSize, elapsed.Round(time.Millisecond))
+	}
+	return store.Close()
+}
+
+// printLatencies prints percentiles of the durations of an operation.
+func printLatencies(w io.Writer, op string, times []time.Duration) {
+	if len(times) == 0 {
+		return
+	}
+	slices.Sort(times)
+	percentile := func(p float64) time.Duration {
+		return times[int(p*float64(len(times)-1))]
+	}
+	fmt.Fprintf(w, "  %s:    %d ops, p50 %v, p99 %v, p99.9 %v, max %v\n",
+		op, len(times), percentile(0.5), percentile(0.99), percentile(0.999), times[len(times)-1])
+}
@@ -0,0 +1,622 @@
+// Command stonekv inspects and maintains StoneKV databases from the shell.
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cryptrunner49/stonekv/stone"
+	"github.com/cryptrunner49/stonekv/stoneserver"
+)
+
+const usage = `usage: stonekv <command> [flags] <args>
+
+Commands:
+  get <db> <key>                 Print the value of key
+  set <db> <key> [value]         Set key to value, read from stdin if omitted
+  del <db> <key>                 Delete key
+  scan [-keys] <db> [prefix]     Print the key/value pairs starting with prefix
+  stats <db>                     Print record and key counts
+  fsck <db>                      Check every record, reporting whether repair is needed
+  dump [-json] <db>              Print every record of the log, or of a WAL segment
+  compact <db>                   Polish the database, dropping stale records
+  backup [flags] <db> <backup>   Back up the database
+  restore [flags] <backup> <db>  Recreate a database from a backup
+  serve [flags] <db>             Serve the database over HTTP, Redis or memcached
+  bench [flags] [db]             Measure load, read/write and compaction speed
+  help                           Show this message
+
+Run 'stonekv <command> -h' for the flags of a command.
+`
+
+// command is a subcommand of stonekv.
+type command struct {
+	args   string // Positional arguments, for the usage of the command
+	minArg int
+	maxArg int
+	run    func(env *env, flags *flag.FlagSet) error
+	flags  func(flags *flag.FlagSet) // Defines the command's flags, if any
+}
+
+// env is what a running command reads from and writes to.
+type env struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+var commands = map[string]*command{
+	"get":     {args: "<db> <key>", minArg: 2, maxArg: 2, run: runGet},
+	"set":     {args: "<db> <key> [value]", minArg: 2, maxArg: 3, run: runSet},
+	"del":     {args: "<db> <key>", minArg: 2, maxArg: 2, run: runDel},
+	"scan":    {args: "[-keys] <db> [prefix]", minArg: 1, maxArg: 2, run: runScan, flags: scanFlags},
+	"stats":   {args: "<db>", minArg: 1, maxArg: 1, run: runStats},
+	"fsck":    {args: "<db>", minArg: 1, maxArg: 1, run: runFsck},
+	"dump":    {args: "[-json] <db>", minArg: 1, maxArg: 1, run: runDump, flags: dumpFlags},
+	"compact": {args: "<db>", minArg: 1, maxArg: 1, run: runCompact},
+	"backup":  {args: "[flags] <db> <backup>", minArg: 2, maxArg: 2, run: runBackup, flags: backupFlags},
+	"restore": {args: "[flags] <backup> <db>", minArg: 2, maxArg: 2, run: runRestore, flags: restoreFlags},
+	"serve":   {args: "[flags] <db>", minArg: 1, maxArg: 1, run: runServe, flags: serveFlags},
+	"bench":   {args: "[flags] [db]", minArg: 0, maxArg: 1, run: runBench, flags: benchFlags},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], &env{stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr}))
+}
+
+// run executes the command line args and returns the exit status.
+func run(args []string, env *env) int {
+	if len(args) == 0 {
+		fmt.Fprint(env.stderr, usage)
+		return 2
+	}
+	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		fmt.Fprint(env.stdout, usage)
+		return 0
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(env.stderr, "stonekv: unknown command %q\n\n%s", args[0], usage)
+		return 2
+	}
+
+	flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	flags.SetOutput(env.stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(env.stderr, "usage: stonekv %s %s\n", args[0], cmd.args)
+		flags.PrintDefaults()
+	}
+	if cmd.flags != nil {
+		cmd.flags(flags)
+	}
+	err := flags.Parse(args[1:])
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		return 2
+	}
+	if flags.NArg() < cmd.minArg || flags.NArg() > cmd.maxArg {
+		flags.Usage()
+		return 2
+	}
+
+	err = cmd.run(env, flags)
+	if err != nil {
+		fmt.Fprintf(env.stderr, "stonekv %s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+// openStore opens the database at path, which must exist unless create is
+// set, so a mistyped path isn't silently created.
+func openStore(path string, create bool) (*stone.Store, error) {
+	if !create {
+		_, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return stone.NewStore(path)
+}
+
+func runGet(env *env, flags *flag.FlagSet) error {
+	store, err := openStore(flags.Arg(0), false)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	value, err := store.Get([]byte(flags.Arg(1)))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(env.stdout, "%s\n", value)
+	return err
+}
+
+func runSet(env *env, flags *flag.FlagSet) error {
+	var value []byte
+	if flags.NArg() == 3 {
+		value = []byte(flags.Arg(2))
+	} else {
+		var err error
+		value, err = io.ReadAll(env.stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read value: %w", err)
+		}
+	}
+
+	store, err := openStore(flags.Arg(0), true)
+	if err != nil {
+		return err
+	}
+	err = store.Set([]byte(flags.Arg(1)), value)
+	if err != nil {
+		store.Close()
+		return err
+	}
+	return store.Close()
+}
+
+func runDel(env *env, flags *flag.FlagSet) error {
+	store, err := openStore(flags.Arg(0), false)
+	if err != nil {
+		return err
+	}
+	key := []byte(flags.Arg(1))
+	_, err = store.Get(key)
+	if err == nil {
+		err = store.Delete(key)
+	}
+	if err != nil {
+		store.Close()
+		return err
+	}
+	return store.Close()
+}
+
+func scanFlags(flags *flag.FlagSet) {
+	flags.Bool("keys", false, "print keys only")
+}
+
+func runScan(env *env, flags *flag.FlagSet) error {
+	store, err := openStore(flags.Arg(0), false)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	keysOnly := flagValue(flags, "keys").(bool)
+	return store.Scan([]byte(flags.Arg(1)), func(key, value []byte) error {
+		if keysOnly {
+			_, err := fmt.Fprintf(env.stdout, "%s\n", key)
+			return err
+		}
+		_, err := fmt.Fprintf(env.stdout, "%s\t%s\n", key, value)
+		return err
+	})
+}
+
+func runStats(env *env, flags *flag.FlagSet) error {
+	path := flags.Arg(0)
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	// The database is a log like any full backup, so read it the same way
+	report, err := stone.VerifyBackup(path, stone.VerifyOptions{})
+	if err != nil {
+		return err
+	}
+
+	stale := 0.0
+	if entries := report.Sets + report.Deletes; entries > 0 {
+		stale = 100 * float64(entries-report.Keys) / float64(entries)
+	}
+	fmt.Fprintf(env.stdout, "file:     %s\n", path)
+	fmt.Fprintf(env.stdout, "size:     %d bytes\n", info.Size())
+	fmt.Fprintf(env.stdout, "records:  %d (%d sets, %d deletes, %d legacy)\n", report.Records, report.Sets, report.Deletes, report.Legacy)
+	fmt.Fprintf(env.stdout, "keys:     %d\n", report.Keys)
+	fmt.Fprintf(env.stdout, "seq:      %d\n", report.Seq)
+	_, err = fmt.Fprintf(env.stdout, "stale:    %.1f%% of records, reclaimed by compact\n", stale)
+	return err
+}
+
+func runFsck(env *env, flags *flag.FlagSet) error {
+	path := flags.Arg(0)
+	report, err := stone.Check(path)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(env.stdout, "file:     %s\n", path)
+	fmt.Fprintf(env.stdout, "size:     %d bytes\n", report.Size)
+	fmt.Fprintf(env.stdout, "records:  %d (%d sets, %d of them deltas, %d deletes, %d checkpoints, %d legacy)\n",
+		report.Records, report.Sets, report.Deltas, report.Deletes, report.Checkpoints, report.Legacy)
+	fmt.Fprintf(env.stdout, "keys:     %d\n", report.Keys)
+	fmt.Fprintf(env.stdout, "seq:      %d\n", report.Seq)
+	for _, p := range report.Problems {
+		fmt.Fprintf(env.stdout, "bad:      record at offset %d: %s\n", p.Offset, p.Err)
+	}
+	if !report.NeedsRepair() {
+		_, err = fmt.Fprintf(env.stdout, "status:   ok\n")
+		return err
+	}
+	if report.Valid < report.Size {
+		fmt.Fprintf(env.stdout, "status:   readable up to offset %d, %d bytes after it are not\n", report.Valid, report.Size-report.Valid)
+	}
+	return fmt.Errorf("%s needs repair", path)
+}
+
+func dumpFlags(flags *flag.FlagSet) {
+	flags.Bool("json", false, "print a JSON object per record")
+}
+
+// dumpedRecord is a record as printed by dump -json.
+type dumpedRecord struct {
+	Offset   int64      `json:"offset"`
+	Size     int64      `json:"size"`
+	Type     string     `json:"type"`
+	Seq      uint64     `json:"seq"`
+	Time     *time.Time `json:"time,omitempty"`
+	Key      string     `json:"key"`
+	Keys     []string   `json:"keys,omitempty"`
+	ValueLen int        `json:"value_len"`
+	Checksum string     `json:"checksum"`
+}
+
+func runDump(env *env, flags *flag.FlagSet) error {
+	w := bufio.NewWriter(env.stdout)
+	defer w.Flush()
+	enc := json.NewEncoder(w)
+	asJSON := flagValue(flags, "json").(bool)
+	return stone.Dump(flags.Arg(0), func(rec stone.DumpRecord) error {
+		if asJSON {
+			d := dumpedRecord{rec.Offset, rec.Size, rec.Type, rec.Seq, nil, string(rec.Key), nil, rec.ValueLen, rec.Checksum}
+			if !rec.Time.IsZero() {
+				d.Time = &rec.Time
+			}
+			for _, key := range rec.Keys {
+				d.Keys = append(d.Keys, string(key))
+			}
+			return enc.Encode(d)
+		}
+		fmt.Fprintf(w, "%d\t%s\tseq=%d\tkey=%q\tvalue=%d\tsize=%d\tchecksum=%s",
+			rec.Offset, rec.Type, rec.Seq, rec.Key, rec.ValueLen, rec.Size, rec.Checksum)
+		if rec.Keys != nil {
+			fmt.Fprintf(w, "\tkeys=%q", rec.Keys)
+		}
+		if !rec.Time.IsZero() {
+			fmt.Fprintf(w, "\ttime=%s", rec.Time.UTC().Format(time.RFC3339Nano))
+		}
+		_, err := fmt.Fprintln(w)
+		return err
+	})
+}
+
+func runCompact(env *env, flags *flag.FlagSet) error {
+	path := flags.Arg(0)
+	store, err := openStore(path, false)
+	if err != nil {
+		return err
+	}
+	before, err := os.Stat(path)
+	if err == nil {
+		err = store.Polish()
+	}
+	if err != nil {
+		store.Close()
+		return err
+	}
+	err = store.Close()
+	if err != nil {
+		return err
+	}
+
+	after, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(env.stdout, "compacted %s: %d -> %d bytes, previous version kept at %s.backup\n",
+		path, before.Size(), after.Size(), path)
+	return err
+}
+
+func backupFlags(flags *flag.FlagSet) {
+	flags.Bool("polished", false, "include only live key/value pairs")
+	flags.Bool("gzip", false, "compress the backup")
+	flags.String("passphrase-file", "", "encrypt the backup with the passphrase in this file")
+}
+
+func runBackup(env *env, flags *flag.FlagSet) error {
+	opts := stone.BackupOptions{
+		Polished: flagValue(flags, "polished").(bool),
+	}
+	if flagValue(flags, "gzip").(bool) {
+		opts.Compress = stone.CompressGzip
+	}
+	passphrase, err := readPassphrase(flagValue(flags, "passphrase-file").(string))
+	if err != nil {
+		return err
+	}
+	opts.Passphrase = passphrase
+
+	store, err := openStore(flags.Arg(0), false)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	return store.Backup(flags.Arg(1), opts)
+}
+
+func restoreFlags(flags *flag.FlagSet) {
+	flags.String("passphrase-file", "", "decrypt the backup with the passphrase in this file")
+	flags.String("wal", "", "roll the backup forward with the WAL in this directory")
+	flags.String("until", "", "stop rolling forward at this RFC 3339 time")
+}
+
+func runRestore(env *env, flags *flag.FlagSet) error {
+	var opts stone.RestoreOptions
+	passphrase, err := readPassphrase(flagValue(flags, "passphrase-file").(string))
+	if err != nil {
+		return err
+	}
+	opts.Passphrase = passphrase
+	opts.WALDir = flagValue(flags, "wal").(string)
+	if until := flagValue(flags, "until").(string); until != "" {
+		if opts.WALDir == "" {
+			return fmt.Errorf("-until requires -wal")
+		}
+		opts.Until, err = time.Parse(time.RFC3339, until)
+		if err != nil {
+			return fmt.Errorf("invalid -until: %w", err)
+		}
+	}
+	return stone.Restore(flags.Arg(0), flags.Arg(1), opts)
+}
+
+func serveFlags(flags *flag.FlagSet) {
+	flags.String("addr", "localhost:7400", "address to serve HTTP on, unix:<path> for a Unix socket, empty to disable")
+	flags.String("resp", "", "address to serve the Redis protocol on, e.g. localhost:6379")
+	flags.String("memcache", "", "address to serve the memcached protocol on, e.g. localhost:11211")
+	flags.String("tls-cert", "", "PEM `file` with the certificate to serve every protocol over TLS with")
+	flags.String("tls-key", "", "PEM `file` with the private key of the certificate")
+	flags.String("tls-client-ca", "", "PEM `file` with the CAs client certificates must be signed by (mutual TLS)")
+	flags.String("acl", "", "JSON `file` with the users allowed to connect and the key prefixes they may use")
+	flags.String("backup-dir", "", "`directory` the HTTP admin endpoints write backups to, empty to disable them")
+	flags.String("socket-mode", "0660", "file mode of Unix sockets, deciding which local users may connect")
+	flags.Duration("shutdown-timeout", 30*time.Second, "time requests in flight have to finish on SIGINT or SIGTERM")
+	flags.Duration("index-snapshot", 0, "interval to snapshot the index at, and on exit, so restarts only read the log written since; 0 disables")
+	flags.Float64("auto-polish", 0, "polish the store whenever this share of its log is dead, e.g. 0.5; 0 disables")
+	flags.String("polish-windows", "", "comma-separated daily windows of local time -auto-polish may start in, e.g. 02:00-04:00; empty allows any time")
+	flags.Int64("polish-rate", 0, "bytes per second compactions may write at, leaving disk bandwidth to requests; 0 for no limit")
+	flags.Int64("read-rate", 0, "bytes per second the store may read, leaving disk bandwidth to other processes; 0 for no limit")
+	flags.Int64("write-rate", 0, "bytes per second the store may write; 0 for no limit")
+	flags.Int64("cache-size", 0, "run the store as a cache of about this many bytes, evicting the least recently used keys; 0 disables")
+	flags.String("cold-path", "", "`file` to move keys unused between compactions to, e.g. on slower storage; empty disables")
+	flags.Bool("delta-values", false, "store values overwriting similar ones as diffs against them")
+	flags.Float64("qps", 0, "requests per second of all clients together, 0 for no limit")
+	flags.Float64("client-qps", 0, "requests per second of each client, 0 for no limit")
+	flags.Float64("bytes-per-sec", 0, "bytes per second of all clients together, 0 for no limit")
+	flags.Float64("client-bytes-per-sec", 0, "bytes per second of each client, 0 for no limit")
+}
+
+// listener is a protocol stonekv serve can serve a store over.
+type listener struct {
+	flag      string // Flag holding the address, empty to disable
+	name      string
+	newServer func(store *stone.Store, opts serveOptions) server
+}
+
+// server is implemented by every server in stoneserver.
+type server interface {
+	Serve(ln net.Listener) error
+	Shutdown(ctx context.Context) error
+}
+
+// serveOptions are the settings of the servers stonekv serve runs.
+type serveOptions struct {
+	acl       *stoneserver.ACL
+	limits    stoneserver.Limits
+	metrics   *stoneserver.Metrics // Shared by every protocol
+	backupDir string
+}
+
+var listeners = []listener{
+	{"addr", "HTTP", func(store *stone.Store, opts serveOptions) server {
+		srv := stoneserver.New(store)
+		srv.ACL = opts.acl
+		srv.Limits = opts.limits
+		srv.Metrics = opts.metrics
+		srv.BackupDir = opts.backupDir
+		return srv
+	}},
+	{"resp", "the Redis protocol", func(store *stone.Store, opts serveOptions) server {
+		srv := stoneserver.NewRESP(store)
+		srv.ACL = opts.acl
+		srv.Limits = opts.limits
+		srv.Metrics = opts.metrics
+		return srv
+	}},
+	{"memcache", "the memcached protocol", func(store *stone.Store, opts serveOptions) server {
+		srv := stoneserver.NewMemcache(store)
+		srv.ACL = opts.acl
+		srv.Limits = opts.limits
+		srv.Metrics = opts.metrics
+		return srv
+	}},
+}
+
+func runServe(env *env, flags *flag.FlagSet) error {
+	var enabled []listener
+	for _, l := range listeners {
+		if flagValue(flags, l.flag).(string) != "" {
+			enabled = append(enabled, l)
+		}
+	}
+	if len(enabled) == 0 {
+		return fmt.Errorf("nothing to serve, set -addr, -resp or -memcache")
+	}
+	certFile := flagValue(flags, "tls-cert").(string)
+	keyFile := flagValue(flags, "tls-key").(string)
+	clientCAFile := flagValue(flags, "tls-client-ca").(string)
+	var tlsConfig *tls.Config
+	if certFile != "" || keyFile != "" || clientCAFile != "" {
+		if certFile == "" || keyFile == "" {
+			return fmt.Errorf("-tls-cert and -tls-key must be set together")
+		}
+		var err error
+		tlsConfig, err = stoneserver.TLSConfig(certFile, keyFile, clientCAFile)
+		if err != nil {
+			return err
+		}
+	}
+	socketMode, err := strconv.ParseUint(flagValue(flags, "socket-mode").(string), 8, 32)
+	if err != nil || socketMode > 0o777 {
+		return fmt.Errorf("invalid -socket-mode %q", flagValue(flags, "socket-mode"))
+	}
+	opts := serveOptions{backupDir: flagValue(flags, "backup-dir").(string)}
+	if path := flagValue(flags, "acl").(string); path != "" {
+		opts.acl, err = stoneserver.LoadACL(path)
+		if err != nil {
+			return err
+		}
+	}
+	opts.limits = stoneserver.Limits{
+		QPS:               flagValue(flags, "qps").(float64),
+		ClientQPS:         flagValue(flags, "client-qps").(float64),
+		BytesPerSec:       flagValue(flags, "bytes-per-sec").(float64),
+		ClientBytesPerSec: flagValue(flags, "client-bytes-per-sec").(float64),
+	}
+
+	storeOpts := stone.Options{
+		PolishRate:  flagValue(flags, "polish-rate").(int64),
+		ReadRate:    flagValue(flags, "read-rate").(int64),
+		WriteRate:   flagValue(flags, "write-rate").(int64),
+		CacheSize:   flagValue(flags, "cache-size").(int64),
+		ColdPath:    flagValue(flags, "cold-path").(string),
+		DeltaValues: flagValue(flags, "delta-values").(bool),
+		Logger:      slog.New(slog.NewTextHandler(env.stderr, nil)),
+	}
+	if interval := flagValue(flags, "index-snapshot").(time.Duration); interval > 0 {
+		storeOpts.IndexSnapshot = true
+		storeOpts.IndexSnapshotInterval = interval
+	}
+	store, err := stone.NewStoreWithOptions(flags.Arg(0), storeOpts)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	opts.metrics = stoneserver.NewMetrics(store)
+	if fragmentation := flagValue(flags, "auto-polish").(float64); fragmentation > 0 {
+		sched := stone.PolishSchedule{
+			Fragmentation: fragmentation, // Failures are logged by the store
+		}
+		if windows := flagValue(flags, "polish-windows").(string); windows != "" {
+			sched.Windows = strings.Split(windows, ",")
+		}
+		scheduler, err := store.SchedulePolish(sched)
+		if err != nil {
+			return err
+		}
+		defer scheduler.Stop()
+	} else if flagValue(flags, "polish-windows").(string) != "" {
+		return fmt.Errorf("-polish-windows requires -auto-polish")
+	}
+
+	// Listen on every address before serving, so a bad address fails the
+	// command right away
+	lns := make([]net.Listener, len(enabled))
+	for i, l := range enabled {
+		lns[i], err = listen(flagValue(flags, l.flag).(string), os.FileMode(socketMode))
+		if err != nil {
+			return err
+		}
+		defer lns[i].Close()
+		if tlsConfig != nil {
+			lns[i] = tls.NewListener(lns[i], tlsConfig)
+		}
+	}
+	security := ""
+	if tlsConfig != nil {
+		security = " with TLS"
+	}
+	servers := make([]server, len(enabled))
+	errs := make(chan error, len(enabled))
+	for i, l := range enabled {
+		servers[i] = l.newServer(store, opts)
+		fmt.Fprintf(env.stderr, "serving %s over %s%s on %s\n", flags.Arg(0), l.name, security, lns[i].Addr())
+		go func() { errs <- servers[i].Serve(lns[i]) }()
+	}
+
+	// Serve until a server fails or the process is asked to stop, then let
+	// requests in flight finish and close the store, which syncs it to disk
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+		fmt.Fprintf(env.stderr, "shutting down\n")
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), flagValue(flags, "shutdown-timeout").(time.Duration))
+	defer cancel()
+	for _, srv := range servers {
+		if serr := srv.Shutdown(shutdownCtx); serr != nil && err == nil {
+			err = fmt.Errorf("failed to shut down: %w", serr)
+		}
+	}
+	if cerr := store.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// listen listens on a TCP address, or a Unix socket with file mode perm
+// for an address of the form unix:<path>.
+func listen(addr string, perm os.FileMode) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		return stoneserver.ListenUnix(path, perm)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+	return ln, nil
+}
+
+// flagValue returns the value of a flag defined by a command.
+func flagValue(flags *flag.FlagSet, name string) any {
+	return flags.Lookup(name).Value.(flag.Getter).Get()
+}
+
+// readPassphrase reads a passphrase from path, if set. A trailing newline
+// is not part of the passphrase.
+func readPassphrase(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	passphrase := strings.TrimRight(string(data), "\r\n")
+	if passphrase == "" {
+		return "", fmt.Errorf("passphrase file %s is empty", path)
+	}
+	return passphrase, nil
+}
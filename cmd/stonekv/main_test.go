@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// stonekv runs a command line and returns its exit status and output.
+func stonekv(t *testing.T, stdin string, args ...string) (int, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(args, &env{stdin: strings.NewReader(stdin), stdout: &stdout, stderr: &stderr})
+	return code, stdout.String() + stderr.String()
+}
+
+func TestCommands(t *testing.T) {
+	dir := t.TempDir()
+	db := filepath.Join(dir, "test.db")
+
+	steps := []struct {
+		stdin string
+		args  []string
+		code  int
+		want  string
+	}{
+		{"", []string{"get", db, "key1"}, 1, "no such file"},
+		{"", []string{"set", db, "user/1", "alice"}, 0, ""},
+		{"bob", []string{"set", db, "user/2"}, 0, ""},
+		{"", []string{"set", db, "group/1", "admins"}, 0, ""},
+		{"", []string{"get", db, "user/2"}, 0, "bob\n"},
+		{"", []string{"scan", db, "user/"}, 0, "user/1\talice\nuser/2\tbob\n"},
+		{"", []string{"scan", "-keys", db}, 0, "group/1\nuser/1\nuser/2\n"},
+		{"", []string{"del", db, "user/1"}, 0, ""},
+		{"", []string{"del", db, "user/1"}, 1, "key not found"},
+		{"", []string{"stats", db}, 0, "keys:     2\n"},
+		{"", []string{"fsck", db}, 0, "status:   ok\n"},
+		{"", []string{"dump", db}, 0, "96\tdelete\tseq=4\tkey=\"user/1\"\tvalue=0\tsize=23\tchecksum=ok\n"},
+		{"", []string{"dump", "-json", db}, 0, `{"offset":0,"size":32,"type":"set","seq":1,"key":"user/1","value_len":5,"checksum":"ok"}`},
+		{"", []string{"fsck", filepath.Join(dir, "missing.db")}, 1, "failed to open file"},
+		{"", []string{"backup", "-gzip", db, filepath.Join(dir, "backup.db.gz")}, 0, ""},
+		{"", []string{"restore", filepath.Join(dir, "backup.db.gz"), filepath.Join(dir, "restored.db")}, 0, ""},
+		{"", []string{"scan", "-keys", filepath.Join(dir, "restored.db")}, 0, "group/1\nuser/2\n"},
+		{"", []string{"compact", db}, 0, "compacted"},
+		{"", []string{"get", db, "group/1"}, 0, "admins\n"},
+		{"", []string{"serve", "-addr", "", db}, 1, "nothing to serve"},
+		{"", []string{"serve", "-tls-cert", "server.crt", db}, 1, "must be set together"},
+		{"", []string{"serve", "-acl", filepath.Join(dir, "missing.json"), db}, 1, "failed to read ACL"},
+		{"", []string{"serve", "-socket-mode", "999", db}, 1, "invalid -socket-mode"},
+		{"", []string{"bench", "-keys", "100", "-ops", "1000", filepath.Join(dir, "bench.db")}, 0, "mixed:    1000 ops"},
+		{"", []string{"bench", db}, 1, "already exists"},
+		{"", []string{"bench", "-reads", "2"}, 1, "-reads must be between 0 and 1"},
+		{"", []string{"get", db}, 2, "usage: stonekv get"},
+		{"", []string{"frobnicate"}, 2, "unknown command"},
+		{"", []string{"help"}, 0, "Commands:"},
+	}
+	for _, step := range steps {
+		code, out := stonekv(t, step.stdin, step.args...)
+		if code != step.code || !strings.Contains(out, step.want) {
+			t.Errorf("stonekv %s: expected status %d and output containing %q, got %d: %s",
+				strings.Join(step.args, " "), step.code, step.want, code, out)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package stone
+
+import "sync"
+
+// Sizes of the write queue of SetAsync.
+const (
+	asyncQueueSize = 1024 // Writes queued before SetAsync blocks
+	asyncBatchSize = 256  // Writes applied under one lock
+)
+
+// writeQueue holds the writes of SetAsync until a goroutine, started with
+// the first of them, applies them in batches.
+type writeQueue struct {
+	once   sync.Once
+	mu     sync.RWMutex // Held for reading while queueing, for writing to close
+	closed bool
+	writes chan asyncWrite
+	done   chan struct{} // Closed when the queue is drained after close
+}
+
+// asyncWrite is a write queued by SetAsync.
+type asyncWrite struct {
+	key, value []byte
+	result     chan error
+}
+
+// SetAsync queues a key/value pair to be stored and returns at once,
+// before the write reaches the disk, so latency-sensitive callers can
+// pipeline writes. The returned channel receives the result of the write,
+// which is what Set would have returned, once the pair is stored; only then
+// is it visible to Get. Key and value are copied, so the caller may reuse
+// them right away.
+//
+// Queued writes are applied in the order SetAsync was called, several at a
+// time under one lock and, with Options.SyncWrites, one sync. SetAsync
+// blocks while the queue is full. Close applies the writes still queued
+// before closing the store.
+func (s *Store) SetAsync(key, value []byte) <-chan error {
+	if !s.intercepted() {
+		return s.setAsync(key, value)
+	}
+	var result <-chan error
+	err := s.intercept(&Operation{Name: "set_async", Key: key, Value: value}, func(op *Operation) error {
+		result = s.setAsync(op.Key, op.Value)
+		return nil
+	})
+	if err != nil || result == nil {
+		// Failed or skipped by an interceptor
+		done := make(chan error, 1)
+		done <- err
+		return done
+	}
+	return result
+}
+
+// setAsync is SetAsync without the interceptors.
+func (s *Store) setAsync(key, value []byte) <-chan error {
+	result := make(chan error, 1)
+	q := &s.queue
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		result <- ErrClosed
+		return result
+	}
+	err := s.checkPair(key, value)
+	if err != nil {
+		result <- err
+		return result
+	}
+
+	q.once.Do(func() {
+		q.writes = make(chan asyncWrite, asyncQueueSize)
+		q.done = make(chan struct{})
+		go s.applyQueue()
+	})
+	q.writes <- asyncWrite{
+		key:    append([]byte(nil), key...),
+		value:  append([]byte{}, value...),
+		result: result,
+	}
+	return result
+}
+
+// applyQueue applies queued writes until the queue is closed and drained.
+func (s *Store) applyQueue() {
+	q := &s.queue
+	defer close(q.done)
+	batch := make([]asyncWrite, 0, asyncBatchSize)
+	for w := range q.writes {
+		// Take whatever else is queued along, so it shares the lock
+		batch = append(batch[:0], w)
+	fill:
+		for len(batch) < asyncBatchSize {
+			select {
+			case w, ok := <-q.writes:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, w)
+			default:
+				break fill
+			}
+		}
+		s.applyBatch(batch)
+	}
+}
+
+// applyBatch writes a batch of queued writes and reports their results.
+func (s *Store) applyBatch(batch []asyncWrite) {
+	errs := make([]error, len(batch))
+	applied := 0
+	err := s.write(func() error {
+		for i, w := range batch {
+			errs[i] = s.set(s.seq+1, w.key, w.value)
+			if errs[i] == ErrQuotaExceeded && s.opts.EvictOnQuota {
+				break // Evict below, without the lock, and go on in order
+			}
+			applied++
+		}
+		return nil
+	})
+	for i, w := range batch[:applied] {
+		if errs[i] == nil {
+			errs[i] = err // A failed sync fails the whole batch
+		}
+		w.result <- errs[i]
+	}
+	for _, w := range batch[applied:] {
+		w.result <- s.setEvicting(w.key, w.value)
+	}
+}
+
+// closeQueue stops SetAsync from queueing writes and waits for those
+// queued to be applied.
+func (s *Store) closeQueue() {
+	q := &s.queue
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	q.once.Do(func() {}) // No writes were ever queued
+	q.mu.Unlock()
+
+	if q.writes != nil {
+		close(q.writes)
+		<-q.done
+	}
+}
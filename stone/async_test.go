@@ -0,0 +1,53 @@
+package stone
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetAsync(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewStoreWithOptions(path, Options{SyncWrites: true})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	key, value := []byte("key"), []byte("first")
+	results := []<-chan error{store.SetAsync(key, value)}
+	copy(value, "reuse") // The caller may reuse its buffers right away
+	for i := 0; i < 100; i++ {
+		results = append(results, store.SetAsync([]byte(fmt.Sprintf("key%d", i)), []byte("value")))
+	}
+	results = append(results, store.SetAsync(key, []byte("last")))
+	for _, result := range results {
+		if err := <-result; err != nil {
+			t.Fatalf("async set failed: %v", err)
+		}
+	}
+	// Writes are applied in order
+	if value, err := store.Get(key); err != nil || string(value) != "last" {
+		t.Errorf("expected last, got %q (%v)", value, err)
+	}
+
+	// Close applies the writes still queued
+	pending := store.SetAsync([]byte("pending"), []byte("value"))
+	if err = store.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	if err = <-pending; err != nil {
+		t.Errorf("expected queued write to be applied, got %v", err)
+	}
+	if err = <-store.SetAsync(key, value); err == nil {
+		t.Error("expected SetAsync to fail after Close")
+	}
+
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if n := store.Stats().Keys; n != 102 {
+		t.Errorf("expected 102 keys, got %d", n)
+	}
+}
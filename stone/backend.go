@@ -0,0 +1,349 @@
+package stone
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Backend is where a store keeps its log and the files next to it: the
+// files Polish writes, the index snapshot and the probe of CheckDisk. The
+// default is the file system of the operating system; a MemoryBackend
+// keeps them in memory instead, e.g. in a browser, and other storage, like
+// the origin private file system of a browser, can be plugged in by
+// implementing Backend. Files outside the store, like backups, restores,
+// dumps and WAL segments, are always on the file system.
+type Backend interface {
+	// OpenFile opens the named file like os.OpenFile, with the flags
+	// os.O_RDONLY, os.O_WRONLY, os.O_RDWR, os.O_APPEND, os.O_CREATE,
+	// os.O_EXCL and os.O_TRUNC. Missing files fail with an error matching
+	// fs.ErrNotExist.
+	OpenFile(name string, flag int, perm fs.FileMode) (File, error)
+	// Rename renames a file like os.Rename, replacing any file at newpath.
+	// Files open under the old name stay readable. Store.Sync only syncs
+	// the directory of the log on the file system, so the renames of other
+	// backends must be as durable as their files once Rename returns.
+	Rename(oldpath, newpath string) error
+	// Remove removes the named file like os.Remove.
+	Remove(name string) error
+}
+
+// File is a file opened by a Backend. It behaves like an *os.File, which
+// is one: Sync makes what was written durable, and methods of a closed
+// file fail with an error matching fs.ErrClosed.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.Seeker
+	io.Closer
+	Name() string
+	Stat() (fs.FileInfo, error)
+	Sync() error
+	Truncate(size int64) error
+}
+
+// osBackend is the Backend of stores opened without Options.Backend.
+type osBackend struct{}
+
+func (osBackend) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	f, err := openFile(name, flag, perm)
+	if err != nil {
+		return nil, err // Not a typed nil File
+	}
+	return f, nil
+}
+
+func (osBackend) Rename(oldpath, newpath string) error { return replaceFile(oldpath, newpath) }
+func (osBackend) Remove(name string) error             { return os.Remove(name) }
+
+// backendOf returns the Backend of a store opened with opts.
+func backendOf(opts Options) Backend {
+	if opts.Backend == nil {
+		return osBackend{}
+	}
+	return opts.Backend
+}
+
+// createTemp creates a new file in the directory of the log, named after
+// pattern with its last * replaced by a random string, like os.CreateTemp.
+func (s *Store) createTemp(pattern string) (File, error) {
+	dir := filepath.Dir(s.file.Name())
+	prefix, suffix := pattern, ""
+	if i := strings.LastIndex(pattern, "*"); i >= 0 {
+		prefix, suffix = pattern[:i], pattern[i+1:]
+	}
+	for try := 0; ; try++ {
+		var random [4]byte
+		_, err := io.ReadFull(s.rand, random[:])
+		if err != nil {
+			return nil, fmt.Errorf("failed to name temporary file: %w", err)
+		}
+		name := filepath.Join(dir, prefix+strconv.FormatUint(uint64(binary.LittleEndian.Uint32(random[:])), 10)+suffix)
+		f, err := s.backend.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if errors.Is(err, fs.ErrExist) && try < 100 {
+			continue
+		}
+		return f, err
+	}
+}
+
+// MemoryBackend is a Backend keeping files in memory, for stores that
+// don't need to outlive the process, or can't use a file system, like in
+// a browser with GOOS=js. Files are named by their cleaned paths; there
+// are no directories. Stores opened at the same path of the same
+// MemoryBackend, one after the other, see the same log, so a store can be
+// closed and opened again. A MemoryBackend is safe for concurrent use.
+type MemoryBackend struct {
+	mu    sync.Mutex
+	files map[string]*memData
+}
+
+// NewMemoryBackend returns an empty MemoryBackend.
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{files: make(map[string]*memData)}
+}
+
+// memData is the content of a file of a MemoryBackend, shared by the files
+// opened on it.
+type memData struct {
+	mu      sync.RWMutex
+	data    []byte
+	modTime time.Time
+}
+
+// OpenFile implements Backend.
+func (b *MemoryBackend) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	name = filepath.Clean(name)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	d, ok := b.files[name]
+	switch {
+	case ok && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	case !ok:
+		d = &memData{modTime: time.Now()}
+		b.files[name] = d
+	}
+	writable := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	if flag&os.O_TRUNC != 0 && writable {
+		d.mu.Lock()
+		d.data, d.modTime = nil, time.Now()
+		d.mu.Unlock()
+	}
+	return &memFile{
+		name:     name,
+		d:        d,
+		readable: flag&os.O_WRONLY == 0,
+		writable: writable,
+		append:   flag&os.O_APPEND != 0,
+	}, nil
+}
+
+// Rename implements Backend.
+func (b *MemoryBackend) Rename(oldpath, newpath string) error {
+	oldpath, newpath = filepath.Clean(oldpath), filepath.Clean(newpath)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	d, ok := b.files[oldpath]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: fs.ErrNotExist}
+	}
+	delete(b.files, oldpath)
+	b.files[newpath] = d
+	return nil
+}
+
+// Remove implements Backend.
+func (b *MemoryBackend) Remove(name string) error {
+	name = filepath.Clean(name)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.files[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(b.files, name)
+	return nil
+}
+
+// memFile is a file opened by a MemoryBackend.
+type memFile struct {
+	name     string
+	d        *memData
+	mu       sync.Mutex // Guards offset and closed
+	offset   int64
+	closed   bool
+	readable bool
+	writable bool
+	append   bool
+}
+
+// check fails if the file is closed, or wasn't opened for the access.
+func (f *memFile) check(op string, write bool) error {
+	switch {
+	case f.closed:
+		return &fs.PathError{Op: op, Path: f.name, Err: fs.ErrClosed}
+	case write && !f.writable, !write && !f.readable:
+		return &fs.PathError{Op: op, Path: f.name, Err: fs.ErrPermission}
+	}
+	return nil
+}
+
+func (f *memFile) Name() string { return f.name }
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.check("read", false); err != nil {
+		return 0, err
+	}
+	n, err := f.readAt(p, f.offset)
+	f.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	err := f.check("read", false)
+	f.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, &fs.PathError{Op: "readat", Path: f.name, Err: errors.New("negative offset")}
+	}
+	return f.readAt(p, off)
+}
+
+func (f *memFile) readAt(p []byte, off int64) (int, error) {
+	f.d.mu.RLock()
+	defer f.d.mu.RUnlock()
+	if off >= int64(len(f.d.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.d.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.check("write", true); err != nil {
+		return 0, err
+	}
+	f.d.mu.Lock()
+	defer f.d.mu.Unlock()
+	if f.append {
+		f.offset = int64(len(f.d.data))
+	}
+	if end := f.offset + int64(len(p)); end > int64(len(f.d.data)) {
+		f.d.data = append(f.d.data, make([]byte, end-int64(len(f.d.data)))...)
+	}
+	copy(f.d.data[f.offset:], p)
+	f.offset += int64(len(p))
+	f.d.modTime = time.Now()
+	return len(p), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrClosed}
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		f.d.mu.RLock()
+		offset += int64(len(f.d.data))
+		f.d.mu.RUnlock()
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *memFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	return nil
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil, &fs.PathError{Op: "stat", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.d.mu.RLock()
+	defer f.d.mu.RUnlock()
+	return memFileInfo{filepath.Base(f.name), int64(len(f.d.data)), f.d.modTime}, nil
+}
+
+// Sync does nothing but fail if the file is closed: memory is as durable
+// as it gets.
+func (f *memFile) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return &fs.PathError{Op: "sync", Path: f.name, Err: fs.ErrClosed}
+	}
+	return nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.check("truncate", true); err != nil {
+		return err
+	}
+	if size < 0 {
+		return &fs.PathError{Op: "truncate", Path: f.name, Err: fs.ErrInvalid}
+	}
+	f.d.mu.Lock()
+	defer f.d.mu.Unlock()
+	if size <= int64(len(f.d.data)) {
+		f.d.data = f.d.data[:size]
+	} else {
+		f.d.data = append(f.d.data, make([]byte, size-int64(len(f.d.data)))...)
+	}
+	f.d.modTime = time.Now()
+	return nil
+}
+
+// memFileInfo describes a file of a MemoryBackend.
+type memFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return fi.size }
+func (fi memFileInfo) Mode() fs.FileMode  { return 0666 }
+func (fi memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi memFileInfo) IsDir() bool        { return false }
+func (fi memFileInfo) Sys() any           { return nil }
@@ -0,0 +1,85 @@
+package stone
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMemoryBackend(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.db")
+	opts := Options{Backend: NewMemoryBackend(), IndexSnapshot: true}
+	store, err := NewStoreWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	for i := range 100 {
+		err = store.Set([]byte(fmt.Sprintf("key%d", i%10)), []byte(fmt.Sprint(i)))
+		if err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+	}
+	err = store.Polish()
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	store.Delete([]byte("key0"))
+	err = store.CheckDisk()
+	if err != nil {
+		t.Errorf("check disk failed: %v", err)
+	}
+	err = store.Sync()
+	if err != nil {
+		t.Errorf("sync failed: %v", err)
+	}
+	// Backups still go to the file system
+	err = store.Backup(filepath.Join(dir, "backup.db"), BackupOptions{})
+	if err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+	err = store.Close()
+	if err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), "backup.db") {
+			t.Errorf("expected only the backup on disk, got %s", entry.Name())
+		}
+	}
+
+	// The log outlives the store, in the backend
+	store, err = NewStoreWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if _, err := store.Get([]byte("key0")); err == nil {
+		t.Errorf("expected key0 to stay deleted")
+	}
+	if value, err := store.Get([]byte("key9")); err != nil || string(value) != "99" {
+		t.Errorf("expected 99, got %q, %v", value, err)
+	}
+	if stats := store.Stats(); stats.Keys != 9 {
+		t.Errorf("expected 9 keys, got %d", stats.Keys)
+	}
+
+	// But not into another backend
+	other, err := NewStoreWithOptions(path, Options{Backend: NewMemoryBackend()})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer other.Close()
+	if stats := other.Stats(); stats.Keys != 0 {
+		t.Errorf("expected an empty store, got %d keys", stats.Keys)
+	}
+
+	_, err = NewStoreWithOptions("mapped.db", Options{Backend: NewMemoryBackend(), MMap: true})
+	if err == nil {
+		t.Errorf("expected MMap to fail with a memory backend")
+	}
+}
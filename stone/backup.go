@@ -0,0 +1,506 @@
+package stone
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// BackupOptions controls how a backup is written.
+type BackupOptions struct {
+	Polished bool        // Include only active key/value pairs instead of copying the whole log
+	Compress Compression // Compression applied to the backup file
+
+	// Passphrase or Key encrypts the backup with AES-256-GCM, independently
+	// of how the store itself is stored, so copies can be kept on untrusted
+	// media. Key must be 32 bytes; at most one of the two may be set.
+	Passphrase string
+	Key        []byte
+
+	// Parent makes the backup incremental: only the records written after
+	// the backup described by Parent are included. Restore applies the
+	// parent first, looking it up by name next to the incremental backup.
+	// Incremental backups cannot be polished, and are only possible while
+	// the history since the parent has not been compacted away by Polish.
+	Parent *Manifest
+}
+
+// RestoreOptions controls how a backup is restored.
+type RestoreOptions struct {
+	// Passphrase or Key the backup was encrypted with, if any.
+	Passphrase string
+	Key        []byte
+
+	// WALDir rolls the restored backup forward with the records archived in
+	// a store's WAL directory (see Options.WALDir), up to Until if it is
+	// set or to the end of the WAL otherwise.
+	WALDir string
+	Until  time.Time
+}
+
+// Compression selects the compression format of a backup.
+type Compression int
+
+const (
+	CompressNone Compression = iota // Plain log format, openable with NewStore
+	CompressGzip                    // gzip stream of the log, see Restore
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressNone:
+		return "none"
+	case CompressGzip:
+		return "gzip"
+	default:
+		return fmt.Sprintf("Compression(%d)", int(c))
+	}
+}
+
+// gzipMagic starts every gzip stream; no log record type collides with it
+// or with encMagic.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// writeBackup writes a backup of the database to w and returns its
+// manifest, leaving the name to the caller. The caller must hold at least
+// the read lock.
+func (s *Store) writeBackup(w io.Writer, opts BackupOptions) (Manifest, error) {
+	var m Manifest
+	if s.closed() {
+		return m, ErrClosed
+	}
+	if opts.Parent != nil {
+		switch {
+		case opts.Polished:
+			return m, fmt.Errorf("incremental backups cannot be polished")
+		case opts.Parent.ToSeq > s.seq:
+			return m, fmt.Errorf("parent backup is ahead of the store: seq %d > %d", opts.Parent.ToSeq, s.seq)
+		case opts.Parent.ToSeq < s.floor:
+			return m, fmt.Errorf("history since the parent backup has been polished away: seq %d < %d", opts.Parent.ToSeq, s.floor)
+		}
+	}
+
+	out := &hashWriter{w: w, h: sha256.New()}
+	w = out
+
+	var enc *encryptWriter
+	if opts.Passphrase != "" || opts.Key != nil {
+		err := checkBackupKey(opts.Passphrase, opts.Key)
+		if err != nil {
+			return m, err
+		}
+		enc, err = newEncryptWriter(w, s.rand, opts.Passphrase, opts.Key)
+		if err != nil {
+			return m, fmt.Errorf("failed to start encryption: %w", err)
+		}
+		w = enc
+	}
+
+	switch opts.Compress {
+	case CompressNone:
+		err := s.writeBackupData(w, opts)
+		if err != nil {
+			return m, err
+		}
+	case CompressGzip:
+		gz := gzip.NewWriter(w)
+		err := s.writeBackupData(gz, opts)
+		if err != nil {
+			return m, err
+		}
+		err = gz.Close()
+		if err != nil {
+			return m, fmt.Errorf("failed to finish compression: %w", err)
+		}
+	default:
+		return m, fmt.Errorf("unknown compression: %d", opts.Compress)
+	}
+
+	if enc != nil {
+		err := enc.Close()
+		if err != nil {
+			return m, fmt.Errorf("failed to finish encryption: %w", err)
+		}
+	}
+
+	m = Manifest{
+		Created:     s.clock.Now().UTC(),
+		ToSeq:       s.seq,
+		Keys:        s.keyCount(),
+		Size:        out.n,
+		SHA256:      hex.EncodeToString(out.h.Sum(nil)),
+		Polished:    opts.Polished,
+		Compression: opts.Compress.String(),
+		Encrypted:   enc != nil,
+	}
+	if opts.Parent != nil {
+		m.FromSeq = opts.Parent.ToSeq
+		m.Parent = opts.Parent.Name
+	}
+	return m, nil
+}
+
+// hashWriter counts and checksums the bytes written through it.
+type hashWriter struct {
+	w io.Writer
+	h hash.Hash
+	n int64
+}
+
+func (hw *hashWriter) Write(p []byte) (int, error) {
+	n, err := hw.w.Write(p)
+	hw.h.Write(p[:n])
+	hw.n += int64(n)
+	return n, err
+}
+
+// writeBackupData writes the uncompressed backup data to w.
+func (s *Store) writeBackupData(w io.Writer, opts BackupOptions) error {
+	if opts.Parent != nil {
+		err := s.writeIncremental(w, opts.Parent.ToSeq)
+		if err != nil {
+			return fmt.Errorf("failed to write backup record: %w", err)
+		}
+		return nil
+	}
+
+	if opts.Polished {
+		// Write only active records
+		err := s.writeCompacted(w)
+		if err != nil {
+			return fmt.Errorf("failed to write backup record: %w", err)
+		}
+		return s.writeColdBackup(w)
+	}
+
+	// Full backup: copy the entire file
+	src, err := s.backend.OpenFile(s.file.Name(), os.O_RDONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open source file: %w", err)
+	}
+	defer src.Close()
+
+	_, err = io.CopyN(w, src, s.end)
+	if err != nil {
+		return fmt.Errorf("failed to copy file: %w", err)
+	}
+	return s.writeColdBackup(w)
+}
+
+// writeColdBackup writes the pairs of the cold tier, if any, after the log
+// of a full backup. They are numbered with the last sequence number of the
+// store, so incremental backups on top of it follow on.
+func (s *Store) writeColdBackup(w io.Writer) error {
+	if s.cold == nil {
+		return nil
+	}
+	err := s.writeCold(w, func() uint64 { return s.seq })
+	if err != nil {
+		return fmt.Errorf("failed to write cold tier: %w", err)
+	}
+	return nil
+}
+
+// writeIncremental writes the records of the log with a sequence number
+// above since.
+func (s *Store) writeIncremental(w io.Writer, since uint64) error {
+	src, err := s.backend.OpenFile(s.file.Name(), os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	return scanRecords(io.NewSectionReader(src, 0, s.end), func(rec *record) error {
+		if rec.kind == typeCheckpoint || rec.seq <= since {
+			return nil
+		}
+		err := rec.materialize(src)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(rec.encode())
+		return err
+	})
+}
+
+// openBackup returns a reader over the log contained in a backup,
+// decrypting and decompressing it as needed.
+func openBackup(r io.Reader, opts RestoreOptions) (io.Reader, error) {
+	err := checkBackupKey(opts.Passphrase, opts.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(encMagic))
+	if err == nil && bytes.Equal(magic, encMagic) {
+		dec, err := newDecryptReader(br, opts.Passphrase, opts.Key)
+		if err != nil {
+			return nil, err
+		}
+		br = bufio.NewReader(dec)
+	} else if opts.Passphrase != "" || opts.Key != nil {
+		return nil, fmt.Errorf("backup is not encrypted")
+	}
+
+	magic, err = br.Peek(len(gzipMagic))
+	if err == nil && bytes.Equal(magic, gzipMagic) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open compressed backup: %w", err)
+		}
+		return gz, nil
+	}
+	return br, nil
+}
+
+// Restore recreates a database at path from the backup at backupPath,
+// decrypting and decompressing it if needed. An incremental backup is
+// restored on top of its parent, which must be in the same directory and
+// use the same passphrase or key. An existing file at path is replaced, so
+// the store at path must not be open.
+func Restore(backupPath, path string, opts RestoreOptions) error {
+	tempPath := path + ".restore"
+	dst, err := os.OpenFile(tempPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(tempPath)
+
+	err = restoreChain(dst, backupPath, opts, make(map[string]bool))
+	if err != nil {
+		dst.Close()
+		return err
+	}
+	if opts.WALDir != "" {
+		err = rollForward(dst, opts.WALDir, opts.Until)
+		if err != nil {
+			dst.Close()
+			return err
+		}
+	}
+	err = dst.Sync()
+	if err != nil {
+		dst.Close()
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+	err = dst.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+
+	err = replaceFile(tempPath, path)
+	if err != nil {
+		return fmt.Errorf("failed to replace file: %w", err)
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// restoreChain writes the log held by the backup at backupPath to w,
+// preceded by the logs of the backups it builds on if it is incremental.
+func restoreChain(w io.Writer, backupPath string, opts RestoreOptions, seen map[string]bool) error {
+	seen[backupPath] = true
+	_, err := os.Stat(backupPath + ManifestSuffix)
+	if err == nil {
+		m, err := ReadManifest(backupPath)
+		if err != nil {
+			return err
+		}
+		if m.Incremental() {
+			parent := filepath.Join(filepath.Dir(backupPath), m.Parent)
+			if seen[parent] {
+				return fmt.Errorf("backup %s is part of a cycle of incremental backups", parent)
+			}
+			err = restoreChain(w, parent, opts, seen)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	src, err := os.Open(backupPath)
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer src.Close()
+
+	r, err := openBackup(src, opts)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	if err != nil {
+		return fmt.Errorf("failed to restore backup %s: %w", backupPath, err)
+	}
+	return nil
+}
+
+// RestoreToTime recreates a database at path from the backup at backupPath
+// and replays the records archived in walDir up to t, recovering the state
+// of the store as it was at that moment. The store must have been opened
+// with Options.WALDir set to walDir since before the backup was taken.
+func RestoreToTime(backupPath, walDir, path string, t time.Time) error {
+	return Restore(backupPath, path, RestoreOptions{WALDir: walDir, Until: t})
+}
+
+// rollForward appends the WAL records following the restored log in file.
+func rollForward(file *os.File, walDir string, until time.Time) error {
+	_, err := file.Seek(0, io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("failed to seek: %w", err)
+	}
+	var seq uint64
+	err = scanRecords(file, func(rec *record) error {
+		seq = max(seq, rec.seq)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("invalid backup: %w", err)
+	}
+
+	err = replayWAL(file, walDir, seq, until)
+	if err != nil {
+		return fmt.Errorf("failed to replay WAL: %w", err)
+	}
+	return nil
+}
+
+// VerifyOptions controls VerifyBackup.
+type VerifyOptions struct {
+	RestoreOptions        // Passphrase or key of an encrypted backup
+	Store          *Store // If set, a full backup must hold as many keys as this store
+}
+
+// VerifyReport summarizes the contents of a verified backup.
+type VerifyReport struct {
+	Records int    // Total number of records
+	Sets    int    // Set records
+	Deletes int    // Delete records
+	Batches int    // Batch records, written by transactions
+	Legacy  int    // Records in the legacy format, which carry no checksum
+	Keys    int    // Keys live at the end of the backup
+	Seq     uint64 // Highest sequence number in the backup
+	Bytes   int64  // Size of the log after decryption and decompression
+}
+
+// VerifyBackup reads the backup at path end to end, checking the framing and
+// checksum of every record as well as the integrity of compressed and
+// encrypted backups, so operators can trust a backup before deleting older
+// ones. If the backup has a manifest, its size and checksum must match too.
+// On failure the report covers the records read before the problem.
+func VerifyBackup(path string, opts VerifyOptions) (VerifyReport, error) {
+	var report VerifyReport
+
+	var manifest *Manifest
+	_, err := os.Stat(path + ManifestSuffix)
+	if err == nil {
+		m, err := ReadManifest(path)
+		if err != nil {
+			return report, err
+		}
+		manifest = &m
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return report, fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer file.Close()
+
+	sum := &hashWriter{w: io.Discard, h: sha256.New()}
+	r, err := openBackup(io.TeeReader(file, sum), opts.RestoreOptions)
+	if err != nil {
+		return report, err
+	}
+
+	keys := make(map[string]struct{})
+	for {
+		rec, err := readRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err == io.ErrUnexpectedEOF {
+			err = errTorn
+		}
+		if err != nil {
+			return report, fmt.Errorf("record at offset %d: %w", report.Bytes, err)
+		}
+
+		report.Records++
+		report.Bytes += rec.size
+		switch rec.kind {
+		case typeSetLegacy:
+			report.Legacy++
+			report.Seq++
+			fallthrough
+		case typeSet, typeDelta:
+			report.Sets++
+			keys[string(rec.key)] = struct{}{}
+		case typeDeleteLegacy:
+			report.Legacy++
+			report.Seq++
+			fallthrough
+		case typeDelete:
+			report.Deletes++
+			delete(keys, string(rec.key))
+		case typeDeleteMulti:
+			deleted, err := rec.keys()
+			if err != nil {
+				return report, fmt.Errorf("record at offset %d: %w", report.Bytes-rec.size, err)
+			}
+			report.Deletes++
+			for _, key := range deleted {
+				delete(keys, string(key))
+			}
+		case typeBatch:
+			writes, err := rec.writes()
+			if err != nil {
+				return report, fmt.Errorf("record at offset %d: %w", report.Bytes-rec.size, err)
+			}
+			report.Batches++
+			for _, w := range writes {
+				if w.kind == typeDelete {
+					delete(keys, string(w.key))
+				} else {
+					keys[string(w.key)] = struct{}{}
+				}
+			}
+		}
+		if rec.seq > report.Seq {
+			report.Seq = rec.seq
+		}
+	}
+	report.Keys = len(keys)
+
+	if manifest != nil {
+		_, err = io.Copy(sum, file)
+		if err != nil {
+			return report, fmt.Errorf("failed to read backup: %w", err)
+		}
+		if sum.n != manifest.Size {
+			return report, fmt.Errorf("size mismatch: backup has %d bytes, manifest says %d", sum.n, manifest.Size)
+		}
+		if hex.EncodeToString(sum.h.Sum(nil)) != manifest.SHA256 {
+			return report, corruptError("checksum mismatch: backup does not match its manifest")
+		}
+	}
+
+	// An incremental backup only holds part of the store
+	if opts.Store != nil && (manifest == nil || !manifest.Incremental()) {
+		opts.Store.mu.RLock()
+		storeKeys := opts.Store.keyCount()
+		opts.Store.mu.RUnlock()
+		if storeKeys != report.Keys {
+			return report, fmt.Errorf("key count mismatch: backup has %d, store has %d", report.Keys, storeKeys)
+		}
+	}
+	return report, nil
+}
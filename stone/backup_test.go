@@ -0,0 +1,116 @@
+package stone
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompressedBackup(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	value := bytes.Repeat([]byte("stone"), 200)
+	for i := 0; i < 20; i++ {
+		err = store.Set([]byte{byte('a' + i)}, value)
+		if err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+	}
+
+	plainPath := filepath.Join(dir, "plain.db")
+	err = store.Backup(plainPath, BackupOptions{})
+	if err != nil {
+		t.Fatalf("plain backup failed: %v", err)
+	}
+	gzipPath := filepath.Join(dir, "backup.db.gz")
+	err = store.Backup(gzipPath, BackupOptions{Compress: CompressGzip})
+	if err != nil {
+		t.Fatalf("compressed backup failed: %v", err)
+	}
+
+	plain, _ := os.Stat(plainPath)
+	compressed, _ := os.Stat(gzipPath)
+	if compressed.Size()*4 > plain.Size() {
+		t.Errorf("compressed backup %d bytes not much smaller than %d", compressed.Size(), plain.Size())
+	}
+
+	// Restore decompresses transparently
+	restoredPath := filepath.Join(dir, "restored.db")
+	err = Restore(gzipPath, restoredPath, RestoreOptions{})
+	if err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	restored, err := NewStore(restoredPath)
+	if err != nil {
+		t.Fatalf("failed to open restored store: %v", err)
+	}
+	defer restored.Close()
+	got, err := restored.Get([]byte("t"))
+	if err != nil || !bytes.Equal(got, value) {
+		t.Errorf("unexpected value after restore (%v)", err)
+	}
+
+	// Uncompressed backups restore as plain copies
+	err = Restore(plainPath, filepath.Join(dir, "restored_plain.db"), RestoreOptions{})
+	if err != nil {
+		t.Fatalf("restore of plain backup failed: %v", err)
+	}
+}
+
+func TestVerifyBackup(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	store.Set([]byte("key1"), []byte("value1"))
+	store.Set([]byte("key2"), []byte("value2"))
+	store.Delete([]byte("key1"))
+
+	backupPath := filepath.Join(dir, "backup.db.gz")
+	err = store.Backup(backupPath, BackupOptions{Compress: CompressGzip, Passphrase: "pass"})
+	if err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+
+	opts := VerifyOptions{RestoreOptions: RestoreOptions{Passphrase: "pass"}, Store: store}
+	report, err := VerifyBackup(backupPath, opts)
+	if err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+	if report.Records != 3 || report.Sets != 2 || report.Deletes != 1 || report.Keys != 1 || report.Seq != 3 {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	// The store has moved on since the backup
+	store.Set([]byte("key3"), []byte("value3"))
+	_, err = VerifyBackup(backupPath, opts)
+	if err == nil {
+		t.Error("expected key count mismatch, got nil")
+	}
+
+	// Corruption is reported with the records read so far
+	plainPath := filepath.Join(dir, "plain.db")
+	err = store.Backup(plainPath, BackupOptions{})
+	if err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+	data, _ := os.ReadFile(plainPath)
+	data[len(data)-6] ^= 0xff
+	os.WriteFile(plainPath, data, 0666)
+	report, err = VerifyBackup(plainPath, VerifyOptions{})
+	if err == nil {
+		t.Error("expected checksum error, got nil")
+	}
+	if report.Records != 3 {
+		t.Errorf("expected 3 good records before the corrupt one, got %d", report.Records)
+	}
+}
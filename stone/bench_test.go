@@ -0,0 +1,147 @@
+package stone
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"path/filepath"
+	"testing"
+)
+
+// benchKeys is the number of keys the benchmarks load.
+const benchKeys = 10000
+
+// benchStore returns a store holding benchKeys keys with values of
+// valueSize bytes.
+func benchStore(b *testing.B, opts Options, valueSize int) *Store {
+	b.Helper()
+	store, err := NewStoreWithOptions(filepath.Join(b.TempDir(), "bench.db"), opts)
+	if err != nil {
+		b.Fatalf("failed to create store: %v", err)
+	}
+	b.Cleanup(func() { store.Close() })
+	value := make([]byte, valueSize)
+	for i := 0; i < benchKeys; i++ {
+		err = store.Set(benchKey(i), value)
+		if err != nil {
+			b.Fatalf("set failed: %v", err)
+		}
+	}
+	return store
+}
+
+func benchKey(i int) []byte {
+	return []byte(fmt.Sprintf("key%08d", i))
+}
+
+func BenchmarkSet(b *testing.B) {
+	for _, size := range []int{100, 4096} {
+		b.Run(fmt.Sprintf("value=%d", size), func(b *testing.B) {
+			store := benchStore(b, Options{}, size)
+			value := make([]byte, size)
+			b.SetBytes(int64(size))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				store.Set(benchKey(i%benchKeys), value)
+			}
+		})
+	}
+}
+
+func BenchmarkSetSyncWrites(b *testing.B) {
+	store := benchStore(b, Options{}, 0)
+	store.opts.SyncWrites = true
+	value := make([]byte, 100)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			store.Set(benchKey(i%benchKeys), value)
+		}
+	})
+}
+
+func BenchmarkSetAsync(b *testing.B) {
+	store := benchStore(b, Options{}, 0)
+	value := make([]byte, 100)
+	results := make([]<-chan error, 0, b.N)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		results = append(results, store.SetAsync(benchKey(i%benchKeys), value))
+	}
+	for _, result := range results {
+		<-result
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	for _, mmap := range []bool{false, true} {
+		b.Run(fmt.Sprintf("mmap=%t", mmap), func(b *testing.B) {
+			store := benchStore(b, Options{MMap: mmap}, 100)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				r := rand.New(rand.NewPCG(1, 2))
+				for pb.Next() {
+					store.Get(benchKey(r.IntN(benchKeys)))
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkGetNoCopy(b *testing.B) {
+	store := benchStore(b, Options{MMap: true}, 100)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewPCG(1, 2))
+		for pb.Next() {
+			store.GetNoCopy(benchKey(r.IntN(benchKeys)))
+		}
+	})
+}
+
+// BenchmarkMixed runs 90% Gets and 10% Sets from parallel goroutines.
+func BenchmarkMixed(b *testing.B) {
+	store := benchStore(b, Options{}, 100)
+	value := make([]byte, 100)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewPCG(1, 2))
+		for pb.Next() {
+			key := benchKey(r.IntN(benchKeys))
+			if r.IntN(10) == 0 {
+				store.Set(key, value)
+			} else {
+				store.Get(key)
+			}
+		}
+	})
+}
+
+func BenchmarkPolish(b *testing.B) {
+	store := benchStore(b, Options{}, 100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := store.Polish()
+		if err != nil {
+			b.Fatalf("polish failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkOpen(b *testing.B) {
+	for _, snapshot := range []bool{false, true} {
+		b.Run(fmt.Sprintf("snapshot=%t", snapshot), func(b *testing.B) {
+			opts := Options{IndexSnapshot: snapshot}
+			store := benchStore(b, opts, 100)
+			path := store.file.Name()
+			store.Close()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				store, err := NewStoreWithOptions(path, opts)
+				if err != nil {
+					b.Fatalf("failed to open store: %v", err)
+				}
+				store.Close()
+			}
+		})
+	}
+}
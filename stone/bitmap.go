@@ -0,0 +1,73 @@
+package stone
+
+import (
+	"math/bits"
+)
+
+// Bitmaps are stored in chunks of bitmapChunk bytes, each under a key named
+// after its index, see idKey, so setting a bit rewrites one small chunk
+// rather than the whole bitmap, and chunks without a bit set aren't stored
+// at all: a bitmap over a large, sparse ID space only takes space for the
+// regions in use. Trailing zero bytes of a chunk aren't stored either.
+const bitmapChunk = 512
+
+// SetBit sets or clears the bit at offset of the bitmap stored under key,
+// creating the bitmap if it doesn't exist, and returns the bit's previous
+// value. Bits are numbered from the most significant bit of the first
+// byte, as in Redis.
+func (s *Store) SetBit(key []byte, offset uint64, value bool) (bool, error) {
+	var old bool
+	err := s.write(func() error {
+		chunkKey := idKey(kindBitmap, key, offset/(bitmapChunk*8))
+		chunk, _, err := s.find(string(chunkKey))
+		if err != nil {
+			return err
+		}
+		i, mask := offset%(bitmapChunk*8)/8, byte(0x80)>>(offset%8)
+		old = i < uint64(len(chunk)) && chunk[i]&mask != 0
+		if old == value {
+			return nil
+		}
+		if value {
+			if i >= uint64(len(chunk)) {
+				chunk = append(chunk, make([]byte, i+1-uint64(len(chunk)))...)
+			}
+			chunk[i] |= mask
+		} else {
+			chunk[i] &^= mask
+		}
+		for len(chunk) > 0 && chunk[len(chunk)-1] == 0 {
+			chunk = chunk[:len(chunk)-1]
+		}
+		if len(chunk) == 0 {
+			return s.del(s.seq+1, chunkKey)
+		}
+		return s.set(s.seq+1, chunkKey, chunk)
+	})
+	return old, err
+}
+
+// GetBit returns the bit at offset of the bitmap stored under key. Bits
+// never set, including those of bitmaps that don't exist, are 0.
+func (s *Store) GetBit(key []byte, offset uint64) (bool, error) {
+	chunk, _, err := s.find(string(idKey(kindBitmap, key, offset/(bitmapChunk*8))))
+	if err != nil {
+		return false, err
+	}
+	i := offset % (bitmapChunk * 8) / 8
+	return i < uint64(len(chunk)) && chunk[i]&(0x80>>(offset%8)) != 0, nil
+}
+
+// BitCount returns the number of bits set in the bitmap stored under key.
+// It reads the chunks one at a time, like Scan, so bits set or cleared
+// meanwhile may or may not be counted.
+func (s *Store) BitCount(key []byte) (int, error) {
+	var n int
+	err := s.scan(typedKey(kindBitmap, key, nil), func(_, chunk []byte) error {
+		for _, b := range chunk {
+			n += bits.OnesCount8(b)
+		}
+		return nil
+	})
+	return n, err
+}
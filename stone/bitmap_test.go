@@ -0,0 +1,64 @@
+package stone
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestBitmap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	key := []byte("active")
+
+	// Offsets far apart land in chunks of their own
+	offsets := []uint64{0, 7, 4095, 4096, 1 << 40}
+	for _, offset := range offsets {
+		old, err := store.SetBit(key, offset, true)
+		if err != nil || old {
+			t.Fatalf("expected bit %d to be clear before, got %v, %v", offset, old, err)
+		}
+	}
+	if stats := store.Stats(); stats.Keys != 3 {
+		t.Errorf("expected 3 chunks, got %d keys", stats.Keys)
+	}
+	old, _ := store.SetBit(key, 7, true)
+	if !old {
+		t.Errorf("expected bit 7 to be set before")
+	}
+	store.Close()
+
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	for _, offset := range []uint64{0, 7, 4095, 4096, 1 << 40} {
+		if bit, err := store.GetBit(key, offset); err != nil || !bit {
+			t.Errorf("expected bit %d to be set, got %v, %v", offset, bit, err)
+		}
+	}
+	for _, offset := range []uint64{1, 4097, 1<<40 + 1, 1 << 50} {
+		if bit, _ := store.GetBit(key, offset); bit {
+			t.Errorf("expected bit %d to be clear", offset)
+		}
+	}
+	n, err := store.BitCount(key)
+	if err != nil || n != len(offsets) {
+		t.Errorf("expected %d bits set, got %d, %v", len(offsets), n, err)
+	}
+
+	// Clearing the last bit of a chunk deletes it
+	old, _ = store.SetBit(key, 1<<40, false)
+	if !old {
+		t.Errorf("expected the bit to be set before")
+	}
+	if stats := store.Stats(); stats.Keys != 2 {
+		t.Errorf("expected 2 chunks left, got %d keys", stats.Keys)
+	}
+	if n, _ := store.BitCount(key); n != len(offsets)-1 {
+		t.Errorf("expected %d bits set, got %d", len(offsets)-1, n)
+	}
+}
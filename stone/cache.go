@@ -0,0 +1,107 @@
+package stone
+
+import (
+	"cmp"
+	"slices"
+)
+
+// evictTarget is the share of a size limit, in percent, eviction frees the
+// store down to, so the writes after it don't evict again right away.
+const evictTarget = 90
+
+// evictee is a key chosen for eviction, along with the sequence number of
+// its record, so it is only deleted if it hasn't been written since.
+type evictee struct {
+	key  string
+	seq  uint64
+	rank uint64
+}
+
+// pickEvictees returns the keys of lowest rank, ties broken by age, whose
+// records take up at least need bytes.
+func (s *Store) pickEvictees(need int64, rank func(key string, entry indexEntry) uint64) []evictee {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	all := make([]evictee, 0, s.index.len())
+	for key, entry := range s.index.all() {
+		all = append(all, evictee{key, entry.seq, rank(key, entry)})
+	}
+	slices.SortFunc(all, func(a, b evictee) int {
+		return cmp.Or(cmp.Compare(a.rank, b.rank), cmp.Compare(a.seq, b.seq))
+	})
+
+	var freed int64
+	for i, v := range all {
+		if freed >= need {
+			return all[:i]
+		}
+		entry, _ := s.index.get(v.key)
+		freed += entry.size
+	}
+	return all
+}
+
+// evictKeys deletes the keys picked for eviction that haven't been written
+// since.
+func (s *Store) evictKeys(victims []evictee) error {
+	return s.write(func() error {
+		for _, v := range victims {
+			entry, ok := s.index.get(v.key)
+			if !ok || entry.seq != v.seq {
+				continue // Written or deleted since
+			}
+			err := s.del(s.seq+1, []byte(v.key))
+			if err != nil {
+				return err
+			}
+			s.evictions++
+		}
+		return nil
+	})
+}
+
+// wakeCache tells cacheLoop the live records may exceed Options.CacheSize.
+func (s *Store) wakeCache() {
+	select {
+	case s.cacheWake <- struct{}{}:
+	default: // Already woken
+	}
+}
+
+// cacheLoop keeps a store opened with Options.CacheSize within it until
+// the store is closed: it evicts the least recently used keys whenever the
+// live records exceed the cap, and polishes the log once the records
+// evicted or overwritten take up more than the cap too. A failed eviction
+// is retried on the next write over the cap, so errors are ignored.
+func (s *Store) cacheLoop() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.cacheWake:
+		}
+
+		s.mu.RLock()
+		live := s.index.live
+		s.mu.RUnlock()
+		if live > s.opts.CacheSize {
+			need := live - s.opts.CacheSize/100*evictTarget
+			victims := s.pickEvictees(need, func(key string, _ indexEntry) uint64 {
+				return s.index.lastUsed(key)
+			})
+			err := s.evictKeys(victims)
+			if err != nil {
+				s.log.Error("failed to evict keys", "error", err)
+				continue
+			}
+		}
+
+		s.mu.RLock()
+		dead := s.end - s.index.live
+		s.mu.RUnlock()
+		if dead > s.opts.CacheSize {
+			s.Polish()
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package stone
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCacheSize(t *testing.T) {
+	store, err := NewStoreWithOptions(filepath.Join(t.TempDir(), "test.db"), Options{CacheSize: 32 << 10})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	// 200 KiB of values, reading the first keys all along so they stay hot
+	value := make([]byte, 1024)
+	for i := 0; i < 200; i++ {
+		if err := store.Set([]byte(fmt.Sprintf("key%03d", i)), value); err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+		for j := 0; j < 5 && j <= i; j++ {
+			if _, err := store.Get([]byte(fmt.Sprintf("key%03d", j))); err != nil {
+				t.Fatalf("expected hot key%03d to stay cached, got %v", j, err)
+			}
+		}
+	}
+
+	// Eviction runs in the background
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		store.mu.RLock()
+		live := store.index.live
+		store.mu.RUnlock()
+		if live <= 32<<10 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected live records to shrink to 32 KiB, still %d bytes", live)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stats := store.Stats()
+	if stats.Evictions == 0 || stats.Compactions == 0 {
+		t.Errorf("expected evictions and compactions, got %d and %d", stats.Evictions, stats.Compactions)
+	}
+	if stats.FileSize > 3*32<<10 {
+		t.Errorf("expected polishing to keep the log small, got %d bytes", stats.FileSize)
+	}
+	for _, key := range []string{"key000", "key004", "key199"} {
+		if _, err := store.Get([]byte(key)); err != nil {
+			t.Errorf("expected recently used %s to be kept, got %v", key, err)
+		}
+	}
+	if _, err := store.Get([]byte("key005")); err == nil {
+		t.Errorf("expected least recently used key005 to be evicted")
+	}
+}
@@ -0,0 +1,71 @@
+package stone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// errFeedStopped stops tailing the log for a feed that has ended.
+var errFeedStopped = errors.New("feed stopped")
+
+// Changes returns a feed of every record with a sequence number above
+// sinceSeq: first the records already in the log, then new records as they
+// are written. It is shorthand for ChangesContext with a background context.
+func (s *Store) Changes(sinceSeq uint64) (<-chan Record, error) {
+	return s.ChangesContext(context.Background(), sinceSeq)
+}
+
+// ChangesContext is like Changes but stops the feed when ctx is done.
+//
+// Polish discards overwritten values and deletes, so a feed can only resume
+// from a sequence number at or after the last compaction; older positions
+// return an error. A sinceSeq of 0 replays the compacted state in full, the
+// cold tier of Options.ColdPath included. The channel is closed when ctx is
+// done, when the store is closed, or when the consumer falls so far behind
+// that a Polish discards records it has not received yet; in that case the
+// consumer must start over from 0. It is also closed if the log can't be
+// read, e.g. a record in it is corrupt, which is logged as an error to
+// Options.Logger.
+func (s *Store) ChangesContext(ctx context.Context, sinceSeq uint64) (<-chan Record, error) {
+	s.mu.RLock()
+	floor := s.floor
+	s.mu.RUnlock()
+	if sinceSeq != 0 && sinceSeq < floor {
+		return nil, fmt.Errorf("changes up to seq %d have been compacted", floor)
+	}
+
+	ch := make(chan Record)
+	go func() {
+		defer close(ch)
+
+		resync := sinceSeq == 0
+		err := s.tail(sinceSeq, ctx.Done(), func(rec *record) error {
+			if rec.kind == typeCheckpoint {
+				if !resync {
+					return errFeedStopped
+				}
+				return nil
+			}
+			recs, err := rec.split()
+			if err != nil {
+				return err
+			}
+			for _, rec := range recs {
+				select {
+				case ch <- rec.public():
+					resync = false
+				case <-ctx.Done():
+					return errFeedStopped
+				case <-s.done:
+					return errFeedStopped
+				}
+			}
+			return nil
+		}, nil)
+		if err != nil && err != errFeedStopped {
+			s.log.Error("change feed failed", "since", sinceSeq, "error", err)
+		}
+	}()
+	return ch, nil
+}
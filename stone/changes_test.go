@@ -0,0 +1,162 @@
+package stone
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// nextRecord receives a record from ch or fails the test after a timeout.
+func nextRecord(t *testing.T, ch <-chan Record) Record {
+	t.Helper()
+	select {
+	case rec, ok := <-ch:
+		if !ok {
+			t.Fatal("changes feed closed unexpectedly")
+		}
+		return rec
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for change")
+	}
+	return Record{}
+}
+
+func TestChanges(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	store.Set([]byte("key1"), []byte("value1"))
+	store.Set([]byte("key2"), []byte("value2"))
+	store.Delete([]byte("key1"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := store.ChangesContext(ctx, 1)
+	if err != nil {
+		t.Fatalf("changes failed: %v", err)
+	}
+
+	// Historical records after sinceSeq are replayed in order
+	rec := nextRecord(t, ch)
+	if rec.Seq != 2 || rec.Type != RecordSet || string(rec.Key) != "key2" || string(rec.Value) != "value2" {
+		t.Errorf("unexpected record %+v", rec)
+	}
+	rec = nextRecord(t, ch)
+	if rec.Seq != 3 || rec.Type != RecordDelete || string(rec.Key) != "key1" {
+		t.Errorf("unexpected record %+v", rec)
+	}
+
+	// New records are tailed
+	store.Set([]byte("key3"), []byte("value3"))
+	rec = nextRecord(t, ch)
+	if rec.Seq != 4 || string(rec.Key) != "key3" || string(rec.Value) != "value3" {
+		t.Errorf("unexpected record %+v", rec)
+	}
+
+	cancel()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Error("expected feed to be closed after cancel")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for feed to close")
+	}
+}
+
+func TestChangesAfterPolish(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	store.Set([]byte("key1"), []byte("value1"))
+	store.Set([]byte("key2"), []byte("value2"))
+	store.Delete([]byte("key1"))
+	err = store.Polish()
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+
+	// Resuming from before the compaction point would miss the delete
+	_, err = store.Changes(1)
+	if err == nil {
+		t.Error("expected error for compacted history, got nil")
+	}
+
+	// Starting over replays the compacted state
+	ch, err := store.Changes(0)
+	if err != nil {
+		t.Fatalf("changes failed: %v", err)
+	}
+	rec := nextRecord(t, ch)
+	if rec.Seq != 2 || string(rec.Key) != "key2" {
+		t.Errorf("unexpected record %+v", rec)
+	}
+
+	// Resuming at the compaction point only yields new records
+	ch, err = store.Changes(3)
+	if err != nil {
+		t.Fatalf("changes failed: %v", err)
+	}
+	store.Set([]byte("key3"), []byte("value3"))
+	rec = nextRecord(t, ch)
+	if rec.Seq != 4 || string(rec.Key) != "key3" {
+		t.Errorf("unexpected record %+v", rec)
+	}
+
+	// Closing the store ends the feed
+	store.Close()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Error("expected feed to be closed after store close")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for feed to close")
+	}
+}
+
+func TestChangesError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	var buf bytes.Buffer
+	store, err := NewStoreWithOptions(path, Options{Logger: slog.New(slog.NewTextHandler(&buf, nil))})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	store.Set([]byte("a"), []byte("1"))
+
+	// Corrupt the value of the record, so it fails its checksum
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("failed to open log: %v", err)
+	}
+	f.WriteAt([]byte("2"), int64(setRecordSize(1, 1))-5)
+	f.Close()
+
+	changes, err := store.Changes(0)
+	if err != nil {
+		t.Fatalf("changes failed: %v", err)
+	}
+	select {
+	case rec, ok := <-changes:
+		if ok {
+			t.Fatalf("expected the feed to stop at the corrupt record, got %+v", rec)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the feed to stop")
+	}
+	if out := buf.String(); !strings.Contains(out, "level=ERROR msg=\"change feed failed\"") || !strings.Contains(out, "checksum mismatch") {
+		t.Errorf("expected the failure to be logged, got %q", out)
+	}
+}
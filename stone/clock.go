@@ -0,0 +1,162 @@
+package stone
+
+import (
+	"crypto/rand"
+	"io"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time of a store: when locks, queue claims and
+// time series samples expire, when scheduled backups and polishes run, and
+// when the index snapshot is saved. Durations of operations, like those of
+// Stats.Latency, are always measured in real time. See SimClock to drive
+// a store through time deterministically in tests.
+type Clock interface {
+	Now() time.Time
+	// NewTimer returns a timer sending the time on its channel once d has
+	// passed.
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a timer of a Clock, like time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+	// Stop keeps the timer from firing, reporting whether it did.
+	Stop() bool
+}
+
+// realClock is the Clock of the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTimer(d time.Duration) Timer { return realTimer{time.NewTimer(d)} }
+
+type realTimer struct{ *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.Timer.C }
+
+// clockOf returns the clock a store opened with opts uses.
+func clockOf(opts Options) Clock {
+	if opts.Clock == nil {
+		return realClock{}
+	}
+	return opts.Clock
+}
+
+// randOf returns the source of randomness a store opened with opts uses.
+func randOf(opts Options) io.Reader {
+	if opts.Rand == nil {
+		return rand.Reader
+	}
+	return opts.Rand
+}
+
+// SimClock is a Clock that only moves when told to, with Advance, for
+// reproducible tests of what a store does over time: expiring locks,
+// backing up on a schedule, polishing within a window. Together with a
+// MemoryBackend and a seeded Options.Rand, a store run from a single
+// goroutine behaves the same on every run. A SimClock is safe for
+// concurrent use.
+type SimClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	timers  []*simTimer   // Pending, in the order they were created
+	changed chan struct{} // Closed and replaced whenever timers changes
+}
+
+// simTimer is a timer of a SimClock.
+type simTimer struct {
+	clock *SimClock
+	when  time.Time
+	c     chan time.Time
+}
+
+// NewSimClock returns a SimClock starting at start.
+func NewSimClock(start time.Time) *SimClock {
+	return &SimClock{now: start, changed: make(chan struct{})}
+}
+
+// Now implements Clock.
+func (c *SimClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTimer implements Clock. A timer for 0 or less fires at once.
+func (c *SimClock) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &simTimer{clock: c, when: c.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.c <- c.now
+		return t
+	}
+	c.timers = append(c.timers, t)
+	c.notify()
+	return t
+}
+
+// Advance moves the clock forward by d, firing the timers due meanwhile in
+// the order of their deadlines, each with the clock set to its deadline.
+func (c *SimClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	end := c.now.Add(d)
+	for {
+		i := -1
+		for j, t := range c.timers {
+			if !t.when.After(end) && (i < 0 || t.when.Before(c.timers[i].when)) {
+				i = j
+			}
+		}
+		if i < 0 {
+			break
+		}
+		t := c.timers[i]
+		c.timers = slices.Delete(c.timers, i, i+1)
+		c.now = t.when
+		t.c <- t.when
+	}
+	c.now = end
+	c.notify()
+}
+
+// WaitTimers waits until n timers are pending, e.g. for background work
+// woken by Advance to finish and wait on the clock again, so the effects
+// of the work can be checked.
+func (c *SimClock) WaitTimers(n int) {
+	for {
+		c.mu.Lock()
+		pending, changed := len(c.timers), c.changed
+		c.mu.Unlock()
+		if pending == n {
+			return
+		}
+		<-changed
+	}
+}
+
+// notify wakes those waiting in WaitTimers. The caller must hold c.mu.
+func (c *SimClock) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (t *simTimer) C() <-chan time.Time { return t.c }
+
+func (t *simTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := slices.Index(c.timers, t)
+	if i < 0 {
+		return false
+	}
+	c.timers = slices.Delete(c.timers, i, i+1)
+	c.notify()
+	return true
+}
@@ -0,0 +1,171 @@
+package stone
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// simStart is when simulations start, in local time as polish windows are.
+var simStart = time.Date(2024, 1, 1, 1, 0, 0, 0, time.Local)
+
+func TestSimClock(t *testing.T) {
+	clock := NewSimClock(simStart)
+	late := clock.NewTimer(2 * time.Hour)
+	early := clock.NewTimer(time.Hour)
+	stopped := clock.NewTimer(time.Hour)
+	if !stopped.Stop() || stopped.Stop() {
+		t.Error("expected only the first Stop to stop the timer")
+	}
+
+	clock.Advance(90 * time.Minute)
+	select {
+	case at := <-early.C():
+		if !at.Equal(simStart.Add(time.Hour)) {
+			t.Errorf("expected the timer to fire at its deadline, got %v", at)
+		}
+	default:
+		t.Error("expected the timer due to fire")
+	}
+	select {
+	case <-late.C():
+		t.Error("expected the timer not yet due not to fire")
+	case <-stopped.C():
+		t.Error("expected the stopped timer not to fire")
+	default:
+	}
+	if now := clock.Now(); !now.Equal(simStart.Add(90 * time.Minute)) {
+		t.Errorf("expected the clock to advance by 90m, got %v", now.Sub(simStart))
+	}
+	clock.WaitTimers(1)
+}
+
+func TestSimLocks(t *testing.T) {
+	clock := NewSimClock(simStart)
+	store, err := NewStoreWithOptions("test.db", Options{Backend: NewMemoryBackend(), Clock: clock})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	_, ok, err := store.TryLock([]byte("lock"), time.Minute)
+	if err != nil || !ok {
+		t.Fatalf("expected to take the lock, got %v, %v", ok, err)
+	}
+	locked := make(chan error)
+	go func() {
+		_, err := store.Lock([]byte("lock"))
+		locked <- err
+	}()
+
+	clock.WaitTimers(1) // Lock waits for the lock to expire
+	clock.Advance(59 * time.Second)
+	if _, ok, _ := store.TryLock([]byte("lock"), 0); ok {
+		t.Error("expected the lock to be held until it expires")
+	}
+	clock.Advance(time.Second)
+	err = <-locked
+	if err != nil {
+		t.Errorf("expected Lock to take the expired lock, got %v", err)
+	}
+}
+
+func TestSimSchedules(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewSimClock(simStart)
+	store, err := NewStoreWithOptions(filepath.Join(dir, "test.db"), Options{Clock: clock})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	for i := range 10 {
+		store.Set([]byte("key"), []byte(fmt.Sprintf("value%d", i)))
+	}
+
+	driver := DirDriver(filepath.Join(dir, "backups"))
+	backups, err := store.ScheduleBackups(BackupSchedule{
+		Interval: time.Hour,
+		Driver:   driver,
+		Name:     "stone-",
+		OnError:  func(err error) { t.Errorf("background backup failed: %v", err) },
+	})
+	if err != nil {
+		t.Fatalf("schedule failed: %v", err)
+	}
+	defer backups.Stop()
+	polishes, err := store.SchedulePolish(PolishSchedule{
+		Windows: []string{"02:00-03:00"},
+		OnError: func(err error) { t.Errorf("background polish failed: %v", err) },
+	})
+	if err != nil {
+		t.Fatalf("schedule failed: %v", err)
+	}
+	defer polishes.Stop()
+
+	// Each scheduler waits on a timer between runs
+	clock.WaitTimers(2)
+	clock.Advance(59 * time.Minute)
+	clock.WaitTimers(2)
+	if compactions := store.Stats().Compactions; compactions != 0 {
+		t.Errorf("expected no polish before the window, got %d", compactions)
+	}
+	clock.Advance(time.Minute)
+	clock.WaitTimers(2)
+	if compactions := store.Stats().Compactions; compactions != 1 {
+		t.Errorf("expected a polish once the window opens, got %d", compactions)
+	}
+
+	clock.Advance(2 * time.Hour)
+	clock.WaitTimers(2)
+	names, err := driver.List("stone-")
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	want := []string{
+		"stone-" + simStart.Add(time.Hour).UTC().Format(backupTimeFormat),
+		"stone-" + simStart.Add(3*time.Hour).UTC().Format(backupTimeFormat),
+	}
+	var got []string
+	for _, name := range names {
+		if filepath.Ext(name) == ".000Z" {
+			got = append(got, name)
+		}
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected backups %v, got %v", want, got)
+	}
+}
+
+func TestSimReproducible(t *testing.T) {
+	run := func() []byte {
+		opts := Options{
+			Backend: NewMemoryBackend(),
+			Clock:   NewSimClock(simStart),
+			Rand:    rand.NewChaCha8([32]byte{1}),
+		}
+		store, err := NewStoreWithOptions("test.db", opts)
+		if err != nil {
+			t.Fatalf("failed to create store: %v", err)
+		}
+		defer store.Close()
+		for i := range 100 {
+			store.Set([]byte(fmt.Sprintf("key%d", i%10)), []byte(fmt.Sprintf("value%d", i)))
+		}
+		err = store.Polish()
+		if err != nil {
+			t.Fatalf("polish failed: %v", err)
+		}
+		var buf bytes.Buffer
+		_, err = store.writeBackup(&buf, BackupOptions{Passphrase: "secret"})
+		if err != nil {
+			t.Fatalf("backup failed: %v", err)
+		}
+		return buf.Bytes()
+	}
+	if !bytes.Equal(run(), run()) {
+		t.Error("expected identical runs to write identical encrypted backups")
+	}
+}
@@ -0,0 +1,61 @@
+package stone
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Clone writes a compacted copy of the store to a new file at path, like
+// Polish would leave the log, and opens it as an independent store with
+// default options. The copy holds the live pairs at one point in time,
+// those of the cold tier with Options.ColdPath included, so writes wait
+// for it to be written. Clone fails if a file exists at path.
+func (s *Store) Clone(path string) (*Store, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create clone: %w", err)
+	}
+	err = s.writeClone(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = syncDir(filepath.Dir(path))
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("failed to write clone: %w", err)
+	}
+	return NewStore(path)
+}
+
+// writeClone writes the log of a clone to f.
+func (s *Store) writeClone(f *os.File) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed() {
+		return ErrClosed
+	}
+	w := bufio.NewWriterSize(f, polishBatchBytes)
+	err := s.writeCompacted(w)
+	if err != nil {
+		return err
+	}
+	if s.cold != nil {
+		// Numbered after the records of the store
+		seq := s.seq
+		err = s.writeCold(w, func() uint64 {
+			seq++
+			return seq
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,76 @@
+package stone
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestClone(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	for i := 0; i < 10; i++ {
+		store.Set([]byte(fmt.Sprintf("key%d", i)), []byte("old"))
+		store.Set([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)))
+	}
+	store.Delete([]byte("key0"))
+
+	clone, err := store.Clone(filepath.Join(dir, "clone.db"))
+	if err != nil {
+		t.Fatalf("clone failed: %v", err)
+	}
+	defer clone.Close()
+	if stats := clone.Stats(); stats.Keys != 9 {
+		t.Errorf("expected 9 keys in the clone, got %d", stats.Keys)
+	}
+	if _, err := clone.Get([]byte("key0")); err != ErrKeyNotFound {
+		t.Errorf("expected the deleted key to stay deleted, got %v", err)
+	}
+	if value, err := clone.Get([]byte("key5")); err != nil || string(value) != "value5" {
+		t.Errorf("expected value5, got %q (%v)", value, err)
+	}
+
+	// The clone is independent of the store
+	clone.Set([]byte("key5"), []byte("changed"))
+	if value, _ := store.Get([]byte("key5")); string(value) != "value5" {
+		t.Errorf("expected the store to keep value5, got %q", value)
+	}
+	if _, err := store.Clone(filepath.Join(dir, "clone.db")); err == nil {
+		t.Errorf("expected cloning over an existing file to fail")
+	}
+}
+
+func TestCloneColdPath(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStoreWithOptions(filepath.Join(dir, "test.db"), Options{ColdPath: filepath.Join(dir, "cold.db")})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	for i := 0; i < 10; i++ {
+		store.Set([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)))
+	}
+	// Move every key but key0 to the cold tier
+	store.Polish()
+	store.Get([]byte("key0"))
+	store.Polish()
+	if stats := store.Stats(); stats.ColdKeys != 9 {
+		t.Fatalf("expected 9 cold keys, got %d", stats.ColdKeys)
+	}
+
+	clone, err := store.Clone(filepath.Join(dir, "clone.db"))
+	if err != nil {
+		t.Fatalf("clone failed: %v", err)
+	}
+	defer clone.Close()
+	if stats := clone.Stats(); stats.Keys != 10 {
+		t.Errorf("expected all 10 keys in the clone, got %d", stats.Keys)
+	}
+	if value, err := clone.Get([]byte("key9")); err != nil || string(value) != "value9" {
+		t.Errorf("expected value9, got %q (%v)", value, err)
+	}
+}
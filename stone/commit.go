@@ -0,0 +1,188 @@
+package stone
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// committer group commits writes when Options.SyncWrites is set, and the
+// calls to Sync along with them. Writers append their records under the
+// store lock, release it and wait in syncTo. The first of them to get there
+// leads: it syncs the log for every write appended so far, without holding
+// the store lock, while the others wait for it and keep appending. N
+// concurrent writes thus share a few fsyncs instead of paying one each.
+type committer struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	synced  uint64 // Writes known to be on disk
+	syncing bool   // Whether a leader is syncing
+	err     error  // Error of a failed sync, after which nothing is durable
+}
+
+// write runs fn, which appends records, under the write lock, or fails
+// with ErrClosed if the store is closed. It then
+// waits for Options.WriteRate to allow for them and, with
+// Options.SyncWrites, until they are synced to disk, and calls the hooks
+// of the writes.
+func (s *Store) write(fn func() error) error {
+	if s.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	s.mu.Lock()
+	if s.closed() {
+		s.mu.Unlock()
+		return ErrClosed
+	}
+	end := s.end
+	err := fn()
+	written, appended := s.written, s.end-end
+	overCache := s.opts.CacheSize > 0 && s.index.live > s.opts.CacheSize
+	events := s.takeEvents()
+	s.mu.Unlock()
+	if overCache {
+		s.wakeCache()
+	}
+	if appended > 0 {
+		s.writeLimit.wait(int(appended))
+	}
+	if err == nil && s.opts.SyncWrites {
+		err = s.syncTo(written)
+	}
+	s.emit(events)
+	return err
+}
+
+// syncTo waits until the first n writes to the store are synced to disk,
+// syncing the log itself if no other goroutine is.
+func (s *Store) syncTo(n uint64) error {
+	c := &s.commit
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.synced < n && c.err == nil {
+		if c.syncing {
+			c.cond.Wait()
+			continue
+		}
+
+		c.syncing = true
+		c.mu.Unlock()
+		written, err := s.syncLog()
+		c.mu.Lock()
+		c.syncing = false
+		c.cond.Broadcast()
+		if err != nil {
+			// A failed fsync may have dropped dirty pages, so a retry that
+			// succeeds proves nothing
+			c.err = err
+		} else if written > c.synced {
+			c.synced = written
+		}
+	}
+	if c.err != nil {
+		return fmt.Errorf("failed to sync file: %w", c.err)
+	}
+	return nil
+}
+
+// syncLog fsyncs the log and returns the number of writes that covers.
+func (s *Store) syncLog() (uint64, error) {
+	s.mu.RLock()
+	file, written := s.file, s.written
+	s.mu.RUnlock()
+
+	err := failpoint("before-sync")
+	if err != nil {
+		return written, err
+	}
+	err = file.Sync()
+	if errors.Is(err, os.ErrClosed) {
+		// Polish or Close replaced or closed the file since, and both sync
+		// everything written to it first
+		err = nil
+	}
+	return written, err
+}
+
+// syncLocked fsyncs the log for writes made under one long-held write lock,
+// e.g. by a sync with a peer, when Options.SyncWrites is set. The caller
+// must hold the write lock.
+func (s *Store) syncLocked() error {
+	if !s.opts.SyncWrites {
+		return nil
+	}
+	err := failpoint("before-sync")
+	if err == nil {
+		err = s.file.Sync()
+	}
+	c := &s.commit
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.err = err
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+	if s.written > c.synced {
+		c.synced = s.written
+	}
+	return nil
+}
+
+// Sync is a durability barrier: it returns once every write made before
+// it is synced to disk, along with the directory entry of the database, so
+// applications that don't set Options.SyncWrites can choose when to pay for
+// durability, e.g. at the end of a logical transaction. Like synced writes,
+// concurrent Syncs share one fsync of the log.
+func (s *Store) Sync() error {
+	s.mu.RLock()
+	written := s.written
+	path := s.file.Name()
+	s.mu.RUnlock()
+	if s.closed() {
+		return ErrClosed
+	}
+
+	err := s.syncTo(written)
+	if err != nil {
+		return err
+	}
+	return syncDirOf(s.backend, path)
+}
+
+// syncDirOf fsyncs the directory of the file at path, making its creation
+// or renaming durable, if backend is the file system. Other backends make
+// their renames durable themselves.
+func syncDirOf(backend Backend, path string) error {
+	if _, ok := backend.(osBackend); !ok {
+		return nil
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir fsyncs a directory, making the creation, renaming and removal
+// of the files in it durable.
+func syncDir(path string) error {
+	if runtime.GOOS == "windows" {
+		return nil // Directories can't be synced there; NTFS journals renames
+	}
+	dir, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open directory: %w", err)
+	}
+	defer dir.Close()
+	err = dir.Sync()
+	if err != nil {
+		return fmt.Errorf("failed to sync directory: %w", err)
+	}
+	return nil
+}
+
+// syncErr returns the error of a failed sync, if any.
+func (s *Store) syncErr() error {
+	s.commit.mu.Lock()
+	defer s.commit.mu.Unlock()
+	return s.commit.err
+}
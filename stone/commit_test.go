@@ -0,0 +1,84 @@
+package stone
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestSyncWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewStoreWithOptions(path, Options{SyncWrites: true})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	// Concurrent writers, with compactions replacing the file under them
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				err := store.Set([]byte(fmt.Sprintf("key%d-%d", g, i)), []byte("value"))
+				if err != nil {
+					t.Errorf("set failed: %v", err)
+					return
+				}
+				if g == 0 && i%10 == 0 {
+					if err := store.Polish(); err != nil {
+						t.Errorf("polish failed: %v", err)
+					}
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if err := store.Delete([]byte("key0-0")); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if err := store.Health(); err != nil {
+		t.Errorf("expected store to be healthy, got %v", err)
+	}
+	if store.commit.synced != store.written {
+		t.Errorf("expected all %d writes to be synced, got %d", store.written, store.commit.synced)
+	}
+	store.Close()
+
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if n := store.Stats().Keys; n != 8*50-1 {
+		t.Errorf("expected %d keys, got %d", 8*50-1, n)
+	}
+}
+
+func TestSync(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	store.Set([]byte("key"), []byte("value"))
+	if store.commit.synced != 0 {
+		t.Fatalf("expected writes not to be synced without SyncWrites")
+	}
+	if err = store.Sync(); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	if store.commit.synced != store.written {
+		t.Errorf("expected all %d writes to be synced, got %d", store.written, store.commit.synced)
+	}
+	// Nothing written since, so there is nothing to sync
+	if err = store.Sync(); err != nil {
+		t.Errorf("second sync failed: %v", err)
+	}
+
+	store.Close()
+	if err = store.Sync(); err == nil {
+		t.Error("expected Sync to fail after Close")
+	}
+}
@@ -0,0 +1,41 @@
+package stone
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	start := time.Date(2026, 10, 16, 14, 32, 10, 0, time.UTC) // A Friday
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2026, 10, 16, 14, 33, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, 10, 16, 14, 45, 0, 0, time.UTC)},
+		{"30 2 * * *", time.Date(2026, 10, 17, 2, 30, 0, 0, time.UTC)},
+		{"0 3 * * 1-5", time.Date(2026, 10, 19, 3, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 29 2 *", time.Date(2028, 2, 29, 12, 0, 0, 0, time.UTC)},
+		{"0 9 13 * 5", time.Date(2026, 10, 23, 9, 0, 0, 0, time.UTC)}, // Either day field matches
+		{"0 0 * * 7", time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)},
+		{"5,10 14 * * *", time.Date(2026, 10, 17, 14, 5, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		sched, err := parseCron(tt.expr)
+		if err != nil {
+			t.Errorf("%q: parse failed: %v", tt.expr, err)
+			continue
+		}
+		if got := sched.next(start); !got.Equal(tt.want) {
+			t.Errorf("%q: expected %v, got %v", tt.expr, tt.want, got)
+		}
+	}
+
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "a * * * *", "5-1 * * * *"} {
+		_, err := parseCron(expr)
+		if err == nil {
+			t.Errorf("%q: expected parse error, got nil", expr)
+		}
+	}
+}
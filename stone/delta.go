@@ -0,0 +1,174 @@
+package stone
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// With Options.DeltaValues, a value overwriting a similar one is stored as
+// a delta record, whose value is a diff against the record it replaces:
+//
+//	[baseOffset:8][baseDepth:1] ops
+//
+// where baseOffset is the offset of the value length field of the base
+// record, baseDepth the number of deltas it is itself the end of, and each
+// op is a uvarint n<<1 followed by n bytes to insert, or a uvarint n<<1|1
+// followed by the uvarint offset of n bytes to copy from the base value.
+//
+// Deltas refer to their base by offset, so they only make sense in the log
+// they were written to: Polish and everything reading records out of the
+// log turn them back into set records, see materialize.
+const (
+	// deltaMinSize is the size under which values are always stored whole.
+	deltaMinSize = 256
+	// deltaMaxDepth bounds the deltas a Get applies to read a value: a
+	// value overwriting the end of a chain this long is stored whole.
+	deltaMaxDepth = 8
+	// deltaBlock is the length of the runs of the base value a diff
+	// looks for in the new one.
+	deltaBlock = 16
+)
+
+// deltaFor returns value encoded as a diff against the current value of
+// key, with the depth of the chain it ends, or nil if the value should be
+// stored whole. It is used with Options.DeltaValues, and by PatchDoc. The
+// caller must hold the write lock.
+func (s *Store) deltaFor(key string, value []byte) ([]byte, uint8, error) {
+	if len(value) < deltaMinSize || s.polishing.Load() {
+		// While Polish runs, the records written are copied to the
+		// polished log as they are, where a delta's base would move
+		return nil, 0, nil
+	}
+	entry, ok := s.index.get(key)
+	if !ok || entry.depth >= deltaMaxDepth {
+		return nil, 0, nil
+	}
+	base, err := s.readValue(entry)
+	if err != nil {
+		return nil, 0, err
+	}
+	delta := encodeDelta(entry.offset, entry.depth, base, value)
+	if delta == nil {
+		return nil, 0, nil
+	}
+	return delta, entry.depth + 1, nil
+}
+
+// encodeDelta returns the diff turning base, whose record has its value
+// length field at offset and ends a chain of depth deltas, into value, or
+// nil if it isn't at most half the size of value.
+func encodeDelta(offset uint64, depth uint8, base, value []byte) []byte {
+	limit := len(value) / 2
+	delta := binary.LittleEndian.AppendUint64(nil, offset)
+	delta = append(delta, depth)
+
+	// Find the blocks of base anywhere in value, then extend each match
+	// both ways
+	blocks := make(map[uint64]int, len(base)/deltaBlock)
+	for i := 0; i+deltaBlock <= len(base); i += deltaBlock {
+		h := blockHash(base[i:])
+		if _, ok := blocks[h]; !ok {
+			blocks[h] = i
+		}
+	}
+	lit := 0 // Start of the bytes to insert before the next copy
+	for i := 0; i+deltaBlock <= len(value); {
+		j, ok := blocks[blockHash(value[i:])]
+		if !ok || !bytes.Equal(base[j:j+deltaBlock], value[i:i+deltaBlock]) {
+			i++
+			continue
+		}
+		for i > lit && j > 0 && value[i-1] == base[j-1] {
+			i--
+			j--
+		}
+		n := deltaBlock
+		for i+n < len(value) && j+n < len(base) && value[i+n] == base[j+n] {
+			n++
+		}
+
+		delta = appendInsert(delta, value[lit:i])
+		delta = binary.AppendUvarint(delta, uint64(n)<<1|1)
+		delta = binary.AppendUvarint(delta, uint64(j))
+		if len(delta) > limit {
+			return nil
+		}
+		i += n
+		lit = i
+	}
+	delta = appendInsert(delta, value[lit:])
+	if len(delta) > limit {
+		return nil
+	}
+	return delta
+}
+
+// blockHash hashes the first deltaBlock bytes of b.
+func blockHash(b []byte) uint64 {
+	return binary.LittleEndian.Uint64(b)*0x9e3779b97f4a7c15 ^ binary.LittleEndian.Uint64(b[8:deltaBlock])
+}
+
+// appendInsert appends an op inserting b, if it isn't empty.
+func appendInsert(delta, b []byte) []byte {
+	if len(b) == 0 {
+		return delta
+	}
+	delta = binary.AppendUvarint(delta, uint64(len(b))<<1)
+	return append(delta, b...)
+}
+
+// applyDelta rebuilds the value a delta encodes, reading its base from the
+// log r.
+func applyDelta(r io.ReaderAt, delta []byte) ([]byte, error) {
+	if len(delta) < 9 || delta[8] >= deltaMaxDepth {
+		return nil, fmt.Errorf("invalid delta")
+	}
+	base, err := readValueAt(r, nil, binary.LittleEndian.Uint64(delta), delta[8])
+	if err != nil {
+		return nil, err
+	}
+
+	var value []byte
+	ops := delta[9:]
+	for len(ops) > 0 {
+		op, n := binary.Uvarint(ops)
+		if n <= 0 {
+			return nil, fmt.Errorf("invalid delta")
+		}
+		ops = ops[n:]
+		size := op >> 1
+		if op&1 == 0 {
+			if size > uint64(len(ops)) {
+				return nil, fmt.Errorf("invalid delta")
+			}
+			value = append(value, ops[:size]...)
+			ops = ops[size:]
+			continue
+		}
+		from, n := binary.Uvarint(ops)
+		if n <= 0 || from > uint64(len(base)) || size > uint64(len(base))-from {
+			return nil, fmt.Errorf("invalid delta")
+		}
+		ops = ops[n:]
+		value = append(value, base[from:from+size]...)
+	}
+	return value, nil
+}
+
+// materialize turns a delta record read from the log r into the set record
+// it stands for, so it can be copied out of the log. Other records are left
+// as they are.
+func (rec *record) materialize(r io.ReaderAt) error {
+	if rec.kind != typeDelta {
+		return nil
+	}
+	value, err := applyDelta(r, rec.value)
+	if err != nil {
+		return fmt.Errorf("failed to apply delta: %w", err)
+	}
+	rec.kind = typeSet
+	rec.value = value
+	return nil
+}
@@ -0,0 +1,94 @@
+package stone
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestDeltaValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	opts := Options{DeltaValues: true, IndexSnapshot: true}
+	store, err := NewStoreWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer func() { store.Close() }()
+
+	// A 10 KiB document updated 20 times, one field at a time
+	doc := func(version int) []byte {
+		var b bytes.Buffer
+		for i := 0; i < 200; i++ {
+			field := i
+			if i == version*7%200 {
+				field = -version
+			}
+			fmt.Fprintf(&b, `{"field%03d": "value of field %08d"},`, i, field)
+		}
+		return b.Bytes()
+	}
+	for v := 0; v < 20; v++ {
+		err = store.Set([]byte("doc"), doc(v))
+		if err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+	}
+	size := store.Stats().FileSize
+	if size > 4*int64(len(doc(0))) {
+		t.Errorf("expected updates to be stored as deltas, got a %d byte log for %d byte values", size, len(doc(0)))
+	}
+
+	check := func(when string) {
+		t.Helper()
+		value, err := store.Get([]byte("doc"))
+		if err != nil || !bytes.Equal(value, doc(19)) {
+			t.Errorf("%s: expected the last version, got %q, %v", when, value, err)
+		}
+	}
+	check("after updates")
+
+	// The index records the chains, whether rebuilt or loaded from snapshot
+	for _, snapshot := range []bool{false, true} {
+		store.Close()
+		opts.IndexSnapshot = snapshot
+		store, err = NewStoreWithOptions(path, opts)
+		if err != nil {
+			t.Fatalf("failed to reopen store: %v", err)
+		}
+		check(fmt.Sprintf("after reopening with IndexSnapshot %v", snapshot))
+	}
+
+	// Records read out of the log carry whole values
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := store.ChangesContext(ctx, 19)
+	if err != nil {
+		t.Fatalf("changes failed: %v", err)
+	}
+	rec := nextRecord(t, ch)
+	if rec.Type != RecordSet || !bytes.Equal(rec.Value, doc(19)) {
+		t.Errorf("expected the change feed to carry the whole value, got %+v", rec)
+	}
+
+	// Polish stores the value whole
+	err = store.Polish()
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	check("after polish")
+	if polished := store.Stats().FileSize; polished > int64(len(doc(0)))+100 {
+		t.Errorf("expected polish to keep the value alone, got %d bytes", polished)
+	}
+
+	// Dissimilar values are stored whole
+	err = store.Set([]byte("doc"), bytes.Repeat([]byte{'x'}, len(doc(0))))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	entry, _ := store.index.get("doc")
+	if entry.depth != 0 {
+		t.Errorf("expected an unrelated value not to be a delta, got depth %d", entry.depth)
+	}
+}
@@ -0,0 +1,75 @@
+package stone
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"sort"
+)
+
+// DiffResult lists the keys that differ between two stores, each sorted.
+type DiffResult struct {
+	Added   [][]byte // Keys in b but not in a
+	Removed [][]byte // Keys in a but not in b
+	Changed [][]byte // Keys in both with different values
+}
+
+// Equal reports whether the stores held the same key/value pairs.
+func (d DiffResult) Equal() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// Diff compares the live key/value pairs of a and b, e.g. to validate a
+// migration or to compare a restored backup against production.
+//
+// Both tiers of a store with Options.ColdPath are compared. Each key is
+// only locked while it is read, so writes to the stores during the
+// comparison may or may not be reflected in the result.
+func Diff(a, b *Store) (DiffResult, error) {
+	var result DiffResult
+
+	// Remember a checksum of every value in a instead of the values, so
+	// comparing large stores doesn't need memory for a copy of a
+	sums, err := a.valueSums()
+	if err != nil {
+		return result, err
+	}
+
+	err = b.scan(nil, func(key, value []byte) error {
+		sum, ok := sums[string(key)]
+		if !ok {
+			result.Added = append(result.Added, key)
+			return nil
+		}
+		delete(sums, string(key))
+		if sha256.Sum256(value) != sum {
+			result.Changed = append(result.Changed, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	for key := range sums {
+		result.Removed = append(result.Removed, []byte(key))
+	}
+
+	for _, keys := range [][][]byte{result.Added, result.Removed, result.Changed} {
+		sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+	}
+	return result, nil
+}
+
+// valueSums returns the SHA-256 checksum of the value of every live key in
+// both tiers.
+func (s *Store) valueSums() (map[string][sha256.Size]byte, error) {
+	sums := make(map[string][sha256.Size]byte)
+	err := s.scan(nil, func(key, value []byte) error {
+		sums[string(key)] = sha256.Sum256(value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sums, nil
+}
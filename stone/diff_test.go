@@ -0,0 +1,48 @@
+package stone
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	dir := t.TempDir()
+	a, err := NewStore(filepath.Join(dir, "a.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer a.Close()
+	b, err := NewStore(filepath.Join(dir, "b.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer b.Close()
+
+	for _, store := range []*Store{a, b} {
+		store.Set([]byte("same"), []byte("value"))
+		store.Set([]byte("changed"), []byte("old"))
+	}
+	a.Set([]byte("removed"), []byte("value"))
+	b.Set([]byte("changed"), []byte("new"))
+	b.Set([]byte("added2"), []byte("value"))
+	b.Set([]byte("added1"), []byte("value"))
+
+	result, err := Diff(a, b)
+	if err != nil {
+		t.Fatalf("diff failed: %v", err)
+	}
+	got := fmt.Sprintf("%q %q %q", result.Added, result.Removed, result.Changed)
+	want := `["added1" "added2"] ["removed"] ["changed"]`
+	if got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+	if result.Equal() {
+		t.Error("expected stores to differ")
+	}
+
+	result, err = Diff(a, a)
+	if err != nil || !result.Equal() {
+		t.Errorf("expected a store to equal itself, got %+v (%v)", result, err)
+	}
+}
@@ -0,0 +1,376 @@
+package stone
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Documents are JSON values stored as ordinary keys, so Get, secondary
+// indexes and everything else see them as they are. PatchDoc rewrites only
+// the objects and arrays on the path to the field it updates, leaving the
+// bytes of the rest of the document as they were, and stores the result as
+// a diff against the document it replaces, as Options.DeltaValues does, so
+// patching a large document appends a small record rather than the whole
+// document.
+//
+// Paths are dot-separated lists of object keys and array indexes, like
+// "address.city" or "tags.0"; the empty path is the whole document.
+
+// splitPath splits a document path into its segments.
+func splitPath(path string) []string {
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, ".")
+}
+
+// docField is a field of a JSON object, its value as it was encoded.
+type docField struct {
+	name  string
+	value json.RawMessage
+}
+
+// parseObject returns the fields of a JSON object in order.
+func parseObject(doc []byte) ([]docField, error) {
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	_, err := dec.Token() // {
+	if err != nil {
+		return nil, err
+	}
+	var fields []docField
+	for dec.More() {
+		name, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		var value json.RawMessage
+		err = dec.Decode(&value)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, docField{name.(string), value})
+	}
+	return fields, nil
+}
+
+// parseArray returns the elements of a JSON array in order.
+func parseArray(doc []byte) ([]json.RawMessage, error) {
+	var elems []json.RawMessage
+	err := json.Unmarshal(doc, &elems)
+	return elems, err
+}
+
+func encodeObject(fields []docField) []byte {
+	buf := []byte{'{'}
+	for i, f := range fields {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		name, _ := json.Marshal(f.name)
+		buf = append(append(append(buf, name...), ':'), f.value...)
+	}
+	return append(buf, '}')
+}
+
+func encodeArray(elems []json.RawMessage) []byte {
+	buf := []byte{'['}
+	for i, elem := range elems {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, elem...)
+	}
+	return append(buf, ']')
+}
+
+// docKind returns the first byte of a JSON value, '{' for objects and '['
+// for arrays.
+func docKind(doc []byte) byte {
+	doc = bytes.TrimLeft(doc, " \t\r\n")
+	if len(doc) == 0 {
+		return 0
+	}
+	return doc[0]
+}
+
+// getPath returns the value at path in doc, reporting whether there is one.
+func getPath(doc []byte, path []string) ([]byte, bool) {
+	for _, seg := range path {
+		switch docKind(doc) {
+		case '{':
+			fields, err := parseObject(doc)
+			if err != nil {
+				return nil, false
+			}
+			found := false
+			for _, f := range fields {
+				if f.name == seg {
+					doc, found = f.value, true
+				}
+			}
+			if !found {
+				return nil, false
+			}
+		case '[':
+			elems, err := parseArray(doc)
+			i, convErr := strconv.Atoi(seg)
+			if err != nil || convErr != nil || i < 0 || i >= len(elems) {
+				return nil, false
+			}
+			doc = elems[i]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+// setPath returns doc with the value at path replaced by value, or removed
+// if value is nil. Missing objects on the path are created; an index one
+// past the end of an array appends to it.
+func setPath(doc []byte, path []string, value []byte) ([]byte, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	seg, rest := path[0], path[1:]
+	switch docKind(doc) {
+	case '{':
+		fields, err := parseObject(doc)
+		if err != nil {
+			return nil, err
+		}
+		i := 0
+		for i < len(fields) && fields[i].name != seg {
+			i++
+		}
+		if i == len(fields) {
+			if value == nil {
+				return doc, nil
+			}
+			fields = append(fields, docField{name: seg, value: json.RawMessage("{}")})
+		}
+		sub, err := setPath(fields[i].value, rest, value)
+		if err != nil {
+			return nil, err
+		}
+		if sub == nil {
+			fields = append(fields[:i], fields[i+1:]...)
+		} else {
+			fields[i].value = sub
+		}
+		return encodeObject(fields), nil
+	case '[':
+		elems, err := parseArray(doc)
+		if err != nil {
+			return nil, err
+		}
+		i, err := strconv.Atoi(seg)
+		if err != nil || i < 0 || i > len(elems) {
+			return nil, fmt.Errorf("invalid index %q of an array of %d elements", seg, len(elems))
+		}
+		if i == len(elems) {
+			if value == nil {
+				return doc, nil
+			}
+			elems = append(elems, json.RawMessage("{}"))
+		}
+		sub, err := setPath(elems[i], rest, value)
+		if err != nil {
+			return nil, err
+		}
+		if sub == nil {
+			elems = append(elems[:i], elems[i+1:]...)
+		} else {
+			elems[i] = sub
+		}
+		return encodeArray(elems), nil
+	}
+	if value == nil {
+		return doc, nil
+	}
+	return nil, fmt.Errorf("field %q is not in an object or array", seg)
+}
+
+// PutDoc stores the JSON document doc under key, compacted.
+func (s *Store) PutDoc(key, doc []byte) error {
+	var buf bytes.Buffer
+	err := json.Compact(&buf, doc)
+	if err != nil {
+		return fmt.Errorf("invalid document: %w", err)
+	}
+	return s.write(func() error {
+		return s.setDiffing(s.seq+1, key, buf.Bytes(), true)
+	})
+}
+
+// GetDoc returns the value at path in the JSON document stored under key,
+// the whole document for the empty path. It fails with ErrKeyNotFound if
+// there is no document or no value at path.
+func (s *Store) GetDoc(key []byte, path string) ([]byte, error) {
+	doc, ok, err := s.find(string(key))
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		doc, ok = getPath(doc, splitPath(path))
+	}
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return doc, nil
+}
+
+// PatchDoc sets the value at path in the JSON document stored under key to
+// the JSON value value, or removes it if value is nil, creating the
+// document and the objects on the path if they don't exist. An index one
+// past the end of an array appends to it. Only the objects and arrays on
+// the path are rewritten, and the document is stored as a diff against the
+// previous one when that is smaller, so small patches to large documents
+// append small records.
+func (s *Store) PatchDoc(key []byte, path string, value []byte) error {
+	if value != nil {
+		var buf bytes.Buffer
+		err := json.Compact(&buf, value)
+		if err != nil {
+			return fmt.Errorf("invalid value: %w", err)
+		}
+		value = buf.Bytes()
+	} else if path == "" {
+		return fmt.Errorf("can't remove the whole document, use Delete")
+	}
+	return s.write(func() error {
+		doc, ok, err := s.find(string(key))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			doc = []byte("{}")
+		}
+		patched, err := setPath(doc, splitPath(path), value)
+		if err != nil {
+			return err
+		}
+		if ok && bytes.Equal(patched, doc) {
+			return nil
+		}
+		return s.setDiffing(s.seq+1, key, patched, true)
+	})
+}
+
+// DocFilter is a condition on a field of JSON documents for FindDocs.
+type DocFilter struct {
+	Path  string // Path of the field
+	Op    string // "==", "!=", "<", "<=", ">" or ">="
+	Value any    // Value to compare the field with, as encoding/json encodes it
+}
+
+// match reports whether doc satisfies the filter. Numbers compare as
+// numbers and strings as strings; other values only compare with == and
+// !=. A document without the field never matches.
+func (f DocFilter) match(doc []byte, value any) bool {
+	field, ok := getPath(doc, splitPath(f.Path))
+	if !ok {
+		return false
+	}
+	got, err := decodeDocValue(field)
+	if err != nil {
+		return false
+	}
+	cmp, ordered := 0, false
+	switch a := got.(type) {
+	case json.Number:
+		if b, ok := value.(json.Number); ok {
+			x, errX := a.Float64()
+			y, errY := b.Float64()
+			if errX == nil && errY == nil {
+				cmp, ordered = compareFloat(x, y), true
+			}
+		}
+	case string:
+		if b, ok := value.(string); ok {
+			cmp, ordered = strings.Compare(a, b), true
+		}
+	}
+	if !ordered {
+		cmp = 1
+		if reflect.DeepEqual(got, value) {
+			cmp = 0
+		}
+	}
+	switch f.Op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	}
+	if !ordered {
+		return false
+	}
+	switch f.Op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+func compareFloat(x, y float64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// decodeDocValue decodes a JSON value, keeping numbers as json.Number.
+func decodeDocValue(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	err := dec.Decode(&v)
+	return v, err
+}
+
+// FindDocs calls fn for every JSON document stored under a key starting
+// with prefix that matches all the filters, in ascending key order, until
+// fn returns an error, which FindDocs then returns. Values that aren't JSON
+// documents are skipped. It reads every document under prefix; to look
+// documents up by a field, create a secondary index with JSONIndex. Like
+// Scan, it doesn't lock the store while fn runs.
+func (s *Store) FindDocs(prefix []byte, filters []DocFilter, fn func(key, doc []byte) error) error {
+	values := make([]any, len(filters))
+	for i, f := range filters {
+		switch f.Op {
+		case "==", "!=", "<", "<=", ">", ">=":
+		default:
+			return fmt.Errorf("invalid operator %q", f.Op)
+		}
+		data, err := json.Marshal(f.Value)
+		if err != nil {
+			return fmt.Errorf("invalid value of %q: %w", f.Path, err)
+		}
+		values[i], _ = decodeDocValue(data)
+	}
+	return s.scan(prefix, func(key, doc []byte) error {
+		if (len(key) > 0 && key[0] == typedKeyMarker) || !json.Valid(doc) {
+			return nil
+		}
+		for i, f := range filters {
+			if !f.match(doc, values[i]) {
+				return nil
+			}
+		}
+		return fn(key, doc)
+	})
+}
@@ -0,0 +1,114 @@
+package stone
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDocuments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	key := []byte("user:1")
+	err = store.PutDoc(key, []byte(`{"name": "ann", "age": 42, "address": {"city": "Oslo"}, "tags": ["a"]}`))
+	if err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	if store.PutDoc(key, []byte(`{"name": `)) == nil {
+		t.Errorf("expected invalid JSON to be rejected")
+	}
+	get := func(path string) string {
+		t.Helper()
+		value, err := store.GetDoc(key, path)
+		if err != nil {
+			t.Fatalf("get of %q failed: %v", path, err)
+		}
+		return string(value)
+	}
+	if got := get(""); got != `{"name":"ann","age":42,"address":{"city":"Oslo"},"tags":["a"]}` {
+		t.Errorf("expected the document compacted, got %s", got)
+	}
+	if got := get("address.city"); got != `"Oslo"` {
+		t.Errorf(`expected "Oslo", got %s`, got)
+	}
+	if _, err := store.GetDoc(key, "address.zip"); err == nil {
+		t.Errorf("expected getting a missing field to fail")
+	}
+
+	for _, patch := range []struct{ path, value string }{
+		{"address.city", `"Rome"`},
+		{"tags.1", `"b"`},
+		{"tags.0", ""},
+		{"address.geo.lat", `41.9`},
+		{"age", ""},
+		{"missing", ""},
+	} {
+		var value []byte
+		if patch.value != "" {
+			value = []byte(patch.value)
+		}
+		err = store.PatchDoc(key, patch.path, value)
+		if err != nil {
+			t.Fatalf("patch of %q failed: %v", patch.path, err)
+		}
+	}
+	if got := get(""); got != `{"name":"ann","address":{"city":"Rome","geo":{"lat":41.9}},"tags":["b"]}` {
+		t.Errorf("expected the patches applied in place, got %s", got)
+	}
+	if store.PatchDoc(key, "name.first", []byte(`"a"`)) == nil {
+		t.Errorf("expected patching a field of a string to fail")
+	}
+	if store.PatchDoc(key, "tags.5", []byte(`"c"`)) == nil {
+		t.Errorf("expected patching past the end of an array to fail")
+	}
+
+	// Patches of large documents are stored as diffs
+	err = store.PatchDoc(key, "bio", []byte(`"`+strings.Repeat("lorem ipsum ", 100)+`"`))
+	if err != nil {
+		t.Fatalf("patch failed: %v", err)
+	}
+	store.PatchDoc(key, "name", []byte(`"anna"`))
+	report, err := Check(path)
+	if err != nil || report.Deltas != 1 {
+		t.Errorf("expected the last patch stored as a diff, got %+v, %v", report, err)
+	}
+	if got := get("name"); got != `"anna"` {
+		t.Errorf(`expected "anna", got %s`, got)
+	}
+
+	store.PatchDoc([]byte("user:2"), "name", []byte(`"bob"`))
+	store.PutDoc([]byte("user:3"), []byte(`{"name": "cy", "age": 30}`))
+	store.Set([]byte("user:4"), []byte("not json"))
+	find := func(filters ...DocFilter) []string {
+		t.Helper()
+		var keys []string
+		err := store.FindDocs([]byte("user:"), filters, func(key, doc []byte) error {
+			keys = append(keys, string(key))
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("find failed: %v", err)
+		}
+		return keys
+	}
+	if got := find(); !slices.Equal(got, []string{"user:1", "user:2", "user:3"}) {
+		t.Errorf("expected every document, got %v", got)
+	}
+	if got := find(DocFilter{"name", ">=", "b"}); !slices.Equal(got, []string{"user:2", "user:3"}) {
+		t.Errorf("expected [user:2 user:3], got %v", got)
+	}
+	if got := find(DocFilter{"age", "<", 31.5}, DocFilter{"name", "!=", "bob"}); !slices.Equal(got, []string{"user:3"}) {
+		t.Errorf("expected [user:3], got %v", got)
+	}
+	if got := find(DocFilter{"address.geo", "==", map[string]float64{"lat": 41.9}}); !slices.Equal(got, []string{"user:1"}) {
+		t.Errorf("expected [user:1], got %v", got)
+	}
+	if err := store.FindDocs(nil, []DocFilter{{"age", "~", 1}}, nil); err == nil {
+		t.Errorf("expected an invalid operator to fail")
+	}
+}
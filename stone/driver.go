@@ -0,0 +1,159 @@
+package stone
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// BackupDriver stores backups somewhere other than the local file system
+// path passed to Backup, such as a directory on another volume or an object
+// storage bucket.
+type BackupDriver interface {
+	// Put stores the backup read from r under name, replacing any backup
+	// already stored under that name.
+	Put(name string, r io.Reader) error
+}
+
+// BackupTo creates a backup of the database as described by opts and hands
+// it to driver under name, followed by its manifest under name plus
+// ManifestSuffix.
+//
+// The backup is first written to a temporary file next to the database, so
+// the store is only locked while that file is written, not while the driver
+// uploads it.
+func (s *Store) BackupTo(driver BackupDriver, name string, opts BackupOptions) error {
+	defer s.latency.observe(opBackup, time.Now())
+	backup := func(*Operation) error {
+		m, err := s.backupToDriver(driver, name, opts)
+		s.backedUp(m, err)
+		return err
+	}
+	if !s.intercepted() {
+		return backup(nil)
+	}
+	return s.intercept(&Operation{Name: "backup"}, backup)
+}
+
+// backupToDriver is BackupTo, returning the manifest of the backup.
+func (s *Store) backupToDriver(driver BackupDriver, name string, opts BackupOptions) (Manifest, error) {
+	s.mu.RLock()
+	tmp, err := s.createTemp(filepath.Base(s.file.Name()) + ".upload-*")
+	if err != nil {
+		s.mu.RUnlock()
+		return Manifest{}, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer s.backend.Remove(tmp.Name())
+	defer tmp.Close()
+
+	m, err := s.writeBackup(tmp, opts)
+	s.mu.RUnlock()
+	if err != nil {
+		return m, err
+	}
+	m.Name = name
+	manifest, err := m.encode()
+	if err != nil {
+		return m, err
+	}
+
+	_, err = tmp.Seek(0, io.SeekStart)
+	if err != nil {
+		return m, fmt.Errorf("failed to seek: %w", err)
+	}
+	err = driver.Put(name, tmp)
+	if err != nil {
+		return m, fmt.Errorf("failed to store backup %q: %w", name, err)
+	}
+
+	// The manifest goes last, so its presence means the backup is complete
+	err = driver.Put(name+ManifestSuffix, bytes.NewReader(manifest))
+	if err != nil {
+		return m, fmt.Errorf("failed to store manifest of %q: %w", name, err)
+	}
+	return m, nil
+}
+
+// DirDriver is a BackupDriver that stores backups as files in a local
+// directory.
+type DirDriver string
+
+// Put writes the backup to a temporary file in the directory and renames it
+// into place, so a failed backup never replaces a good one.
+func (d DirDriver) Put(name string, r io.Reader) error {
+	dir := string(d)
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, name+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	err = tmp.Sync()
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync backup: %w", err)
+	}
+	err = tmp.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close backup: %w", err)
+	}
+
+	err = replaceFile(tmp.Name(), filepath.Join(dir, name))
+	if err != nil {
+		return fmt.Errorf("failed to rename backup: %w", err)
+	}
+	return syncDir(dir)
+}
+
+// BackupCatalog is implemented by drivers that can list and remove the
+// backups they store, as needed to enforce backup retention.
+type BackupCatalog interface {
+	// List returns the names of the stored backups starting with prefix,
+	// sorted by name.
+	List(prefix string) ([]string, error)
+	// Delete removes the named backup.
+	Delete(name string) error
+}
+
+// List returns the backups in the directory whose names start with prefix.
+func (d DirDriver) List(prefix string) ([]string, error) {
+	entries, err := os.ReadDir(string(d))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasPrefix(name, prefix) && !strings.Contains(name, ".tmp-") {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// Delete removes the named backup from the directory.
+func (d DirDriver) Delete(name string) error {
+	err := os.Remove(filepath.Join(string(d), name))
+	if err != nil {
+		return fmt.Errorf("failed to delete backup: %w", err)
+	}
+	return nil
+}
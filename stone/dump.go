@@ -0,0 +1,100 @@
+package stone
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// DumpRecord describes a record of a log as read by Dump.
+type DumpRecord struct {
+	Offset   int64     // Offset of the record in the file
+	Size     int64     // Encoded length of the record in bytes
+	Type     string    // "set", "delta", "delete", "delete_multi", "batch" or "checkpoint", with a "legacy_" prefix for legacy records
+	Seq      uint64    // Sequence number, 0 for legacy records
+	Time     time.Time // When the record was written, zero unless it carries a timestamp, as WAL records do
+	Key      []byte    // Nil for checkpoints, delete_multi and batch records
+	Keys     [][]byte  // Keys of delete_multi records, and the keys batch records write
+	ValueLen int       // Length of the value of sets, or of the delta of deltas
+	Checksum string    // "ok", "bad", or "none" for legacy records
+}
+
+// recordTypes are the names of record types in a DumpRecord.
+var recordTypes = map[byte]string{
+	typeSetLegacy:    "legacy_set",
+	typeDeleteLegacy: "legacy_delete",
+	typeSet:          "set",
+	typeDelete:       "delete",
+	typeCheckpoint:   "checkpoint",
+	typeDelta:        "delta",
+	typeDeleteMulti:  "delete_multi",
+	typeBatch:        "batch",
+}
+
+// Dump reads the log at path, the file of a store or a WAL segment, without
+// opening it for writes, calling fn for every record in order, for
+// debugging the on-disk format. Records failing their checksum are passed
+// to fn too. It stops at the first record that can't be read, failing with
+// its offset, or at the first error fn returns.
+func Dump(path string, fn func(rec DumpRecord) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	r := bufio.NewReaderSize(file, indexReadBuffer)
+	var offset int64
+	for {
+		rec, err := readRecord(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil && err != errChecksum {
+			return fmt.Errorf("record at offset %d: %w", offset, err)
+		}
+
+		d := DumpRecord{
+			Offset:   offset,
+			Size:     rec.size,
+			Type:     recordTypes[rec.kind],
+			Seq:      rec.seq,
+			Key:      rec.key,
+			ValueLen: len(rec.value),
+			Checksum: "ok",
+		}
+		if rec.ts != 0 {
+			d.Time = time.Unix(0, rec.ts)
+		}
+		if rec.kind == typeDeleteMulti && err == nil {
+			d.Key = nil
+			d.Keys, err = rec.keys()
+			if err != nil {
+				return fmt.Errorf("record at offset %d: %w", offset, err)
+			}
+		}
+		if rec.kind == typeBatch && err == nil {
+			d.Key = nil
+			writes, err := rec.writes()
+			if err != nil {
+				return fmt.Errorf("record at offset %d: %w", offset, err)
+			}
+			for _, w := range writes {
+				d.Keys = append(d.Keys, w.key)
+			}
+		}
+		switch {
+		case rec.kind == typeSetLegacy || rec.kind == typeDeleteLegacy:
+			d.Checksum = "none"
+		case err == errChecksum:
+			d.Checksum = "bad"
+		}
+		err = fn(d)
+		if err != nil {
+			return err
+		}
+		offset += rec.size
+	}
+}
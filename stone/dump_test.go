@@ -0,0 +1,74 @@
+package stone
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.db")
+	store, err := NewStoreWithOptions(path, Options{WALDir: filepath.Join(dir, "wal")})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	store.Set([]byte("a"), []byte("1"))
+	store.Set([]byte("b"), []byte("22"))
+	store.Delete([]byte("a"))
+	store.Close()
+
+	dump := func(path string) ([]DumpRecord, error) {
+		var recs []DumpRecord
+		err := Dump(path, func(rec DumpRecord) error {
+			recs = append(recs, rec)
+			return nil
+		})
+		return recs, err
+	}
+	recs, err := dump(path)
+	if err != nil {
+		t.Fatalf("dump failed: %v", err)
+	}
+	want := []DumpRecord{
+		{Offset: 0, Size: setRecordSize(1, 1), Type: "set", Seq: 1, Key: []byte("a"), ValueLen: 1, Checksum: "ok"},
+		{Offset: setRecordSize(1, 1), Size: setRecordSize(1, 2), Type: "set", Seq: 2, Key: []byte("b"), ValueLen: 2, Checksum: "ok"},
+		{Offset: setRecordSize(1, 1) + setRecordSize(1, 2), Size: 18, Type: "delete", Seq: 3, Key: []byte("a"), Checksum: "ok"},
+	}
+	if len(recs) != len(want) {
+		t.Fatalf("expected %d records, got %+v", len(want), recs)
+	}
+	for i, rec := range recs {
+		w := want[i]
+		if rec.Offset != w.Offset || rec.Size != w.Size || rec.Type != w.Type || rec.Seq != w.Seq ||
+			string(rec.Key) != string(w.Key) || rec.ValueLen != w.ValueLen || rec.Checksum != w.Checksum || !rec.Time.IsZero() {
+			t.Errorf("expected record %d to be %+v, got %+v", i, w, rec)
+		}
+	}
+
+	// WAL segments carry timestamps
+	segments, _ := filepath.Glob(filepath.Join(dir, "wal", "wal-*.log"))
+	if len(segments) != 1 {
+		t.Fatalf("expected a WAL segment, got %v", segments)
+	}
+	recs, err = dump(segments[0])
+	if err != nil || len(recs) != 3 || recs[0].Time.IsZero() {
+		t.Errorf("expected timestamped records, got %+v, %v", recs, err)
+	}
+
+	// Records failing their checksum are dumped, and so are those after
+	data, _ := os.ReadFile(path)
+	data[len(data)-5] ^= 1
+	os.WriteFile(path, data, 0666)
+	recs, err = dump(path)
+	if err != nil || len(recs) != 3 || recs[2].Checksum != "bad" {
+		t.Errorf("expected the last checksum to be bad, got %+v, %v", recs, err)
+	}
+
+	// Torn records end the dump
+	os.WriteFile(path, data[:len(data)-1], 0666)
+	recs, err = dump(path)
+	if err == nil || len(recs) != 2 {
+		t.Errorf("expected the dump to fail at the torn record, got %d records, %v", len(recs), err)
+	}
+}
@@ -0,0 +1,282 @@
+package stone
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Encrypted backups start with a header followed by a sequence of chunks:
+//
+//	header: [magic:8][version:1][kdf:1][iterations:4][salt:16][noncePrefix:7]
+//	chunk:  [len:4][ciphertext+tag]
+//
+// Each chunk holds up to encChunkSize bytes of plaintext sealed with
+// AES-256-GCM. The nonce is the prefix, the chunk counter and a flag marking
+// the final chunk, so chunks cannot be reordered, dropped or truncated
+// without detection. The high bit of len repeats the final-chunk flag, and
+// the header is authenticated as additional data of every chunk.
+var encMagic = []byte("STONEENC")
+
+const (
+	encVersion      = 1
+	encChunkSize    = 64 << 10
+	encHeaderSize   = 8 + 1 + 1 + 4 + 16 + 7
+	encLastChunk    = 1 << 31
+	kdfNone         = 0 // The caller supplied a raw key
+	kdfPBKDF2       = 1 // The key is derived from a passphrase with PBKDF2-HMAC-SHA256
+	pbkdf2Rounds    = 600000
+	encryptionBytes = 32
+)
+
+// checkBackupKey validates the key options shared by BackupOptions and
+// RestoreOptions.
+func checkBackupKey(passphrase string, key []byte) error {
+	if passphrase != "" && key != nil {
+		return fmt.Errorf("only one of passphrase and key can be set")
+	}
+	if key != nil && len(key) != encryptionBytes {
+		return fmt.Errorf("encryption key must be %d bytes, got %d", encryptionBytes, len(key))
+	}
+	return nil
+}
+
+// encryptWriter encrypts everything written to it onto an underlying writer.
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	header  []byte
+	nonce   []byte
+	counter uint32
+	buf     []byte
+}
+
+// newEncryptWriter writes the encryption header to w and returns a writer
+// that encrypts onto it, using key or else a key derived from passphrase.
+// The salt and nonce are read from random.
+func newEncryptWriter(w io.Writer, random io.Reader, passphrase string, key []byte) (*encryptWriter, error) {
+	header := make([]byte, encHeaderSize)
+	copy(header, encMagic)
+	header[8] = encVersion
+	salt := header[14:30]
+	_, err := io.ReadFull(random, header[14:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	if passphrase != "" {
+		header[9] = kdfPBKDF2
+		binary.LittleEndian.PutUint32(header[10:14], pbkdf2Rounds)
+		key = pbkdf2SHA256([]byte(passphrase), salt, pbkdf2Rounds, encryptionBytes)
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = w.Write(header)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptWriter{
+		w:      w,
+		aead:   aead,
+		header: header,
+		nonce:  make([]byte, aead.NonceSize()),
+		buf:    make([]byte, 0, encChunkSize+1),
+	}, nil
+}
+
+// Write buffers p, sealing a chunk whenever a full one is followed by more
+// data; the last chunk is only sealed by Close.
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if len(e.buf) > encChunkSize {
+			err := e.seal(e.buf[:encChunkSize], false)
+			if err != nil {
+				return 0, err
+			}
+			e.buf = append(e.buf[:0], e.buf[encChunkSize:]...)
+		}
+		room := encChunkSize + 1 - len(e.buf)
+		if room > len(p) {
+			room = len(p)
+		}
+		e.buf = append(e.buf, p[:room]...)
+		p = p[room:]
+	}
+	return n, nil
+}
+
+// Close seals the final chunk. It does not close the underlying writer.
+func (e *encryptWriter) Close() error {
+	if len(e.buf) > encChunkSize {
+		err := e.seal(e.buf[:encChunkSize], false)
+		if err != nil {
+			return err
+		}
+		e.buf = e.buf[encChunkSize:]
+	}
+	return e.seal(e.buf, true)
+}
+
+func (e *encryptWriter) seal(chunk []byte, last bool) error {
+	chunkNonce(e.nonce, e.header, e.counter, last)
+	e.counter++
+
+	sealed := e.aead.Seal(nil, e.nonce, chunk, e.header)
+	length := uint32(len(sealed))
+	if last {
+		length |= encLastChunk
+	}
+	var lenBuf [4]byte
+	binary.LittleEndian.PutUint32(lenBuf[:], length)
+	_, err := e.w.Write(lenBuf[:])
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(sealed)
+	return err
+}
+
+// decryptReader decrypts a stream written by encryptWriter.
+type decryptReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	header  []byte
+	nonce   []byte
+	counter uint32
+	buf     []byte
+	done    bool
+}
+
+// newDecryptReader reads the encryption header from r and returns a reader
+// over the decrypted stream. passphrase and key are as in RestoreOptions.
+func newDecryptReader(r io.Reader, passphrase string, key []byte) (*decryptReader, error) {
+	header := make([]byte, encHeaderSize)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption header: %w", unexpected(err))
+	}
+	if !bytes.Equal(header[:8], encMagic) || header[8] != encVersion {
+		return nil, fmt.Errorf("unsupported encryption header")
+	}
+
+	switch header[9] {
+	case kdfNone:
+		if key == nil {
+			return nil, fmt.Errorf("backup is encrypted with a key")
+		}
+	case kdfPBKDF2:
+		if passphrase == "" {
+			return nil, fmt.Errorf("backup is encrypted with a passphrase")
+		}
+		rounds := int(binary.LittleEndian.Uint32(header[10:14]))
+		key = pbkdf2SHA256([]byte(passphrase), header[14:30], rounds, encryptionBytes)
+	default:
+		return nil, fmt.Errorf("unsupported key derivation: %d", header[9])
+	}
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{
+		r:      r,
+		aead:   aead,
+		header: header,
+		nonce:  make([]byte, aead.NonceSize()),
+	}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		err := d.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+// open reads and decrypts the next chunk into buf.
+func (d *decryptReader) open() error {
+	var lenBuf [4]byte
+	_, err := io.ReadFull(d.r, lenBuf[:])
+	if err != nil {
+		return fmt.Errorf("encrypted backup is truncated: %w", unexpected(err))
+	}
+	length := binary.LittleEndian.Uint32(lenBuf[:])
+	last := length&encLastChunk != 0
+	length &^= encLastChunk
+	if length > encChunkSize+uint32(d.aead.Overhead()) {
+		return fmt.Errorf("invalid encrypted chunk length: %d", length)
+	}
+
+	sealed := make([]byte, length)
+	_, err = io.ReadFull(d.r, sealed)
+	if err != nil {
+		return fmt.Errorf("encrypted backup is truncated: %w", unexpected(err))
+	}
+
+	chunkNonce(d.nonce, d.header, d.counter, last)
+	d.counter++
+	d.buf, err = d.aead.Open(sealed[:0], d.nonce, sealed, d.header)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt backup: wrong key or corrupted data")
+	}
+	d.done = last
+	return nil
+}
+
+// chunkNonce builds the nonce of a chunk from the header's nonce prefix.
+func chunkNonce(nonce, header []byte, counter uint32, last bool) {
+	copy(nonce, header[30:37])
+	binary.BigEndian.PutUint32(nonce[7:11], counter)
+	nonce[11] = 0
+	if last {
+		nonce[11] = 1
+	}
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// pbkdf2SHA256 derives a key from password as specified by RFC 8018.
+func pbkdf2SHA256(password, salt []byte, rounds, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var key []byte
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.Write(prf, binary.BigEndian, block)
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < rounds; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
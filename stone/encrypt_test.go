@@ -0,0 +1,109 @@
+package stone
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPBKDF2(t *testing.T) {
+	// Test vector from RFC 7914 section 11
+	got := pbkdf2SHA256([]byte("passwd"), []byte("salt"), 1, 64)
+	want := "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" +
+		"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"
+	if hex.EncodeToString(got) != want {
+		t.Errorf("unexpected key %x", got)
+	}
+}
+
+func TestEncryptStream(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	for _, size := range []int{0, 1, encChunkSize, encChunkSize + 1, 3*encChunkSize + 17} {
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(i * 31)
+		}
+
+		var buf bytes.Buffer
+		enc, err := newEncryptWriter(&buf, rand.Reader, "", key)
+		if err != nil {
+			t.Fatalf("failed to create writer: %v", err)
+		}
+		enc.Write(data)
+		err = enc.Close()
+		if err != nil {
+			t.Fatalf("close failed: %v", err)
+		}
+		sealed := buf.Bytes()
+
+		dec, err := newDecryptReader(bytes.NewReader(sealed), "", key)
+		if err != nil {
+			t.Fatalf("failed to create reader: %v", err)
+		}
+		got, err := io.ReadAll(dec)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("size %d: round trip failed (%v)", size, err)
+		}
+
+		// Dropping the end of the stream is detected
+		dec, _ = newDecryptReader(bytes.NewReader(sealed[:len(sealed)-1]), "", key)
+		_, err = io.ReadAll(dec)
+		if err == nil {
+			t.Errorf("size %d: expected error for truncated stream", size)
+		}
+	}
+}
+
+func TestEncryptedBackup(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	store.Set([]byte("secret"), []byte("the eagle lands at dawn"))
+
+	backupPath := filepath.Join(dir, "backup.enc")
+	err = store.Backup(backupPath, BackupOptions{Compress: CompressGzip, Passphrase: "correct horse"})
+	if err != nil {
+		t.Fatalf("encrypted backup failed: %v", err)
+	}
+	data, _ := os.ReadFile(backupPath)
+	if bytes.Contains(data, []byte("secret")) {
+		t.Error("backup contains plaintext key")
+	}
+
+	restoredPath := filepath.Join(dir, "restored.db")
+	err = Restore(backupPath, restoredPath, RestoreOptions{Passphrase: "wrong horse"})
+	if err == nil {
+		t.Error("expected error restoring with wrong passphrase")
+	}
+	err = Restore(backupPath, restoredPath, RestoreOptions{})
+	if err == nil {
+		t.Error("expected error restoring without passphrase")
+	}
+
+	err = Restore(backupPath, restoredPath, RestoreOptions{Passphrase: "correct horse"})
+	if err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	restored, err := NewStore(restoredPath)
+	if err != nil {
+		t.Fatalf("failed to open restored store: %v", err)
+	}
+	defer restored.Close()
+	value, err := restored.Get([]byte("secret"))
+	if err != nil || string(value) != "the eagle lands at dawn" {
+		t.Errorf("unexpected value '%s' (%v)", value, err)
+	}
+
+	// Raw keys must be 32 bytes
+	err = store.Backup(backupPath, BackupOptions{Key: []byte("short")})
+	if err == nil {
+		t.Error("expected error for short key")
+	}
+}
@@ -0,0 +1,80 @@
+package stone
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// Errors returned by stores, for callers to test for with errors.Is. Others
+// belong to the features returning them, like ErrQuotaExceeded,
+// ErrConflict and ErrQueueEmpty.
+var (
+	// ErrKeyNotFound is returned when reading a key that doesn't exist, or
+	// an element of a data type, like a hash field, that doesn't.
+	ErrKeyNotFound = errors.New("key not found")
+	// ErrClosed is returned by operations on a store that is closed.
+	ErrClosed = errors.New("store is closed")
+	// ErrCorrupt is returned when data the store reads, like its log, is
+	// damaged: cut short, failing its checksum or malformed.
+	ErrCorrupt = errors.New("data is corrupt")
+	// ErrReadOnly is returned by writes to a store opened with
+	// Options.ReadOnly.
+	ErrReadOnly = errors.New("store is read-only")
+)
+
+// corruptError is the error of damaged data, matching ErrCorrupt while
+// keeping its own message.
+type corruptError string
+
+func (e corruptError) Error() string { return string(e) }
+
+func (e corruptError) Is(target error) bool {
+	return target == ErrCorrupt
+}
+
+// OpError is the error of an operation failing to read or write the log,
+// e.g. on an I/O error or damaged data, saying where. Logical failures,
+// like ErrKeyNotFound or ErrVersionMismatch, aren't wrapped in one, so
+// errors.As tells them apart.
+type OpError struct {
+	// Op is the operation that failed: "read", "write" or "delete".
+	Op string
+	// Key is the key it failed on, nil if it has none or with
+	// Options.RedactKeys.
+	Key []byte
+	// Offset is where in the log it failed, -1 if that isn't known.
+	Offset int64
+	// Err is the underlying error.
+	Err error
+
+	redacted bool
+}
+
+func (e *OpError) Error() string {
+	msg := e.Op
+	switch {
+	case e.redacted:
+		msg += " of a key"
+	case e.Key != nil:
+		msg += fmt.Sprintf(" of key %q", e.Key)
+	}
+	if e.Offset >= 0 {
+		msg += fmt.Sprintf(" at offset %d", e.Offset)
+	}
+	return msg + ": " + e.Err.Error()
+}
+
+func (e *OpError) Unwrap() error { return e.Err }
+
+// opError wraps err, if not nil, in an OpError, leaving out key with
+// Options.RedactKeys.
+func (s *Store) opError(op string, key []byte, offset int64, err error) error {
+	if err == nil {
+		return nil
+	}
+	if s.opts.RedactKeys {
+		return &OpError{Op: op, Offset: offset, Err: err, redacted: key != nil}
+	}
+	return &OpError{Op: op, Key: bytes.Clone(key), Offset: offset, Err: err}
+}
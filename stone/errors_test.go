@@ -0,0 +1,270 @@
+package stone
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	store.Set([]byte("key"), []byte("value"))
+	if _, err := store.Get([]byte("missing")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound, got %v", err)
+	}
+	if _, err := store.HGet([]byte("hash"), []byte("field")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound for a missing field, got %v", err)
+	}
+	store.Close()
+	if err := store.Close(); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed closing twice, got %v", err)
+	}
+	if err := store.Polish(); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed polishing a closed store, got %v", err)
+	}
+
+	for name, err := range map[string]error{
+		"Get":    func() error { _, err := store.Get([]byte("key")); return err }(),
+		"Set":    store.Set([]byte("key"), []byte("value")),
+		"Delete": store.Delete([]byte("key")),
+		"HSet":   store.HSet([]byte("hash"), []byte("field"), []byte("value")),
+		"Scan":   store.Scan(nil, func(key, value []byte) error { return nil }),
+		"Backup": store.Backup(path+".backup", BackupOptions{}),
+	} {
+		if !errors.Is(err, ErrClosed) {
+			t.Errorf("expected ErrClosed from %s on a closed store, got %v", name, err)
+		}
+	}
+
+	// A read-only store reads but doesn't write
+	store, err = NewStoreWithOptions(path, Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("failed to open read-only store: %v", err)
+	}
+	if value, err := store.Get([]byte("key")); err != nil || string(value) != "value" {
+		t.Errorf("expected value, got %q, %v", value, err)
+	}
+	if err := store.Set([]byte("key"), nil); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly from Set, got %v", err)
+	}
+	if err := store.Delete([]byte("key")); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly from Delete, got %v", err)
+	}
+	if err := store.Polish(); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly from Polish, got %v", err)
+	}
+	store.Close()
+	_, err = NewStoreWithOptions(filepath.Join(t.TempDir(), "missing.db"), Options{ReadOnly: true})
+	if err == nil {
+		t.Errorf("expected opening a missing store read-only to fail")
+	}
+
+	// Damaged logs fail to open with ErrCorrupt, except for a torn last
+	// record, which is cut off
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, data[:len(data)-1], 0666)
+	if store, err := NewStore(path); err != nil {
+		t.Errorf("expected a torn record to be cut off, got %v", err)
+	} else {
+		store.Close()
+	}
+	data[len(data)-1] ^= 0xff
+	os.WriteFile(path, data, 0666)
+	if _, err := NewStore(path); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("expected ErrCorrupt for a checksum mismatch, got %v", err)
+	}
+}
+
+func TestOpError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	store.Set([]byte("user/alice"), []byte("value"))
+
+	var opErr *OpError
+	if _, err := store.Get([]byte("missing")); errors.As(err, &opErr) {
+		t.Errorf("expected a missing key not to be an OpError, got %v", err)
+	}
+
+	// Reads failing on I/O say what and where
+	os.Truncate(path, 0)
+	_, err = store.Get([]byte("user/alice"))
+	if !errors.As(err, &opErr) {
+		t.Fatalf("expected an OpError, got %v", err)
+	}
+	if opErr.Op != "read" || string(opErr.Key) != "user/alice" || opErr.Offset <= 0 {
+		t.Errorf("expected a read of user/alice at an offset, got %+v", opErr)
+	}
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("expected the error to wrap io.EOF, got %v", err)
+	}
+
+	// Unless redacted
+	redacted, err := NewStoreWithOptions(filepath.Join(t.TempDir(), "test.db"), Options{RedactKeys: true})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer redacted.Close()
+	redacted.Set([]byte("user/alice"), []byte("value"))
+	os.Truncate(redacted.file.Name(), 0)
+	_, err = redacted.Get([]byte("user/alice"))
+	if !errors.As(err, &opErr) || opErr.Key != nil || strings.Contains(err.Error(), "alice") {
+		t.Errorf("expected an OpError without the key, got %v", err)
+	}
+}
+
+func TestGetRacingClose(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	store.Set([]byte("key"), []byte("value"))
+
+	errs := make(chan error)
+	for i := 0; i < 8; i++ {
+		go func() {
+			for {
+				_, err := store.Get([]byte("key"))
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	store.Close()
+	for i := 0; i < 8; i++ {
+		if err := <-errs; err != ErrClosed {
+			t.Errorf("expected Gets racing Close to fail with ErrClosed, got %v", err)
+		}
+	}
+}
+
+// tearingBackend is a Backend whose files write only half of what they are
+// given, failing, while tear is set, like a disk filling up mid-write.
+type tearingBackend struct {
+	Backend
+	tear *bool
+}
+
+func (b tearingBackend) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := b.Backend.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return tearingFile{f, b.tear}, nil
+}
+
+type tearingFile struct {
+	File
+	tear *bool
+}
+
+func (f tearingFile) Write(p []byte) (int, error) {
+	if !*f.tear {
+		return f.File.Write(p)
+	}
+	n, _ := f.File.Write(p[:len(p)/2])
+	return n, errors.New("no space left on device")
+}
+
+func TestPartialWrite(t *testing.T) {
+	var tear bool
+	backend := tearingBackend{NewMemoryBackend(), &tear}
+	store, err := NewStoreWithOptions("test.db", Options{Backend: backend})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	store.Set([]byte("key1"), []byte("value1"))
+
+	tear = true
+	if err := store.Set([]byte("key2"), []byte("value2")); err == nil {
+		t.Fatal("expected the torn write to fail")
+	}
+	tear = false
+	if err := store.Set([]byte("key3"), []byte("value3")); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	for key, want := range map[string]string{"key1": "value1", "key3": "value3"} {
+		if value, err := store.Get([]byte(key)); err != nil || string(value) != want {
+			t.Errorf("expected %s, got %q (%v)", want, value, err)
+		}
+	}
+	store.Close()
+
+	// Nothing of the torn write is left in the log
+	store, err = NewStoreWithOptions("test.db", Options{Backend: backend})
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if _, err := store.Get([]byte("key2")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected the torn key not to be found, got %v", err)
+	}
+	if value, err := store.Get([]byte("key3")); err != nil || string(value) != "value3" {
+		t.Errorf("expected value3, got %q (%v)", value, err)
+	}
+}
+
+// countingBackend is a Backend keeping count of the files open, and
+// failing to open the file named fail.
+type countingBackend struct {
+	Backend
+	open *int
+	fail string
+}
+
+func (b countingBackend) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if name == b.fail {
+		return nil, errors.New("permission denied")
+	}
+	f, err := b.Backend.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	*b.open++
+	return countingFile{f, b.open}, nil
+}
+
+type countingFile struct {
+	File
+	open *int
+}
+
+func (f countingFile) Close() error {
+	*f.open--
+	return f.File.Close()
+}
+
+func TestOpenFailure(t *testing.T) {
+	var open int
+	backend := countingBackend{NewMemoryBackend(), &open, "cold.db"}
+	opts := Options{Backend: backend, ReadHandles: 2, ColdPath: "cold.db", WALDir: t.TempDir()}
+	_, err := NewStoreWithOptions("test.db", opts)
+	if err == nil {
+		t.Fatal("expected opening the cold tier to fail")
+	}
+	if open != 0 {
+		t.Errorf("expected a failed open to close every file it opened, %d left open", open)
+	}
+
+	opts.ColdPath = ""
+	store, err := NewStoreWithOptions("test.db", opts)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	store.Close()
+	if open != 0 {
+		t.Errorf("expected Close to close every file, %d left open", open)
+	}
+}
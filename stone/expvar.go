@@ -0,0 +1,55 @@
+package stone
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// published holds the stores published with Options.Expvar, by name.
+// expvar can't remove a variable, so a name stays published once a store
+// took it and the next store opened with it takes it over.
+var published struct {
+	sync.Mutex
+	stores map[string]*atomic.Pointer[Store]
+}
+
+// publish publishes the Stats of the store in expvar under name.
+func (s *Store) publish(name string) error {
+	published.Lock()
+	defer published.Unlock()
+	if p, ok := published.stores[name]; ok {
+		p.Store(s)
+		return nil
+	}
+	if expvar.Get(name) != nil {
+		return fmt.Errorf("expvar %s is already published", name)
+	}
+	p := &atomic.Pointer[Store]{}
+	p.Store(s)
+	if published.stores == nil {
+		published.stores = make(map[string]*atomic.Pointer[Store])
+	}
+	published.stores[name] = p
+	expvar.Publish(name, expvar.Func(func() any {
+		if s := p.Load(); s != nil {
+			return s.Stats()
+		}
+		return nil
+	}))
+	return nil
+}
+
+// unpublish stops publishing the Stats of the store, unless another store
+// took its name over.
+func (s *Store) unpublish() {
+	if s.opts.Expvar == "" {
+		return
+	}
+	published.Lock()
+	defer published.Unlock()
+	if p, ok := published.stores[s.opts.Expvar]; ok {
+		p.CompareAndSwap(s, nil)
+	}
+}
@@ -0,0 +1,54 @@
+package stone
+
+import (
+	"encoding/json"
+	"expvar"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpvar(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	opts := Options{Expvar: "stonekv_test"}
+	store, err := NewStoreWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	store.Set([]byte("a"), []byte("1"))
+
+	published := func() *Stats {
+		t.Helper()
+		var stats *Stats
+		err := json.Unmarshal([]byte(expvar.Get("stonekv_test").String()), &stats)
+		if err != nil {
+			t.Fatalf("expected stats in JSON, got %v", err)
+		}
+		return stats
+	}
+	if stats := published(); stats == nil || stats.Keys != 1 || stats.Latency["set"].Count != 1 {
+		t.Errorf("expected the stats of the store, got %+v", stats)
+	}
+
+	// The name is freed on close, for the store to be opened again
+	store.Close()
+	if stats := published(); stats != nil {
+		t.Errorf("expected nothing published for a closed store, got %+v", stats)
+	}
+	store, err = NewStoreWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if stats := published(); stats == nil || stats.Keys != 1 || stats.Latency["set"].Count != 0 {
+		t.Errorf("expected the stats of the reopened store, got %+v", stats)
+	}
+
+	// Names published by others are left alone
+	if expvar.Get("stonekv_test_taken") == nil {
+		expvar.NewInt("stonekv_test_taken")
+	}
+	_, err = NewStoreWithOptions(filepath.Join(t.TempDir(), "other.db"), Options{Expvar: "stonekv_test_taken"})
+	if err == nil {
+		t.Errorf("expected an error for a name already published")
+	}
+}
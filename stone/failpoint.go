@@ -0,0 +1,74 @@
+//go:build failpoints
+
+package stone
+
+import (
+	"os"
+	"sync"
+)
+
+// Failpoints, built in with the failpoints build tag, let tests make the
+// store fail, or the process crash, at the points where durability is
+// decided, to check that what was acknowledged survives:
+//
+//	go test -tags failpoints ./stone
+const (
+	// FailBeforeSync fails syncing the log, before the fsync.
+	FailBeforeSync = "before-sync"
+	// FailPartialWrite runs after writing only the first half of a record
+	// being appended: Crash leaves the record torn, like a crash mid-write,
+	// and an error fails the append, like a full disk.
+	FailPartialWrite = "partial-write"
+	// FailMidPolish fails Polish once the polished log is written and
+	// synced, just before it replaces the original.
+	FailMidPolish = "mid-polish"
+)
+
+var failpoints sync.Map // Name to the func() error it runs
+
+// SetFailpoint makes the failpoint name run fn whenever the store reaches
+// it, failing with the error fn returns, if any. Crash makes it end the
+// process instead.
+func SetFailpoint(name string, fn func() error) {
+	failpoints.Store(name, fn)
+}
+
+// ClearFailpoint disables the failpoint name.
+func ClearFailpoint(name string) {
+	failpoints.Delete(name)
+}
+
+// Crash ends the process at once, without closing or syncing anything,
+// like a crash of the process would. Pass it to SetFailpoint in a child
+// process, and reopen the store in the parent.
+func Crash() error {
+	os.Exit(3)
+	return nil
+}
+
+// writeRecord appends record to f, only writing its first half before
+// running FailPartialWrite, if set.
+func writeRecord(f File, record []byte) (int, error) {
+	fn, ok := failpoints.Load(FailPartialWrite)
+	if !ok {
+		return f.Write(record)
+	}
+	n, err := f.Write(record[:len(record)/2])
+	if err == nil {
+		err = fn.(func() error)()
+	}
+	if err != nil {
+		return n, err
+	}
+	m, err := f.Write(record[n:])
+	return n + m, err
+}
+
+// failpoint runs the failpoint name, if set.
+func failpoint(name string) error {
+	fn, ok := failpoints.Load(name)
+	if !ok {
+		return nil
+	}
+	return fn.(func() error)()
+}
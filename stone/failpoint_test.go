@@ -0,0 +1,164 @@
+//go:build failpoints
+
+package stone
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// crashScenarios run in a child process, see TestCrashHelper, which ends
+// at a failpoint set to Crash.
+var crashScenarios = map[string]func(store *Store){
+	FailBeforeSync: func(store *Store) {
+		SetFailpoint(FailBeforeSync, Crash)
+		store.Set([]byte("unsynced"), []byte("value"))
+	},
+	FailPartialWrite: func(store *Store) {
+		SetFailpoint(FailPartialWrite, Crash)
+		store.Set([]byte("torn"), []byte("value"))
+	},
+	FailMidPolish: func(store *Store) {
+		for i := 0; i < 100; i++ {
+			store.Set(crashKey(i), []byte("old"))
+		}
+		SetFailpoint(FailMidPolish, Crash)
+		store.Polish()
+	},
+}
+
+func crashKey(i int) []byte { return []byte(fmt.Sprintf("key%03d", i)) }
+
+// TestCrashHelper is the child process of runCrash: it writes 100 synced
+// pairs to the store at STONEKV_CRASH_PATH, then runs the scenario named
+// by STONEKV_CRASH.
+func TestCrashHelper(t *testing.T) {
+	scenario := os.Getenv("STONEKV_CRASH")
+	if scenario == "" {
+		t.Skip("only run by the crash tests")
+	}
+	store, err := NewStoreWithOptions(os.Getenv("STONEKV_CRASH_PATH"), Options{SyncWrites: true})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		store.Set(crashKey(i), []byte(fmt.Sprintf("value%d", i)))
+	}
+	crashScenarios[scenario](store)
+	t.Fatalf("expected scenario %s to crash", scenario)
+}
+
+// runCrash runs scenario in a child process and returns the path of its
+// store once it crashed.
+func runCrash(t *testing.T, scenario string) string {
+	path := filepath.Join(t.TempDir(), "test.db")
+	cmd := exec.Command(os.Args[0], "-test.run=^TestCrashHelper$")
+	cmd.Env = append(os.Environ(), "STONEKV_CRASH="+scenario, "STONEKV_CRASH_PATH="+path)
+	out, err := cmd.CombinedOutput()
+	var exit *exec.ExitError
+	if !errors.As(err, &exit) || exit.ExitCode() != 3 {
+		t.Fatalf("expected the child to crash, got %v:\n%s", err, out)
+	}
+	return path
+}
+
+// checkAcknowledged fails unless the store at path has the 100 pairs the
+// child wrote before its scenario, with values from value.
+func checkAcknowledged(t *testing.T, path string, value func(i int) string) {
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	for i := 0; i < 100; i++ {
+		got, err := store.Get(crashKey(i))
+		if err != nil || string(got) != value(i) {
+			t.Fatalf("expected %s to survive the crash, got %q (%v)", crashKey(i), got, err)
+		}
+	}
+}
+
+func TestCrashBeforeSync(t *testing.T) {
+	path := runCrash(t, FailBeforeSync)
+	checkAcknowledged(t, path, func(i int) string { return fmt.Sprintf("value%d", i) })
+}
+
+func TestCrashMidPolish(t *testing.T) {
+	path := runCrash(t, FailMidPolish)
+	checkAcknowledged(t, path, func(int) string { return "old" })
+}
+
+func TestCrashPartialWrite(t *testing.T) {
+	path := runCrash(t, FailPartialWrite)
+
+	// Check finds the torn record right after the acknowledged records
+	report, err := Check(path)
+	if err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+	torn := setRecordSize(len(crashKey(0)), len("value0"))*10 + setRecordSize(len(crashKey(10)), len("value10"))*90
+	if len(report.Problems) != 1 || report.Problems[0].Offset != torn || !report.Torn {
+		t.Errorf("expected a torn record at offset %d, got %+v", torn, report.Problems)
+	}
+
+	// Opening the store cuts it off, with a warning
+	var logged bytes.Buffer
+	store, err := NewStoreWithOptions(path, Options{Logger: slog.New(slog.NewTextHandler(&logged, nil))})
+	if err != nil {
+		t.Fatalf("expected a torn log to open, got %v", err)
+	}
+	if _, err := store.Get([]byte("torn")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected the torn key not to be found, got %v", err)
+	}
+	store.Close()
+	if !strings.Contains(logged.String(), "level=WARN") || !strings.Contains(logged.String(), fmt.Sprintf("offset=%d", torn)) {
+		t.Errorf("expected a warning about the torn record, got %q", logged.String())
+	}
+	checkAcknowledged(t, path, func(i int) string { return fmt.Sprintf("value%d", i) })
+	if report, _ := Check(path); report.NeedsRepair() {
+		t.Errorf("expected the log to be whole once opened, got %+v", report.Problems)
+	}
+}
+
+func TestFailpoints(t *testing.T) {
+	store, err := NewStoreWithOptions(filepath.Join(t.TempDir(), "test.db"), Options{SyncWrites: true})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	injected := errors.New("injected")
+
+	SetFailpoint(FailMidPolish, func() error { return injected })
+	store.Set([]byte("key"), []byte("value"))
+	if err := store.Polish(); !errors.Is(err, injected) {
+		t.Errorf("expected the injected error from Polish, got %v", err)
+	}
+	ClearFailpoint(FailMidPolish)
+	if err := store.Polish(); err != nil {
+		t.Errorf("expected polish to succeed once cleared, got %v", err)
+	}
+
+	// A failed append leaves nothing behind for the next one to land after
+	SetFailpoint(FailPartialWrite, func() error { return injected })
+	if err := store.Set([]byte("torn"), []byte("value")); !errors.Is(err, injected) {
+		t.Errorf("expected the injected error from Set, got %v", err)
+	}
+	ClearFailpoint(FailPartialWrite)
+	store.Set([]byte("after"), []byte("value"))
+	if value, err := store.Get([]byte("after")); err != nil || string(value) != "value" {
+		t.Errorf("expected value after a failed append, got %q (%v)", value, err)
+	}
+
+	SetFailpoint(FailBeforeSync, func() error { return injected })
+	defer ClearFailpoint(FailBeforeSync)
+	if err := store.Set([]byte("key"), []byte("value")); !errors.Is(err, injected) {
+		t.Errorf("expected the injected error from a synced Set, got %v", err)
+	}
+}
@@ -0,0 +1,223 @@
+package stone
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// FS returns a read-only view of the store as a file system, so templates
+// and assets stored in it can be served with http.FileServer(http.FS(...))
+// or parsed with template.ParseFS: keys are paths and their values the
+// contents of the files. Directories are implied by the keys, so the key
+// "assets/css/site.css" is the file site.css in the directory assets/css.
+// Keys that aren't valid paths, see fs.ValidPath, and those of lists and
+// the other data types, aren't in it, and a key that is also a directory,
+// like "assets" alongside "assets/logo.png", shows as the directory.
+//
+// A file holds the value its key had when it was opened. Directories list
+// the keys live when they were opened, which takes a pass over every key.
+func (s *Store) FS() fs.FS {
+	return storeFS{s}
+}
+
+// storeFS is the file system of Store.FS.
+type storeFS struct {
+	s *Store
+}
+
+// Open implements fs.FS.
+func (f storeFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if entries, ok := f.readDir(name); ok {
+		return &fsDir{info: fsInfo{name: path.Base(name), dir: true}, entries: entries}, nil
+	}
+	value, err := f.readFile("open", name)
+	if err != nil {
+		return nil, err
+	}
+	info := fsInfo{name: path.Base(name), size: int64(len(value))}
+	return &fsFile{Reader: bytes.NewReader(value), info: info}, nil
+}
+
+// ReadFile implements fs.ReadFileFS.
+func (f storeFS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: fs.ErrInvalid}
+	}
+	if _, ok := f.readDir(name); ok {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: errIsDir}
+	}
+	return f.readFile("readfile", name)
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (f storeFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	entries, ok := f.readDir(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	return entries, nil
+}
+
+// Stat implements fs.StatFS.
+func (f storeFS) Stat(name string) (fs.FileInfo, error) {
+	file, err := f.Open(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: errors.Unwrap(err)}
+	}
+	return file.Stat()
+}
+
+// errIsDir is the error of reading a directory as a file.
+var errIsDir = errors.New("is a directory")
+
+// readFile returns the value of the key name, failing with fs.ErrNotExist
+// if there is none.
+func (f storeFS) readFile(op, name string) ([]byte, error) {
+	if name == "." || strings.HasPrefix(name, "\x00") {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	s := f.s
+	value, ok, err := s.find(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	if s.tracksUse() {
+		s.index.touch(name)
+	}
+	s.readLimit.wait(len(value))
+	return value, nil
+}
+
+// readDir returns the entries of the directory name, sorted by name, and
+// whether it exists: the root always does, other directories if keys
+// start with their path followed by a slash.
+func (f storeFS) readDir(name string) ([]fs.DirEntry, bool) {
+	prefix := name + "/"
+	if name == "." {
+		prefix = ""
+	}
+	dirs := make(map[string]bool) // Whether each entry is a directory
+	for _, key := range f.s.sortedKeys([]byte(prefix)) {
+		if strings.HasPrefix(key, "\x00") || !fs.ValidPath(key) {
+			continue
+		}
+		rest := key[len(prefix):]
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			dirs[rest[:i]] = true
+		} else if !dirs[rest] {
+			dirs[rest] = false
+		}
+	}
+	if len(dirs) == 0 && name != "." {
+		return nil, false
+	}
+	entries := make([]fs.DirEntry, 0, len(dirs))
+	for entry, dir := range dirs {
+		entries = append(entries, fsEntry{f, path.Join(name, entry), dir})
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return entries, true
+}
+
+// fsInfo describes a file or directory of a storeFS.
+type fsInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (fi fsInfo) Name() string       { return fi.name }
+func (fi fsInfo) Size() int64        { return fi.size }
+func (fi fsInfo) ModTime() time.Time { return time.Time{} }
+func (fi fsInfo) IsDir() bool        { return fi.dir }
+func (fi fsInfo) Sys() any           { return nil }
+
+func (fi fsInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+// fsEntry is an entry of a directory of a storeFS. The size of a file is
+// read when Info is called.
+type fsEntry struct {
+	f    storeFS
+	path string
+	dir  bool
+}
+
+func (e fsEntry) Name() string { return path.Base(e.path) }
+func (e fsEntry) IsDir() bool  { return e.dir }
+
+func (e fsEntry) Type() fs.FileMode {
+	if e.dir {
+		return fs.ModeDir
+	}
+	return 0
+}
+
+func (e fsEntry) Info() (fs.FileInfo, error) {
+	if e.dir {
+		return fsInfo{name: e.Name(), dir: true}, nil
+	}
+	value, err := e.f.readFile("stat", e.path)
+	if err != nil {
+		return nil, err
+	}
+	return fsInfo{name: e.Name(), size: int64(len(value))}, nil
+}
+
+// fsFile is a file of a storeFS. It is an io.ReadSeeker and io.ReaderAt,
+// so http.FileServer can serve ranges of it.
+type fsFile struct {
+	*bytes.Reader
+	info fsInfo
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *fsFile) Close() error               { return nil }
+
+// fsDir is a directory of a storeFS.
+type fsDir struct {
+	info    fsInfo
+	entries []fs.DirEntry
+	offset  int // Entries already returned by ReadDir
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *fsDir) Close() error               { return nil }
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errIsDir}
+}
+
+// ReadDir implements fs.ReadDirFile.
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n > 0 {
+		if len(rest) == 0 {
+			return nil, io.EOF
+		}
+		rest = rest[:min(n, len(rest))]
+	}
+	d.offset += len(rest)
+	return rest, nil
+}
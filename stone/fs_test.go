@@ -0,0 +1,89 @@
+package stone
+
+import (
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"text/template"
+)
+
+func TestFS(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	for name, content := range map[string]string{
+		"index.html":             "<h1>home</h1>",
+		"assets":                 "hidden by the directory",
+		"assets/css/site.css":    "body {}",
+		"assets/logo.png":        "png",
+		"assets.bak":             "backup",
+		"templates/hello.tmpl":   "Hello, {{.}}!",
+		"templates/goodbye.tmpl": "Bye, {{.}}.",
+		"/absolute":              "not a path",
+		"trailing/":              "not a path",
+		"templates/../x":         "not a path",
+	} {
+		store.Set([]byte(name), []byte(content))
+	}
+	store.RPush([]byte("assets/list"), []byte("not a file either"))
+
+	fsys := store.FS()
+	err = fstest.TestFS(fsys, "index.html", "assets/css/site.css", "assets/logo.png",
+		"assets.bak", "templates/hello.tmpl", "templates/goodbye.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		t.Fatalf("failed to read root: %v", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if got := strings.Join(names, " "); got != "assets assets.bak index.html templates" {
+		t.Errorf("expected only the keys that are paths, got %s", got)
+	}
+	if info, err := fs.Stat(fsys, "assets"); err != nil || !info.IsDir() {
+		t.Errorf("expected assets to be a directory, got %v", err)
+	}
+
+	tmpl, err := template.ParseFS(fsys, "templates/*.tmpl")
+	if err != nil {
+		t.Fatalf("failed to parse templates: %v", err)
+	}
+	var out strings.Builder
+	err = tmpl.ExecuteTemplate(&out, "hello.tmpl", "world")
+	if err != nil || out.String() != "Hello, world!" {
+		t.Errorf("expected Hello, world!, got %q, %v", out.String(), err)
+	}
+
+	server := httptest.NewServer(http.FileServer(http.FS(fsys)))
+	defer server.Close()
+	req, _ := http.NewRequest("GET", server.URL+"/assets/css/site.css", nil)
+	req.Header.Set("Range", "bytes=0-3")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || string(body) != "body" {
+		t.Errorf("expected the first 4 bytes, got %d %q", resp.StatusCode, body)
+	}
+	resp, err = http.Get(server.URL + "/missing.html")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", resp.StatusCode)
+	}
+}
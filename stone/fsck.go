@@ -0,0 +1,131 @@
+package stone
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+)
+
+// CheckReport summarizes the log checked by Check.
+type CheckReport struct {
+	Records     int    // Records read, up to the first unreadable one
+	Sets        int    // Set records, including those stored as deltas
+	Deltas      int    // Set records stored as deltas, see Options.DeltaValues
+	Deletes     int    // Delete records
+	Batches     int    // Batch records, written by transactions
+	Checkpoints int    // Checkpoint records, written by Polish and replication
+	Legacy      int    // Records in the legacy format, which carry no checksum
+	Keys        int    // Keys live at the end of the readable log
+	Seq         uint64 // Highest sequence number in the readable log
+	Size        int64  // Size of the file
+	Valid       int64  // Offset up to which the log is readable, Size if all of it
+	Torn        bool   // The log ends in a record cut short, as a crash mid-write leaves
+	Problems    []CheckProblem
+}
+
+// CheckProblem is a record Check found wrong.
+type CheckProblem struct {
+	Offset int64  // Offset of the record in the log
+	Err    string // What is wrong with it
+}
+
+// NeedsRepair reports whether the log has problems: opening a store on it
+// fails if it can't be read to the end, short of a torn last record, which
+// opening cuts off, and reading the values of bad deltas fails.
+func (r CheckReport) NeedsRepair() bool {
+	return len(r.Problems) > 0
+}
+
+// Check reads the log of the store at path end to end without opening it
+// for writes, checking the framing and checksum of every record and that
+// every delta applies to the value it was written against. Problems found
+// are listed in the report rather than returned; reading stops at the first
+// record that can't be read, since the records after it can't be found.
+// The store may be open, but then only the log written before Check
+// started is read. It returns an error only if the file can't be read.
+func Check(path string) (CheckReport, error) {
+	var report CheckReport
+	file, err := os.Open(path)
+	if err != nil {
+		return report, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return report, fmt.Errorf("failed to stat file: %w", err)
+	}
+	report.Size = info.Size()
+
+	r := bufio.NewReaderSize(io.NewSectionReader(file, 0, report.Size), indexReadBuffer)
+	keys := make(map[string]struct{})
+	for report.Valid < report.Size {
+		offset := report.Valid
+		rec, err := readRecord(r)
+		if err == io.ErrUnexpectedEOF {
+			report.Torn = true
+			report.Problems = append(report.Problems, CheckProblem{offset, "record cut short by the end of the file"})
+			break
+		}
+		if err != nil {
+			report.Problems = append(report.Problems, CheckProblem{offset, err.Error()})
+			break
+		}
+
+		report.Records++
+		report.Valid += rec.size
+		switch rec.kind {
+		case typeSetLegacy:
+			report.Legacy++
+			report.Seq++
+			fallthrough
+		case typeSet:
+			report.Sets++
+			keys[string(rec.key)] = struct{}{}
+		case typeDelta:
+			report.Sets++
+			report.Deltas++
+			keys[string(rec.key)] = struct{}{}
+			err = rec.materialize(io.NewSectionReader(file, 0, offset))
+			if err != nil {
+				report.Problems = append(report.Problems, CheckProblem{offset, err.Error()})
+			}
+		case typeDeleteLegacy:
+			report.Legacy++
+			report.Seq++
+			fallthrough
+		case typeDelete:
+			report.Deletes++
+			delete(keys, string(rec.key))
+		case typeDeleteMulti:
+			deleted, err := rec.keys()
+			if err != nil {
+				report.Problems = append(report.Problems, CheckProblem{offset, err.Error()})
+			}
+			report.Deletes++
+			for _, key := range deleted {
+				delete(keys, string(key))
+			}
+		case typeBatch:
+			writes, err := rec.writes()
+			if err != nil {
+				report.Problems = append(report.Problems, CheckProblem{offset, err.Error()})
+			}
+			report.Batches++
+			for _, w := range writes {
+				if w.kind == typeDelete {
+					delete(keys, string(w.key))
+				} else {
+					keys[string(w.key)] = struct{}{}
+				}
+			}
+		case typeCheckpoint:
+			report.Checkpoints++
+		}
+		if rec.seq > report.Seq {
+			report.Seq = rec.seq
+		}
+	}
+	report.Keys = len(keys)
+	return report, nil
+}
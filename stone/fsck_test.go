@@ -0,0 +1,66 @@
+package stone
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewStoreWithOptions(path, Options{DeltaValues: true})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	value := bytes.Repeat([]byte("0123456789abcdef"), 64)
+	store.Set([]byte("doc"), value)
+	value[100] = 'x'
+	store.Set([]byte("doc"), value)
+	store.Set([]byte("a"), []byte("1"))
+	store.Delete([]byte("a"))
+	store.Close()
+
+	report, err := Check(path)
+	if err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+	if report.Records != 4 || report.Sets != 3 || report.Deltas != 1 || report.Deletes != 1 || report.Keys != 1 || report.Seq != 4 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if report.NeedsRepair() || report.Valid != report.Size {
+		t.Errorf("expected a sound log, got %+v", report)
+	}
+	data, _ := os.ReadFile(path)
+	deleteSize := int64(1 + 8 + 4 + 1 + 4)
+
+	// A crash mid-write leaves a record cut short
+	os.WriteFile(path, data[:len(data)-3], 0666)
+	report, _ = Check(path)
+	if !report.Torn || !report.NeedsRepair() || report.Records != 3 || report.Valid != int64(len(data))-deleteSize {
+		t.Errorf("expected a torn last record, got %+v", report)
+	}
+
+	// A flipped bit fails the checksum, and hides the records after it
+	bad := bytes.Clone(data)
+	bad[20] ^= 1
+	os.WriteFile(path, bad, 0666)
+	report, _ = Check(path)
+	if report.Torn || len(report.Problems) != 1 || report.Problems[0].Offset != 0 || report.Records != 0 {
+		t.Errorf("expected a checksum mismatch in the first record, got %+v", report)
+	}
+
+	// A delta against a corrupt base doesn't apply
+	first := setRecordSize(3, len(value))
+	bad = bytes.Clone(data)
+	delta := bad[first : int64(len(bad))-setRecordSize(1, 1)-deleteSize]
+	binary.LittleEndian.PutUint64(delta[1+8+4+3+4:], uint64(first))
+	binary.LittleEndian.PutUint32(delta[len(delta)-4:], crc32.ChecksumIEEE(delta[:len(delta)-4]))
+	os.WriteFile(path, bad, 0666)
+	report, _ = Check(path)
+	if len(report.Problems) != 1 || report.Problems[0].Offset != first || report.Records != 4 {
+		t.Errorf("expected a bad delta at offset %d, got %+v", first, report)
+	}
+}
@@ -0,0 +1,257 @@
+package stone
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// Geo sets are stored like sorted sets, as two keys per member: one
+// holding its position, for lookups by member, and one named after the
+// geohash of the position and the member. Members in the same geohash cell
+// share a key prefix, so GeoRadius reads the cells around the center of
+// the search with prefix scans rather than the whole set.
+
+// GeoMember is a member of a geo set and its position, in degrees.
+type GeoMember struct {
+	Member    []byte
+	Latitude  float64
+	Longitude float64
+}
+
+const (
+	geohashLength = 11 // Characters of stored geohashes, cells of about 15 cm
+	earthRadius   = 6371008.8
+)
+
+// geohashAlphabet is the base 32 alphabet of geohashes.
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// geohash returns the geohash of the position of length characters.
+func geohash(lat, lon float64, length int) string {
+	latRange, lonRange := [2]float64{-90, 90}, [2]float64{-180, 180}
+	hash := make([]byte, length)
+	even := true
+	for i := range hash {
+		var c byte
+		for range 5 {
+			r, v := &latRange, lat
+			if even {
+				r, v = &lonRange, lon
+			}
+			mid := (r[0] + r[1]) / 2
+			c <<= 1
+			if v >= mid {
+				c |= 1
+				r[0] = mid
+			} else {
+				r[1] = mid
+			}
+			even = !even
+		}
+		hash[i] = geohashAlphabet[c]
+	}
+	return string(hash)
+}
+
+// geohashCell returns the height and width in degrees of the geohash cells
+// of length characters.
+func geohashCell(length int) (height, width float64) {
+	bits := 5 * length
+	return 180 / math.Exp2(float64(bits/2)), 360 / math.Exp2(float64(bits-bits/2))
+}
+
+// geoDistance returns the distance in meters between two positions, along
+// the surface of the Earth taken as a sphere.
+func geoDistance(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dLat, dLon := (lat2-lat1)*rad, (lon2-lon1)*rad
+	a := math.Pow(math.Sin(dLat/2), 2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Pow(math.Sin(dLon/2), 2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(min(a, 1)))
+}
+
+// geoCells returns the geohashes of the cells whose prefix scans cover the
+// circle of radius meters around the position: the cell of the position and
+// its neighbors, of the longest length whose cells are larger than the
+// radius, or the empty geohash, covering everything, if none is.
+func geoCells(lat, lon, radius float64) []string {
+	// The narrowest the cells get within the circle, toward the pole
+	extent := radius / earthRadius * 180 / math.Pi
+	maxLat := math.Abs(lat) + extent
+	if maxLat >= 90 {
+		return []string{""}
+	}
+	length := geohashLength
+	for ; length > 0; length-- {
+		height, width := geohashCell(length)
+		if height >= extent && width*math.Cos(maxLat*math.Pi/180) >= extent {
+			break
+		}
+	}
+	if length == 0 {
+		return []string{""}
+	}
+	height, width := geohashCell(length)
+	var cells []string
+	for _, dLat := range []float64{-height, 0, height} {
+		for _, dLon := range []float64{-width, 0, width} {
+			cellLat, cellLon := lat+dLat, lon+dLon
+			if cellLat < -90 || cellLat > 90 {
+				continue
+			}
+			cellLon = math.Mod(cellLon+540, 360) - 180 // Across the antimeridian
+			if cell := geohash(cellLat, cellLon, length); !slices.Contains(cells, cell) {
+				cells = append(cells, cell)
+			}
+		}
+	}
+	return cells
+}
+
+func encodePosition(lat, lon float64) []byte {
+	buf := binary.BigEndian.AppendUint64(nil, math.Float64bits(lat))
+	return binary.BigEndian.AppendUint64(buf, math.Float64bits(lon))
+}
+
+func decodePosition(buf []byte) (lat, lon float64) {
+	return math.Float64frombits(binary.BigEndian.Uint64(buf)), math.Float64frombits(binary.BigEndian.Uint64(buf[8:]))
+}
+
+// geoHashKey returns the geohash key of member at the encoded position in
+// geo set name.
+func geoHashKey(name, member, position []byte) []byte {
+	lat, lon := decodePosition(position)
+	return typedKey(kindGeoHash, name, append([]byte(geohash(lat, lon, geohashLength)), member...))
+}
+
+// GeoAdd adds members to the geo set stored under key, creating the set if
+// it doesn't exist, or moves them if they are in it already. It returns how
+// many members were added. Latitudes must be within ±90 degrees and
+// longitudes within ±180.
+func (s *Store) GeoAdd(key []byte, members ...GeoMember) (int, error) {
+	var added int
+	err := s.write(func() error {
+		for _, m := range members {
+			if !(m.Latitude >= -90 && m.Latitude <= 90 && m.Longitude >= -180 && m.Longitude <= 180) {
+				return fmt.Errorf("invalid position %v,%v of %q", m.Latitude, m.Longitude, m.Member)
+			}
+			posKey := typedKey(kindGeo, key, m.Member)
+			old, ok, err := s.find(string(posKey))
+			if err != nil {
+				return err
+			}
+			position := encodePosition(m.Latitude, m.Longitude)
+			if ok && string(old) == string(position) {
+				continue
+			}
+			// The old geohash first, so a crash in between leaves the
+			// member out of searches, rather than in them twice, until it
+			// is added again
+			if ok && len(old) == 16 {
+				err = s.del(s.seq+1, geoHashKey(key, m.Member, old))
+				if err != nil {
+					return err
+				}
+			}
+			if !ok {
+				added++
+			}
+			err = s.set(s.seq+1, posKey, position)
+			if err != nil {
+				return err
+			}
+			err = s.set(s.seq+1, geoHashKey(key, m.Member, position), nil)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return added, err
+}
+
+// GeoRem removes members from the geo set stored under key and returns how
+// many of them were in it.
+func (s *Store) GeoRem(key []byte, members ...[]byte) (int, error) {
+	var removed int
+	err := s.write(func() error {
+		for _, member := range members {
+			posKey := typedKey(kindGeo, key, member)
+			position, ok, err := s.find(string(posKey))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			if len(position) == 16 {
+				err = s.del(s.seq+1, geoHashKey(key, member, position))
+				if err != nil {
+					return err
+				}
+			}
+			err = s.del(s.seq+1, posKey)
+			if err != nil {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// GeoRadius returns the members of the geo set stored under key within
+// radius meters of the position, nearest first. Distances are measured
+// along the surface of the Earth taken as a sphere, so they may be off by
+// up to 0.5%. A set that doesn't exist is empty.
+func (s *Store) GeoRadius(key []byte, lat, lon, radius float64) ([]GeoMember, error) {
+	if !(lat >= -90 && lat <= 90 && lon >= -180 && lon <= 180) {
+		return nil, fmt.Errorf("invalid position %v,%v", lat, lon)
+	}
+	if !(radius >= 0) {
+		return nil, fmt.Errorf("invalid radius %v", radius)
+	}
+	type hit struct {
+		member   GeoMember
+		distance float64
+	}
+	var hits []hit
+	seen := make(map[string]bool) // Members moved meanwhile may be in two cells
+	prefix := typedKey(kindGeoHash, key, nil)
+	for _, cell := range geoCells(lat, lon, radius) {
+		for _, k := range s.sortedKeys(typedKey(kindGeoHash, key, []byte(cell))) {
+			if len(k) < len(prefix)+geohashLength || seen[k[len(prefix)+geohashLength:]] {
+				continue
+			}
+			member := []byte(k[len(prefix)+geohashLength:])
+			seen[string(member)] = true
+			position, ok, err := s.find(string(typedKey(kindGeo, key, member)))
+			if err != nil {
+				return nil, err
+			}
+			if !ok || len(position) != 16 {
+				continue // Removed meanwhile
+			}
+			mLat, mLon := decodePosition(position)
+			if d := geoDistance(lat, lon, mLat, mLon); d <= radius {
+				hits = append(hits, hit{GeoMember{member, mLat, mLon}, d})
+			}
+		}
+	}
+	slices.SortFunc(hits, func(a, b hit) int {
+		if a.distance != b.distance {
+			if a.distance < b.distance {
+				return -1
+			}
+			return 1
+		}
+		return slices.Compare(a.member.Member, b.member.Member)
+	})
+	members := make([]GeoMember, len(hits))
+	for i, h := range hits {
+		members[i] = h.member
+	}
+	return members, nil
+}
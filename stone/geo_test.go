@@ -0,0 +1,88 @@
+package stone
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestGeo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	key := []byte("places")
+
+	n, err := store.GeoAdd(key,
+		GeoMember{[]byte("palermo"), 38.115556, 13.361389},
+		GeoMember{[]byte("catania"), 37.502669, 15.087269},
+		GeoMember{[]byte("rome"), 0, 0},
+		GeoMember{[]byte("suva"), -18.1416, 178.4419},
+		GeoMember{[]byte("taveuni"), -16.85, -179.95},
+	)
+	if err != nil || n != 5 {
+		t.Fatalf("expected 5 members added, got %d, %v", n, err)
+	}
+	// Adding a member again moves it
+	n, err = store.GeoAdd(key, GeoMember{[]byte("rome"), 41.9028, 12.4964})
+	if err != nil || n != 0 {
+		t.Errorf("expected no member added, got %d, %v", n, err)
+	}
+	_, err = store.GeoAdd(key, GeoMember{[]byte("nowhere"), 91, 0})
+	if err == nil {
+		t.Errorf("expected an invalid position to be rejected")
+	}
+	if d := geoDistance(38.115556, 13.361389, 37.502669, 15.087269); math.Abs(d-166274) > 500 {
+		t.Errorf("expected about 166 km from palermo to catania, got %v m", d)
+	}
+
+	check := func(store *Store, lat, lon, radius float64, want ...string) {
+		t.Helper()
+		members, err := store.GeoRadius(key, lat, lon, radius)
+		if err != nil {
+			t.Fatalf("radius failed: %v", err)
+		}
+		var got []string
+		for _, m := range members {
+			got = append(got, string(m.Member))
+		}
+		if len(got) != len(want) {
+			t.Errorf("expected %v within %v m of %v,%v, got %v", want, radius, lat, lon, got)
+			return
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Errorf("expected %v within %v m of %v,%v, got %v", want, radius, lat, lon, got)
+				return
+			}
+		}
+	}
+	check(store, 38.115556, 13.361389, 0, "palermo")
+	check(store, 37.5, 15, 200_000, "catania", "palermo")
+	check(store, 38, 14, 700_000, "palermo", "catania", "rome")
+	check(store, 40, 13, 10, nil...)
+	check(store, -17.5, 179.9, 300_000, "taveuni", "suva") // Across the antimeridian
+	check(store, 89.9, 0, 5_800_000, "rome", "palermo")    // Around the pole
+	check(store, 0, 0, 30_000_000, "catania", "palermo", "rome", "suva", "taveuni")
+
+	n, err = store.GeoRem(key, []byte("catania"), []byte("nowhere"))
+	if err != nil || n != 1 {
+		t.Errorf("expected 1 member removed, got %d, %v", n, err)
+	}
+	err = store.Polish()
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	store.Close()
+
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	check(store, 38, 14, 700_000, "palermo", "rome")
+	if stats := store.Stats(); stats.Keys != 8 {
+		t.Errorf("expected 2 keys per member, got %d keys", stats.Keys)
+	}
+}
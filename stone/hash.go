@@ -0,0 +1,63 @@
+package stone
+
+// Hashes are stored as a key per field, so updating a field rewrites only
+// its value rather than the whole object.
+
+// HSet sets field of the hash stored under key to value, creating the hash
+// if it doesn't exist.
+func (s *Store) HSet(key, field, value []byte) error {
+	return s.write(func() error {
+		return s.set(s.seq+1, typedKey(kindHash, key, field), value)
+	})
+}
+
+// HGet returns the value of field of the hash stored under key. It fails
+// with ErrKeyNotFound if the hash or the field doesn't exist.
+func (s *Store) HGet(key, field []byte) ([]byte, error) {
+	value, ok, err := s.find(string(typedKey(kindHash, key, field)))
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return value, err
+}
+
+// HDel removes fields from the hash stored under key and returns how many
+// of them were in it. A hash is deleted with its last field.
+func (s *Store) HDel(key []byte, fields ...[]byte) (int, error) {
+	var removed int
+	err := s.write(func() error {
+		for _, field := range fields {
+			element := typedKey(kindHash, key, field)
+			_, ok, err := s.find(string(element))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			err = s.del(s.seq+1, element)
+			if err != nil {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// HGetAll returns the fields of the hash stored under key and their values,
+// empty if the hash doesn't exist. Fields written or deleted meanwhile may
+// or may not be included, as with Scan.
+func (s *Store) HGetAll(key []byte) (map[string][]byte, error) {
+	prefix := typedKey(kindHash, key, nil)
+	fields := make(map[string][]byte)
+	err := s.scan(prefix, func(k, value []byte) error {
+		fields[string(k[len(prefix):])] = value
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
@@ -0,0 +1,47 @@
+package stone
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestHash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	key := []byte("user:1")
+
+	store.HSet(key, []byte("name"), []byte("Ada"))
+	store.HSet(key, []byte("email"), []byte("ada@example.com"))
+	store.HSet(key, []byte("name"), []byte("Ada L."))
+	value, err := store.HGet(key, []byte("name"))
+	if err != nil || string(value) != "Ada L." {
+		t.Errorf("expected the field to be updated, got %q, %v", value, err)
+	}
+	_, err = store.HGet(key, []byte("phone"))
+	if err == nil {
+		t.Errorf("expected a missing field not to be found")
+	}
+
+	n, err := store.HDel(key, []byte("email"), []byte("phone"))
+	if err != nil || n != 1 {
+		t.Errorf("expected 1 field removed, got %d, %v", n, err)
+	}
+	store.Close()
+
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	fields, err := store.HGetAll(key)
+	if err != nil || len(fields) != 1 || string(fields["name"]) != "Ada L." {
+		t.Errorf("expected only the name to be left, got %q, %v", fields, err)
+	}
+	fields, _ = store.HGetAll([]byte("user"))
+	if len(fields) != 0 {
+		t.Errorf("expected hashes not to share fields, got %q", fields)
+	}
+}
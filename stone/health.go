@@ -0,0 +1,47 @@
+package stone
+
+import (
+	"fmt"
+)
+
+// Health reports whether the store can serve requests: it must be open,
+// the last write to its log must have succeeded and no sync of it may have
+// failed. It does no I/O, so it is cheap enough for a liveness probe.
+func (s *Store) Health() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed() {
+		return ErrClosed
+	}
+	if s.writeErr != nil {
+		return fmt.Errorf("last write failed: %w", s.writeErr)
+	}
+	if err := s.syncErr(); err != nil {
+		return fmt.Errorf("sync failed: %w", err)
+	}
+	return nil
+}
+
+// CheckDisk reports whether the directory of the store is writable, by
+// writing, syncing and removing a small file next to the database. A full
+// or read-only disk fails the check before a write to the store does.
+func (s *Store) CheckDisk() error {
+	s.mu.RLock()
+	f, err := s.createTemp(".stonekv-probe-*")
+	s.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to create probe file: %w", err)
+	}
+	defer s.backend.Remove(f.Name())
+	_, err = f.Write([]byte("probe"))
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write probe file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package stone
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	if err = store.Health(); err != nil {
+		t.Errorf("expected open store to be healthy, got %v", err)
+	}
+	if err = store.CheckDisk(); err != nil {
+		t.Errorf("expected disk check to pass, got %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("expected the probe file to be removed, got %d files", len(entries))
+	}
+
+	// A failed write makes the store unhealthy until a write succeeds
+	file := store.file
+	store.file, _ = os.Open(file.Name())
+	if err = store.Set([]byte("key"), []byte("value")); err == nil {
+		t.Fatal("expected write to a read-only file to fail")
+	}
+	if err = store.Health(); err == nil {
+		t.Error("expected store to be unhealthy after a failed write")
+	}
+	store.file.Close()
+	store.file = file
+	if err = store.Set([]byte("key"), []byte("value")); err != nil || store.Health() != nil {
+		t.Errorf("expected store to recover after a successful write, got %v", store.Health())
+	}
+
+	store.Close()
+	if err = store.Health(); err == nil {
+		t.Error("expected closed store to be unhealthy")
+	}
+}
@@ -0,0 +1,207 @@
+package stone
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"slices"
+)
+
+// A HyperLogLog sketch estimates the number of distinct elements added to
+// it from hllRegisters registers, each holding the highest rank seen among
+// the hashes of the elements falling into it, with a standard error of
+// 0.81%. Sketches of few elements are stored sparse, as 3 bytes per
+// register in use, and dense, as a byte per register, once that is
+// smaller; either way a sketch is one value, never more than 16 KiB, and
+// PFAdd only writes it when a register changes.
+const (
+	hllPrecision = 14
+	hllRegisters = 1 << hllPrecision
+	hllSparse    = 's'
+	hllDense     = 'd'
+)
+
+// hllSketch is a decoded sketch: the registers in use, by index.
+type hllSketch map[uint16]uint8
+
+// decodeHLL decodes a sketch as stored by encode.
+func decodeHLL(value []byte) (hllSketch, error) {
+	sketch := make(hllSketch)
+	if len(value) == 0 {
+		return sketch, nil
+	}
+	switch data := value[1:]; value[0] {
+	case hllSparse:
+		if len(data)%3 != 0 {
+			return nil, fmt.Errorf("invalid sparse sketch of %d bytes", len(data))
+		}
+		for i := 0; i < len(data); i += 3 {
+			sketch[binary.BigEndian.Uint16(data[i:])] = data[i+2]
+		}
+	case hllDense:
+		if len(data) != hllRegisters {
+			return nil, fmt.Errorf("invalid dense sketch of %d bytes", len(data))
+		}
+		for i, rank := range data {
+			if rank != 0 {
+				sketch[uint16(i)] = rank
+			}
+		}
+	default:
+		return nil, fmt.Errorf("invalid sketch encoding %q", value[0])
+	}
+	return sketch, nil
+}
+
+// encode encodes the sketch in the smaller of the sparse and the dense
+// encodings.
+func (h hllSketch) encode() []byte {
+	if 3*len(h) >= hllRegisters {
+		value := make([]byte, 1+hllRegisters)
+		value[0] = hllDense
+		for i, rank := range h {
+			value[1+int(i)] = rank
+		}
+		return value
+	}
+	indexes := make([]uint16, 0, len(h))
+	for i := range h {
+		indexes = append(indexes, i)
+	}
+	slices.Sort(indexes)
+	value := make([]byte, 1, 1+3*len(h))
+	value[0] = hllSparse
+	for _, i := range indexes {
+		value = binary.BigEndian.AppendUint16(value, i)
+		value = append(value, h[i])
+	}
+	return value
+}
+
+// add adds element, reporting whether a register changed.
+func (h hllSketch) add(element []byte) bool {
+	f := fnv.New64a()
+	f.Write(element)
+	hash := mix64(f.Sum64())
+	i := uint16(hash >> (64 - hllPrecision))
+	rank := uint8(bits.LeadingZeros64(hash<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rank <= h[i] {
+		return false
+	}
+	h[i] = rank
+	return true
+}
+
+// count estimates the number of distinct elements added, with the
+// estimator of Ertl's "New cardinality estimation algorithms for
+// HyperLogLog sketches", which needs no empirical bias correction to be
+// precise for small and large numbers alike.
+func (h hllSketch) count() int {
+	const q = 64 - hllPrecision
+	const m = float64(hllRegisters)
+	var counts [q + 2]float64 // Registers by rank
+	counts[0] = m - float64(len(h))
+	for _, rank := range h {
+		counts[rank]++
+	}
+	if counts[0] == m {
+		return 0
+	}
+	z := m * hllTau(1-counts[q+1]/m)
+	for k := q; k >= 1; k-- {
+		z = 0.5 * (z + counts[k])
+	}
+	z += m * hllSigma(counts[0]/m)
+	return int(m*m/(2*math.Ln2*z) + 0.5)
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		prev := z
+		z += x * y
+		y += y
+		if z == prev {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		prev := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if z == prev {
+			return z / 3
+		}
+	}
+}
+
+// mix64 is the finalizer of MurmurHash3, spreading the bits of FNV hashes,
+// whose high bits, which pick the register, vary little for similar
+// elements.
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// PFAdd adds elements to the HyperLogLog sketch stored under key, creating
+// it if it doesn't exist, and reports whether the sketch changed, which it
+// does for most elements not added before and for none added before. The
+// elements themselves aren't stored, only the sketch, see PFCount.
+func (s *Store) PFAdd(key []byte, elements ...[]byte) (bool, error) {
+	var changed bool
+	err := s.write(func() error {
+		sketchKey := typedKey(kindHLL, key, nil)
+		value, ok, err := s.find(string(sketchKey))
+		if err != nil {
+			return err
+		}
+		sketch, err := decodeHLL(value)
+		if err != nil {
+			return err
+		}
+		for _, element := range elements {
+			if sketch.add(element) {
+				changed = true
+			}
+		}
+		if !changed && ok {
+			return nil
+		}
+		return s.set(s.seq+1, sketchKey, sketch.encode())
+	})
+	return changed, err
+}
+
+// PFCount returns the approximate number of distinct elements added to the
+// HyperLogLog sketch stored under key, 0 if it doesn't exist. The estimate
+// is typically within 1% of the exact number, and exact in practice for
+// small numbers.
+func (s *Store) PFCount(key []byte) (int, error) {
+	value, _, err := s.find(string(typedKey(kindHLL, key, nil)))
+	if err != nil {
+		return 0, err
+	}
+	sketch, err := decodeHLL(value)
+	if err != nil {
+		return 0, err
+	}
+	return sketch.count(), nil
+}
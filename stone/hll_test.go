@@ -0,0 +1,61 @@
+package stone
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	key := []byte("visitors")
+
+	changed, err := store.PFAdd(key, []byte("alice"), []byte("bob"), []byte("alice"))
+	if err != nil || !changed {
+		t.Fatalf("expected the sketch to change, got %v, %v", changed, err)
+	}
+	changed, _ = store.PFAdd(key, []byte("bob"))
+	if changed {
+		t.Errorf("expected an element added before not to change the sketch")
+	}
+	if n, err := store.PFCount(key); err != nil || n != 2 {
+		t.Errorf("expected 2 distinct elements, got %d, %v", n, err)
+	}
+	if n, _ := store.PFCount([]byte("missing")); n != 0 {
+		t.Errorf("expected a missing sketch to count 0, got %d", n)
+	}
+
+	// Large counts are estimated within a few standard errors, and the
+	// sketch turns dense on the way
+	const distinct = 100000
+	for i := 0; i < distinct; i += 1000 {
+		batch := make([][]byte, 1000)
+		for j := range batch {
+			batch[j] = []byte(fmt.Sprintf("user-%d", i+j))
+		}
+		_, err := store.PFAdd(key, batch...)
+		if err != nil {
+			t.Fatalf("add failed: %v", err)
+		}
+	}
+	store.Close()
+
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	n, err := store.PFCount(key)
+	if err != nil || math.Abs(float64(n-distinct-2))/distinct > 0.03 {
+		t.Errorf("expected about %d distinct elements, got %d, %v", distinct+2, n, err)
+	}
+	value, _ := store.Get(typedKey(kindHLL, key, nil))
+	if len(value) != 1+hllRegisters || value[0] != hllDense {
+		t.Errorf("expected a dense sketch, got %d bytes", len(value))
+	}
+}
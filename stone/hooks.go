@@ -0,0 +1,120 @@
+package stone
+
+import "slices"
+
+// Hooks are functions a store calls on mutations and maintenance, so
+// applications can wire metrics, audit trails or cache invalidation without
+// wrapping every call site. Register them with Store.Hook; fields left nil
+// are skipped.
+//
+// Hooks are called in the goroutine that made the change, after the store
+// is unlocked, so they may use the store, but the call that made the change
+// waits for them. Writes by one goroutine reach the hooks in order; writes
+// by concurrent goroutines may not, use Changes for a feed in log order.
+// Keys and values must not be modified or kept after a hook returns.
+type Hooks struct {
+	// OnSet is called for every key written, whether by Set, SetAsync,
+	// merges and copies, sync or replication.
+	OnSet func(key, value []byte)
+	// OnDelete is called for every key deleted, including the keys evicted
+	// by Options.CacheSize and EvictOnQuota.
+	OnDelete func(key []byte)
+	// OnCompactStart and OnCompactEnd are called when Polish starts and
+	// when it finishes, with the error it failed with, if any. Polish
+	// can't start again until they return.
+	OnCompactStart func()
+	OnCompactEnd   func(err error)
+	// OnBackup is called when Backup or BackupTo finishes, with the
+	// manifest of the backup or the error it failed with.
+	OnBackup func(m Manifest, err error)
+}
+
+// event is a write waiting for the hooks to be called, see Store.write.
+type event struct {
+	key, value []byte
+	deleted    bool
+}
+
+// Hook registers hooks with the store and returns a function that
+// unregisters them.
+func (s *Store) Hook(h Hooks) (unhook func()) {
+	hooks := &h
+	s.hooksMu.Lock()
+	defer s.hooksMu.Unlock()
+	all := append(slices.Clone(s.hookList()), hooks)
+	s.hooks.Store(&all)
+
+	return func() {
+		s.hooksMu.Lock()
+		defer s.hooksMu.Unlock()
+		all := slices.DeleteFunc(slices.Clone(s.hookList()), func(other *Hooks) bool {
+			return other == hooks
+		})
+		s.hooks.Store(&all)
+	}
+}
+
+// hookList returns the registered hooks.
+func (s *Store) hookList() []*Hooks {
+	if all := s.hooks.Load(); all != nil {
+		return *all
+	}
+	return nil
+}
+
+// queueEvent queues a write for the hooks, if any are registered. The
+// caller must hold the write lock.
+func (s *Store) queueEvent(e event) {
+	if len(s.hookList()) > 0 {
+		s.events = append(s.events, e)
+	}
+}
+
+// takeEvents returns the writes queued for the hooks and clears the queue.
+// The caller must hold the write lock.
+func (s *Store) takeEvents() []event {
+	events := s.events
+	s.events = nil
+	return events
+}
+
+// emit calls the hooks of the writes taken by takeEvents.
+func (s *Store) emit(events []event) {
+	for _, e := range events {
+		for _, h := range s.hookList() {
+			switch {
+			case e.deleted && h.OnDelete != nil:
+				h.OnDelete(e.key)
+			case !e.deleted && h.OnSet != nil:
+				h.OnSet(e.key, e.value)
+			}
+		}
+	}
+}
+
+// compactStarted calls the OnCompactStart hooks.
+func (s *Store) compactStarted() {
+	for _, h := range s.hookList() {
+		if h.OnCompactStart != nil {
+			h.OnCompactStart()
+		}
+	}
+}
+
+// compactEnded calls the OnCompactEnd hooks.
+func (s *Store) compactEnded(err error) {
+	for _, h := range s.hookList() {
+		if h.OnCompactEnd != nil {
+			h.OnCompactEnd(err)
+		}
+	}
+}
+
+// backedUp calls the OnBackup hooks.
+func (s *Store) backedUp(m Manifest, err error) {
+	for _, h := range s.hookList() {
+		if h.OnBackup != nil {
+			h.OnBackup(m, err)
+		}
+	}
+}
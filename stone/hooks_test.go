@@ -0,0 +1,72 @@
+package stone
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestHooks(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	var mu sync.Mutex
+	var events []string
+	add := func(format string, args ...any) {
+		mu.Lock()
+		events = append(events, fmt.Sprintf(format, args...))
+		mu.Unlock()
+	}
+	unhook := store.Hook(Hooks{
+		OnSet: func(key, value []byte) {
+			// Hooks run after the store is unlocked, so they may use it
+			current, err := store.Get(key)
+			if err != nil || string(current) != string(value) {
+				t.Errorf("expected the store to hold %q in its hook, got %q, %v", value, current, err)
+			}
+			add("set %s=%s", key, value)
+		},
+		OnDelete:       func(key []byte) { add("delete %s", key) },
+		OnCompactStart: func() { add("compact start") },
+		OnCompactEnd:   func(err error) { add("compact end %v", err) },
+		OnBackup:       func(m Manifest, err error) { add("backup %s %v", m.Name, err) },
+	})
+
+	store.Set([]byte("a"), []byte("1"))
+	<-store.SetAsync([]byte("b"), []byte("2"))
+	store.Delete([]byte("a"))
+	if err := store.Polish(); err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	if err := store.Backup(filepath.Join(dir, "backup.db"), BackupOptions{}); err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+	if err := store.BackupTo(DirDriver(filepath.Join(dir, "backups")), "nightly", BackupOptions{}); err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+
+	// Once unhooked, the hooks aren't called anymore
+	unhook()
+	store.Set([]byte("c"), []byte("3"))
+
+	want := []string{
+		"set a=1",
+		"set b=2",
+		"delete a",
+		"compact start",
+		"compact end <nil>",
+		"backup backup.db <nil>",
+		"backup nightly <nil>",
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Equal(events, want) {
+		t.Errorf("expected hooks %q, got %q", want, events)
+	}
+}
@@ -0,0 +1,330 @@
+package stone
+
+import (
+	"iter"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// indexShards is the number of shards the index is split into. Each has
+// its own lock, so Gets of different keys don't contend on one mutex and a
+// write only blocks the Gets of keys in the shard it updates.
+const indexShards = 64
+
+// keySeparators end the prefix of a hierarchical key, e.g. "users/42/" in
+// "users/42/name". Each shard stores a prefix once for all the keys that
+// share it, rather than once per key.
+const keySeparators = "/:"
+
+// index maps keys to the location of their live record. It is sharded by
+// key hash, and the locking rules are:
+//
+//   - Shards are only modified by goroutines holding the store's write lock
+//     and the lock of the shard, so holding the store lock in either mode
+//     is enough to read the whole index, and holding a shard's read lock
+//     is enough to read that shard.
+//   - The log file is only replaced or truncated while holding the store's
+//     write lock and every shard lock (see lock), so a Get holding a
+//     shard's read lock can read the value at an offset it found.
+type index struct {
+	shards [indexShards]indexShard
+	live   int64         // Total size of the live records, kept like the shards
+	clock  atomic.Uint64 // Ticks on every use recorded by touch
+}
+
+type indexShard struct {
+	mu      sync.RWMutex
+	entries map[indexKey]indexEntry
+
+	// Prefixes of the keys, by the number indexKey refers to them with.
+	// Number 0 is the empty prefix. Guarded by mu like entries.
+	prefixes []prefix
+	ids      map[string]uint32
+	free     []uint32 // Numbers of prefixes no key uses anymore
+	bytes    int64    // Length of the suffixes and prefixes held
+
+	usedMu    sync.Mutex
+	used      map[string]uint64 // Clock of the last use of keys, see touch
+	usedBytes int64             // Length of the keys in used
+}
+
+// indexKey is a key split into its prefix, kept by the shard, and the rest.
+type indexKey struct {
+	prefix uint32
+	suffix string
+}
+
+// prefix is a key prefix shared by refs keys of a shard.
+type prefix struct {
+	name string
+	refs int
+}
+
+// splitKey splits key after its last separator.
+func splitKey(key string) (prefix, suffix string) {
+	i := strings.LastIndexAny(key, keySeparators)
+	return key[:i+1], key[i+1:]
+}
+
+// find returns the indexKey of key, or false if no key with its prefix is
+// in the shard.
+func (sh *indexShard) find(key string) (indexKey, bool) {
+	prefix, suffix := splitKey(key)
+	if prefix == "" {
+		return indexKey{0, suffix}, true
+	}
+	id, ok := sh.ids[prefix]
+	return indexKey{id, suffix}, ok
+}
+
+// get looks up key. The caller must hold the store lock or the shard lock.
+func (sh *indexShard) get(key string) (indexEntry, bool) {
+	k, ok := sh.find(key)
+	if !ok {
+		return indexEntry{}, false
+	}
+	entry, ok := sh.entries[k]
+	return entry, ok
+}
+
+// set sets the entry of key and returns the previous one, if any.
+func (sh *indexShard) set(key string, entry indexEntry) (indexEntry, bool) {
+	k, ok := sh.find(key)
+	if ok {
+		if old, ok := sh.entries[k]; ok {
+			sh.entries[k] = entry
+			return old, true
+		}
+	}
+
+	// Copy what is kept, so the index doesn't pin the whole key
+	prefix, suffix := splitKey(key)
+	k = indexKey{sh.intern(prefix), strings.Clone(suffix)}
+	sh.entries[k] = entry
+	sh.bytes += int64(len(suffix))
+	return indexEntry{}, false
+}
+
+// delete deletes the entry of key and returns it, if there was one.
+func (sh *indexShard) delete(key string) (indexEntry, bool) {
+	k, ok := sh.find(key)
+	if !ok {
+		return indexEntry{}, false
+	}
+	old, ok := sh.entries[k]
+	if !ok {
+		return indexEntry{}, false
+	}
+	delete(sh.entries, k)
+	sh.bytes -= int64(len(k.suffix))
+	if k.prefix != 0 {
+		p := &sh.prefixes[k.prefix]
+		p.refs--
+		if p.refs == 0 {
+			sh.bytes -= int64(len(p.name))
+			delete(sh.ids, p.name)
+			*p = prefix{}
+			sh.free = append(sh.free, k.prefix)
+		}
+	}
+	return old, true
+}
+
+// intern returns the number of prefix, adding a reference to it.
+func (sh *indexShard) intern(name string) uint32 {
+	if name == "" {
+		return 0
+	}
+	id, ok := sh.ids[name]
+	if !ok {
+		name = strings.Clone(name)
+		if n := len(sh.free); n > 0 {
+			id = sh.free[n-1]
+			sh.free = sh.free[:n-1]
+			sh.prefixes[id] = prefix{name: name}
+		} else {
+			id = uint32(len(sh.prefixes))
+			sh.prefixes = append(sh.prefixes, prefix{name: name})
+		}
+		sh.ids[name] = id
+		sh.bytes += int64(len(name))
+	}
+	sh.prefixes[id].refs++
+	return id
+}
+
+// key returns the key k stands for.
+func (sh *indexShard) key(k indexKey) string {
+	if k.prefix == 0 {
+		return k.suffix
+	}
+	return sh.prefixes[k.prefix].name + k.suffix
+}
+
+func newIndex() *index {
+	x := &index{}
+	x.clear()
+	return x
+}
+
+// shard returns the shard holding key.
+func (x *index) shard(key string) *indexShard {
+	// FNV-1a
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return &x.shards[h%indexShards]
+}
+
+// get looks up key. The caller must hold the store lock or the read lock
+// of the key's shard.
+func (x *index) get(key string) (indexEntry, bool) {
+	return x.shard(key).get(key)
+}
+
+// put sets the entry of key. The caller must hold the store's write lock.
+func (x *index) put(key string, entry indexEntry) {
+	sh := x.shard(key)
+	sh.mu.Lock()
+	x.putUnlocked(key, entry)
+	sh.mu.Unlock()
+}
+
+// remove deletes the entry of key. The caller must hold the store's write
+// lock.
+func (x *index) remove(key string) {
+	sh := x.shard(key)
+	sh.mu.Lock()
+	x.removeUnlocked(key)
+	sh.mu.Unlock()
+
+	sh.usedMu.Lock()
+	n := len(sh.used)
+	delete(sh.used, key)
+	if len(sh.used) < n {
+		sh.usedBytes -= int64(len(key))
+	}
+	sh.usedMu.Unlock()
+}
+
+// putUnlocked is put for callers that hold every shard lock or have the
+// index to themselves.
+func (x *index) putUnlocked(key string, entry indexEntry) {
+	old, _ := x.shard(key).set(key, entry)
+	x.live += entry.size - old.size
+}
+
+// removeUnlocked is remove for callers that hold every shard lock or have
+// the index to themselves.
+func (x *index) removeUnlocked(key string) {
+	old, _ := x.shard(key).delete(key)
+	x.live -= old.size
+}
+
+// len returns the number of keys. The caller must hold the store lock.
+func (x *index) len() int {
+	n := 0
+	for i := range x.shards {
+		n += len(x.shards[i].entries)
+	}
+	return n
+}
+
+// all iterates over the keys and their entries in no particular order. The
+// caller must hold the store lock.
+func (x *index) all() iter.Seq2[string, indexEntry] {
+	return func(yield func(string, indexEntry) bool) {
+		for i := range x.shards {
+			sh := &x.shards[i]
+			for k, entry := range sh.entries {
+				if !yield(sh.key(k), entry) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Estimated memory taken by an entry of each map of a shard, besides the
+// bytes of its strings: maps are between half and fully loaded as they
+// grow, so a slot of each map is counted half again.
+const (
+	entryMemory  = (unsafe.Sizeof(indexKey{}) + unsafe.Sizeof(indexEntry{})) * 3 / 2
+	prefixMemory = unsafe.Sizeof(prefix{}) + (unsafe.Sizeof("")+4)*3/2
+	usedMemory   = (unsafe.Sizeof("") + 8) * 3 / 2
+)
+
+// memory estimates the bytes of memory the index takes. The caller must
+// hold the store lock.
+func (x *index) memory() int64 {
+	var n uintptr
+	var bytes int64
+	for i := range x.shards {
+		sh := &x.shards[i]
+		n += uintptr(len(sh.entries))*entryMemory + uintptr(len(sh.ids))*prefixMemory
+		bytes += sh.bytes
+		sh.usedMu.Lock()
+		n += uintptr(len(sh.used)) * usedMemory
+		bytes += sh.usedBytes
+		sh.usedMu.Unlock()
+	}
+	return int64(n) + bytes
+}
+
+// lock takes the lock of every shard, waiting for Gets in flight.
+func (x *index) lock() {
+	for i := range x.shards {
+		x.shards[i].mu.Lock()
+	}
+}
+
+func (x *index) unlock() {
+	for i := range x.shards {
+		x.shards[i].mu.Unlock()
+	}
+}
+
+// clear removes every key. The caller must hold the store's write lock and
+// every shard lock, or have the index to itself.
+func (x *index) clear() {
+	for i := range x.shards {
+		sh := &x.shards[i]
+		sh.entries = make(map[indexKey]indexEntry)
+		sh.prefixes = []prefix{{}}
+		sh.ids = make(map[string]uint32)
+		sh.free = nil
+		sh.bytes = 0
+	}
+	x.live = 0
+}
+
+// touch records a use of key, so the least recently used keys can be told
+// apart, e.g. to evict them with Options.CacheSize. It only takes a lock of
+// its own, so it may be called with or without the shard lock.
+func (x *index) touch(key string) {
+	sh := x.shard(key)
+	tick := x.clock.Add(1)
+	sh.usedMu.Lock()
+	if sh.used == nil {
+		sh.used = make(map[string]uint64)
+	}
+	n := len(sh.used)
+	sh.used[key] = tick
+	if len(sh.used) > n {
+		sh.usedBytes += int64(len(key))
+	}
+	sh.usedMu.Unlock()
+}
+
+// lastUsed returns the clock of the last use of key, 0 if it wasn't used
+// since the store was opened.
+func (x *index) lastUsed(key string) uint64 {
+	sh := x.shard(key)
+	sh.usedMu.Lock()
+	defer sh.usedMu.Unlock()
+	return sh.used[key]
+}
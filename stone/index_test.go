@@ -0,0 +1,123 @@
+package stone
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestGetDuringWrites(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	for i := 0; i < 100; i++ {
+		store.Set([]byte(fmt.Sprintf("key%d", i)), []byte("v"))
+	}
+
+	// Gets must find a value whatever writes and compactions are running
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			store.Set([]byte(fmt.Sprintf("key%d", i%100)), []byte(fmt.Sprintf("v%d", i)))
+			store.Set([]byte(fmt.Sprintf("tmp%d", i)), []byte("x"))
+			store.Delete([]byte(fmt.Sprintf("tmp%d", i)))
+			if i%50 == 0 {
+				store.Polish()
+			}
+		}
+	}()
+
+	errs := make(chan error, 4)
+	var readers sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		readers.Add(1)
+		go func(g int) {
+			defer readers.Done()
+			for i := 0; i < 2000; i++ {
+				key := fmt.Sprintf("key%d", (i+g)%100)
+				value, err := store.Get([]byte(key))
+				if err != nil || len(value) == 0 || value[0] != 'v' {
+					errs <- fmt.Errorf("get %s returned %q (%v)", key, value, err)
+					return
+				}
+			}
+		}(g)
+	}
+	readers.Wait()
+	close(stop)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if n := store.Stats().Keys; n != 100 {
+		t.Errorf("expected 100 keys, got %d", n)
+	}
+}
+
+func TestIndexPrefixes(t *testing.T) {
+	x := newIndex()
+	key := func(i int) string { return fmt.Sprintf("tenants/acme/users/%d", i) }
+	for i := 0; i < 1000; i++ {
+		x.putUnlocked(key(i), indexEntry{seq: uint64(i), size: 10})
+	}
+	x.putUnlocked("flat", indexEntry{seq: 1000, size: 10})
+	x.putUnlocked("a:b", indexEntry{seq: 1001, size: 10})
+	x.putUnlocked(key(0), indexEntry{seq: 1002, size: 10}) // Overwrite
+
+	// Each shard keeps the shared prefix once
+	prefixes := 0
+	for i := range x.shards {
+		prefixes += len(x.shards[i].ids)
+	}
+	if prefixes > indexShards+1 {
+		t.Errorf("expected at most one prefix per shard, plus \"a:\", got %d", prefixes)
+	}
+	if x.len() != 1002 || x.live != 1002*10 {
+		t.Errorf("expected 1002 keys of 10 bytes, got %d keys of %d bytes", x.len(), x.live)
+	}
+	if entry, ok := x.get(key(0)); !ok || entry.seq != 1002 {
+		t.Errorf("expected overwritten entry, got %v %v", entry, ok)
+	}
+	if _, ok := x.get("tenants/acme/users/"); ok {
+		t.Errorf("expected the bare prefix not to be a key")
+	}
+	seen := make(map[string]bool)
+	for k := range x.all() {
+		seen[k] = true
+	}
+	if len(seen) != 1002 || !seen[key(999)] || !seen["flat"] || !seen["a:b"] {
+		t.Errorf("expected all to yield the full keys, got %d", len(seen))
+	}
+
+	// Prefixes no key uses anymore are dropped, and their numbers reused
+	for i := 0; i < 1000; i++ {
+		x.removeUnlocked(key(i))
+	}
+	x.removeUnlocked("a:b")
+	for i := range x.shards {
+		if n := len(x.shards[i].ids); n != 0 {
+			t.Fatalf("expected no prefixes left in shard %d, got %d", i, n)
+		}
+	}
+	x.putUnlocked(key(1), indexEntry{seq: 1})
+	if entry, ok := x.get(key(1)); !ok || entry.seq != 1 {
+		t.Errorf("expected key under a reused prefix number, got %v %v", entry, ok)
+	}
+	if x.len() != 2 || x.live != 10 {
+		t.Errorf("expected 2 keys of 10 bytes in all, got %d and %d", x.len(), x.live)
+	}
+}
@@ -0,0 +1,45 @@
+package stone
+
+// Operation is a call to a store as seen by the interceptors of
+// Options.Interceptors.
+type Operation struct {
+	// Name is "get", "set", "set_async", "delete", "scan", "polish" or
+	// "backup".
+	Name string
+	// Key is the key of gets, sets and deletes, and the prefix of scans.
+	// Interceptors may replace it before calling the next handler.
+	Key []byte
+	// Value is the value of sets, which interceptors may replace like Key,
+	// and the value read by gets once the next handler returns.
+	Value []byte
+}
+
+// Handler carries out an operation, or passes it on to the next
+// interceptor.
+type Handler func(op *Operation) error
+
+// Interceptor wraps the handling of every operation on a store, like HTTP
+// middleware: it may inspect or change op, call next or fail without
+// calling it, and inspect the result, e.g. for logging, metrics, tracing or
+// validation.
+type Interceptor func(next Handler) Handler
+
+// Chain composes interceptors into one, the first outermost.
+func Chain(interceptors ...Interceptor) Interceptor {
+	return func(next Handler) Handler {
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			next = interceptors[i](next)
+		}
+		return next
+	}
+}
+
+// intercepted reports whether operations go through interceptors.
+func (s *Store) intercepted() bool {
+	return len(s.opts.Interceptors) > 0
+}
+
+// intercept carries out op with fn, through the interceptors.
+func (s *Store) intercept(op *Operation, fn Handler) error {
+	return Chain(s.opts.Interceptors...)(fn)(op)
+}
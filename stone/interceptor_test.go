@@ -0,0 +1,85 @@
+package stone
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestInterceptors(t *testing.T) {
+	var calls []string
+	logging := func(next Handler) Handler {
+		return func(op *Operation) error {
+			err := next(op)
+			calls = append(calls, fmt.Sprintf("%s %s=%s %v", op.Name, op.Key, op.Value, err))
+			return err
+		}
+	}
+	errEmpty := errors.New("empty key")
+	validation := func(next Handler) Handler {
+		return func(op *Operation) error {
+			if op.Name != "scan" && op.Key != nil && len(op.Key) == 0 {
+				return errEmpty
+			}
+			return next(op)
+		}
+	}
+	namespace := func(next Handler) Handler {
+		return func(op *Operation) error {
+			if op.Key != nil {
+				op.Key = append([]byte("app/"), op.Key...)
+			}
+			return next(op)
+		}
+	}
+
+	store, err := NewStoreWithOptions(filepath.Join(t.TempDir(), "test.db"), Options{
+		Interceptors: []Interceptor{logging, Chain(validation, namespace)},
+	})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	store.Set([]byte("a"), []byte("1"))
+	<-store.SetAsync([]byte("b"), []byte("2"))
+	value, err := store.Get([]byte("a"))
+	if err != nil || string(value) != "1" {
+		t.Errorf("expected '1', got %q, %v", value, err)
+	}
+	store.Delete([]byte("b"))
+	if err := store.Set([]byte{}, []byte("3")); err != errEmpty {
+		t.Errorf("expected the validation interceptor to fail the set, got %v", err)
+	}
+	if err := <-store.SetAsync([]byte{}, []byte("3")); err != errEmpty {
+		t.Errorf("expected the validation interceptor to fail the async set, got %v", err)
+	}
+	var keys []string
+	store.Scan([]byte(""), func(key, value []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	})
+	if err := store.Polish(); err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+
+	// Interceptors see the operations as the outer ones left them
+	want := []string{
+		"set app/a=1 <nil>",
+		"set_async app/b=2 <nil>",
+		"get app/a=1 <nil>",
+		"delete app/b= <nil>",
+		"set =3 empty key",
+		"set_async =3 empty key",
+		"scan app/= <nil>",
+		"polish = <nil>",
+	}
+	if !slices.Equal(calls, want) {
+		t.Errorf("expected calls %q, got %q", want, calls)
+	}
+	if !slices.Equal(keys, []string{"app/a"}) {
+		t.Errorf("expected the namespaced keys, got %q", keys)
+	}
+}
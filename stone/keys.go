@@ -0,0 +1,97 @@
+package stone
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Default limits on the sizes of keys and values written, see
+// Options.MaxKeySize and Options.MaxValueSize. The log frames both with
+// 4-byte lengths, so they could be up to 4 GiB, but few tools reading logs
+// or dumps cope with records anywhere near that.
+const (
+	DefaultMaxKeySize   = 64 << 10
+	DefaultMaxValueSize = 256 << 20
+)
+
+// ErrInvalidKey is returned by writes of a key that is empty or that
+// Options.ValidateKey rejects. The error of ValidateKey is wrapped too, so
+// errors.Is sees both.
+var ErrInvalidKey = errors.New("invalid key")
+
+// ErrEmptyKey is returned by writes of an empty key, but not by deletes, which tools reading
+// the log, like dumps and the HTTP server, can't tell from no key at all.
+// It is an ErrInvalidKey.
+var ErrEmptyKey = fmt.Errorf("%w: key is empty", ErrInvalidKey)
+
+// ErrReservedKey is returned by writes of a key starting with a zero byte,
+// which starts the keys of lists and the other data types, see typedKey, so
+// plain writes can't forge or overwrite their elements. Deletes don't
+// return it, so such keys written before the rule can still be removed. It
+// is an ErrInvalidKey.
+var ErrReservedKey = fmt.Errorf("%w: keys starting with a zero byte are reserved", ErrInvalidKey)
+
+// ErrKeyTooLarge is returned by writes of a key longer than
+// Options.MaxKeySize. It is an ErrInvalidKey.
+var ErrKeyTooLarge = fmt.Errorf("%w: key is too large", ErrInvalidKey)
+
+// ErrValueTooLarge is returned by writes of a value longer than
+// Options.MaxValueSize.
+var ErrValueTooLarge = errors.New("value is too large")
+
+// checkKey fails with ErrEmptyKey if key is empty, with ErrReservedKey if
+// it starts with a zero byte, or wraps the error of Options.ValidateKey in
+// ErrInvalidKey.
+func (s *Store) checkKey(key []byte) error {
+	if len(key) == 0 {
+		return ErrEmptyKey
+	}
+	if key[0] == typedKeyMarker {
+		return ErrReservedKey
+	}
+	if s.opts.ValidateKey == nil {
+		return nil
+	}
+	err := s.opts.ValidateKey(key)
+	if err != nil && !errors.Is(err, ErrInvalidKey) {
+		err = fmt.Errorf("%w: %w", ErrInvalidKey, err)
+	}
+	return err
+}
+
+// checkDeleted is checkKey for deletes, which accept empty keys and keys
+// starting with a zero byte without calling Options.ValidateKey, so keys
+// written before checkKey rejected them can still be removed.
+func (s *Store) checkDeleted(key []byte) error {
+	if len(key) == 0 || key[0] == typedKeyMarker {
+		return nil
+	}
+	return s.checkKey(key)
+}
+
+// checkPair is checkKey for the writes storing a value, also failing with
+// ErrKeyTooLarge or ErrValueTooLarge past Options.MaxKeySize or
+// Options.MaxValueSize. Deletes aren't limited, so keys written under a
+// larger limit can still be removed.
+func (s *Store) checkPair(key, value []byte) error {
+	err := s.checkKey(key)
+	if err != nil {
+		return err
+	}
+	if limit := sizeLimit(s.opts.MaxKeySize, DefaultMaxKeySize); len(key) > limit {
+		return fmt.Errorf("%w: %d bytes, at most %d", ErrKeyTooLarge, len(key), limit)
+	}
+	if limit := sizeLimit(s.opts.MaxValueSize, DefaultMaxValueSize); len(value) > limit {
+		return fmt.Errorf("%w: %d bytes, at most %d", ErrValueTooLarge, len(value), limit)
+	}
+	return nil
+}
+
+// sizeLimit is the limit set, or def if it is 0, capped at what the 4-byte
+// length of a record can frame.
+func sizeLimit(set, def int) int {
+	if set <= 0 {
+		return def
+	}
+	return int(min(int64(set), 1<<32-1))
+}
@@ -0,0 +1,142 @@
+package stone
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyValidation(t *testing.T) {
+	errSlash := errors.New("keys must not start with a slash")
+	store, err := NewStoreWithOptions(filepath.Join(t.TempDir(), "test.db"), Options{
+		ValidateKey: func(key []byte) error {
+			if len(key) > 16 {
+				return fmt.Errorf("key longer than 16 bytes")
+			}
+			if key[0] == '/' {
+				return errSlash
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Set([]byte("user/1"), []byte("alice")); err != nil {
+		t.Fatalf("expected a valid key to be set, got %v", err)
+	}
+	if err := store.Set(nil, []byte("value")); !errors.Is(err, ErrEmptyKey) || !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("expected ErrEmptyKey for an empty key, got %v", err)
+	}
+	err = store.Set([]byte("/user/1"), []byte("alice"))
+	if !errors.Is(err, ErrInvalidKey) || !errors.Is(err, errSlash) {
+		t.Errorf("expected ErrInvalidKey wrapping the validator's error, got %v", err)
+	}
+	if err := <-store.SetAsync([]byte("a very long key indeed"), nil); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("expected ErrInvalidKey from SetAsync, got %v", err)
+	}
+	if _, err := store.SetIfVersion([]byte("/x"), nil, 0); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("expected ErrInvalidKey from SetIfVersion, got %v", err)
+	}
+	if err := store.Delete([]byte("")); err != nil {
+		t.Errorf("expected Delete to accept an empty key, got %v", err)
+	}
+	if err := store.DeleteMulti([][]byte{[]byte("user/1"), []byte("/x")}); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("expected ErrInvalidKey from DeleteMulti, got %v", err)
+	}
+	if _, err := store.Get([]byte("user/1")); err != nil {
+		t.Errorf("expected a rejected DeleteMulti to delete nothing, got %v", err)
+	}
+
+	tx := store.Begin()
+	if err := tx.Set([]byte("/x"), nil); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("expected ErrInvalidKey from Txn.Set, got %v", err)
+	}
+	if err := tx.Delete(nil); err != nil {
+		t.Errorf("expected Txn.Delete to accept an empty key, got %v", err)
+	}
+	tx.Rollback()
+
+	// Data types store their elements in keys of their own, not checked
+	if err := store.HSet([]byte("/hash"), []byte("field"), []byte("value")); err != nil {
+		t.Errorf("expected HSet not to be validated, got %v", err)
+	}
+	if stats := store.Stats(); stats.Keys != 2 {
+		t.Errorf("expected only the valid writes, got %d keys", stats.Keys)
+	}
+
+	// and plain sets can't forge them
+	var forged []byte
+	store.Scan([]byte{typedKeyMarker}, func(key, value []byte) error {
+		forged = key
+		return nil
+	})
+	if err := store.Set(forged, []byte("forged")); !errors.Is(err, ErrReservedKey) || !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("expected ErrReservedKey for a typed key, got %v", err)
+	}
+	if value, err := store.HGet([]byte("/hash"), []byte("field")); err != nil || string(value) != "value" {
+		t.Errorf("expected the hash field to be untouched, got %q, %v", value, err)
+	}
+
+	// Keys written before the rules can still be deleted
+	keys := store.Stats().Keys
+	legacy := [][]byte{nil, {typedKeyMarker, 'x'}, {typedKeyMarker, 'y'}}
+	for _, key := range legacy {
+		store.write(func() error {
+			return store.set(store.seq+1, key, []byte("old"))
+		})
+	}
+	if err := store.Delete(legacy[0]); err != nil {
+		t.Errorf("expected an empty key to be deleted, got %v", err)
+	}
+	if err := store.DeleteMulti(legacy[1:]); err != nil {
+		t.Errorf("expected keys starting with a zero byte to be deleted, got %v", err)
+	}
+	if stats := store.Stats(); stats.Keys != keys {
+		t.Errorf("expected the legacy keys to be gone, got %d keys, want %d", stats.Keys, keys)
+	}
+}
+
+func TestSizeLimits(t *testing.T) {
+	store, err := NewStoreWithOptions(filepath.Join(t.TempDir(), "test.db"), Options{
+		MaxKeySize:   8,
+		MaxValueSize: 16,
+	})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Set([]byte("12345678"), make([]byte, 16)); err != nil {
+		t.Fatalf("expected a pair at the limits to be set, got %v", err)
+	}
+	err = store.Set([]byte("123456789"), nil)
+	if !errors.Is(err, ErrKeyTooLarge) || !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("expected ErrKeyTooLarge, got %v", err)
+	}
+	if err := store.Set([]byte("key"), make([]byte, 17)); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("expected ErrValueTooLarge, got %v", err)
+	}
+	if err := <-store.SetAsync([]byte("key"), make([]byte, 17)); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("expected ErrValueTooLarge from SetAsync, got %v", err)
+	}
+	if _, err := store.SetIfVersion([]byte("key"), make([]byte, 17), 0); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("expected ErrValueTooLarge from SetIfVersion, got %v", err)
+	}
+	tx := store.Begin()
+	if err := tx.Set([]byte("key"), make([]byte, 17)); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("expected ErrValueTooLarge from Txn.Set, got %v", err)
+	}
+	tx.Rollback()
+
+	// Deletes aren't limited, so keys written under larger limits can go
+	if err := store.Delete([]byte("123456789")); err != nil {
+		t.Errorf("expected Delete not to be limited, got %v", err)
+	}
+	if stats := store.Stats(); stats.Keys != 1 {
+		t.Errorf("expected only the pair within the limits, got %d keys", stats.Keys)
+	}
+}
@@ -0,0 +1,113 @@
+package stone
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// Operations whose latency the store tracks, see Stats.Latency.
+const (
+	opGet = iota
+	opSet
+	opDelete
+	opScan
+	opPolish
+	opBackup
+	numOps
+)
+
+// opNames are the names of the tracked operations, as in Operation.Name.
+var opNames = [numOps]string{"get", "set", "delete", "scan", "polish", "backup"}
+
+// Latency buckets: durations under 8ns have one each, then each power of
+// two is split in 4, so a percentile is within 25% of the true value. The
+// last bucket holds everything from about 4 hours up.
+const (
+	latencyOctaves = 45
+	latencyBuckets = 8 + (latencyOctaves-4)*4
+)
+
+// Latency summarizes how long an operation took, over every call since the
+// store was opened. Percentiles are upper bounds, within 25% of the
+// durations they estimate.
+type Latency struct {
+	Count         uint64
+	Total         time.Duration // Sum of the durations
+	P50, P95, P99 time.Duration
+}
+
+// latencies counts the durations of each tracked operation in buckets.
+type latencies struct {
+	buckets [numOps][latencyBuckets]atomic.Uint64
+	total   [numOps]atomic.Int64
+}
+
+// observe records an operation that started at start.
+func (l *latencies) observe(op int, start time.Time) {
+	d := time.Since(start)
+	l.buckets[op][latencyBucket(d)].Add(1)
+	l.total[op].Add(int64(d))
+}
+
+// latencyBucket returns the bucket d falls in.
+func latencyBucket(d time.Duration) int {
+	v := uint64(max(d, 0))
+	if v < 8 {
+		return int(v)
+	}
+	n := min(bits.Len64(v), latencyOctaves)
+	if n == latencyOctaves {
+		return latencyBuckets - 1
+	}
+	return 8 + (n-4)*4 + int(v>>(n-3)) - 4
+}
+
+// latencyBound returns the largest duration bucket i holds.
+func latencyBound(i int) time.Duration {
+	if i < 8 {
+		return time.Duration(i)
+	}
+	n, sub := (i-8)/4+4, (i-8)%4
+	return time.Duration((5+sub)<<(n-3) - 1)
+}
+
+// summary returns the Latency of op.
+func (l *latencies) summary(op int) Latency {
+	var counts [latencyBuckets]uint64
+	var total uint64
+	for i := range counts {
+		counts[i] = l.buckets[op][i].Load()
+		total += counts[i]
+	}
+	if total == 0 {
+		return Latency{}
+	}
+	percentile := func(q float64) time.Duration {
+		rank := max(uint64(q*float64(total)+0.5), 1)
+		var seen uint64
+		for i, n := range counts {
+			seen += n
+			if seen >= rank {
+				return latencyBound(i)
+			}
+		}
+		return latencyBound(latencyBuckets - 1)
+	}
+	return Latency{
+		Count: total,
+		Total: time.Duration(l.total[op].Load()),
+		P50:   percentile(0.50),
+		P95:   percentile(0.95),
+		P99:   percentile(0.99),
+	}
+}
+
+// summaries returns the Latency of every tracked operation, by name.
+func (l *latencies) summaries() map[string]Latency {
+	m := make(map[string]Latency, numOps)
+	for op, name := range opNames {
+		m[name] = l.summary(op)
+	}
+	return m
+}
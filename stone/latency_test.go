@@ -0,0 +1,53 @@
+package stone
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLatency(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	for i := 0; i < 10; i++ {
+		store.Set([]byte("a"), []byte("1"))
+	}
+	store.Get([]byte("a"))
+	store.Get([]byte("missing"))
+	store.Delete([]byte("a"))
+
+	latency := store.Stats().Latency
+	for name, count := range map[string]uint64{"set": 10, "get": 2, "delete": 1, "scan": 0, "polish": 0, "backup": 0} {
+		l, ok := latency[name]
+		if !ok || l.Count != count {
+			t.Errorf("expected %d %s calls, got %+v", count, name, l)
+		}
+		if count > 0 && (l.P50 <= 0 || l.P50 > l.P95 || l.P95 > l.P99 || l.Total <= 0) {
+			t.Errorf("expected ordered %s percentiles, got %+v", name, l)
+		}
+	}
+
+	// Percentiles are bucket bounds within 25% of the durations
+	var l latencies
+	for i := 0; i < 1000; i++ {
+		d := time.Duration(i+1) * time.Microsecond
+		l.buckets[opGet][latencyBucket(d)].Add(1)
+	}
+	got := l.summary(opGet)
+	for _, p := range []struct {
+		got, want time.Duration
+	}{{got.P50, 500 * time.Microsecond}, {got.P95, 950 * time.Microsecond}, {got.P99, 990 * time.Microsecond}} {
+		if p.got < p.want || p.got > p.want*5/4 {
+			t.Errorf("expected a percentile within 25%% above %v, got %v", p.want, p.got)
+		}
+	}
+	for _, d := range []time.Duration{0, 7, 8, 9, 1000, time.Second, time.Hour, 1<<63 - 1} {
+		i := latencyBucket(d)
+		if d > latencyBound(i) && i != latencyBuckets-1 || i > 0 && d <= latencyBound(i-1) {
+			t.Errorf("expected %v in bucket %d, bounded by %v", d, i, latencyBound(i))
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package stone
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// listMeta is the metadata of a list: the position of its first element
+// and the one after its last. Positions grow to the right; LPush takes the
+// ones left of head.
+type listMeta struct {
+	head, tail int64
+}
+
+func (m listMeta) encode() []byte {
+	buf := make([]byte, 16)
+	binary.LittleEndian.PutUint64(buf, uint64(m.head))
+	binary.LittleEndian.PutUint64(buf[8:], uint64(m.tail))
+	return buf
+}
+
+// listElement returns the key of the element of list name at pos. Positions
+// are encoded so that the keys sort in list order.
+func listElement(name []byte, pos int64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(pos)^1<<63)
+	return typedKey(kindList, name, buf[:])
+}
+
+// listMeta reads the metadata of list name, reporting whether the list
+// exists. The caller must hold the write lock or its read side.
+func (s *Store) listMeta(name []byte) (listMeta, bool, error) {
+	value, ok, err := s.find(string(typedKey(kindList, name, nil)))
+	if !ok || err != nil {
+		return listMeta{}, false, err
+	}
+	if len(value) != 16 {
+		return listMeta{}, false, fmt.Errorf("invalid metadata of list %q", name)
+	}
+	m := listMeta{
+		head: int64(binary.LittleEndian.Uint64(value)),
+		tail: int64(binary.LittleEndian.Uint64(value[8:])),
+	}
+	return m, true, nil
+}
+
+// LPush inserts values at the head of the list stored under key, creating
+// the list if it doesn't exist, and returns its new length. The values are
+// inserted one after the other, so they end up in reverse order. Every
+// element is a record of its own, so pushing doesn't rewrite the list.
+func (s *Store) LPush(key []byte, values ...[]byte) (int, error) {
+	return s.push(key, values, true)
+}
+
+// RPush appends values to the tail of the list stored under key, creating
+// the list if it doesn't exist, and returns its new length.
+func (s *Store) RPush(key []byte, values ...[]byte) (int, error) {
+	return s.push(key, values, false)
+}
+
+// push is LPush, or RPush if left is false.
+func (s *Store) push(name []byte, values [][]byte, left bool) (int, error) {
+	var length int
+	err := s.write(func() error {
+		m, _, err := s.listMeta(name)
+		if err != nil {
+			return err
+		}
+		length = int(m.tail - m.head)
+		if len(values) == 0 {
+			return nil
+		}
+		// The elements first, so a crash before the metadata is written
+		// leaves them outside the list, to be overwritten by the next push
+		for _, value := range values {
+			pos := m.tail
+			if left {
+				m.head--
+				pos = m.head
+			} else {
+				m.tail++
+			}
+			err = s.set(s.seq+1, listElement(name, pos), value)
+			if err != nil {
+				return err
+			}
+		}
+		err = s.set(s.seq+1, typedKey(kindList, name, nil), m.encode())
+		if err != nil {
+			return err
+		}
+		length = int(m.tail - m.head)
+		return nil
+	})
+	return length, err
+}
+
+// LPop removes and returns the first element of the list stored under key.
+// A list is deleted with its last element; popping from a list that doesn't
+// exist fails with ErrKeyNotFound.
+func (s *Store) LPop(key []byte) ([]byte, error) {
+	return s.pop(key, true)
+}
+
+// RPop removes and returns the last element of the list stored under key.
+func (s *Store) RPop(key []byte) ([]byte, error) {
+	return s.pop(key, false)
+}
+
+// pop is LPop, or RPop if left is false.
+func (s *Store) pop(name []byte, left bool) ([]byte, error) {
+	var value []byte
+	err := s.write(func() error {
+		m, ok, err := s.listMeta(name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrKeyNotFound
+		}
+		pos := m.head
+		if left {
+			m.head++
+		} else {
+			m.tail--
+			pos = m.tail
+		}
+		element := listElement(name, pos)
+		value, _, err = s.find(string(element))
+		if err != nil {
+			return err
+		}
+
+		// The metadata first, so a crash before the element is deleted
+		// leaves it outside the list
+		meta := typedKey(kindList, name, nil)
+		if m.head == m.tail {
+			err = s.del(s.seq+1, meta)
+		} else {
+			err = s.set(s.seq+1, meta, m.encode())
+		}
+		if err != nil {
+			return err
+		}
+		return s.del(s.seq+1, element)
+	})
+	return value, err
+}
+
+// LRange returns the elements of the list stored under key from index start
+// to stop, both included. Negative indexes count from the end of the list,
+// -1 being its last element. Indexes out of the list are clamped to it, so
+// LRange(key, 0, -1) returns the whole list, and a list that doesn't exist
+// is empty. Writes wait while the elements are read, so they are those of
+// one version of the list.
+func (s *Store) LRange(key []byte, start, stop int) ([][]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m, _, err := s.listMeta(key)
+	if err != nil {
+		return nil, err
+	}
+	length := m.tail - m.head
+	first, last := int64(start), int64(stop)
+	if first < 0 {
+		first += length
+	}
+	if last < 0 {
+		last += length
+	}
+	first, last = max(first, 0), min(last, length-1)
+
+	values := make([][]byte, 0, max(last-first+1, 0))
+	for i := first; i <= last; i++ {
+		value, ok, err := s.find(string(listElement(key, m.head+i)))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			values = append(values, value)
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,93 @@
+package stone
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	key := []byte("feed")
+	rangeOf := func(store *Store, start, stop int) []string {
+		t.Helper()
+		values, err := store.LRange(key, start, stop)
+		if err != nil {
+			t.Fatalf("range failed: %v", err)
+		}
+		var strs []string
+		for _, value := range values {
+			strs = append(strs, string(value))
+		}
+		return strs
+	}
+
+	n, err := store.RPush(key, []byte("c"), []byte("d"))
+	if err != nil || n != 2 {
+		t.Fatalf("expected a length of 2, got %d, %v", n, err)
+	}
+	n, err = store.LPush(key, []byte("b"), []byte("a"))
+	if err != nil || n != 4 {
+		t.Fatalf("expected a length of 4, got %d, %v", n, err)
+	}
+	if got := rangeOf(store, 0, -1); !slices.Equal(got, []string{"a", "b", "c", "d"}) {
+		t.Errorf("expected [a b c d], got %v", got)
+	}
+	if got := rangeOf(store, -3, 1); !slices.Equal(got, []string{"b"}) {
+		t.Errorf("expected [b], got %v", got)
+	}
+	if got := rangeOf(store, 2, 100); !slices.Equal(got, []string{"c", "d"}) {
+		t.Errorf("expected [c d], got %v", got)
+	}
+	if got := rangeOf(store, 3, 1); len(got) != 0 {
+		t.Errorf("expected an empty range, got %v", got)
+	}
+
+	// Lists don't share keys with each other or with user keys
+	store.Set(key, []byte("plain"))
+	store.RPush([]byte("fee"), []byte("other"))
+	if got := rangeOf(store, 0, -1); len(got) != 4 {
+		t.Errorf("expected the list to be left alone, got %v", got)
+	}
+
+	value, err := store.LPop(key)
+	if err != nil || string(value) != "a" {
+		t.Errorf("expected to pop a, got %q, %v", value, err)
+	}
+	value, err = store.RPop(key)
+	if err != nil || string(value) != "d" {
+		t.Errorf("expected to pop d, got %q, %v", value, err)
+	}
+	err = store.Polish()
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	store.Close()
+
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if got := rangeOf(store, 0, -1); !slices.Equal(got, []string{"b", "c"}) {
+		t.Errorf("expected [b c] after reopening, got %v", got)
+	}
+
+	// A list goes with its last element
+	store.RPop(key)
+	store.RPop(key)
+	_, err = store.LPop(key)
+	if err == nil {
+		t.Errorf("expected popping an empty list to fail")
+	}
+	if stats := store.Stats(); stats.Keys != 3 {
+		t.Errorf("expected only the plain key and the other list left, got %d keys", stats.Keys)
+	}
+	if value, err := store.Get(key); err != nil || string(value) != "plain" {
+		t.Errorf("expected the plain key to be left alone, got %q, %v", value, err)
+	}
+}
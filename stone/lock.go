@@ -0,0 +1,153 @@
+package stone
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Advisory locks are stored as a key per lock, holding when it expires and
+// the token of whoever took it, so they are persisted like any other
+// write: a lock taken before a crash or restart is still held afterwards,
+// until it expires or is unlocked. They lock nothing in the store
+// themselves; they only exclude each other.
+
+// ErrNotLocked is returned by Unlock when the lock isn't held, or is held
+// by someone else.
+var ErrNotLocked = errors.New("lock is not held")
+
+// LockToken identifies one taking of an advisory lock, so that only
+// whoever took it can Unlock it: once a lock expired and someone else took
+// it, the token of the first holder no longer unlocks it.
+type LockToken uint64
+
+// lockKey returns the key of the advisory lock key.
+func lockKey(key []byte) []byte {
+	return typedKey(kindLock, key, nil)
+}
+
+// lockHeld reports whether the advisory lock key is held, until when, the
+// zero time for a lock held until unlocked, and by whom. The caller must
+// hold the write lock.
+func (s *Store) lockHeld(key []byte) (bool, time.Time, LockToken, error) {
+	value, ok, err := s.find(string(lockKey(key)))
+	if err != nil || !ok {
+		return false, time.Time{}, 0, err
+	}
+	if len(value) != 8 && len(value) != 16 {
+		return true, time.Time{}, 0, nil
+	}
+	var token LockToken
+	if len(value) == 16 {
+		token = LockToken(binary.BigEndian.Uint64(value[8:]))
+	}
+	deadline := int64(binary.BigEndian.Uint64(value))
+	if deadline == 0 {
+		return true, time.Time{}, token, nil
+	}
+	until := time.Unix(0, deadline)
+	return s.clock.Now().Before(until), until, token, nil
+}
+
+// TryLock takes the advisory lock key if it isn't held, and reports
+// whether it did, with the token to Unlock it with. The lock is held for
+// ttl, or until Unlock if ttl is 0, so a holder that dies with a ttl
+// doesn't keep it forever. Locks are advisory: they are shared by everyone
+// using the store, goroutines and server clients alike, and don't keep
+// anyone from using the keys they stand for.
+func (s *Store) TryLock(key []byte, ttl time.Duration) (LockToken, bool, error) {
+	token, ok, _, _, err := s.tryLock(key, ttl)
+	return token, ok, err
+}
+
+// tryLock is TryLock, also returning, if the lock is held, a channel closed
+// on the next write to the store, and when the lock expires, the zero time
+// if it doesn't, for Lock to wait for them.
+func (s *Store) tryLock(key []byte, ttl time.Duration) (LockToken, bool, <-chan struct{}, time.Time, error) {
+	if ttl < 0 {
+		return 0, false, nil, time.Time{}, fmt.Errorf("invalid lock ttl %v", ttl)
+	}
+	var token LockToken
+	var changed <-chan struct{}
+	var until time.Time
+	err := s.write(func() error {
+		held, deadline, _, err := s.lockHeld(key)
+		if err != nil {
+			return err
+		}
+		if held {
+			changed, until = s.notify, deadline
+			return nil
+		}
+		// The sequence number of the record taking the lock is never
+		// reused, so neither is the token
+		seq := s.seq + 1
+		var value [16]byte
+		if ttl > 0 {
+			binary.BigEndian.PutUint64(value[:8], uint64(s.clock.Now().Add(ttl).UnixNano()))
+		}
+		binary.BigEndian.PutUint64(value[8:], seq)
+		err = s.set(seq, lockKey(key), value[:])
+		if err == nil {
+			token = LockToken(seq)
+		}
+		return err
+	})
+	return token, token != 0, changed, until, err
+}
+
+// Lock takes the advisory lock key, waiting until it isn't held, and holds
+// it until Unlock with the token it returns. See TryLock. It fails if the
+// store is closed meanwhile.
+func (s *Store) Lock(key []byte) (LockToken, error) {
+	for {
+		token, ok, changed, until, err := s.tryLock(key, 0)
+		if err != nil || ok {
+			return token, err
+		}
+		var expired <-chan time.Time
+		var timer Timer
+		if !until.IsZero() {
+			timer = s.clock.NewTimer(until.Sub(s.clock.Now()))
+			expired = timer.C()
+		}
+		select {
+		case <-changed:
+		case <-expired:
+		case <-s.done:
+			err = ErrClosed
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Unlock releases the advisory lock key taken with token. It fails with
+// ErrNotLocked if the lock isn't held, e.g. because it expired, or is held
+// with another token, e.g. because someone else took it once it expired.
+func (s *Store) Unlock(key []byte, token LockToken) error {
+	return s.write(func() error {
+		held, _, holder, err := s.lockHeld(key)
+		if err != nil {
+			return err
+		}
+		if held && holder == token {
+			return s.del(s.seq+1, lockKey(key))
+		}
+		if !held {
+			_, ok, err := s.find(string(lockKey(key)))
+			if err == nil && ok {
+				err = s.del(s.seq+1, lockKey(key)) // Expired
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return ErrNotLocked
+	})
+}
@@ -0,0 +1,112 @@
+package stone
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	key := []byte("report")
+
+	token, ok, err := store.TryLock(key, 0)
+	if !ok || err != nil {
+		t.Fatalf("expected to take a free lock, got %v, %v", ok, err)
+	}
+	if _, ok, _ := store.TryLock(key, 0); ok {
+		t.Errorf("expected not to take a held lock")
+	}
+	if _, err := store.Get(key); err == nil {
+		t.Errorf("expected the lock not to show as the key")
+	}
+
+	// Lock waits for Unlock
+	locked := make(chan error)
+	go func() {
+		var err error
+		token, err = store.Lock(key)
+		locked <- err
+	}()
+	select {
+	case <-locked:
+		t.Fatalf("expected Lock to wait for the lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := store.Unlock(key, token+1); !errors.Is(err, ErrNotLocked) {
+		t.Fatalf("expected ErrNotLocked unlocking with another token, got %v", err)
+	}
+	if err := store.Unlock(key, token); err != nil {
+		t.Fatalf("unlock failed: %v", err)
+	}
+	select {
+	case err := <-locked:
+		if err != nil {
+			t.Fatalf("lock failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected Lock to take the lock once unlocked")
+	}
+
+	// Held locks survive reopening
+	store.Close()
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if _, ok, _ := store.TryLock(key, 0); ok {
+		t.Errorf("expected the lock to still be held after reopening")
+	}
+	if err := store.Unlock(key, token); err != nil {
+		t.Errorf("unlock failed: %v", err)
+	}
+	if err := store.Unlock(key, token); !errors.Is(err, ErrNotLocked) {
+		t.Errorf("expected ErrNotLocked unlocking a free lock, got %v", err)
+	}
+
+	// Locks with a ttl expire, and Lock waits for them to
+	expiring, ok, err := store.TryLock(key, 50*time.Millisecond)
+	if !ok || err != nil {
+		t.Fatalf("expected to take a free lock, got %v, %v", ok, err)
+	}
+	start := time.Now()
+	token, err = store.Lock(key)
+	if err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	if time.Since(start) < 40*time.Millisecond {
+		t.Errorf("expected Lock to wait for the lock to expire")
+	}
+	// The first holder can't unlock it anymore
+	if err := store.Unlock(key, expiring); !errors.Is(err, ErrNotLocked) {
+		t.Errorf("expected ErrNotLocked unlocking a lock taken over, got %v", err)
+	}
+	if _, ok, _ := store.TryLock(key, 0); ok {
+		t.Errorf("expected the lock to stay held by its new holder")
+	}
+	if _, _, err := store.TryLock(key, -time.Second); err == nil {
+		t.Errorf("expected a negative ttl to fail")
+	}
+
+	// Closing the store stops Lock waiting
+	go func() {
+		_, err := store.Lock(key)
+		locked <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	store.Close()
+	select {
+	case err := <-locked:
+		if err == nil {
+			t.Errorf("expected Lock to fail once the store is closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected Lock to return once the store is closed")
+	}
+}
@@ -0,0 +1,45 @@
+package stone
+
+import "log/slog"
+
+// Logger receives what a store would otherwise keep to itself: warnings,
+// such as an index snapshot it couldn't use, the results of Polish, and
+// the errors of work it does in the background, such as index snapshots,
+// evictions and scheduled backups. Args are alternating keys and values, as
+// in log/slog, and always start with the path of the store. A *slog.Logger
+// is a Logger.
+type Logger interface {
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+var _ Logger = (*slog.Logger)(nil)
+
+// storeLogger logs to Options.Logger, if set, with the path of the store.
+type storeLogger struct {
+	logger Logger
+	path   string
+}
+
+func (l storeLogger) Info(msg string, args ...any) {
+	if l.logger != nil {
+		l.logger.Info(msg, l.args(args)...)
+	}
+}
+
+func (l storeLogger) Warn(msg string, args ...any) {
+	if l.logger != nil {
+		l.logger.Warn(msg, l.args(args)...)
+	}
+}
+
+func (l storeLogger) Error(msg string, args ...any) {
+	if l.logger != nil {
+		l.logger.Error(msg, l.args(args)...)
+	}
+}
+
+func (l storeLogger) args(args []any) []any {
+	return append([]any{"path", l.path}, args...)
+}
@@ -0,0 +1,51 @@
+package stone
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	var buf bytes.Buffer
+	opts := Options{IndexSnapshot: true, Logger: slog.New(slog.NewTextHandler(&buf, nil))}
+	store, err := NewStoreWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected a missing snapshot not to be logged, got %q", buf.String())
+	}
+	store.Set([]byte("a"), []byte("1"))
+	store.Set([]byte("a"), []byte("2"))
+	err = store.Polish()
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	store.Close()
+	if out := buf.String(); !strings.Contains(out, "level=INFO msg=\"polished log\" path="+path) || !strings.Contains(out, "after=") {
+		t.Errorf("expected the result of polish to be logged, got %q", out)
+	}
+
+	// A snapshot that can't be used is worth a warning
+	buf.Reset()
+	err = os.WriteFile(snapshotPath(path), []byte("garbage"), 0666)
+	if err != nil {
+		t.Fatalf("failed to write snapshot: %v", err)
+	}
+	store, err = NewStoreWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if out := buf.String(); !strings.Contains(out, "level=WARN msg=\"index snapshot unusable, reading the whole log\"") {
+		t.Errorf("expected an unusable snapshot to be logged, got %q", out)
+	}
+	if value, err := store.Get([]byte("a")); err != nil || string(value) != "2" {
+		t.Errorf("expected the index to be rebuilt, got %q, %v", value, err)
+	}
+}
@@ -0,0 +1,228 @@
+package stone
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ManagerOptions configures a Manager.
+type ManagerOptions struct {
+	// Store is what every store is opened with. WALDir and ColdPath are
+	// directories here, holding the WAL segments and the cold log of each
+	// store under its name, and Expvar, if set, publishes each store under
+	// it with "." and the name of the store appended.
+	Store Options
+	// IdleTimeout closes stores that weren't asked for with Manager.Store
+	// for this long, so a manager of many tenants only keeps the busy ones
+	// open. They are opened again when next asked for. 0 keeps stores open
+	// until the manager is closed.
+	IdleTimeout time.Duration
+}
+
+// Manager opens, tracks and closes many named stores under one directory,
+// e.g. one per tenant of a multi-tenant application. Stores are opened when
+// first asked for with Store, and each is kept in a file named after it
+// with a ".db" suffix.
+type Manager struct {
+	root  string
+	opts  ManagerOptions
+	clock Clock // ManagerOptions.Store.Clock, or the time package
+
+	mu      sync.Mutex
+	stores  map[string]*managedStore
+	closing map[string]chan struct{} // Stores being closed, closed once they are
+	closed  bool
+	stop    chan struct{} // Closed by Close, to stop closeIdle
+	done    chan struct{} // Closed once closeIdle returned
+}
+
+// managedStore is a store of a Manager, or one being opened.
+type managedStore struct {
+	ready chan struct{} // Closed once the store is opened or failed to open
+	store *Store
+	err   error
+	used  time.Time // When it was last asked for
+}
+
+// NewManager returns a Manager of the stores in the directory root,
+// creating it if needed.
+func NewManager(root string, opts ManagerOptions) (*Manager, error) {
+	if opts.Store.Backend == nil {
+		err := os.MkdirAll(root, 0777)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create directory: %w", err)
+		}
+		if opts.Store.ColdPath != "" {
+			err = os.MkdirAll(opts.Store.ColdPath, 0777)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create cold directory: %w", err)
+			}
+		}
+	}
+	m := &Manager{
+		root:    root,
+		opts:    opts,
+		clock:   clockOf(opts.Store),
+		stores:  make(map[string]*managedStore),
+		closing: make(map[string]chan struct{}),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if opts.IdleTimeout > 0 {
+		go m.closeIdle()
+	} else {
+		close(m.done)
+	}
+	return m, nil
+}
+
+// Store returns the store named name, opening it, and creating it if it
+// doesn't exist, unless it is open already. Names must be usable as file
+// names: not empty, "." or "..", and without path separators. Stores may
+// be closed once idle for ManagerOptions.IdleTimeout, after which their
+// operations fail with ErrClosed, so rather than keep a store, ask for it
+// again for every unit of work. It fails with ErrClosed once the manager
+// is closed.
+func (m *Manager) Store(name string) (*Store, error) {
+	err := checkStoreName(name)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		m.mu.Lock()
+		if m.closed {
+			m.mu.Unlock()
+			return nil, ErrClosed
+		}
+		if closing, ok := m.closing[name]; ok {
+			// Wait for the idle store to be closed before opening it anew
+			m.mu.Unlock()
+			<-closing
+			continue
+		}
+		ms, ok := m.stores[name]
+		if !ok {
+			ms = &managedStore{ready: make(chan struct{})}
+			m.stores[name] = ms
+		}
+		ms.used = m.clock.Now()
+		m.mu.Unlock()
+
+		if !ok {
+			ms.store, ms.err = NewStoreWithOptions(filepath.Join(m.root, name+".db"), m.storeOptions(name))
+			close(ms.ready)
+			if ms.err != nil {
+				m.mu.Lock()
+				if m.stores[name] == ms {
+					delete(m.stores, name) // Try again when next asked for
+				}
+				m.mu.Unlock()
+			}
+		}
+		<-ms.ready
+		return ms.store, ms.err
+	}
+}
+
+// checkStoreName fails if name can't be the name of a store.
+func checkStoreName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`+"\x00") {
+		return fmt.Errorf("invalid store name %q", name)
+	}
+	return nil
+}
+
+// storeOptions returns the options the store named name is opened with.
+func (m *Manager) storeOptions(name string) Options {
+	opts := m.opts.Store
+	if opts.WALDir != "" {
+		opts.WALDir = filepath.Join(opts.WALDir, name)
+	}
+	if opts.ColdPath != "" {
+		opts.ColdPath = filepath.Join(opts.ColdPath, name+".db")
+	}
+	if opts.Expvar != "" {
+		opts.Expvar += "." + name
+	}
+	return opts
+}
+
+// closeIdle closes the stores idle for ManagerOptions.IdleTimeout until
+// the manager is closed.
+func (m *Manager) closeIdle() {
+	defer close(m.done)
+	for {
+		timer := m.clock.NewTimer(max(m.opts.IdleTimeout/2, time.Millisecond))
+		select {
+		case <-m.stop:
+			timer.Stop()
+			return
+		case now := <-timer.C():
+			m.closeIdleSince(now.Add(-m.opts.IdleTimeout))
+		}
+	}
+}
+
+// closeIdleSince closes the open stores last asked for before since.
+func (m *Manager) closeIdleSince(since time.Time) {
+	idle := make(map[string]*managedStore)
+	m.mu.Lock()
+	for name, ms := range m.stores {
+		select {
+		case <-ms.ready:
+		default:
+			continue // Still opening
+		}
+		if ms.err == nil && ms.used.Before(since) {
+			idle[name] = ms
+			delete(m.stores, name)
+			m.closing[name] = make(chan struct{})
+		}
+	}
+	m.mu.Unlock()
+
+	for name, ms := range idle {
+		err := ms.store.Close()
+		if err != nil {
+			ms.store.log.Error("failed to close idle store", "error", err)
+		}
+		m.mu.Lock()
+		close(m.closing[name])
+		delete(m.closing, name)
+		m.mu.Unlock()
+	}
+}
+
+// Close closes the manager and every store it has open, waiting for those
+// being opened or closed. Later calls to Store fail with ErrClosed.
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return ErrClosed
+	}
+	m.closed = true
+	close(m.stop)
+	stores := m.stores
+	m.stores = nil
+	m.mu.Unlock()
+	<-m.done // No more idle stores being closed
+
+	var errs []error
+	for name, ms := range stores {
+		<-ms.ready
+		if ms.err != nil {
+			continue
+		}
+		err := ms.store.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to close store %q: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
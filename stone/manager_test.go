@@ -0,0 +1,72 @@
+package stone
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestManager(t *testing.T) {
+	root := t.TempDir()
+	mgr, err := NewManager(root, ManagerOptions{IdleTimeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+	defer mgr.Close()
+
+	a, err := mgr.Store("tenant-1")
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	a.Set([]byte("key"), []byte("a"))
+	b, err := mgr.Store("tenant-2")
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	b.Set([]byte("key"), []byte("b"))
+	if again, _ := mgr.Store("tenant-1"); again != a {
+		t.Errorf("expected the open store to be returned again")
+	}
+	for _, name := range []string{"", "..", "a/b", `a\b`} {
+		if _, err := mgr.Store(name); err == nil {
+			t.Errorf("expected store name %q to be rejected", name)
+		}
+	}
+
+	// Idle stores are closed, and opened again when asked for
+	deadline := time.Now().Add(5 * time.Second)
+	for a.Health() == nil && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := a.Health(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected the idle store to be closed, got %v", err)
+	}
+	a, err = mgr.Store("tenant-1")
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	if value, err := a.Get([]byte("key")); err != nil || string(value) != "a" {
+		t.Errorf("expected a, got %q (%v)", value, err)
+	}
+
+	if err := mgr.Close(); err != nil {
+		t.Fatalf("failed to close manager: %v", err)
+	}
+	if _, err := mgr.Store("tenant-1"); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed from a closed manager, got %v", err)
+	}
+	if err := a.Health(); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected Close to close the stores, got %v", err)
+	}
+
+	// Each store has its own file
+	store, err := NewStore(filepath.Join(root, "tenant-2.db"))
+	if err != nil {
+		t.Fatalf("failed to open store file: %v", err)
+	}
+	defer store.Close()
+	if value, err := store.Get([]byte("key")); err != nil || string(value) != "b" {
+		t.Errorf("expected b, got %q (%v)", value, err)
+	}
+}
@@ -0,0 +1,102 @@
+package stone
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Manifest describes a backup. Backup and BackupTo store it next to the
+// backup, under the backup's name followed by ManifestSuffix.
+type Manifest struct {
+	Name        string    `json:"name"`             // Name of the backup file or object
+	Created     time.Time `json:"created"`          // When the backup was taken
+	FromSeq     uint64    `json:"from_seq"`         // The backup holds the records after this sequence number; 0 unless incremental
+	ToSeq       uint64    `json:"to_seq"`           // Sequence number of the store when the backup was taken
+	Keys        int       `json:"keys"`             // Live keys in the store when the backup was taken
+	Size        int64     `json:"size"`             // Size of the backup in bytes
+	SHA256      string    `json:"sha256"`           // Hex SHA-256 checksum of the backup
+	Polished    bool      `json:"polished"`         // Only active key/value pairs were included
+	Compression string    `json:"compression"`      // "none" or "gzip"
+	Encrypted   bool      `json:"encrypted"`        // The backup needs a passphrase or key to restore
+	Parent      string    `json:"parent,omitempty"` // Name of the backup an incremental backup builds on
+}
+
+// ManifestSuffix is appended to a backup's name to name its manifest.
+const ManifestSuffix = ".manifest"
+
+// Incremental reports whether the backup only holds the records written
+// since its parent backup.
+func (m Manifest) Incremental() bool {
+	return m.Parent != ""
+}
+
+// ReadManifest reads the manifest of the backup at backupPath.
+func ReadManifest(backupPath string) (Manifest, error) {
+	var m Manifest
+	data, err := os.ReadFile(backupPath + ManifestSuffix)
+	if err != nil {
+		return m, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	err = json.Unmarshal(data, &m)
+	if err != nil {
+		return m, fmt.Errorf("invalid manifest %s: %w", backupPath+ManifestSuffix, err)
+	}
+	return m, nil
+}
+
+// ListBackups returns the manifests of the backups in dir, oldest first.
+// Backups written without a manifest are not listed.
+func ListBackups(dir string) ([]Manifest, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	var manifests []Manifest
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || !strings.HasSuffix(name, ManifestSuffix) {
+			continue
+		}
+		m, err := ReadManifest(filepath.Join(dir, strings.TrimSuffix(name, ManifestSuffix)))
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, m)
+	}
+
+	sort.Slice(manifests, func(i, j int) bool {
+		if !manifests[i].Created.Equal(manifests[j].Created) {
+			return manifests[i].Created.Before(manifests[j].Created)
+		}
+		return manifests[i].Name < manifests[j].Name
+	})
+	return manifests, nil
+}
+
+// encode returns the JSON form of m as stored next to a backup.
+func (m Manifest) encode() ([]byte, error) {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// writeManifest stores m next to the backup at backupPath.
+func writeManifest(backupPath string, m Manifest) error {
+	data, err := m.encode()
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(backupPath+ManifestSuffix, data, 0666)
+	if err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
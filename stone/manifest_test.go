@@ -0,0 +1,123 @@
+package stone
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupManifest(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	store.Set([]byte("key1"), []byte("value1"))
+	store.Set([]byte("key2"), []byte("value2"))
+
+	fullPath := filepath.Join(dir, "full.db.gz")
+	err = store.Backup(fullPath, BackupOptions{Compress: CompressGzip})
+	if err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+	full, err := ReadManifest(fullPath)
+	if err != nil {
+		t.Fatalf("read manifest failed: %v", err)
+	}
+	info, _ := os.Stat(fullPath)
+	if full.Name != "full.db.gz" || full.ToSeq != 2 || full.Keys != 2 || full.Size != info.Size() ||
+		full.Compression != "gzip" || full.Incremental() {
+		t.Errorf("unexpected manifest: %+v", full)
+	}
+
+	// An incremental backup holds only what changed since its parent
+	store.Delete([]byte("key1"))
+	store.Set([]byte("key3"), []byte("value3"))
+	incPath := filepath.Join(dir, "inc.db")
+	err = store.Backup(incPath, BackupOptions{Parent: &full})
+	if err != nil {
+		t.Fatalf("incremental backup failed: %v", err)
+	}
+	inc, err := ReadManifest(incPath)
+	if err != nil {
+		t.Fatalf("read manifest failed: %v", err)
+	}
+	if inc.Parent != "full.db.gz" || inc.FromSeq != 2 || inc.ToSeq != 4 {
+		t.Errorf("unexpected incremental manifest: %+v", inc)
+	}
+	report, err := VerifyBackup(incPath, VerifyOptions{Store: store})
+	if err != nil || report.Records != 2 {
+		t.Errorf("expected 2 verified records, got %d (%v)", report.Records, err)
+	}
+
+	manifests, err := ListBackups(dir)
+	if err != nil {
+		t.Fatalf("list backups failed: %v", err)
+	}
+	if len(manifests) != 2 || manifests[0].Name != "full.db.gz" || manifests[1].Name != "inc.db" {
+		t.Errorf("unexpected backup list: %+v", manifests)
+	}
+
+	// Restoring the incremental backup applies its parent first
+	restoredPath := filepath.Join(dir, "restored.db")
+	err = Restore(incPath, restoredPath, RestoreOptions{})
+	if err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	restored, err := NewStore(restoredPath)
+	if err != nil {
+		t.Fatalf("failed to open restored store: %v", err)
+	}
+	defer restored.Close()
+	if _, err = restored.Get([]byte("key1")); err == nil {
+		t.Error("expected key1 to be deleted after restore")
+	}
+	for _, key := range []string{"key2", "key3"} {
+		if _, err = restored.Get([]byte(key)); err != nil {
+			t.Errorf("expected %s after restore: %v", key, err)
+		}
+	}
+
+	// History compacted away cannot back an incremental backup
+	err = store.Polish()
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	err = store.Backup(filepath.Join(dir, "inc2.db"), BackupOptions{Parent: &full})
+	if err == nil {
+		t.Error("expected incremental backup across a polish to fail")
+	}
+}
+
+func TestVerifyBackupManifest(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	store.Set([]byte("key1"), []byte("value1"))
+
+	path := filepath.Join(dir, "backup.db")
+	err = store.Backup(path, BackupOptions{})
+	if err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+	_, err = VerifyBackup(path, VerifyOptions{})
+	if err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+
+	// Appending a valid record keeps the log readable but breaks the checksum
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("failed to open backup: %v", err)
+	}
+	file.Write(encodeRecord(typeSet, 2, []byte("key2"), []byte("value2")))
+	file.Close()
+	_, err = VerifyBackup(path, VerifyOptions{})
+	if err == nil {
+		t.Error("expected verify to fail on a backup that does not match its manifest")
+	}
+}
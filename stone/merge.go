@@ -0,0 +1,158 @@
+package stone
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Conflict describes a key that MergeFrom found in both stores, or that
+// SyncWith found changed on both sides.
+type Conflict struct {
+	Key    []byte
+	Dst    []byte // Value in the store being merged into
+	Src    []byte // Value in the store being merged from
+	DstSeq uint64 // Sequence number the value was written with in each store, 0 in the cold tier
+	SrcSeq uint64
+}
+
+// ConflictPolicy decides which value a key found in both stores ends up
+// with. A non-nil error aborts the merge.
+type ConflictPolicy func(c Conflict) ([]byte, error)
+
+// LastWriteWins keeps the value written with the higher sequence number,
+// and the destination's value on a tie. Sequence numbers are only
+// comparable between stores that share their history, such as a primary and
+// a store restored from its backup.
+func LastWriteWins(c Conflict) ([]byte, error) {
+	if c.SrcSeq > c.DstSeq {
+		return c.Src, nil
+	}
+	return c.Dst, nil
+}
+
+// PreferDst keeps the value already in the destination store.
+func PreferDst(c Conflict) ([]byte, error) {
+	return c.Dst, nil
+}
+
+// PreferSrc overwrites the destination's value with the merged one.
+func PreferSrc(c Conflict) ([]byte, error) {
+	return c.Src, nil
+}
+
+// MergeFrom copies every live key/value pair of other into s, e.g. to
+// consolidate several database files into one. Keys found in both stores,
+// in either tier with Options.ColdPath, are resolved by policy, which may
+// also be a custom function.
+//
+// Merged values are written as new records of s. Keys are merged one at a
+// time, so concurrent writes to either store are not blocked for the whole
+// merge, and a merge aborted by an error leaves the keys merged so far.
+func (s *Store) MergeFrom(other *Store, policy ConflictPolicy) error {
+	if other == s {
+		return nil
+	}
+
+	for _, key := range other.sortedKeys(nil) {
+		value, seq, ok, err := other.findSeq(key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue // Deleted since the merge started
+		}
+
+		err = s.mergeKey([]byte(key), value, seq, policy)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeKey stores value under key, resolving a conflict with policy.
+func (s *Store) mergeKey(key, value []byte, seq uint64, policy ConflictPolicy) error {
+	return s.write(func() error {
+		return s.mergeLocked(key, value, seq, policy)
+	})
+}
+
+// mergeLocked is mergeKey for a caller holding the write lock.
+func (s *Store) mergeLocked(key, value []byte, seq uint64, policy ConflictPolicy) error {
+	dst, dstSeq, ok, err := s.findSeq(string(key))
+	if err != nil {
+		return err
+	}
+	if ok {
+		value, err = policy(Conflict{Key: key, Dst: dst, Src: value, DstSeq: dstSeq, SrcSeq: seq})
+		if err != nil {
+			return fmt.Errorf("failed to merge key %q: %w", key, err)
+		}
+		if bytes.Equal(value, dst) {
+			return nil
+		}
+	}
+	return s.set(s.seq+1, key, value)
+}
+
+// copyBatchBytes bounds the values CopyTo reads before writing them out.
+const copyBatchBytes = 1 << 20
+
+// CopyTo writes every live key/value pair whose key starts with prefix to
+// dst, overwriting the values dst already has for those keys. A nil prefix
+// copies the whole store. Use it e.g. to extract one tenant's keys into a
+// store of its own. With Options.ColdPath, the pairs of both tiers are
+// copied.
+//
+// Pairs are copied in batches, each read under one read lock of s and
+// written under one write lock of dst, so neither store is blocked for the
+// whole copy. Keys written or deleted in s during the copy may or may not
+// be copied.
+func (s *Store) CopyTo(dst *Store, prefix []byte) error {
+	if dst == s {
+		return nil
+	}
+
+	keys := s.sortedKeys(prefix)
+	for len(keys) > 0 {
+		var batch []Record
+		var size int
+
+		s.mu.RLock()
+		for len(keys) > 0 && size < copyBatchBytes {
+			key := keys[0]
+			keys = keys[1:]
+			value, ok, err := s.find(key)
+			if err != nil {
+				s.mu.RUnlock()
+				return err
+			}
+			if !ok {
+				continue // Deleted since the copy started
+			}
+			batch = append(batch, Record{Key: []byte(key), Value: value})
+			size += len(key) + len(value)
+		}
+		s.mu.RUnlock()
+		s.readLimit.wait(size)
+
+		err := dst.setBatch(batch)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setBatch writes the key/value pairs of records under one write lock.
+func (s *Store) setBatch(records []Record) error {
+	return s.write(func() error {
+		for _, rec := range records {
+			err := s.set(s.seq+1, rec.Key, rec.Value)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
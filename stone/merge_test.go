@@ -0,0 +1,124 @@
+package stone
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestMergeFrom(t *testing.T) {
+	open := func(t *testing.T, name string) *Store {
+		store, err := NewStore(filepath.Join(t.TempDir(), name))
+		if err != nil {
+			t.Fatalf("failed to create store: %v", err)
+		}
+		t.Cleanup(func() { store.Close() })
+		store.Set([]byte("shared"), []byte(name))
+		return store
+	}
+
+	policies := []struct {
+		name   string
+		policy ConflictPolicy
+		want   string
+	}{
+		{"prefer-dst", PreferDst, "dst.db"},
+		{"prefer-src", PreferSrc, "src.db"},
+		// The source wrote the key later in its history
+		{"last-write-wins", LastWriteWins, "src.db"},
+		{"callback", func(c Conflict) ([]byte, error) {
+			return append(append(c.Dst, '+'), c.Src...), nil
+		}, "dst.db+src.db"},
+	}
+	for _, tc := range policies {
+		t.Run(tc.name, func(t *testing.T) {
+			dst := open(t, "dst.db")
+			src := open(t, "src.db")
+			src.Set([]byte("other"), []byte("value"))
+			src.Set([]byte("shared"), []byte("src.db"))
+
+			err := dst.MergeFrom(src, tc.policy)
+			if err != nil {
+				t.Fatalf("merge failed: %v", err)
+			}
+			value, err := dst.Get([]byte("shared"))
+			if err != nil || string(value) != tc.want {
+				t.Errorf("expected %q, got %q (%v)", tc.want, value, err)
+			}
+			value, err = dst.Get([]byte("other"))
+			if err != nil || string(value) != "value" {
+				t.Errorf("expected key only in source to be copied, got %q (%v)", value, err)
+			}
+		})
+	}
+}
+
+func TestMergeFromAbort(t *testing.T) {
+	dir := t.TempDir()
+	dst, err := NewStore(filepath.Join(dir, "dst.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer dst.Close()
+	src, err := NewStore(filepath.Join(dir, "src.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer src.Close()
+
+	dst.Set([]byte("key"), []byte("dst"))
+	src.Set([]byte("key"), []byte("src"))
+	refuse := errors.New("conflict")
+	err = dst.MergeFrom(src, func(c Conflict) ([]byte, error) { return nil, refuse })
+	if err == nil {
+		t.Fatal("expected merge to fail")
+	}
+	value, _ := dst.Get([]byte("key"))
+	if string(value) != "dst" {
+		t.Errorf("expected value to be kept after aborted merge, got %q", value)
+	}
+}
+
+func TestCopyTo(t *testing.T) {
+	dir := t.TempDir()
+	src, err := NewStore(filepath.Join(dir, "src.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer src.Close()
+	dst, err := NewStore(filepath.Join(dir, "dst.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer dst.Close()
+
+	src.Set([]byte("tenant1/a"), []byte("1a"))
+	src.Set([]byte("tenant1/b"), []byte("1b"))
+	src.Set([]byte("tenant2/a"), []byte("2a"))
+	dst.Set([]byte("tenant1/a"), []byte("stale"))
+
+	err = src.CopyTo(dst, []byte("tenant1/"))
+	if err != nil {
+		t.Fatalf("copy failed: %v", err)
+	}
+	for key, want := range map[string]string{"tenant1/a": "1a", "tenant1/b": "1b"} {
+		value, err := dst.Get([]byte(key))
+		if err != nil || string(value) != want {
+			t.Errorf("expected %q for %s, got %q (%v)", want, key, value, err)
+		}
+	}
+	_, err = dst.Get([]byte("tenant2/a"))
+	if err == nil {
+		t.Error("expected keys outside the prefix not to be copied")
+	}
+
+	// Without a prefix the stores end up equal
+	err = src.CopyTo(dst, nil)
+	if err != nil {
+		t.Fatalf("copy failed: %v", err)
+	}
+	result, err := Diff(src, dst)
+	if err != nil || !result.Equal() {
+		t.Errorf("expected equal stores after copy, got %+v (%v)", result, err)
+	}
+}
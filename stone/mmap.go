@@ -0,0 +1,157 @@
+package stone
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// mmapMinSize is the size of the first mapping of the log. Mappings extend
+// past the end of the file, so they only need to grow once the log does.
+var mmapMinSize int64 = 64 << 20
+
+// mmapLog maps the log into memory for Options.MMap. When the log outgrows
+// the current mapping, a mapping twice as large replaces it, and the old
+// one is kept, since values returned by GetNoCopy may still point into it.
+// All of them are unmapped when the log is replaced or closed.
+type mmapLog struct {
+	mu       sync.Mutex             // Held while mapping
+	current  atomic.Pointer[[]byte] // Largest mapping
+	mappings [][]byte               // Every mapping, to unmap
+}
+
+// slice returns n bytes of the log at offset, mapping more of it if
+// needed. The bytes must have been written to the log already.
+func (m *mmapLog) slice(file File, offset, n int64) ([]byte, error) {
+	data := m.current.Load()
+	if data == nil || offset+n > int64(len(*data)) {
+		var err error
+		data, err = m.grow(file, offset+n)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return (*data)[offset : offset+n : offset+n], nil
+}
+
+// grow maps at least size bytes of the log.
+func (m *mmapLog) grow(file File, size int64) (*[]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if data := m.current.Load(); data != nil && int64(len(*data)) >= size {
+		return data, nil // Grown by another goroutine meanwhile
+	}
+
+	mapSize := mmapMinSize
+	if data := m.current.Load(); data != nil {
+		mapSize = 2 * int64(len(*data))
+	}
+	for mapSize < size {
+		mapSize *= 2
+	}
+	if int64(int(mapSize)) != mapSize {
+		return nil, fmt.Errorf("log is too large to map")
+	}
+	f, ok := file.(*os.File)
+	if !ok {
+		return nil, fmt.Errorf("failed to map file: %w", errors.ErrUnsupported)
+	}
+	data, err := mmapFile(f, int(mapSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to map file: %w", err)
+	}
+	m.mappings = append(m.mappings, data)
+	m.current.Store(&data)
+	return &data, nil
+}
+
+// unmap releases every mapping. The caller must hold the write lock of the
+// store and every shard lock of the index.
+func (m *mmapLog) unmap() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var err error
+	for _, data := range m.mappings {
+		if uerr := munmap(data); uerr != nil && err == nil {
+			err = fmt.Errorf("failed to unmap file: %w", uerr)
+		}
+	}
+	m.mappings = nil
+	m.current.Store(nil)
+	return err
+}
+
+// mappedValue returns the length-prefixed value stored at offset from the
+// memory mapping of the log, without copying it.
+func (s *Store) mappedValue(offset uint64) ([]byte, error) {
+	lenBuf, err := s.mmap.slice(s.file, int64(offset), 4)
+	if err != nil {
+		return nil, err
+	}
+	valLen := int64(binary.LittleEndian.Uint32(lenBuf))
+	return s.mmap.slice(s.file, int64(offset)+4, valLen)
+}
+
+// GetNoCopy is Get without the allocation: with Options.MMap, the value it
+// returns points straight into the memory mapping of the log. This comes
+// with rules Get doesn't have:
+//
+//   - The value must not be modified. The mapping is read-only, so writing
+//     to it crashes the program.
+//   - The value is only valid until the next Polish or Close of the store,
+//     or until a follower receives a full copy from its primary, all of
+//     which unmap the log. Reading it afterwards may crash the program, so
+//     copy values that need to outlive them.
+//
+// Values stored as diffs with Options.DeltaValues are rebuilt, so they are
+// copies. Without Options.MMap, GetNoCopy is the same as Get.
+func (s *Store) GetNoCopy(key []byte) ([]byte, error) {
+	defer s.latency.observe(opGet, time.Now())
+	if !s.intercepted() {
+		return s.getNoCopy(key)
+	}
+	op := &Operation{Name: "get", Key: key}
+	err := s.intercept(op, func(op *Operation) error {
+		var err error
+		op.Value, err = s.getNoCopy(op.Key)
+		return err
+	})
+	return op.Value, err
+}
+
+// getNoCopy is GetNoCopy without the interceptors.
+func (s *Store) getNoCopy(key []byte) ([]byte, error) {
+	if s.mmap == nil {
+		return s.get(key)
+	}
+
+	sh := s.index.shard(string(key))
+	sh.mu.RLock()
+	if s.closed() {
+		sh.mu.RUnlock()
+		return nil, ErrClosed // The log is unmapped
+	}
+	entry, ok := sh.get(string(key))
+	if !ok {
+		sh.mu.RUnlock()
+		return s.get(key) // Reports it missing, or reads it from the cold tier
+	}
+	var value []byte
+	var err error
+	if entry.depth > 0 {
+		value, err = s.readValue(entry) // Rebuilt from deltas, so a copy
+	} else {
+		value, err = s.mappedValue(entry.offset)
+	}
+	sh.mu.RUnlock()
+	if s.tracksUse() {
+		s.index.touch(string(key))
+	}
+
+	s.readLimit.wait(len(value))
+	return value, s.opError("read", key, int64(entry.offset), err)
+}
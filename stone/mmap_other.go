@@ -0,0 +1,16 @@
+//go:build !unix
+
+package stone
+
+import (
+	"errors"
+	"os"
+)
+
+func mmapFile(file *os.File, size int) ([]byte, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func munmap(data []byte) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,91 @@
+//go:build unix
+
+package stone
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestMMap(t *testing.T) {
+	defer func(size int64) { mmapMinSize = size }(mmapMinSize)
+	mmapMinSize = 4096 // Small enough for the log to outgrow it
+
+	store, err := NewStoreWithOptions(filepath.Join(t.TempDir(), "test.db"), Options{MMap: true})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	value := bytes.Repeat([]byte("x"), 100)
+	store.Set([]byte("first"), []byte("value"))
+	first, err := store.GetNoCopy([]byte("first"))
+	if err != nil || string(first) != "value" {
+		t.Fatalf("expected value, got %q (%v)", first, err)
+	}
+	for i := 0; i < 1000; i++ {
+		store.Set([]byte(fmt.Sprintf("key%d", i)), value)
+	}
+	for i := 0; i < 1000; i += 99 {
+		got, err := store.Get([]byte(fmt.Sprintf("key%d", i)))
+		if err != nil || !bytes.Equal(got, value) {
+			t.Fatalf("get key%d returned %q (%v)", i, got, err)
+		}
+	}
+	if len(store.mmap.mappings) < 2 {
+		t.Errorf("expected the mapping to grow, got %d mappings", len(store.mmap.mappings))
+	}
+	// Values from earlier mappings stay valid as the log grows
+	if string(first) != "value" {
+		t.Errorf("expected earlier value to stay valid, got %q", first)
+	}
+
+	key := []byte("key500")
+	allocs := testing.AllocsPerRun(100, func() {
+		store.GetNoCopy(key)
+	})
+	if allocs != 0 {
+		t.Errorf("expected GetNoCopy not to allocate, got %v allocations", allocs)
+	}
+
+	// Values are mapped again after compaction
+	if err = store.Polish(); err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	got, err := store.GetNoCopy(key)
+	if err != nil || !bytes.Equal(got, value) {
+		t.Errorf("get after polish returned %q (%v)", got, err)
+	}
+}
+
+func TestZeroCopyReads(t *testing.T) {
+	store, err := NewStoreWithOptions(filepath.Join(t.TempDir(), "test.db"), Options{MMap: true, ZeroCopyReads: true})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	key := []byte("key")
+	store.Set(key, []byte("value"))
+	got, err := store.Get(key)
+	if err != nil || string(got) != "value" {
+		t.Fatalf("expected value, got %q (%v)", got, err)
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		store.Get(key)
+	})
+	if allocs != 0 {
+		t.Errorf("expected Get not to allocate, got %v allocations", allocs)
+	}
+	if _, err := store.Get([]byte("missing")); err != ErrKeyNotFound {
+		t.Errorf("expected ErrKeyNotFound, got %v", err)
+	}
+
+	// The log is unmapped on Close, so reads must not touch it
+	store.Close()
+	if _, err := store.Get(key); err != ErrClosed {
+		t.Errorf("expected ErrClosed after Close, got %v", err)
+	}
+}
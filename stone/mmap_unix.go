@@ -0,0 +1,19 @@
+//go:build unix
+
+package stone
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapFile maps the first size bytes of file read-only. The mapping may
+// extend past the end of the file, as long as those bytes aren't read
+// before the file grows to cover them.
+func mmapFile(file *os.File, size int) ([]byte, error) {
+	return syscall.Mmap(int(file.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmap(data []byte) error {
+	return syscall.Munmap(data)
+}
@@ -0,0 +1,481 @@
+package stone
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// polishBatchBytes bounds the log Polish reads under one read lock.
+const polishBatchBytes = 1 << 20
+
+// Polish compacts the database by creating a new file with only active key/value pairs.
+// It backs up the original file before replacing it with the polished version.
+//
+// Polish takes the write lock only to copy the records written while it
+// ran and to swap the files. Until then it works in batches, each under
+// one read lock, so reads and writes carry on between them, and with
+// Options.PolishRate it paces its writes to leave disk bandwidth to them.
+// One Polish runs at a time. The polished file replaces the log with one
+// atomic rename, on Windows too; if that fails, the store carries on with
+// the original log.
+//
+// With Options.ColdPath, the keys not used since the previous Polish are
+// moved to the cold tier rather than copied. Samples of time series older
+// than their retention are dropped, see TSSetRetention.
+func (s *Store) Polish() error {
+	defer s.latency.observe(opPolish, time.Now())
+	if !s.intercepted() {
+		return s.runPolish()
+	}
+	return s.intercept(&Operation{Name: "polish"}, func(*Operation) error {
+		return s.runPolish()
+	})
+}
+
+// runPolish is Polish without the interceptors.
+func (s *Store) runPolish() error {
+	if s.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	s.polishMu.Lock()
+	defer s.polishMu.Unlock()
+
+	s.compactStarted()
+	start := time.Now()
+	before := s.size()
+	err := s.polish()
+	if err != nil {
+		s.log.Error("polish failed", "error", err)
+	} else {
+		after, elapsed := s.size(), time.Since(start)
+		s.log.Info("polished log", "before", before, "after", after, "duration", elapsed)
+		s.mu.Lock()
+		s.polishSpeed = float64(before+after) / elapsed.Seconds()
+		s.mu.Unlock()
+	}
+	s.compactEnded(err)
+	return err
+}
+
+// PolishPlan describes what a Polish would do, see Store.PolishPlan.
+type PolishPlan struct {
+	Size      int64         // Size of the log now
+	Polished  int64         // Size of the log once polished
+	Reclaimed int64         // Bytes the polish would free
+	Written   int64         // Bytes the polish would write: a backup of the log and the polished log
+	Duration  time.Duration // Estimated time the polish would take, 0 if unknown
+}
+
+// PolishPlan computes how many bytes a Polish would reclaim and how long it
+// might take, without doing it, so operators can decide when to run it.
+// The duration is estimated from the speed of the last Polish since the
+// store was opened, bounded by Options.PolishRate and WriteRate; before
+// the first, it is only known if either is set. The sizes count values
+// stored as deltas at their size as deltas, and ignore the keys Polish
+// would move to the cold tier of Options.ColdPath.
+func (s *Store) PolishPlan() PolishPlan {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	plan := PolishPlan{
+		Size:     s.end,
+		Polished: checkpointSize + s.index.live,
+	}
+	plan.Reclaimed = max(plan.Size-plan.Polished, 0)
+	plan.Written = plan.Size + plan.Polished
+
+	speed := s.polishSpeed
+	for _, limit := range []int64{s.opts.PolishRate, s.opts.WriteRate} {
+		if limit > 0 && (speed == 0 || float64(limit) < speed) {
+			speed = float64(limit)
+		}
+	}
+	if speed > 0 {
+		plan.Duration = time.Duration(float64(plan.Written) / speed * float64(time.Second))
+	}
+	return plan
+}
+
+// checkpointSize is the size of the checkpoint a polished log starts with.
+const checkpointSize = 1 + 8 + 4
+
+// size returns the size of the log.
+func (s *Store) size() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.end
+}
+
+// polish is Polish for a caller holding polishMu.
+func (s *Store) polish() error {
+	p, err := s.startPolish()
+	if err != nil {
+		return err
+	}
+	defer p.close()
+
+	err = p.copyLog(0, p.copied, p.backupWriter)
+	if err != nil {
+		return fmt.Errorf("failed to create backup before polish: %w", err)
+	}
+	err = p.copyLive()
+	if err != nil {
+		return fmt.Errorf("failed to write polished record: %w", err)
+	}
+	err = p.catchUp()
+	if err != nil {
+		return err
+	}
+	if len(p.moved) > 0 {
+		// The keys moved must be safe in the cold tier before they are
+		// gone from here
+		err = s.cold.Sync()
+		if err != nil {
+			return err
+		}
+	}
+	err = p.finish()
+	if err != nil || s.cold == nil {
+		return err
+	}
+	return s.polishCold()
+}
+
+// polisher writes a polished copy of the log, along with a backup of it,
+// while the store stays in use. The pairs live when it starts are copied
+// first, then the log written since as is.
+type polisher struct {
+	s      *Store
+	gen    uint64   // Generation of the log being polished
+	seq    uint64   // Sequence number the copied pairs are current at
+	keys   []string // Keys live when the polish started
+	copied int64    // Offset up to which the log is copied to both files
+
+	temp, backup             File
+	tempWriter, backupWriter io.Writer             // Throttled by Options.PolishRate and WriteRate
+	size                     int64                 // Bytes written to temp
+	tailStart                int64                 // Offset in temp the log written since starts at
+	entries                  map[string]indexEntry // Index of the pairs in temp
+
+	coldMark uint64    // Clock of the previous polish, see Store.isCold
+	clock    uint64    // First tick of the clock after the polish started
+	moved    []evictee // Keys moved to the cold tier
+
+	started    time.Time                // When the polish started, for retentions
+	retentions map[string]time.Duration // Retentions of time series, see expired
+}
+
+// startPolish notes where the log ends and the keys it holds, and creates
+// the files the polish writes to.
+func (s *Store) startPolish() (*polisher, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed() {
+		return nil, ErrClosed
+	}
+
+	p := &polisher{
+		s:       s,
+		gen:     s.gen,
+		seq:     s.seq,
+		keys:    make([]string, 0, s.index.len()),
+		copied:  s.end,
+		entries: make(map[string]indexEntry, s.index.len()),
+
+		coldMark: s.coldMark,
+		clock:    s.index.clock.Load() + 1, // Uses from now on tick at least this
+		started:  s.clock.Now(),
+	}
+	for key := range s.index.all() {
+		p.keys = append(p.keys, key)
+	}
+	s.polishing.Store(true)
+
+	origPath := s.file.Name()
+	var err error
+	p.backup, err = s.backend.OpenFile(origPath+".backup", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create backup before polish: %w", err)
+	}
+	p.temp, err = s.backend.OpenFile(origPath+".tmp", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		p.backup.Close()
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	rate := newLimiter(s.opts.PolishRate)
+	p.tempWriter = throttledWriter{throttledWriter{p.temp, s.writeLimit}, rate}
+	p.backupWriter = throttledWriter{throttledWriter{p.backup, s.writeLimit}, rate}
+	return p, nil
+}
+
+// close closes the files of the polish, deleting the temp file unless it
+// replaced the log.
+func (p *polisher) close() {
+	p.s.polishing.Store(false)
+	p.backup.Close()
+	p.temp.Close()
+	p.s.backend.Remove(p.temp.Name())
+}
+
+// check fails if the store was closed or its log replaced since the polish
+// started. The caller must hold the store lock.
+func (p *polisher) check() error {
+	if p.s.closed() {
+		return ErrClosed
+	}
+	if p.s.gen != p.gen {
+		return fmt.Errorf("log was replaced during polish")
+	}
+	return nil
+}
+
+// copyLog copies the log from offset from to offset to into dsts, reading
+// a batch at a time.
+func (p *polisher) copyLog(from, to int64, dsts ...io.Writer) error {
+	s := p.s
+	buf := make([]byte, min(to-from, polishBatchBytes))
+	for from < to {
+		n := min(to-from, int64(len(buf)))
+		s.mu.RLock()
+		err := p.check()
+		if err == nil {
+			_, err = s.file.ReadAt(buf[:n], from)
+		}
+		s.mu.RUnlock()
+		if err != nil {
+			return err
+		}
+		s.readLimit.wait(int(n))
+
+		for _, w := range dsts {
+			_, err = w.Write(buf[:n])
+			if err != nil {
+				return err
+			}
+		}
+		from += n
+	}
+	return nil
+}
+
+// copyLive writes a checkpoint to temp followed by a set record for every
+// pair live when the polish started and not changed since. The checkpoint
+// marks that history up to then has been compacted away.
+func (p *polisher) copyLive() error {
+	s := p.s
+	batch := encodeRecord(typeCheckpoint, p.seq, nil, nil)
+	value, buf := getBuffer(), getBuffer()
+	defer putBuffer(value)
+	defer putBuffer(buf)
+	for keys := p.keys; len(keys) > 0 || len(batch) > 0; {
+		var cold []Record
+		var coldSize int
+
+		s.mu.RLock()
+		err := p.check()
+		for err == nil && len(keys) > 0 && len(batch)+coldSize < polishBatchBytes {
+			key := keys[0]
+			keys = keys[1:]
+			entry, ok := s.index.get(key)
+			if !ok || entry.seq > p.seq {
+				continue // Deleted or set since; the log copied next has it
+			}
+			if p.expired(key) {
+				continue // Older than the retention of its series
+			}
+			*value, err = s.readValueInto(*value, entry)
+			if err != nil {
+				break
+			}
+			if s.isCold(key, p.coldMark) {
+				cold = append(cold, Record{Key: []byte(key), Value: bytes.Clone(*value)})
+				coldSize += len(key) + len(*value)
+				p.moved = append(p.moved, evictee{key: key, seq: entry.seq})
+				continue
+			}
+
+			rec := record{kind: typeSet, seq: entry.seq, key: []byte(key), value: *value}
+			*buf = encodeRecordInto(*buf, rec.kind, rec.seq, 0, rec.key, rec.value)
+			p.entries[key] = indexEntry{
+				offset: uint64(p.size + int64(len(batch)) + rec.valLenOffset()),
+				seq:    entry.seq,
+				size:   int64(len(*buf)),
+			}
+			batch = append(batch, *buf...)
+		}
+		s.mu.RUnlock()
+		if err != nil {
+			return err
+		}
+		s.readLimit.wait(len(batch) + coldSize)
+
+		// Write the batch without the lock, which the rate limits may hold up
+		if len(cold) > 0 {
+			err = s.cold.setBatch(cold)
+			if err != nil {
+				return fmt.Errorf("failed to move keys to cold tier: %w", err)
+			}
+		}
+		_, err = p.tempWriter.Write(batch)
+		if err != nil {
+			return err
+		}
+		p.size += int64(len(batch))
+		batch = batch[:0]
+	}
+	p.tailStart = p.size
+	return nil
+}
+
+// catchUp copies the log written since the polish started to temp and the
+// backup, until little enough is left to copy under the write lock.
+func (p *polisher) catchUp() error {
+	s := p.s
+	for {
+		s.mu.RLock()
+		end := s.end
+		s.mu.RUnlock()
+		if end-p.copied <= polishBatchBytes {
+			return nil
+		}
+
+		err := p.copyLog(p.copied, end, p.tempWriter, p.backupWriter)
+		if err != nil {
+			return fmt.Errorf("failed to copy log: %w", err)
+		}
+		p.copied = end
+	}
+}
+
+// finish copies the rest of the log and replaces the log with temp.
+func (p *polisher) finish() error {
+	s := p.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := p.check()
+	if err != nil {
+		return err
+	}
+
+	// A key moved to the cold tier may have been written since it was read,
+	// leaving a stale or deleted value there
+	for _, v := range p.moved {
+		entry, ok := s.index.get(v.key)
+		if !ok || entry.seq != v.seq {
+			err = s.cold.deleteIfPresent([]byte(v.key))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Copy the last records at full speed, since writes wait for them
+	tail := io.NewSectionReader(s.file, p.copied, s.end-p.copied)
+	_, err = io.Copy(io.MultiWriter(p.temp, p.backup), tail)
+	if err != nil {
+		return fmt.Errorf("failed to copy log: %w", err)
+	}
+	// Writes acknowledged as synced must stay so in the new file
+	err = p.temp.Sync()
+	if err != nil {
+		return fmt.Errorf("failed to sync temp file: %w", err)
+	}
+	err = failpoint("mid-polish")
+	if err != nil {
+		return err
+	}
+
+	// Wait for Gets in flight, then replace the original file with the
+	// temp file
+	s.index.lock()
+	defer s.index.unlock()
+	err = s.removeIndexSnapshot()
+	if err != nil {
+		return err
+	}
+	if s.mmap != nil {
+		err = s.mmap.unmap()
+		if err != nil {
+			return err
+		}
+	}
+	// Windows can't replace or rename files open in this process, so close
+	// every handle on both first
+	tempPath, origPath := p.temp.Name(), s.file.Name()
+	err = p.temp.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+	err = s.file.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close original file: %w", err)
+	}
+	s.readers.close()
+	renameErr := s.backend.Rename(tempPath, origPath)
+
+	// Reopen the log, the polished one unless the rename failed
+	file, err := s.backend.OpenFile(origPath, os.O_RDWR|os.O_APPEND, 0666)
+	if err != nil {
+		return s.closeBroken(fmt.Errorf("failed to reopen log: %w", err))
+	}
+	s.file = file
+	if s.readers != nil {
+		s.readers, err = openReadPool(s.backend, origPath, s.opts.ReadHandles)
+		if err != nil {
+			s.log.Warn("reading values through the log handle", "error", err)
+		}
+	}
+	if renameErr != nil {
+		return fmt.Errorf("failed to replace original file: %w", renameErr)
+	}
+
+	s.allocated = 0
+
+	// Index the copied pairs, then read the records copied after them
+	s.index.clear()
+	for key, entry := range p.entries {
+		s.index.putUnlocked(key, entry)
+	}
+	s.floor = p.seq
+	err = s.replay(p.tailStart)
+	if err != nil {
+		return s.closeBroken(fmt.Errorf("failed to rebuild index after polish: %w", err))
+	}
+
+	// Tell anyone tailing the log that the file has been replaced
+	s.gen++
+	s.signal()
+
+	s.coldMark = p.clock
+	s.compactions++
+	s.lastPolish = s.clock.Now()
+
+	// A crash could otherwise undo the rename, bringing back the original
+	// log, while writes made since went to the polished one
+	return syncDirOf(s.backend, origPath)
+}
+
+// closeBroken closes the store once a failed finish has left it without a
+// log handle or with a half-built index, so later calls fail with
+// ErrClosed rather than panic or read the wrong values. The log on disk is
+// whole, so reopening the store recovers it. The caller must hold the write
+// lock and every shard lock.
+func (s *Store) closeBroken(err error) error {
+	s.log.Error("closing the store after a failed polish", "error", err)
+	close(s.done)
+	s.unpublish()
+	if s.mmap != nil {
+		s.mmap.unmap()
+	}
+	s.readers.close()
+	if s.wal != nil {
+		s.wal.close()
+	}
+	if s.cold != nil {
+		s.cold.Close()
+	}
+	s.file.Close()
+	return fmt.Errorf("%w, closed the store", err)
+}
@@ -0,0 +1,237 @@
+package stone
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPolishRate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewStoreWithOptions(path, Options{PolishRate: 8 << 20})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	// About 2 MiB of live pairs, so 4 MiB to write with the backup
+	want := make(map[string][]byte)
+	value := bytes.Repeat([]byte("v"), 1024)
+	for i := 0; i < 2000; i++ {
+		key := fmt.Sprintf("key%04d", i)
+		if err := store.Set([]byte(key), value); err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+		want[key] = value
+	}
+
+	// Write while the polish runs; none of it should wait for the polish
+	done := make(chan error)
+	start := time.Now()
+	go func() { done <- store.Polish() }()
+	var slowest time.Duration
+	for i := 0; ; i++ {
+		select {
+		case err = <-done:
+		default:
+			key := fmt.Sprintf("key%04d", i%2500)
+			writeStart := time.Now()
+			if i%3 == 0 {
+				err = store.Delete([]byte(key))
+				delete(want, key)
+			} else {
+				v := []byte(fmt.Sprintf("value%d", i))
+				err = store.Set([]byte(key), v)
+				want[key] = v
+			}
+			if err != nil && !errors.Is(err, ErrKeyNotFound) {
+				t.Fatalf("write failed: %v", err)
+			}
+			slowest = max(slowest, time.Since(writeStart))
+			time.Sleep(100 * time.Microsecond)
+			continue
+		}
+		break
+	}
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	if elapsed < 400*time.Millisecond {
+		t.Errorf("expected polish to take 0.5s at 8 MiB/s, took %v", elapsed)
+	}
+	if slowest > elapsed/4 {
+		t.Errorf("expected writes not to wait for the polish, slowest took %v of %v", slowest, elapsed)
+	}
+	if _, err := os.Stat(path + ".backup"); err != nil {
+		t.Errorf("expected a backup: %v", err)
+	}
+
+	check := func(store *Store) {
+		t.Helper()
+		if n := store.Stats().Keys; n != len(want) {
+			t.Errorf("expected %d keys, got %d", len(want), n)
+		}
+		for key, v := range want {
+			got, err := store.Get([]byte(key))
+			if err != nil || !bytes.Equal(got, v) {
+				t.Fatalf("expected %s to be %.10q, got %.10q (%v)", key, v, got, err)
+			}
+		}
+	}
+	check(store)
+	store.Close()
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	check(store)
+}
+
+func TestPolishClosed(t *testing.T) {
+	store, err := NewStoreWithOptions(filepath.Join(t.TempDir(), "test.db"), Options{PolishRate: 1 << 20})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	for i := 0; i < 1000; i++ {
+		store.Set([]byte(fmt.Sprintf("key%04d", i)), make([]byte, 1024))
+	}
+
+	done := make(chan error)
+	go func() { done <- store.Polish() }()
+	time.Sleep(100 * time.Millisecond)
+	if err := store.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	if err := <-done; err == nil {
+		t.Errorf("expected polish to fail once the store is closed")
+	}
+}
+
+// renameFailing is a Backend whose renames fail, like replacing a file
+// another process holds open on Windows.
+type renameFailing struct{ Backend }
+
+func (renameFailing) Rename(oldpath, newpath string) error {
+	return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrPermission}
+}
+
+func TestPolishRenameFails(t *testing.T) {
+	store, err := NewStoreWithOptions("test.db", Options{Backend: renameFailing{NewMemoryBackend()}, ReadHandles: 2})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	store.Set([]byte("key"), []byte("old"))
+	store.Set([]byte("key"), []byte("value"))
+
+	if err := store.Polish(); err == nil {
+		t.Fatalf("expected polish to fail")
+	}
+	// The store goes on with the original log
+	if value, err := store.Get([]byte("key")); err != nil || string(value) != "value" {
+		t.Errorf("expected value, got %q (%v)", value, err)
+	}
+	if err := store.Set([]byte("other"), []byte("value")); err != nil {
+		t.Errorf("expected writes to go on, got %v", err)
+	}
+	if err := store.Health(); err != nil {
+		t.Errorf("expected the store to stay healthy, got %v", err)
+	}
+}
+
+// reopenFailing is a Backend that fails to open the log once it has been
+// replaced, like a file system running out of handles.
+type reopenFailing struct {
+	Backend
+	renamed *bool
+}
+
+func (b reopenFailing) Rename(oldpath, newpath string) error {
+	*b.renamed = true
+	return b.Backend.Rename(oldpath, newpath)
+}
+
+func (b reopenFailing) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if *b.renamed && name == "test.db" {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+	}
+	return b.Backend.OpenFile(name, flag, perm)
+}
+
+func TestPolishReopenFails(t *testing.T) {
+	backend := NewMemoryBackend()
+	store, err := NewStoreWithOptions("test.db", Options{Backend: reopenFailing{backend, new(bool)}})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	store.Set([]byte("key"), []byte("old"))
+	store.Set([]byte("key"), []byte("value"))
+
+	if err := store.Polish(); err == nil {
+		t.Fatalf("expected polish to fail")
+	}
+	// Without a log handle, the store is closed rather than left to panic
+	if _, err := store.Get([]byte("key")); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed from Get, got %v", err)
+	}
+	if err := store.Set([]byte("other"), []byte("value")); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed from Set, got %v", err)
+	}
+	if err := store.Close(); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed from Close, got %v", err)
+	}
+
+	// and reopening it finds the polished log
+	store, err = NewStoreWithOptions("test.db", Options{Backend: backend})
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if value, err := store.Get([]byte("key")); err != nil || string(value) != "value" {
+		t.Errorf("expected value, got %q (%v)", value, err)
+	}
+}
+
+func TestPolishPlan(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	value := make([]byte, 1024)
+	for i := 0; i < 1000; i++ {
+		store.Set([]byte(fmt.Sprintf("key%04d", i%100)), value)
+	}
+
+	plan := store.PolishPlan()
+	live := 100 * setRecordSize(7, len(value))
+	if plan.Size != 1000*setRecordSize(7, len(value)) || plan.Polished != checkpointSize+live ||
+		plan.Reclaimed != plan.Size-plan.Polished || plan.Written != plan.Size+plan.Polished || plan.Duration != 0 {
+		t.Errorf("unexpected plan before any polish %+v", plan)
+	}
+
+	// The plan is what Polish does
+	err = store.Polish()
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	if size := store.Stats().FileSize; size != plan.Polished {
+		t.Errorf("expected the polished log to take %d bytes, got %d", plan.Polished, size)
+	}
+	plan = store.PolishPlan()
+	if plan.Reclaimed != 0 || plan.Duration <= 0 {
+		t.Errorf("expected nothing to reclaim and a duration from the last polish, got %+v", plan)
+	}
+
+	// Rate limits bound the estimate
+	store.opts.PolishRate = 1 << 10
+	if plan := store.PolishPlan(); plan.Duration != time.Duration(plan.Written)*time.Second>>10 {
+		t.Errorf("expected the duration at 1 KiB/s, got %+v", plan)
+	}
+}
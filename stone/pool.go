@@ -0,0 +1,27 @@
+package stone
+
+import "sync"
+
+// maxPooledBuffer bounds the buffers kept in bufPool, so one huge value
+// doesn't keep its buffer alive for good.
+const maxPooledBuffer = 64 << 10
+
+// bufPool holds buffers for encoding records and reading values that don't
+// outlive an operation, sparing the garbage collector an allocation per
+// write under load.
+var bufPool = sync.Pool{
+	New: func() any { return new([]byte) },
+}
+
+// getBuffer returns a buffer from the pool. Pass it back to putBuffer once
+// nothing refers to its contents.
+func getBuffer() *[]byte {
+	return bufPool.Get().(*[]byte)
+}
+
+func putBuffer(buf *[]byte) {
+	if cap(*buf) > maxPooledBuffer {
+		return
+	}
+	bufPool.Put(buf)
+}
@@ -0,0 +1,29 @@
+package stone
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestSetReusesBuffers(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	// Records are encoded into pooled buffers, so writing a large value
+	// doesn't allocate its size every time
+	key, value := []byte("key"), make([]byte, 16<<10)
+	store.Set(key, value)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	for i := 0; i < 100; i++ {
+		store.Set(key, value)
+	}
+	runtime.ReadMemStats(&after)
+	if perSet := (after.TotalAlloc - before.TotalAlloc) / 100; perSet >= uint64(len(value)) {
+		t.Errorf("expected Set to reuse its buffer, got %d bytes allocated per Set", perSet)
+	}
+}
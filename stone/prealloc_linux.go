@@ -0,0 +1,16 @@
+package stone
+
+import (
+	"os"
+	"syscall"
+)
+
+// fallocKeepSize is FALLOC_FL_KEEP_SIZE: allocate blocks without changing
+// the size of the file, so readers still find the end of the log at EOF.
+const fallocKeepSize = 0x1
+
+// preallocate allocates disk blocks for length bytes of file from offset,
+// without changing its size.
+func preallocate(file *os.File, offset, length int64) error {
+	return syscall.Fallocate(int(file.Fd()), fallocKeepSize, offset, length)
+}
@@ -0,0 +1,44 @@
+package stone
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestPreallocate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	const extent = 1 << 20
+	store, err := NewStoreWithOptions(path, Options{Preallocate: extent})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	store.Set([]byte("key"), []byte("value"))
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat failed: %v", err)
+	}
+	if info.Size() != store.Stats().FileSize {
+		t.Errorf("expected the file size to be the log size %d, got %d", store.Stats().FileSize, info.Size())
+	}
+	if store.allocated == 0 {
+		store.Close()
+		t.Skip("file system doesn't support fallocate")
+	}
+	if blocks := info.Sys().(*syscall.Stat_t).Blocks * 512; blocks < extent {
+		t.Errorf("expected at least %d bytes allocated, got %d", extent, blocks)
+	}
+
+	// The log is read to its end, not to the end of the space reserved
+	store.Close()
+	store, err = NewStoreWithOptions(path, Options{Preallocate: extent})
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if value, err := store.Get([]byte("key")); err != nil || string(value) != "value" {
+		t.Errorf("expected value, got %q (%v)", value, err)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package stone
+
+import (
+	"errors"
+	"os"
+)
+
+func preallocate(file *os.File, offset, length int64) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,83 @@
+package stone
+
+import (
+	"context"
+)
+
+// Messages are published by writing them to a key of their channel, so
+// subscribers receive them from the change feed, like any other write.
+// Every message overwrites the one before, so Polish keeps only the last.
+
+// Message is a message received on a channel by a subscription.
+type Message struct {
+	Channel []byte
+	Payload []byte
+}
+
+// Publish sends message to the subscribers of channel and returns how many
+// subscriptions of the store it was sent to. Only subscriptions open when
+// it is published receive a message; it isn't kept for later ones.
+func (s *Store) Publish(channel, message []byte) (int, error) {
+	err := s.write(func() error {
+		return s.set(s.seq+1, typedKey(kindChannel, channel, nil), message)
+	})
+	if err != nil {
+		return 0, err
+	}
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	return s.subs[string(channel)], nil
+}
+
+// Subscribe returns a feed of the messages published on channels from now
+// on, in the order they were published. The channel is closed when ctx is
+// done or the store is closed, or if the subscriber falls so far behind
+// that a Polish discards messages it hasn't received yet, see Changes; the
+// subscriber must read it promptly, as publishing doesn't wait for it.
+func (s *Store) Subscribe(ctx context.Context, channels ...[]byte) (<-chan Message, error) {
+	wanted := make(map[string][]byte, len(channels))
+	for _, channel := range channels {
+		wanted[string(typedKey(kindChannel, channel, nil))] = channel
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	changes, err := s.ChangesContext(ctx, s.Seq())
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	s.subscribe(wanted, 1)
+
+	ch := make(chan Message)
+	go func() {
+		defer close(ch)
+		defer s.subscribe(wanted, -1)
+		defer cancel()
+		for rec := range changes {
+			channel, ok := wanted[string(rec.Key)]
+			if !ok || rec.Type != RecordSet {
+				continue
+			}
+			select {
+			case ch <- Message{Channel: channel, Payload: rec.Value}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// subscribe adds n to the subscriptions of the channels of keys.
+func (s *Store) subscribe(keys map[string][]byte, n int) {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	if s.subs == nil {
+		s.subs = make(map[string]int)
+	}
+	for _, channel := range keys {
+		s.subs[string(channel)] += n
+		if s.subs[string(channel)] == 0 {
+			delete(s.subs, string(channel))
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package stone
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPubSub(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	store.Publish([]byte("news"), []byte("before"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := store.Subscribe(ctx, []byte("news"), []byte("alerts"))
+	if err != nil {
+		t.Fatalf("subscribe failed: %v", err)
+	}
+	n, err := store.Publish([]byte("news"), []byte("one"))
+	if err != nil || n != 1 {
+		t.Errorf("expected 1 subscription, got %d, %v", n, err)
+	}
+	store.Set([]byte("news"), []byte("not a message"))
+	store.Publish([]byte("sports"), []byte("ignored"))
+	store.Publish([]byte("alerts"), []byte("two"))
+
+	for _, want := range []Message{{[]byte("news"), []byte("one")}, {[]byte("alerts"), []byte("two")}} {
+		select {
+		case msg := <-ch:
+			if string(msg.Channel) != string(want.Channel) || string(msg.Payload) != string(want.Payload) {
+				t.Errorf("expected %s on %s, got %s on %s", want.Payload, want.Channel, msg.Payload, msg.Channel)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s", want.Payload)
+		}
+	}
+
+	cancel()
+	for range ch {
+	}
+	n, _ = store.Publish([]byte("news"), []byte("three"))
+	if n != 0 {
+		t.Errorf("expected no subscriptions once cancelled, got %d", n)
+	}
+}
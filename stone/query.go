@@ -0,0 +1,184 @@
+package stone
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Secondary indexes map the values extracted from the values of keys back
+// to the keys, for Query. They are kept in memory, not in the log: they are
+// built by CreateIndex from the keys already stored and then updated by
+// every write under the write lock, so a Query never sees a key indexed
+// by a value it no longer has.
+
+// Indexer extracts from the value of key the values to index the key by.
+// It may return any number of them, none to leave the key out of the
+// index. It is called under the write lock, so it must not use the store,
+// and must not keep key or value.
+type Indexer func(key, value []byte) [][]byte
+
+// secondaryIndex is an index created by CreateIndex.
+type secondaryIndex struct {
+	fn     Indexer
+	keys   map[string]map[string]struct{} // Keys by indexed value
+	values map[string][]string            // Indexed values by key
+}
+
+// add indexes key by the values fn extracts from value.
+func (x *secondaryIndex) add(key string, value []byte) {
+	var values []string
+	for _, v := range x.fn([]byte(key), value) {
+		if slices.Contains(values, string(v)) {
+			continue
+		}
+		values = append(values, string(v))
+		keys := x.keys[string(v)]
+		if keys == nil {
+			keys = make(map[string]struct{})
+			x.keys[string(v)] = keys
+		}
+		keys[key] = struct{}{}
+	}
+	if len(values) > 0 {
+		x.values[key] = values
+	}
+}
+
+// remove removes key from the index.
+func (x *secondaryIndex) remove(key string) {
+	for _, v := range x.values[key] {
+		delete(x.keys[v], key)
+		if len(x.keys[v]) == 0 {
+			delete(x.keys, v)
+		}
+	}
+	delete(x.values, key)
+}
+
+// CreateIndex creates the secondary index name, indexing every key by the
+// values fn extracts from its value, for Query. Keys of lists and the other
+// data types aren't indexed. The index is built from the keys already
+// stored, with writes waiting until it is done, and then maintained by
+// every write, until the store is closed: indexes aren't persisted, so
+// they must be created again whenever the store is opened.
+func (s *Store) CreateIndex(name string, fn Indexer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.indexes[name]; ok {
+		return fmt.Errorf("index %q already exists", name)
+	}
+	x := &secondaryIndex{
+		fn:     fn,
+		keys:   make(map[string]map[string]struct{}),
+		values: make(map[string][]string),
+	}
+	for _, key := range s.sortedKeysLocked(nil) {
+		if strings.HasPrefix(key, "\x00") {
+			continue // Data types, see typedKey
+		}
+		value, ok, err := s.find(key)
+		if err != nil {
+			return err
+		}
+		if ok {
+			x.add(key, value)
+		}
+	}
+	if s.indexes == nil {
+		s.indexes = make(map[string]*secondaryIndex)
+	}
+	s.indexes[name] = x
+	return nil
+}
+
+// DropIndex drops the secondary index name, if it exists.
+func (s *Store) DropIndex(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.indexes, name)
+}
+
+// Query returns the keys indexed by value in the secondary index of the
+// given name, in ascending order. It fails if the index doesn't exist.
+func (s *Store) Query(index string, value []byte) ([][]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	x, ok := s.indexes[index]
+	if !ok {
+		return nil, fmt.Errorf("index %q not found", index)
+	}
+	keys := make([]string, 0, len(x.keys[string(value)]))
+	for key := range x.keys[string(value)] {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	result := make([][]byte, len(keys))
+	for i, key := range keys {
+		result[i] = []byte(key)
+	}
+	return result, nil
+}
+
+// updateIndexes indexes key by its new value, or removes it from the
+// indexes if it was deleted. The caller must hold the write lock.
+func (s *Store) updateIndexes(key, value []byte, deleted bool) {
+	if len(s.indexes) == 0 || (len(key) > 0 && key[0] == typedKeyMarker) {
+		return
+	}
+	for _, x := range s.indexes {
+		x.remove(string(key))
+		if !deleted {
+			x.add(string(key), value)
+		}
+	}
+}
+
+// JSONIndex returns an Indexer for CreateIndex indexing JSON values by the
+// field at path, a dot-separated list of object keys, like "address.city".
+// Strings are indexed as they are and other scalars as their JSON text, so
+// a key with {"age": 42} is found by Query(index, []byte("42")); every
+// element of an array is indexed. Values that aren't JSON, or don't have
+// the field, aren't indexed.
+func JSONIndex(path string) Indexer {
+	fields := strings.Split(path, ".")
+	return func(key, value []byte) [][]byte {
+		dec := json.NewDecoder(bytes.NewReader(value))
+		dec.UseNumber()
+		var v any
+		if dec.Decode(&v) != nil {
+			return nil
+		}
+		for _, field := range fields {
+			obj, ok := v.(map[string]any)
+			if !ok {
+				return nil
+			}
+			v = obj[field]
+		}
+		if elems, ok := v.([]any); ok {
+			var values [][]byte
+			for _, elem := range elems {
+				values = append(values, jsonScalar(elem)...)
+			}
+			return values
+		}
+		return jsonScalar(v)
+	}
+}
+
+// jsonScalar returns the indexed value of a decoded JSON scalar, none for
+// null, objects and arrays.
+func jsonScalar(v any) [][]byte {
+	switch v := v.(type) {
+	case string:
+		return [][]byte{[]byte(v)}
+	case json.Number:
+		return [][]byte{[]byte(v)}
+	case bool:
+		return [][]byte{[]byte(fmt.Sprint(v))}
+	}
+	return nil
+}
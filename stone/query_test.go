@@ -0,0 +1,73 @@
+package stone
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	store.Set([]byte("user:1"), []byte(`{"name": "ann", "address": {"city": "Oslo"}, "tags": ["a", "b"], "age": 42}`))
+	store.Set([]byte("user:2"), []byte(`{"name": "bob", "address": {"city": "Rome"}, "tags": ["b"]}`))
+	store.Set([]byte("blob"), []byte("not json"))
+	store.RPush([]byte("list"), []byte(`{"address": {"city": "Oslo"}}`))
+
+	query := func(index, value string) []string {
+		t.Helper()
+		keys, err := store.Query(index, []byte(value))
+		if err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+		var strs []string
+		for _, key := range keys {
+			strs = append(strs, string(key))
+		}
+		return strs
+	}
+
+	// Built from the keys already stored
+	err = store.CreateIndex("city", JSONIndex("address.city"))
+	if err != nil {
+		t.Fatalf("failed to create index: %v", err)
+	}
+	if got := query("city", "Oslo"); !slices.Equal(got, []string{"user:1"}) {
+		t.Errorf("expected [user:1] in Oslo, got %v", got)
+	}
+	if store.CreateIndex("city", JSONIndex("name")) == nil {
+		t.Errorf("expected creating an index twice to fail")
+	}
+	store.CreateIndex("tag", JSONIndex("tags"))
+	store.CreateIndex("age", JSONIndex("age"))
+	if got := query("tag", "b"); !slices.Equal(got, []string{"user:1", "user:2"}) {
+		t.Errorf("expected [user:1 user:2] tagged b, got %v", got)
+	}
+	if got := query("age", "42"); !slices.Equal(got, []string{"user:1"}) {
+		t.Errorf("expected [user:1] aged 42, got %v", got)
+	}
+
+	// Maintained by writes
+	store.Set([]byte("user:1"), []byte(`{"address": {"city": "Rome"}}`))
+	store.Set([]byte("user:3"), []byte(`{"address": {"city": "Oslo"}}`))
+	store.Delete([]byte("user:2"))
+	if got := query("city", "Rome"); !slices.Equal(got, []string{"user:1"}) {
+		t.Errorf("expected [user:1] in Rome, got %v", got)
+	}
+	if got := query("city", "Oslo"); !slices.Equal(got, []string{"user:3"}) {
+		t.Errorf("expected [user:3] in Oslo, got %v", got)
+	}
+	if got := query("tag", "b"); len(got) != 0 {
+		t.Errorf("expected nothing tagged b, got %v", got)
+	}
+
+	store.DropIndex("city")
+	_, err = store.Query("city", []byte("Rome"))
+	if err == nil {
+		t.Errorf("expected querying a dropped index to fail")
+	}
+}
@@ -0,0 +1,128 @@
+package stone
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// Queues are stored as a key per message, named after its ID so they sort
+// in the order they were enqueued, see nextID, and a key per message being
+// processed, holding when its visibility timeout ends.
+
+// ErrQueueEmpty is returned by Dequeue when no message is ready.
+var ErrQueueEmpty = errors.New("queue is empty")
+
+// QueueMessage is a message dequeued from a queue.
+type QueueMessage struct {
+	ID   uint64 // Assigned by Enqueue, in increasing order
+	Body []byte
+}
+
+// Enqueue appends a message with body to the queue stored under queue,
+// creating the queue if it doesn't exist, and returns its ID.
+func (s *Store) Enqueue(queue, body []byte) (uint64, error) {
+	var id uint64
+	err := s.write(func() error {
+		var err error
+		id, err = s.nextID(kindQueue, queue)
+		if err != nil {
+			return err
+		}
+		return s.set(s.seq+1, idKey(kindQueue, queue, id), body)
+	})
+	return id, err
+}
+
+// Dequeue returns the oldest message of queue that is ready, hiding it from
+// other Dequeues for the visibility timeout. The message stays in the queue
+// until it is acknowledged with Ack; if it isn't by the end of the timeout,
+// e.g. because the process handling it crashed, it is delivered again, so
+// every message is delivered at least once. It fails with ErrQueueEmpty if
+// no message is ready.
+func (s *Store) Dequeue(queue []byte, visibility time.Duration) (QueueMessage, error) {
+	var msg QueueMessage
+	err := s.write(func() error {
+		prefix := typedKey(kindQueue, queue, nil)
+		now := s.clock.Now()
+		for _, key := range s.sortedKeysLocked(prefix) {
+			if len(key) != len(prefix)+8 {
+				continue // The next ID
+			}
+			id := binary.BigEndian.Uint64([]byte(key[len(prefix):]))
+			deadline, ok, err := s.find(string(idKey(kindClaim, queue, id)))
+			if err != nil {
+				return err
+			}
+			if ok && len(deadline) == 8 && now.UnixNano() < int64(binary.BigEndian.Uint64(deadline)) {
+				continue // Being processed
+			}
+			body, ok, err := s.find(key)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			until := binary.BigEndian.AppendUint64(nil, uint64(now.Add(visibility).UnixNano()))
+			err = s.set(s.seq+1, idKey(kindClaim, queue, id), until)
+			if err != nil {
+				return err
+			}
+			msg = QueueMessage{ID: id, Body: body}
+			return nil
+		}
+		return ErrQueueEmpty
+	})
+	return msg, err
+}
+
+// Ack acknowledges that message id of queue was processed, removing it from
+// the queue. It fails with ErrKeyNotFound if the message isn't in the
+// queue, e.g. because it was acknowledged already.
+func (s *Store) Ack(queue []byte, id uint64) error {
+	return s.write(func() error {
+		key := idKey(kindQueue, queue, id)
+		_, ok, err := s.find(string(key))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrKeyNotFound
+		}
+		// The message first, so a crash in between can't deliver it again
+		err = s.del(s.seq+1, key)
+		if err != nil {
+			return err
+		}
+		return s.release(queue, id)
+	})
+}
+
+// Nack gives message id of queue back without processing it, making it
+// ready to be dequeued again at once rather than at the end of its
+// visibility timeout. It fails with ErrKeyNotFound if the message isn't in
+// the queue.
+func (s *Store) Nack(queue []byte, id uint64) error {
+	return s.write(func() error {
+		_, ok, err := s.find(string(idKey(kindQueue, queue, id)))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrKeyNotFound
+		}
+		return s.release(queue, id)
+	})
+}
+
+// release deletes the visibility timeout of message id of queue, if it has
+// one. The caller must hold the write lock.
+func (s *Store) release(queue []byte, id uint64) error {
+	claim := idKey(kindClaim, queue, id)
+	_, ok, err := s.find(string(claim))
+	if err != nil || !ok {
+		return err
+	}
+	return s.del(s.seq+1, claim)
+}
@@ -0,0 +1,71 @@
+package stone
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestQueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	queue := []byte("jobs")
+	for _, body := range []string{"a", "b", "c"} {
+		_, err := store.Enqueue(queue, []byte(body))
+		if err != nil {
+			t.Fatalf("enqueue failed: %v", err)
+		}
+	}
+
+	msg, err := store.Dequeue(queue, time.Hour)
+	if err != nil || msg.ID != 1 || string(msg.Body) != "a" {
+		t.Fatalf("expected message 1, got %+v, %v", msg, err)
+	}
+	// Messages being processed are hidden from other consumers
+	msg, err = store.Dequeue(queue, time.Hour)
+	if err != nil || string(msg.Body) != "b" {
+		t.Fatalf("expected message b, got %+v, %v", msg, err)
+	}
+	err = store.Ack(queue, 1)
+	if err != nil {
+		t.Errorf("ack failed: %v", err)
+	}
+	if err := store.Ack(queue, 1); err == nil {
+		t.Errorf("expected acknowledging twice to fail")
+	}
+	err = store.Nack(queue, msg.ID)
+	if err != nil {
+		t.Errorf("nack failed: %v", err)
+	}
+	msg, err = store.Dequeue(queue, time.Millisecond)
+	if err != nil || string(msg.Body) != "b" {
+		t.Errorf("expected a message given back to be redelivered, got %+v, %v", msg, err)
+	}
+	store.Close()
+
+	// A message not acknowledged in time, e.g. after a crash, is redelivered
+	time.Sleep(5 * time.Millisecond)
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	msg, err = store.Dequeue(queue, time.Hour)
+	if err != nil || msg.ID != 2 || string(msg.Body) != "b" {
+		t.Errorf("expected message 2 to be redelivered, got %+v, %v", msg, err)
+	}
+	msg, _ = store.Dequeue(queue, time.Hour)
+	store.Ack(queue, 2)
+	store.Ack(queue, msg.ID)
+	_, err = store.Dequeue(queue, time.Hour)
+	if err != ErrQueueEmpty {
+		t.Errorf("expected the queue to be empty, got %v", err)
+	}
+	id, _ := store.Enqueue(queue, []byte("d"))
+	if id != 4 {
+		t.Errorf("expected IDs not to be reused, got %d", id)
+	}
+}
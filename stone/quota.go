@@ -0,0 +1,93 @@
+package stone
+
+import "errors"
+
+// ErrQuotaExceeded is returned by writes that would grow the log past
+// Options.MaxSize.
+var ErrQuotaExceeded = errors.New("database size quota exceeded")
+
+// checkQuota fails with ErrQuotaExceeded if appending size bytes would
+// grow the log past Options.MaxSize. The caller must hold the write lock.
+func (s *Store) checkQuota(size int) error {
+	if s.opts.MaxSize > 0 && s.end+int64(size) > s.opts.MaxSize {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// setEvicting is set for Set and SetAsync: it checks the pair, see
+// checkPair, and evicts keys to make room for the pair first if the quota
+// is exceeded and Options.EvictOnQuota is set.
+func (s *Store) setEvicting(key, value []byte) error {
+	err := s.checkPair(key, value)
+	if err != nil {
+		return err
+	}
+	set := func() error {
+		return s.set(s.seq+1, key, value)
+	}
+	err = s.write(set)
+	if err != ErrQuotaExceeded || !s.opts.EvictOnQuota {
+		return err
+	}
+	err = s.evict(setRecordSize(len(key), len(value)))
+	if err != nil {
+		return err
+	}
+	return s.write(set)
+}
+
+// setRecordSize is the size of a set record without a timestamp.
+func setRecordSize(keyLen, valueLen int) int64 {
+	return 1 + 8 + 4 + int64(keyLen) + 4 + int64(valueLen) + 4
+}
+
+// evict makes room for a record of size bytes under Options.MaxSize. It
+// polishes the log and, if that doesn't free enough, deletes the keys
+// written longest ago and polishes again, down to evictTarget percent of
+// the quota. It fails with ErrQuotaExceeded if the record alone doesn't
+// fit.
+func (s *Store) evict(size int64) error {
+	s.evictMu.Lock()
+	defer s.evictMu.Unlock()
+	target := s.opts.MaxSize / 100 * evictTarget
+	if size > target {
+		return ErrQuotaExceeded // Evicting everything wouldn't be enough
+	}
+	s.mu.RLock()
+	err := s.checkQuota(int(size))
+	s.mu.RUnlock()
+	if err == nil {
+		return nil // Another write made room in the meantime
+	}
+
+	excess := func() int64 {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return s.end + size - target
+	}
+
+	err = s.Polish()
+	if err != nil {
+		return err
+	}
+	need := excess()
+	if need <= 0 {
+		return nil
+	}
+
+	// Evict the keys written longest ago
+	victims := s.pickEvictees(need, func(string, indexEntry) uint64 { return 0 })
+	err = s.evictKeys(victims)
+	if err != nil {
+		return err
+	}
+	err = s.Polish()
+	if err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.checkQuota(int(size))
+}
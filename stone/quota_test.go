@@ -0,0 +1,91 @@
+package stone
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestMaxSize(t *testing.T) {
+	store, err := NewStoreWithOptions(filepath.Join(t.TempDir(), "test.db"), Options{MaxSize: 4096})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	value := make([]byte, 100)
+	var n int
+	for ; n < 100; n++ {
+		err = store.Set([]byte(fmt.Sprintf("key%02d", n)), value)
+		if err != nil {
+			break
+		}
+	}
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+	}
+	if size := store.Stats().FileSize; size > 4096 {
+		t.Errorf("expected the log to stay within 4096 bytes, got %d", size)
+	}
+
+	// Deletes still go through, and polishing reclaims their space
+	for i := 0; i < n/2; i++ {
+		if err := store.Delete([]byte(fmt.Sprintf("key%02d", i))); err != nil {
+			t.Fatalf("delete failed: %v", err)
+		}
+	}
+	if err := store.Polish(); err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	if err := store.Set([]byte("again"), value); err != nil {
+		t.Errorf("expected set to succeed after polish, got %v", err)
+	}
+}
+
+func TestEvictOnQuota(t *testing.T) {
+	store, err := NewStoreWithOptions(filepath.Join(t.TempDir(), "test.db"), Options{
+		MaxSize:      64 << 10,
+		EvictOnQuota: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	// About three times the quota, half through SetAsync
+	value := make([]byte, 100)
+	for i := 0; i < 1500; i++ {
+		key := []byte(fmt.Sprintf("key%04d", i))
+		if i%2 == 0 {
+			err = store.Set(key, value)
+		} else {
+			err = <-store.SetAsync(key, value)
+		}
+		if err != nil {
+			t.Fatalf("set %d failed: %v", i, err)
+		}
+	}
+	stats := store.Stats()
+	if stats.FileSize > 64<<10 {
+		t.Errorf("expected the log to stay within the quota, got %d bytes", stats.FileSize)
+	}
+	if stats.Keys >= 1500 || stats.Keys < 300 {
+		t.Errorf("expected the oldest keys to be evicted, got %d keys", stats.Keys)
+	}
+	if _, err := store.Get([]byte("key0000")); err == nil {
+		t.Errorf("expected the oldest key to be evicted")
+	}
+	if _, err := store.Get([]byte("key1499")); err != nil {
+		t.Errorf("expected the newest key to be kept, got %v", err)
+	}
+
+	// Nothing can make room for a value larger than the quota
+	err = store.Set([]byte("huge"), make([]byte, 64<<10))
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected ErrQuotaExceeded, got %v", err)
+	}
+	if n := store.Stats().Keys; n != stats.Keys {
+		t.Errorf("expected a failed set not to evict, got %d keys instead of %d", n, stats.Keys)
+	}
+}
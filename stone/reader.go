@@ -0,0 +1,92 @@
+package stone
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ValueReader reads a value where it is stored in the log, without loading
+// it into memory, see ValueReaderAt. It is an io.ReaderAt and an
+// io.ReadSeeker, with a Size.
+type ValueReader struct {
+	*io.SectionReader
+	file File // Handle on the log, nil for values read whole
+}
+
+// Close releases the handle on the log the reader holds.
+func (r *ValueReader) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	return r.file.Close()
+}
+
+// ValueReaderAt returns a reader over the value of key, reading it from the
+// log as it goes rather than copying it whole, so HTTP range requests and
+// parsers of large values only read the parts they need; http.ServeContent
+// accepts it as is. The reader sees the value key had when it was opened,
+// through its own handle on the log, so later writes and Polish don't
+// affect it, but a follower receiving a full copy from its primary does,
+// like GetNoCopy. Values stored as diffs with Options.DeltaValues are
+// rebuilt in memory. The reader must be closed to release its handle on
+// the log.
+func (s *Store) ValueReaderAt(key []byte) (*ValueReader, error) {
+	if s.closed() {
+		return nil, ErrClosed
+	}
+	r, ok, err := s.openValue(string(key))
+	if !ok && err == nil && s.cold != nil {
+		r, ok, err = s.cold.openValue(string(key))
+		if !ok && err == nil {
+			// A Set moves a key back from the cold tier, see find
+			r, ok, err = s.openValue(string(key))
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	if s.tracksUse() {
+		s.index.touch(string(key))
+	}
+	return r, nil
+}
+
+// openValue returns a reader over the value of key in this tier, reporting
+// whether the key exists.
+func (s *Store) openValue(key string) (*ValueReader, bool, error) {
+	sh := s.index.shard(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock() // Polish replaces the log under every shard lock
+	entry, ok := sh.get(key)
+	if !ok {
+		return nil, false, nil
+	}
+	if entry.depth > 0 {
+		value, err := s.readValue(entry)
+		if err != nil {
+			return nil, true, s.opError("read", []byte(key), int64(entry.offset), err)
+		}
+		s.readLimit.wait(len(value))
+		return &ValueReader{SectionReader: io.NewSectionReader(bytes.NewReader(value), 0, int64(len(value)))}, true, nil
+	}
+
+	file, err := s.backend.OpenFile(s.file.Name(), os.O_RDONLY, 0)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to open file: %w", err)
+	}
+	var lenBuf [4]byte
+	_, err = file.ReadAt(lenBuf[:], int64(entry.offset))
+	if err != nil {
+		file.Close()
+		return nil, true, s.opError("read", []byte(key), int64(entry.offset), fmt.Errorf("failed to read value length: %w", err))
+	}
+	valLen := int64(binary.LittleEndian.Uint32(lenBuf[:]))
+	r := throttledReaderAt{file, s.readLimit}
+	return &ValueReader{io.NewSectionReader(r, int64(entry.offset)+4, valLen), file}, true, nil
+}
@@ -0,0 +1,76 @@
+package stone
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func TestValueReaderAt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewStoreWithOptions(path, Options{DeltaValues: true})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	value := bytes.Repeat([]byte("0123456789"), 1000)
+	store.Set([]byte("blob"), value)
+	store.Set([]byte("other"), []byte("x"))
+
+	r, err := store.ValueReaderAt([]byte("blob"))
+	if err != nil {
+		t.Fatalf("failed to open value: %v", err)
+	}
+	defer r.Close()
+	if r.Size() != int64(len(value)) {
+		t.Errorf("expected a size of %d, got %d", len(value), r.Size())
+	}
+	buf := make([]byte, 5)
+	n, err := r.ReadAt(buf, 9997)
+	if n != 3 || err != io.EOF || string(buf[:n]) != "789" {
+		t.Errorf("expected to read 789 at the end, got %q, %v", buf[:n], err)
+	}
+	_, err = r.Seek(4, io.SeekStart)
+	if err != nil {
+		t.Fatalf("seek failed: %v", err)
+	}
+	io.ReadFull(r, buf)
+	if string(buf) != "45678" {
+		t.Errorf("expected 45678, got %q", buf)
+	}
+
+	// The reader keeps the value it was opened on
+	changed := bytes.Clone(value)
+	changed[0] = 'x'
+	store.Set([]byte("blob"), changed)
+	err = store.Polish()
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	all, err := io.ReadAll(io.NewSectionReader(r, 0, r.Size()))
+	if err != nil || !bytes.Equal(all, value) {
+		t.Errorf("expected the value before the write, got %d bytes, %v", len(all), err)
+	}
+
+	// Values stored as diffs are rebuilt
+	changed[1] = 'x'
+	store.Set([]byte("blob"), changed)
+	if report, _ := Check(path); report.Deltas != 1 {
+		t.Fatalf("expected the value to be stored as a diff, got %d diffs", report.Deltas)
+	}
+	r2, err := store.ValueReaderAt([]byte("blob"))
+	if err != nil {
+		t.Fatalf("failed to open value: %v", err)
+	}
+	defer r2.Close()
+	all, err = io.ReadAll(r2)
+	if err != nil || !bytes.Equal(all, changed) {
+		t.Errorf("expected the new value, got %d bytes, %v", len(all), err)
+	}
+
+	_, err = store.ValueReaderAt([]byte("missing"))
+	if err == nil {
+		t.Errorf("expected a missing key to fail")
+	}
+}
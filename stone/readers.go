@@ -0,0 +1,54 @@
+package stone
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// readPool spreads the reads of values over several read-only handles on
+// the log, for Options.ReadHandles, so parallel readers don't all contend
+// for the one handle writes go through.
+type readPool struct {
+	files []File
+	next  atomic.Uint32
+}
+
+// openReadPool opens n read-only handles on the log at path.
+func openReadPool(backend Backend, path string, n int) (*readPool, error) {
+	p := &readPool{files: make([]File, 0, n)}
+	for range n {
+		f, err := backend.OpenFile(path, os.O_RDONLY, 0)
+		if err != nil {
+			p.close()
+			return nil, fmt.Errorf("failed to open read handle: %w", err)
+		}
+		p.files = append(p.files, f)
+	}
+	return p, nil
+}
+
+// file returns the handle for the next read, taking turns.
+func (p *readPool) file() File {
+	return p.files[(p.next.Add(1)-1)%uint32(len(p.files))]
+}
+
+// close closes the handles. A nil pool has none.
+func (p *readPool) close() {
+	if p == nil {
+		return
+	}
+	for _, f := range p.files {
+		f.Close()
+	}
+}
+
+// reader returns the handle to read a value from: one of the pool with
+// Options.ReadHandles, the log handle otherwise. The caller must hold a
+// shard lock of the index or the write lock, as Polish replaces both.
+func (s *Store) reader() File {
+	if s.readers == nil {
+		return s.file
+	}
+	return s.readers.file()
+}
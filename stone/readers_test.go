@@ -0,0 +1,51 @@
+package stone
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestReadHandles(t *testing.T) {
+	store, err := NewStoreWithOptions(filepath.Join(t.TempDir(), "test.db"), Options{ReadHandles: 4})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	if len(store.readers.files) != 4 {
+		t.Fatalf("expected 4 read handles, got %d", len(store.readers.files))
+	}
+
+	for i := 0; i < 100; i++ {
+		store.Set([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)))
+	}
+	check := func() {
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 100; i++ {
+					value, err := store.Get([]byte(fmt.Sprintf("key%d", i)))
+					if err != nil || string(value) != fmt.Sprintf("value%d", i) {
+						t.Errorf("get key%d returned %q (%v)", i, value, err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+	}
+	check()
+
+	// The handles follow the log to the polished file
+	for i := 0; i < 100; i += 2 {
+		store.Delete([]byte(fmt.Sprintf("key%d", i)))
+		store.Set([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)))
+	}
+	if err := store.Polish(); err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	check()
+}
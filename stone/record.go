@@ -0,0 +1,424 @@
+package stone
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"slices"
+)
+
+// Record types as they appear in the first byte of every log record.
+//
+// Legacy records carry no sequence number, timestamp or checksum; they are
+// still read, but all new records are written in the sequenced format.
+const (
+	typeSetLegacy    byte = 0 // [0][keyLen:4][key][valLen:4][value]
+	typeDeleteLegacy byte = 1 // [1][keyLen:4][key]
+	typeSet          byte = 2 // [2][seq:8][keyLen:4][key][valLen:4][value][crc:4]
+	typeDelete       byte = 3 // [3][seq:8][keyLen:4][key][crc:4]
+	typeCheckpoint   byte = 4 // [4][seq:8][crc:4]
+	typeDelta        byte = 5 // [5][seq:8][keyLen:4][key][valLen:4][delta][crc:4], see delta.go
+	typeDeleteMulti  byte = 6 // [6][seq:8][keysLen:4]([keyLen:4][key])...[crc:4], see packKeys
+	typeBatch        byte = 7 // [7][seq:8][writesLen:4]([2|3][keyLen:4][key]([valLen:4][value]))...[crc:4], see packBatch
+
+	// flagTimestamp marks a sequenced record with an 8-byte Unix nanosecond
+	// timestamp following the sequence number.
+	flagTimestamp byte = 0x80
+)
+
+// RecordType identifies the kind of mutation a Record describes.
+type RecordType byte
+
+const (
+	RecordSet RecordType = iota
+	RecordDelete
+)
+
+// String returns a readable name for the record type.
+func (t RecordType) String() string {
+	switch t {
+	case RecordSet:
+		return "set"
+	case RecordDelete:
+		return "delete"
+	default:
+		return fmt.Sprintf("RecordType(%d)", byte(t))
+	}
+}
+
+// Record is a single mutation from the append-only log.
+type Record struct {
+	Seq   uint64     // Sequence number assigned when the record was written
+	Type  RecordType // Set or Delete
+	Key   []byte
+	Value []byte // Nil for deletes
+}
+
+// record is a decoded log record together with its framing details.
+type record struct {
+	kind  byte
+	seq   uint64
+	ts    int64 // Unix nanoseconds, 0 if the record carries no timestamp
+	key   []byte
+	value []byte
+	size  int64 // Encoded length in bytes
+}
+
+// valLenOffset returns the offset of the value length field relative to the
+// start of a set record.
+func (r *record) valLenOffset() int64 {
+	if r.kind == typeSetLegacy {
+		return 1 + 4 + int64(len(r.key))
+	}
+	if r.ts != 0 {
+		return 1 + 8 + 8 + 4 + int64(len(r.key))
+	}
+	return 1 + 8 + 4 + int64(len(r.key))
+}
+
+// public converts a decoded set or delete record to a Record.
+func (r *record) public() Record {
+	rec := Record{
+		Seq:   r.seq,
+		Type:  RecordSet,
+		Key:   r.key,
+		Value: r.value,
+	}
+	if r.kind == typeDelete || r.kind == typeDeleteLegacy {
+		rec.Type = RecordDelete
+		rec.Value = nil
+	}
+	return rec
+}
+
+// encodeRecord builds a sequenced record of the given type.
+func encodeRecord(kind byte, seq uint64, key, value []byte) []byte {
+	return encodeRecordAt(kind, seq, 0, key, value)
+}
+
+// encodeRecordAt builds a sequenced record carrying the timestamp ts, or no
+// timestamp if ts is 0.
+func encodeRecordAt(kind byte, seq uint64, ts int64, key, value []byte) []byte {
+	return encodeRecordInto(nil, kind, seq, ts, key, value)
+}
+
+// encodeRecordInto is encodeRecordAt writing into buf if it is large
+// enough, e.g. a buffer from bufPool.
+func encodeRecordInto(buf []byte, kind byte, seq uint64, ts int64, key, value []byte) []byte {
+	size := 1 + 8 + 4
+	if ts != 0 {
+		size += 8
+	}
+	if kind != typeCheckpoint {
+		size += 4 + len(key)
+	}
+	if kind == typeSet || kind == typeDelta {
+		size += 4 + len(value)
+	}
+
+	if cap(buf) < size {
+		buf = make([]byte, size)
+	}
+	buf = buf[:size]
+	buf[0] = kind
+	binary.LittleEndian.PutUint64(buf[1:9], seq)
+	n := 9
+	if ts != 0 {
+		buf[0] |= flagTimestamp
+		binary.LittleEndian.PutUint64(buf[9:17], uint64(ts))
+		n = 17
+	}
+	if kind != typeCheckpoint {
+		binary.LittleEndian.PutUint32(buf[n:n+4], uint32(len(key)))
+		n += 4
+		n += copy(buf[n:], key)
+	}
+	if kind == typeSet || kind == typeDelta {
+		binary.LittleEndian.PutUint32(buf[n:n+4], uint32(len(value)))
+		n += 4
+		n += copy(buf[n:], value)
+	}
+	binary.LittleEndian.PutUint32(buf[n:], crc32.ChecksumIEEE(buf[:n]))
+	return buf
+}
+
+// errChecksum is the error of a record whose checksum doesn't match.
+var errChecksum error = corruptError("checksum mismatch")
+
+// errTorn is the error of opening a log ending in a record cut short, as a
+// crash mid-write leaves; readRecord returns io.ErrUnexpectedEOF for it.
+var errTorn error = corruptError("record cut short by the end of the file")
+
+// readRecord decodes the next record from r. It returns io.EOF only when r
+// is exhausted exactly at a record boundary; a truncated record yields
+// io.ErrUnexpectedEOF. A record failing its checksum is returned along
+// with errChecksum, so its framing can be used to read on.
+func readRecord(r io.Reader) (*record, error) {
+	var kind [1]byte
+	_, err := io.ReadFull(r, kind[:])
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &record{kind: kind[0] &^ flagTimestamp}
+	timed := kind[0]&flagTimestamp != 0
+	if timed && (rec.kind == typeSetLegacy || rec.kind == typeDeleteLegacy) {
+		return nil, corruptError(fmt.Sprintf("invalid record type: %d", kind[0]))
+	}
+	switch rec.kind {
+	case typeSetLegacy, typeDeleteLegacy:
+		err = rec.readBody(r)
+		rec.size += 1
+	case typeSet, typeDelete, typeCheckpoint, typeDelta, typeDeleteMulti, typeBatch:
+		h := crc32.NewIEEE()
+		h.Write(kind[:])
+		tr := io.TeeReader(r, h)
+
+		var seq [8]byte
+		_, err = io.ReadFull(tr, seq[:])
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		rec.seq = binary.LittleEndian.Uint64(seq[:])
+		if timed {
+			var ts [8]byte
+			_, err = io.ReadFull(tr, ts[:])
+			if err != nil {
+				return nil, unexpected(err)
+			}
+			rec.ts = int64(binary.LittleEndian.Uint64(ts[:]))
+			rec.size += 8
+		}
+
+		if rec.kind != typeCheckpoint {
+			err = rec.readBody(tr)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		var sum [4]byte
+		_, err = io.ReadFull(r, sum[:])
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		rec.size += 1 + 8 + 4
+		if binary.LittleEndian.Uint32(sum[:]) != h.Sum32() {
+			return rec, errChecksum
+		}
+	default:
+		return nil, corruptError(fmt.Sprintf("invalid record type: %d", rec.kind))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// readBody reads the key and, for set and delta records, the value,
+// adding their encoded length to rec.size.
+func (rec *record) readBody(r io.Reader) error {
+	var lenBuf [4]byte
+	_, err := io.ReadFull(r, lenBuf[:])
+	if err != nil {
+		return unexpected(err)
+	}
+	rec.key, err = readN(r, nil, int(binary.LittleEndian.Uint32(lenBuf[:])))
+	if err != nil {
+		return unexpected(err)
+	}
+	rec.size += 4 + int64(len(rec.key))
+
+	if rec.kind == typeSet || rec.kind == typeSetLegacy || rec.kind == typeDelta {
+		_, err = io.ReadFull(r, lenBuf[:])
+		if err != nil {
+			return unexpected(err)
+		}
+		rec.value, err = readN(r, nil, int(binary.LittleEndian.Uint32(lenBuf[:])))
+		if err != nil {
+			return unexpected(err)
+		}
+		rec.size += 4 + int64(len(rec.value))
+	}
+	return nil
+}
+
+// readChunk is the most readN allocates before the bytes it reads arrive.
+const readChunk = 1 << 20
+
+// readN reads n bytes from r, into buf if it is large enough. Lengths read
+// from the log may be corrupt and claim up to 4 GiB, so beyond readChunk
+// the buffer only grows as the bytes arrive, and a log cut short fails
+// without allocating what it claims.
+func readN(r io.Reader, buf []byte, n int) ([]byte, error) {
+	if n <= cap(buf) || n <= readChunk {
+		if cap(buf) < n {
+			buf = make([]byte, n)
+		}
+		buf = buf[:n]
+		_, err := io.ReadFull(r, buf)
+		return buf, err
+	}
+	buf = buf[:0]
+	for len(buf) < n {
+		buf = slices.Grow(buf, min(n-len(buf), max(len(buf), readChunk)))
+		m, err := io.ReadFull(r, buf[len(buf):min(n, cap(buf))])
+		buf = buf[:len(buf)+m]
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// packKeys encodes the keys of a delete multi record, which are stored in
+// place of the key of a delete record, each preceded by its length.
+func packKeys(keys [][]byte) []byte {
+	size := 0
+	for _, key := range keys {
+		size += 4 + len(key)
+	}
+	buf := make([]byte, 0, size)
+	for _, key := range keys {
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(key)))
+		buf = append(buf, key...)
+	}
+	return buf
+}
+
+// keys returns the keys a delete multi record deletes.
+func (r *record) keys() ([][]byte, error) {
+	var keys [][]byte
+	packed := r.key
+	for len(packed) > 0 {
+		if len(packed) < 4 || uint64(len(packed)-4) < uint64(binary.LittleEndian.Uint32(packed)) {
+			return nil, corruptError("invalid keys in delete multi record")
+		}
+		n := 4 + int(binary.LittleEndian.Uint32(packed))
+		keys = append(keys, packed[4:n:n])
+		packed = packed[n:]
+	}
+	return keys, nil
+}
+
+// packBatch encodes the writes of a batch record, set and delete records,
+// which are stored in place of the key of a delete record, each as its type
+// followed by its length-prefixed key and, for sets, value.
+func packBatch(writes []*record) []byte {
+	size := 0
+	for _, w := range writes {
+		size += int(w.batchSize())
+	}
+	buf := make([]byte, 0, size)
+	for _, w := range writes {
+		buf = append(buf, w.kind)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(w.key)))
+		buf = append(buf, w.key...)
+		if w.kind == typeSet {
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(len(w.value)))
+			buf = append(buf, w.value...)
+		}
+	}
+	return buf
+}
+
+// batchSize returns the encoded length of a set or delete record in a batch
+// record.
+func (r *record) batchSize() int64 {
+	if r.kind == typeSet {
+		return 1 + 4 + int64(len(r.key)) + 4 + int64(len(r.value))
+	}
+	return 1 + 4 + int64(len(r.key))
+}
+
+// writes returns the writes of a batch record as set and delete records
+// with its sequence number, each sized as encoded in the batch record.
+func (r *record) writes() ([]*record, error) {
+	var writes []*record
+	packed := r.key
+	field := func() ([]byte, bool) {
+		if len(packed) < 4 || uint64(len(packed)-4) < uint64(binary.LittleEndian.Uint32(packed)) {
+			return nil, false
+		}
+		n := 4 + int(binary.LittleEndian.Uint32(packed))
+		b := packed[4:n:n]
+		packed = packed[n:]
+		return b, true
+	}
+	for len(packed) > 0 {
+		w := &record{kind: packed[0], seq: r.seq, ts: r.ts}
+		packed = packed[1:]
+		var ok bool
+		w.key, ok = field()
+		if ok && w.kind == typeSet {
+			w.value, ok = field()
+		}
+		if !ok || (w.kind != typeSet && w.kind != typeDelete) {
+			return nil, corruptError("invalid writes in batch record")
+		}
+		w.size = w.batchSize()
+		writes = append(writes, w)
+	}
+	return writes, nil
+}
+
+// split returns a delete multi record as a delete record per key and a
+// batch record as its writes, with the same sequence number, and any other
+// record as is.
+func (r *record) split() ([]*record, error) {
+	if r.kind == typeBatch {
+		return r.writes()
+	}
+	if r.kind != typeDeleteMulti {
+		return []*record{r}, nil
+	}
+	keys, err := r.keys()
+	if err != nil {
+		return nil, err
+	}
+	recs := make([]*record, len(keys))
+	for i, key := range keys {
+		recs[i] = &record{kind: typeDelete, seq: r.seq, ts: r.ts, key: key}
+	}
+	return recs, nil
+}
+
+// unexpected converts a clean EOF in the middle of a record into
+// io.ErrUnexpectedEOF.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// scanRecords calls fn for every record read from r until it is exhausted,
+// numbering legacy records in log order as buildIndex does.
+func scanRecords(r io.Reader, fn func(rec *record) error) error {
+	br := bufio.NewReader(r)
+	var seq uint64
+	var offset int64
+	for {
+		rec, err := readRecord(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("record at offset %d: %w", offset, err)
+		}
+		offset += rec.size
+
+		if rec.kind == typeSetLegacy || rec.kind == typeDeleteLegacy {
+			rec.seq = seq + 1
+		}
+		if rec.seq > seq {
+			seq = rec.seq
+		}
+		err = fn(rec)
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package stone
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestLegacyRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.db")
+
+	// [0][keyLen][key][valLen][value] followed by [1][keyLen][key]
+	var data []byte
+	data = append(data, 0)
+	data = binary.LittleEndian.AppendUint32(data, 4)
+	data = append(data, "key1"...)
+	data = binary.LittleEndian.AppendUint32(data, 6)
+	data = append(data, "value1"...)
+	data = append(data, 0)
+	data = binary.LittleEndian.AppendUint32(data, 4)
+	data = append(data, "key2"...)
+	data = binary.LittleEndian.AppendUint32(data, 6)
+	data = append(data, "value2"...)
+	data = append(data, 1)
+	data = binary.LittleEndian.AppendUint32(data, 4)
+	data = append(data, "key2"...)
+	err := os.WriteFile(path, data, 0666)
+	if err != nil {
+		t.Fatalf("failed to write legacy file: %v", err)
+	}
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to open legacy store: %v", err)
+	}
+	defer store.Close()
+
+	if store.Seq() != 3 {
+		t.Errorf("expected legacy records to be numbered up to 3, got %d", store.Seq())
+	}
+	value, err := store.Get([]byte("key1"))
+	if err != nil || string(value) != "value1" {
+		t.Errorf("expected 'value1', got '%s' (%v)", value, err)
+	}
+	_, err = store.Get([]byte("key2"))
+	if err == nil {
+		t.Error("expected key2 to be deleted")
+	}
+
+	// New records continue the numbering in the sequenced format
+	err = store.Set([]byte("key3"), []byte("value3"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if store.Seq() != 4 {
+		t.Errorf("expected seq 4, got %d", store.Seq())
+	}
+}
+
+func TestRecordChecksum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "corrupt.db")
+
+	data := encodeRecord(typeSet, 1, []byte("key1"), []byte("value1"))
+	data[len(data)-5] ^= 0xff // Flip a byte of the value
+	err := os.WriteFile(path, data, 0666)
+	if err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	_, err = NewStore(path)
+	if err == nil {
+		t.Error("expected checksum error on open, got nil")
+	}
+}
+
+func TestRecordHugeLength(t *testing.T) {
+	// A set record claiming a 4 GiB value, cut short after a few bytes
+	data := encodeRecord(typeSet, 1, []byte("key"), []byte("value"))
+	binary.LittleEndian.PutUint32(data[1+8+4+3:], 1<<32-1)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := readRecord(bytes.NewReader(data))
+	runtime.ReadMemStats(&after)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 4*readChunk {
+		t.Errorf("expected a bounded allocation, got %d bytes", alloc)
+	}
+}
+
+// fuzzSeeds are valid logs to start fuzzing the decoding of records from.
+func fuzzSeeds() [][]byte {
+	return [][]byte{
+		encodeRecord(typeSet, 1, []byte("key"), []byte("value")),
+		append(encodeRecord(typeSet, 1, []byte("key"), []byte("value")), encodeRecord(typeDelete, 2, []byte("key"), nil)...),
+		encodeRecordAt(typeSet, 1, 1e18, []byte("key"), []byte("value")),
+		encodeRecord(typeCheckpoint, 3, nil, nil),
+		encodeRecord(typeDeleteMulti, 4, packKeys([][]byte{[]byte("a"), []byte("b")}), nil),
+	}
+}
+
+func FuzzReadRecord(f *testing.F) {
+	for _, seed := range fuzzSeeds() {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := bytes.NewReader(data)
+		for {
+			rec, err := readRecord(r)
+			if err != nil && !errors.Is(err, errChecksum) {
+				return
+			}
+			if rec.kind == typeDeleteMulti {
+				rec.keys()
+			}
+			if err != nil {
+				return
+			}
+		}
+	})
+}
+
+func FuzzOpen(f *testing.F) {
+	for _, seed := range fuzzSeeds() {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		backend := NewMemoryBackend()
+		file, err := backend.OpenFile("fuzz.db", os.O_CREATE|os.O_RDWR, 0666)
+		if err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+		_, err = file.Write(data)
+		if err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		file.Close()
+
+		store, err := NewStoreWithOptions("fuzz.db", Options{Backend: backend})
+		if err != nil {
+			return
+		}
+		defer store.Close()
+		store.Scan(nil, func(key, value []byte) error { return nil })
+	})
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+
+package stone
+
+import (
+	"io/fs"
+	"os"
+)
+
+// openFile opens name like os.OpenFile, which lets replaceFile replace
+// files that are open on these systems.
+func openFile(name string, flag int, perm fs.FileMode) (*os.File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+// replaceFile renames oldpath to newpath, atomically replacing any file
+// there, which is what os.Rename does on these systems.
+func replaceFile(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
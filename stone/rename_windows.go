@@ -0,0 +1,120 @@
+//go:build windows
+
+package stone
+
+import (
+	"io/fs"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var procMoveFileExW = syscall.NewLazyDLL("kernel32.dll").NewProc("MoveFileExW")
+
+const (
+	movefileReplaceExisting = 0x1
+	movefileWriteThrough    = 0x8
+
+	errorSharingViolation syscall.Errno = 32
+
+	fileWriteEA = 0x10
+)
+
+// openFile opens name like os.OpenFile, but shares it for deletion too, so
+// that replaceFile can replace the log while the store, its read handles,
+// change feeds and backups have it open, as they can on other systems.
+func openFile(name string, flag int, perm fs.FileMode) (*os.File, error) {
+	path, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	// Access and creation as in syscall.Open
+	var access uint32
+	switch flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR) {
+	case os.O_RDONLY:
+		access = syscall.GENERIC_READ
+	case os.O_WRONLY:
+		access = syscall.GENERIC_WRITE
+	case os.O_RDWR:
+		access = syscall.GENERIC_READ | syscall.GENERIC_WRITE
+	}
+	if flag&os.O_CREATE != 0 {
+		access |= syscall.GENERIC_WRITE
+	}
+	if flag&os.O_APPEND != 0 {
+		if flag&os.O_TRUNC == 0 {
+			access &^= syscall.GENERIC_WRITE
+		}
+		access |= syscall.FILE_APPEND_DATA | syscall.FILE_WRITE_ATTRIBUTES | fileWriteEA |
+			syscall.STANDARD_RIGHTS_WRITE | syscall.SYNCHRONIZE
+	}
+	var createmode uint32
+	switch {
+	case flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		createmode = syscall.CREATE_NEW
+	case flag&os.O_CREATE != 0:
+		createmode = syscall.OPEN_ALWAYS
+	default:
+		createmode = syscall.OPEN_EXISTING
+	}
+	var attrs uint32 = syscall.FILE_ATTRIBUTE_NORMAL
+	if perm&0200 == 0 {
+		attrs = syscall.FILE_ATTRIBUTE_READONLY
+	}
+
+	share := uint32(syscall.FILE_SHARE_READ | syscall.FILE_SHARE_WRITE | syscall.FILE_SHARE_DELETE)
+	h, err := syscall.CreateFile(path, access, share, nil, createmode, attrs, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	f := os.NewFile(uintptr(h), name)
+	if flag&os.O_TRUNC != 0 {
+		err = f.Truncate(0)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// replaceRetry bounds how long replaceFile retries while another process,
+// like a virus scanner or a backup tool, has newpath open.
+const replaceRetry = 2 * time.Second
+
+// replaceFile renames oldpath to newpath, replacing any file there. It
+// uses MoveFileEx, which replaces the file in one step, and waits for the
+// rename to reach the disk. Windows refuses to replace a file that is open
+// without delete sharing, as other processes often briefly have it, so it
+// retries on sharing violations for up to replaceRetry.
+func replaceFile(oldpath, newpath string) error {
+	from, err := syscall.UTF16PtrFromString(oldpath)
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+	to, err := syscall.UTF16PtrFromString(newpath)
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+
+	deadline := time.Now().Add(replaceRetry)
+	delay := time.Millisecond
+	for {
+		ok, _, err := procMoveFileExW.Call(
+			uintptr(unsafe.Pointer(from)),
+			uintptr(unsafe.Pointer(to)),
+			movefileReplaceExisting|movefileWriteThrough,
+		)
+		if ok != 0 {
+			return nil
+		}
+		retry := err == syscall.ERROR_ACCESS_DENIED || err == errorSharingViolation
+		if !retry || time.Now().After(deadline) {
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+		}
+		time.Sleep(delay)
+		delay = min(2*delay, 100*time.Millisecond)
+	}
+}
@@ -0,0 +1,355 @@
+package stone
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+)
+
+// ServeReplication accepts follower connections on ln and streams the log to
+// each of them. Every follower first receives the records it is missing and
+// then new records as they are written. It returns when ln is closed.
+func (s *Store) ServeReplication(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return fmt.Errorf("failed to accept follower: %w", err)
+		}
+		go s.serveFollower(conn)
+	}
+}
+
+// serveFollower streams records to a single follower until it disconnects
+// or the store is closed.
+func (s *Store) serveFollower(conn net.Conn) {
+	defer conn.Close()
+
+	var buf [8]byte
+	_, err := io.ReadFull(conn, buf[:])
+	if err != nil {
+		return
+	}
+	since := binary.LittleEndian.Uint64(buf[:])
+
+	// Followers never send anything after the handshake, so a read returning
+	// means the connection is gone.
+	stop := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, conn)
+		close(stop)
+	}()
+
+	w := bufio.NewWriter(conn)
+	s.tail(since, stop, func(rec *record) error {
+		_, err := w.Write(rec.encode())
+		return err
+	}, w.Flush)
+}
+
+// tail calls fn for every record with a sequence number above since, first
+// from the log as it stands and then for new records as they are written.
+// If the history after since has been compacted away, fn receives the
+// checkpoint record followed by a set record for every pair of the cold
+// tier, see tailCold, and every record of the compacted log.
+// Before blocking for new records tail calls idle. It returns when fn or
+// idle fail, when stop is closed, or when the store is closed.
+func (s *Store) tail(since uint64, stop <-chan struct{}, fn func(rec *record) error, idle func() error) error {
+	var (
+		file   File
+		gen    uint64
+		offset int64
+		last   uint64 // Highest sequence number seen in the current file
+		all    bool   // Pass every record, the consumer is being resynced
+	)
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+
+	for {
+		s.mu.RLock()
+		select {
+		case <-s.done:
+			s.mu.RUnlock()
+			return nil
+		default:
+		}
+		if file == nil || gen != s.gen {
+			if file != nil {
+				file.Close()
+			}
+			var err error
+			file, err = s.backend.OpenFile(s.file.Name(), os.O_RDONLY, 0)
+			if err != nil {
+				s.mu.RUnlock()
+				return fmt.Errorf("failed to open file: %w", err)
+			}
+			gen, offset, last, all = s.gen, 0, 0, false
+		}
+		end := s.end
+		notify := s.notify
+		s.mu.RUnlock()
+
+		r := bufio.NewReader(io.NewSectionReader(file, offset, end-offset))
+		for offset < end {
+			rec, err := readRecord(r)
+			if err != nil {
+				return fmt.Errorf("record at offset %d: %w", offset, err)
+			}
+			offset += rec.size
+
+			// Legacy records have no sequence number; number them in log order.
+			if rec.kind == typeSetLegacy || rec.kind == typeDeleteLegacy {
+				rec.seq = last + 1
+			}
+			if rec.seq > last {
+				last = rec.seq
+			}
+
+			if rec.kind == typeCheckpoint {
+				if rec.seq <= since {
+					continue
+				}
+				all = true
+			} else if !all && rec.seq <= since {
+				continue
+			}
+			if rec.seq > since {
+				since = rec.seq
+			}
+			err = rec.materialize(file)
+			if err != nil {
+				return err
+			}
+			err = fn(rec)
+			if err != nil {
+				return err
+			}
+			if rec.kind == typeCheckpoint {
+				err = s.tailCold(rec.seq, fn)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if idle != nil {
+			err := idle()
+			if err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-notify:
+		case <-stop:
+			return nil
+		case <-s.done:
+			return nil
+		}
+	}
+}
+
+// tailCold calls fn with a set record numbered seq for every pair of the
+// cold tier, for a consumer of tail resyncing from the checkpoint seq. Keys
+// written or deleted meanwhile have records after the checkpoint, which
+// fn receives later.
+func (s *Store) tailCold(seq uint64, fn func(rec *record) error) error {
+	if s.cold == nil {
+		return nil
+	}
+	for _, key := range s.cold.sortedKeys(nil) {
+		value, ok, err := s.cold.lookup(key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue // Deleted or moved back since
+		}
+		err = fn(&record{kind: typeSet, seq: seq, key: []byte(key), value: value})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encode returns the sequenced encoding of a decoded record, converting
+// legacy records on the way.
+func (r *record) encode() []byte {
+	switch r.kind {
+	case typeSet, typeSetLegacy:
+		return encodeRecord(typeSet, r.seq, r.key, r.value)
+	case typeDelete, typeDeleteLegacy:
+		return encodeRecord(typeDelete, r.seq, r.key, nil)
+	case typeDeleteMulti, typeBatch:
+		return encodeRecord(r.kind, r.seq, r.key, nil)
+	default:
+		return encodeRecord(r.kind, r.seq, nil, nil)
+	}
+}
+
+// Follower applies the log streamed by a primary to a local store, keeping
+// it as a warm standby.
+type Follower struct {
+	store *Store
+	conn  net.Conn
+	done  chan struct{}
+
+	mu      sync.Mutex
+	err     error
+	closing bool
+}
+
+// Follow connects to a primary serving replication at addr and applies its
+// records to s in the background. Records keep the primary's sequence
+// numbers, so a follower that reconnects only receives what it missed.
+func (s *Store) Follow(addr string) (*Follower, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to primary: %w", err)
+	}
+
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], s.Seq())
+	_, err = conn.Write(buf[:])
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send handshake: %w", err)
+	}
+
+	f := &Follower{
+		store: s,
+		conn:  conn,
+		done:  make(chan struct{}),
+	}
+	go f.run()
+	return f, nil
+}
+
+// run reads records from the primary and applies them in order.
+func (f *Follower) run() {
+	defer close(f.done)
+
+	r := bufio.NewReader(f.conn)
+	for {
+		rec, err := readRecord(r)
+		if err == nil {
+			err = f.store.apply(rec)
+		}
+		if err != nil {
+			f.mu.Lock()
+			if !f.closing {
+				f.err = err
+			}
+			f.mu.Unlock()
+			f.conn.Close()
+			return
+		}
+	}
+}
+
+// Wait blocks until the follower stops and returns the error that stopped
+// it, or nil if it was stopped by Close.
+func (f *Follower) Wait() error {
+	<-f.done
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+// Close disconnects from the primary and waits for the follower to stop.
+// The local store stays open.
+func (f *Follower) Close() error {
+	f.mu.Lock()
+	f.closing = true
+	f.mu.Unlock()
+
+	f.conn.Close()
+	<-f.done
+	return nil
+}
+
+// apply writes a record received from a primary, keeping its sequence number.
+// A checkpoint means the primary no longer has the history the store is
+// missing, so the store is emptied to receive a full copy.
+func (s *Store) apply(rec *record) error {
+	return s.write(func() error {
+		switch rec.kind {
+		case typeSet:
+			return s.set(rec.seq, rec.key, rec.value)
+		case typeDelete:
+			return s.del(rec.seq, rec.key)
+		case typeDeleteMulti:
+			keys, err := rec.keys()
+			if err != nil {
+				return err
+			}
+			return s.delMulti(rec.seq, keys)
+		case typeBatch:
+			writes, err := rec.writes()
+			if err != nil {
+				return err
+			}
+			return s.batch(rec.seq, writes)
+		case typeCheckpoint:
+			return s.reset(rec.seq)
+		default:
+			return fmt.Errorf("invalid record type: %d", rec.kind)
+		}
+	})
+}
+
+// reset truncates the log, empties the cold tier and starts the log over
+// with a checkpoint at seq. The caller must hold the write lock.
+func (s *Store) reset(seq uint64) error {
+	err := s.removeIndexSnapshot()
+	if err != nil {
+		return err
+	}
+	if s.cold != nil {
+		// The pairs of the cold tier of the primary follow the checkpoint
+		err = s.cold.write(func() error {
+			return s.cold.reset(s.cold.seq)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to empty cold tier: %w", err)
+		}
+	}
+	s.index.lock()
+	if s.mmap != nil {
+		// Reading a mapping past the end of the file is fatal
+		err = s.mmap.unmap()
+		if err != nil {
+			s.index.unlock()
+			return err
+		}
+	}
+	err = s.file.Truncate(0)
+	if err != nil {
+		s.index.unlock()
+		return fmt.Errorf("failed to truncate file: %w", err)
+	}
+	s.index.clear()
+	s.index.unlock()
+	for _, x := range s.indexes {
+		clear(x.keys)
+		clear(x.values)
+	}
+	s.allocated = 0
+	s.end = 0
+	s.seq = seq
+	s.floor = seq
+	s.gen++
+
+	_, err = s.appendRecord(encodeRecord(typeCheckpoint, seq, nil, nil))
+	if err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package stone
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitForSeq waits until store has applied every record up to seq.
+func waitForSeq(t *testing.T, store *Store, seq uint64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for store.Seq() < seq {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for seq %d, at %d", seq, store.Seq())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func startPrimary(t *testing.T) (*Store, string) {
+	t.Helper()
+	primary, err := NewStore(filepath.Join(t.TempDir(), "primary.db"))
+	if err != nil {
+		t.Fatalf("failed to create primary: %v", err)
+	}
+	t.Cleanup(func() { primary.Close() })
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go primary.ServeReplication(ln)
+
+	return primary, ln.Addr().String()
+}
+
+func TestReplication(t *testing.T) {
+	primary, addr := startPrimary(t)
+
+	// Records written before the follower connects are replayed
+	primary.Set([]byte("key1"), []byte("value1"))
+	primary.Set([]byte("key2"), []byte("value2"))
+	primary.Delete([]byte("key1"))
+
+	followerStore, err := NewStore(filepath.Join(t.TempDir(), "follower.db"))
+	if err != nil {
+		t.Fatalf("failed to create follower store: %v", err)
+	}
+	defer followerStore.Close()
+
+	follower, err := followerStore.Follow(addr)
+	if err != nil {
+		t.Fatalf("follow failed: %v", err)
+	}
+	waitForSeq(t, followerStore, primary.Seq())
+
+	_, err = followerStore.Get([]byte("key1"))
+	if err == nil {
+		t.Error("expected key1 to be deleted on follower")
+	}
+	value, err := followerStore.Get([]byte("key2"))
+	if err != nil || string(value) != "value2" {
+		t.Errorf("expected 'value2' on follower, got '%s' (%v)", value, err)
+	}
+
+	// Records written afterwards are streamed
+	primary.Set([]byte("key3"), []byte("value3"))
+	waitForSeq(t, followerStore, primary.Seq())
+	value, err = followerStore.Get([]byte("key3"))
+	if err != nil || string(value) != "value3" {
+		t.Errorf("expected 'value3' on follower, got '%s' (%v)", value, err)
+	}
+
+	err = follower.Close()
+	if err != nil {
+		t.Fatalf("close follower failed: %v", err)
+	}
+	if err = follower.Wait(); err != nil {
+		t.Errorf("expected nil error after close, got %v", err)
+	}
+
+	// A reconnecting follower catches up from where it left off
+	primary.Set([]byte("key4"), []byte("value4"))
+	follower, err = followerStore.Follow(addr)
+	if err != nil {
+		t.Fatalf("refollow failed: %v", err)
+	}
+	defer follower.Close()
+	waitForSeq(t, followerStore, primary.Seq())
+	value, err = followerStore.Get([]byte("key4"))
+	if err != nil || string(value) != "value4" {
+		t.Errorf("expected 'value4' on follower, got '%s' (%v)", value, err)
+	}
+
+	// Multi-key deletes are applied whole
+	primary.DeleteMulti([][]byte{[]byte("key2"), []byte("key3")})
+	waitForSeq(t, followerStore, primary.Seq())
+	for _, key := range []string{"key2", "key3"} {
+		if _, err := followerStore.Get([]byte(key)); err == nil {
+			t.Errorf("expected %s to be deleted on follower", key)
+		}
+	}
+}
+
+func TestReplicationAfterPolish(t *testing.T) {
+	primary, addr := startPrimary(t)
+
+	followerStore, err := NewStore(filepath.Join(t.TempDir(), "follower.db"))
+	if err != nil {
+		t.Fatalf("failed to create follower store: %v", err)
+	}
+	defer followerStore.Close()
+
+	primary.Set([]byte("key1"), []byte("value1"))
+	follower, err := followerStore.Follow(addr)
+	if err != nil {
+		t.Fatalf("follow failed: %v", err)
+	}
+	waitForSeq(t, followerStore, primary.Seq())
+	follower.Close()
+
+	// The delete of key1 is compacted away while the follower is offline
+	primary.Set([]byte("key2"), []byte("value2"))
+	primary.Delete([]byte("key1"))
+	err = primary.Polish()
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	// A resync jumps to the checkpoint's sequence number before the compacted
+	// records arrive, so wait on a record written after it.
+	primary.Set([]byte("key3"), []byte("value3"))
+
+	follower, err = followerStore.Follow(addr)
+	if err != nil {
+		t.Fatalf("refollow failed: %v", err)
+	}
+	defer follower.Close()
+	waitForSeq(t, followerStore, primary.Seq())
+
+	_, err = followerStore.Get([]byte("key1"))
+	if err == nil {
+		t.Error("expected key1 to be deleted on follower after resync")
+	}
+	value, err := followerStore.Get([]byte("key2"))
+	if err != nil || string(value) != "value2" {
+		t.Errorf("expected 'value2' on follower, got '%s' (%v)", value, err)
+	}
+
+	value, err = followerStore.Get([]byte("key3"))
+	if err != nil || string(value) != "value3" {
+		t.Errorf("expected 'value3' on follower, got '%s' (%v)", value, err)
+	}
+
+	// Tailing continues across a Polish on the primary
+	err = primary.Polish()
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	primary.Set([]byte("key4"), []byte("value4"))
+	waitForSeq(t, followerStore, primary.Seq())
+	value, err = followerStore.Get([]byte("key4"))
+	if err != nil || string(value) != "value4" {
+		t.Errorf("expected 'value4' on follower, got '%s' (%v)", value, err)
+	}
+}
+
+func TestReplicationColdPath(t *testing.T) {
+	dir := t.TempDir()
+	open := func(name string) *Store {
+		store, err := NewStoreWithOptions(filepath.Join(dir, name+".db"), Options{ColdPath: filepath.Join(dir, name+".cold")})
+		if err != nil {
+			t.Fatalf("failed to create store: %v", err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return store
+	}
+	primary := open("primary")
+	primary.Set([]byte("cold"), []byte("value"))
+	primary.Set([]byte("gone"), []byte("value"))
+	primary.Delete([]byte("gone"))
+	chill(t, primary)
+	primary.Set([]byte("hot"), []byte("value"))
+
+	// Feeds from the start get the cold tier right after the checkpoint
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes, err := primary.ChangesContext(ctx, 0)
+	if err != nil {
+		t.Fatalf("changes failed: %v", err)
+	}
+	for _, want := range []string{"cold", "hot"} {
+		if rec := nextRecord(t, changes); string(rec.Key) != want {
+			t.Errorf("expected %s in the feed, got %s", want, rec.Key)
+		}
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go primary.ServeReplication(ln)
+
+	// A follower behind the checkpoint is resynced, cold tier included
+	followerStore := open("follower")
+	followerStore.Set([]byte("stale"), []byte("value"))
+	chill(t, followerStore)
+	follower, err := followerStore.Follow(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("follow failed: %v", err)
+	}
+	defer follower.Close()
+	waitForSeq(t, followerStore, primary.Seq())
+	if value, err := followerStore.Get([]byte("cold")); err != nil || string(value) != "value" {
+		t.Errorf("expected the cold key on the follower, got %q, %v", value, err)
+	}
+	if _, err := followerStore.Get([]byte("stale")); err == nil {
+		t.Error("expected the resync to empty the cold tier of the follower")
+	}
+}
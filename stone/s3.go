@@ -0,0 +1,356 @@
+package stone
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Driver is a BackupDriver that uploads backups to an S3-compatible object
+// store (AWS S3, MinIO, Ceph RGW, ...). Requests are signed with AWS
+// Signature Version 4 and addressed path-style. Backups larger than one part
+// are sent as multipart uploads, and failed requests are retried with
+// exponential backoff.
+type S3Driver struct {
+	Endpoint  string // Base URL, e.g. "https://s3.eu-west-1.amazonaws.com"
+	Region    string // Signing region, e.g. "eu-west-1"
+	Bucket    string
+	Prefix    string // Prepended to backup names to form object keys
+	AccessKey string
+	SecretKey string
+
+	PartSize     int           // Bytes per multipart part; defaults to 8 MiB (S3 requires at least 5 MiB)
+	MaxRetries   int           // Retries per request after the first attempt; defaults to 3
+	RetryBackoff time.Duration // Delay before the first retry, doubled for each further one; defaults to 200ms
+	Client       *http.Client  // Defaults to http.DefaultClient
+}
+
+const (
+	defaultPartSize     = 8 << 20
+	defaultMaxRetries   = 3
+	defaultRetryBackoff = 200 * time.Millisecond
+)
+
+// Put uploads the backup as the object Prefix+name.
+func (d *S3Driver) Put(name string, r io.Reader) error {
+	key := d.Prefix + name
+	partSize := d.PartSize
+	if partSize <= 0 {
+		partSize = defaultPartSize
+	}
+
+	// Backups that fit in one part are sent with a single PUT
+	part := make([]byte, partSize)
+	n, err := io.ReadFull(r, part)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		_, err = d.do(http.MethodPut, key, nil, part[:n])
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read backup: %w", err)
+	}
+
+	uploadID, err := d.createMultipart(key)
+	if err != nil {
+		return err
+	}
+	err = d.uploadParts(key, uploadID, part, r)
+	if err != nil {
+		// Best effort: don't leave the parts behind to be billed for
+		d.do(http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil)
+		return err
+	}
+	return nil
+}
+
+// List returns the names of the backups under Prefix starting with prefix.
+func (d *S3Driver) List(prefix string) ([]string, error) {
+	var names []string
+	token := ""
+	for {
+		query := url.Values{
+			"list-type": {"2"},
+			"prefix":    {d.Prefix + prefix},
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := d.do(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.Unmarshal(resp.body, &result)
+		if err != nil {
+			return nil, fmt.Errorf("invalid list response: %w", err)
+		}
+		for _, object := range result.Contents {
+			names = append(names, strings.TrimPrefix(object.Key, d.Prefix))
+		}
+		if !result.IsTruncated {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Delete removes the named backup from the bucket.
+func (d *S3Driver) Delete(name string) error {
+	_, err := d.do(http.MethodDelete, d.Prefix+name, nil, nil)
+	return err
+}
+
+// createMultipart starts a multipart upload and returns its ID.
+func (d *S3Driver) createMultipart(key string) (string, error) {
+	resp, err := d.do(http.MethodPost, key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return "", err
+	}
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.Unmarshal(resp.body, &result)
+	if err != nil || result.UploadID == "" {
+		return "", fmt.Errorf("invalid create multipart upload response: %s", resp.body)
+	}
+	return result.UploadID, nil
+}
+
+// completedPart identifies an uploaded part when completing an upload.
+type completedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+// uploadParts uploads first and the rest of r as parts of uploadID and
+// completes the upload.
+func (d *S3Driver) uploadParts(key, uploadID string, first []byte, r io.Reader) error {
+	var parts []completedPart
+	part := first
+	for number := 1; ; number++ {
+		query := url.Values{
+			"partNumber": {strconv.Itoa(number)},
+			"uploadId":   {uploadID},
+		}
+		resp, err := d.do(http.MethodPut, key, query, part)
+		if err != nil {
+			return err
+		}
+		parts = append(parts, completedPart{PartNumber: number, ETag: resp.header.Get("ETag")})
+
+		n, err := io.ReadFull(r, part[:cap(part)])
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return fmt.Errorf("failed to read backup: %w", err)
+		}
+		part = part[:n]
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	_, err = d.do(http.MethodPost, key, url.Values{"uploadId": {uploadID}}, body)
+	return err
+}
+
+// s3Response is the part of an HTTP response the driver needs.
+type s3Response struct {
+	header http.Header
+	body   []byte
+}
+
+// do sends a signed request, retrying network errors, throttling and server
+// errors with exponential backoff.
+func (d *S3Driver) do(method, key string, query url.Values, body []byte) (*s3Response, error) {
+	retries := d.MaxRetries
+	if retries <= 0 {
+		retries = defaultMaxRetries
+	}
+	backoff := d.RetryBackoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff << (attempt - 1))
+		}
+
+		req, err := d.newRequest(method, key, query, body)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		respBody, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+			lastErr = fmt.Errorf("%s %s: %s", method, key, resp.Status)
+			continue
+		case resp.StatusCode >= 300:
+			return nil, fmt.Errorf("%s %s: %s: %s", method, key, resp.Status, respBody)
+		case bytes.Contains(respBody, []byte("<Error>")):
+			// CompleteMultipartUpload can fail after sending a 200 status
+			lastErr = fmt.Errorf("%s %s: %s", method, key, respBody)
+			continue
+		}
+		return &s3Response{header: resp.Header, body: respBody}, nil
+	}
+	return nil, fmt.Errorf("giving up after %d attempts: %w", retries+1, lastErr)
+}
+
+// newRequest builds a signed request for the object key, or for the bucket
+// itself if key is empty.
+func (d *S3Driver) newRequest(method, key string, query url.Values, body []byte) (*http.Request, error) {
+	u, err := url.Parse(strings.TrimSuffix(d.Endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint: %w", err)
+	}
+	base := strings.TrimSuffix(u.EscapedPath(), "/")
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + d.Bucket
+	u.RawPath = base + "/" + awsEscape(d.Bucket, false)
+	if key != "" {
+		u.Path += "/" + key
+		u.RawPath += "/" + awsEscape(key, false)
+	}
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(body)
+	d.sign(req, hex.EncodeToString(sum[:]), time.Now())
+	return req, nil
+}
+
+// sign adds AWS Signature Version 4 headers to req.
+func (d *S3Driver) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	scope := date + "/" + d.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+d.SecretKey), date)
+	signingKey = hmacSHA256(signingKey, d.Region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+d.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery encodes query parameters sorted by name, as SigV4 requires.
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var pairs []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			pairs = append(pairs, awsEscape(name, true)+"="+awsEscape(value, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// awsEscape percent-encodes every byte outside the RFC 3986 unreserved set.
+// Slashes are kept unless encodeSlash is set.
+func awsEscape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,319 @@
+package stone
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BackupSchedule describes periodic backups taken by ScheduleBackups.
+type BackupSchedule struct {
+	Interval time.Duration // Time between backups; set either Interval or Cron
+	Cron     string        // Five-field cron expression in local time, e.g. "30 2 * * *"
+
+	Driver  BackupDriver  // Where backups are stored, e.g. DirDriver("/var/backups/stone")
+	Name    string        // Prefix of backup names; the UTC backup time is appended
+	Options BackupOptions // How each backup is written
+	Retain  int           // Number of backups to keep, 0 keeps all; requires a BackupCatalog driver
+
+	OnError func(error) // Called with errors from background backups, if set
+}
+
+// BackupScheduler runs the backups of a BackupSchedule in the background.
+type BackupScheduler struct {
+	store *Store
+	sched BackupSchedule
+	cron  *cronSchedule
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// backupTimeFormat sorts lexically in chronological order.
+const backupTimeFormat = "20060102T150405.000Z"
+
+// ScheduleBackups starts taking backups according to sched until the
+// scheduler is stopped or the store is closed.
+func (s *Store) ScheduleBackups(sched BackupSchedule) (*BackupScheduler, error) {
+	b := &BackupScheduler{
+		store: s,
+		sched: sched,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+
+	switch {
+	case (sched.Interval > 0) == (sched.Cron != ""):
+		return nil, fmt.Errorf("exactly one of interval and cron must be set")
+	case sched.Cron != "":
+		cron, err := parseCron(sched.Cron)
+		if err != nil {
+			return nil, err
+		}
+		b.cron = cron
+	}
+	if sched.Driver == nil {
+		return nil, fmt.Errorf("backup driver must be set")
+	}
+	if _, ok := sched.Driver.(BackupCatalog); sched.Retain > 0 && !ok {
+		return nil, fmt.Errorf("retention requires a driver implementing BackupCatalog")
+	}
+
+	go b.run()
+	return b, nil
+}
+
+// Stop stops taking backups and waits for a backup in progress to finish.
+func (b *BackupScheduler) Stop() {
+	select {
+	case <-b.stop:
+	default:
+		close(b.stop)
+	}
+	<-b.done
+}
+
+func (b *BackupScheduler) run() {
+	defer close(b.done)
+
+	for {
+		wait := b.sched.Interval
+		if b.cron != nil {
+			next := b.cron.next(b.store.clock.Now())
+			if next.IsZero() {
+				b.report(fmt.Errorf("cron expression %q never matches", b.sched.Cron))
+				return
+			}
+			wait = next.Sub(b.store.clock.Now())
+		}
+
+		timer := b.store.clock.NewTimer(wait)
+		select {
+		case <-timer.C():
+		case <-b.stop:
+			timer.Stop()
+			return
+		case <-b.store.done:
+			timer.Stop()
+			return
+		}
+
+		err := b.backup(b.store.clock.Now())
+		if err != nil {
+			b.report(err)
+		}
+	}
+}
+
+// backup takes one backup and prunes old ones beyond the retention count.
+func (b *BackupScheduler) backup(now time.Time) error {
+	name := b.sched.Name + now.UTC().Format(backupTimeFormat)
+	err := b.store.BackupTo(b.sched.Driver, name, b.sched.Options)
+	if err != nil {
+		return err
+	}
+	if b.sched.Retain <= 0 {
+		return nil
+	}
+
+	catalog := b.sched.Driver.(BackupCatalog)
+	listed, err := catalog.List(b.sched.Name)
+	if err != nil {
+		return fmt.Errorf("failed to list backups: %w", err)
+	}
+	var names []string
+	manifests := make(map[string]bool)
+	for _, name := range listed {
+		if strings.HasSuffix(name, ManifestSuffix) {
+			manifests[name] = true
+		} else {
+			names = append(names, name)
+		}
+	}
+
+	for len(names) > b.sched.Retain {
+		// Delete the manifest first, so a backup is never listed as complete
+		// after it has started to be removed
+		if manifests[names[0]+ManifestSuffix] {
+			err = catalog.Delete(names[0] + ManifestSuffix)
+			if err != nil {
+				return fmt.Errorf("failed to delete manifest of old backup %q: %w", names[0], err)
+			}
+		}
+		err = catalog.Delete(names[0])
+		if err != nil {
+			return fmt.Errorf("failed to delete old backup %q: %w", names[0], err)
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+func (b *BackupScheduler) report(err error) {
+	b.store.log.Error("scheduled backup failed", "error", err)
+	if b.sched.OnError != nil {
+		b.sched.OnError(err)
+	}
+}
+
+// PolishSchedule describes automatic compaction run by SchedulePolish.
+type PolishSchedule struct {
+	// Windows are the daily windows of local time a Polish may start in,
+	// e.g. "02:00-04:00", or "22:00-02:00" across midnight. Empty allows
+	// any time.
+	Windows []string
+	// Fragmentation is the share of the log, from 0 to 1, that must be
+	// dead for a Polish to start, see Stats. Defaults to 0.5.
+	Fragmentation float64
+	// Interval is how often the store is checked. Defaults to a minute.
+	Interval time.Duration
+
+	OnError func(error) // Called with errors from background polishes, if set
+}
+
+// PolishScheduler runs the polishes of a PolishSchedule in the background.
+type PolishScheduler struct {
+	store   *Store
+	sched   PolishSchedule
+	windows []dailyWindow
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// dailyWindow is a window of local time, as offsets from midnight. It
+// wraps around midnight if end is before start.
+type dailyWindow struct {
+	start, end time.Duration
+}
+
+// SchedulePolish starts polishing the store whenever enough of its log is
+// dead, within the daily windows of sched, until the scheduler is stopped
+// or the store is closed.
+func (s *Store) SchedulePolish(sched PolishSchedule) (*PolishScheduler, error) {
+	if sched.Fragmentation == 0 {
+		sched.Fragmentation = 0.5
+	}
+	if sched.Interval == 0 {
+		sched.Interval = time.Minute
+	}
+	switch {
+	case sched.Fragmentation < 0 || sched.Fragmentation > 1:
+		return nil, fmt.Errorf("fragmentation must be between 0 and 1")
+	case sched.Interval < 0:
+		return nil, fmt.Errorf("interval must be positive")
+	}
+	p := &PolishScheduler{
+		store: s,
+		sched: sched,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	for _, w := range sched.Windows {
+		window, err := parseWindow(w)
+		if err != nil {
+			return nil, err
+		}
+		p.windows = append(p.windows, window)
+	}
+
+	go p.run()
+	return p, nil
+}
+
+// parseWindow parses a daily window such as "02:00-04:00". The end may be
+// "24:00".
+func parseWindow(s string) (dailyWindow, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return dailyWindow{}, fmt.Errorf("invalid window %q, expected HH:MM-HH:MM", s)
+	}
+	start, err := parseTimeOfDay(strings.TrimSpace(from))
+	if err == nil && start == 24*time.Hour {
+		err = fmt.Errorf("a window can't start at 24:00")
+	}
+	if err != nil {
+		return dailyWindow{}, fmt.Errorf("invalid window %q: %w", s, err)
+	}
+	end, err := parseTimeOfDay(strings.TrimSpace(to))
+	if err != nil {
+		return dailyWindow{}, fmt.Errorf("invalid window %q: %w", s, err)
+	}
+	if start == end {
+		return dailyWindow{}, fmt.Errorf("invalid window %q: it is empty", s)
+	}
+	return dailyWindow{start, end}, nil
+}
+
+// parseTimeOfDay parses HH:MM as an offset from midnight.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	h, m, ok := strings.Cut(s, ":")
+	hour, herr := strconv.Atoi(h)
+	minute, merr := strconv.Atoi(m)
+	if !ok || herr != nil || merr != nil || len(m) != 2 || hour < 0 || minute < 0 || minute > 59 ||
+		hour > 24 || hour == 24 && minute != 0 {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	return time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute, nil
+}
+
+// contains reports whether t falls within the window.
+func (w dailyWindow) contains(t time.Time) bool {
+	hour, minute, sec := t.Clock()
+	d := time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute + time.Duration(sec)*time.Second
+	if w.start < w.end {
+		return w.start <= d && d < w.end
+	}
+	return d >= w.start || d < w.end
+}
+
+// allows reports whether a Polish may start at t.
+func (p *PolishScheduler) allows(t time.Time) bool {
+	if len(p.windows) == 0 {
+		return true
+	}
+	for _, w := range p.windows {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Stop stops polishing and waits for a Polish in progress to finish.
+func (p *PolishScheduler) Stop() {
+	select {
+	case <-p.stop:
+	default:
+		close(p.stop)
+	}
+	<-p.done
+}
+
+func (p *PolishScheduler) run() {
+	defer close(p.done)
+
+	for {
+		timer := p.store.clock.NewTimer(p.sched.Interval)
+		select {
+		case <-timer.C():
+		case <-p.stop:
+			timer.Stop()
+			return
+		case <-p.store.done:
+			timer.Stop()
+			return
+		}
+
+		if !p.allows(p.store.clock.Now()) {
+			continue
+		}
+		stats := p.store.Stats()
+		if stats.DeadBytes == 0 || stats.Fragmentation < p.sched.Fragmentation {
+			continue
+		}
+		err := p.store.Polish()
+		if err != nil && p.sched.OnError != nil {
+			p.sched.OnError(err)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package stone
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingDriver counts the backups stored through it.
+type countingDriver struct {
+	DirDriver
+	puts atomic.Int32
+}
+
+func (d *countingDriver) Put(name string, r io.Reader) error {
+	if !strings.HasSuffix(name, ManifestSuffix) {
+		d.puts.Add(1)
+	}
+	return d.DirDriver.Put(name, r)
+}
+
+func TestScheduleBackups(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	store.Set([]byte("key1"), []byte("value1"))
+
+	driver := &countingDriver{DirDriver: DirDriver(filepath.Join(dir, "backups"))}
+	scheduler, err := store.ScheduleBackups(BackupSchedule{
+		Interval: 10 * time.Millisecond,
+		Driver:   driver,
+		Name:     "stone-",
+		Retain:   2,
+		OnError:  func(err error) { t.Errorf("background backup failed: %v", err) },
+	})
+	if err != nil {
+		t.Fatalf("schedule failed: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for driver.puts.Load() < 4 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for backups")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	scheduler.Stop()
+
+	names, err := driver.List("stone-")
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if len(names) != 4 {
+		t.Fatalf("expected 2 retained backups and their manifests, got %v", names)
+	}
+	manifests, err := ListBackups(filepath.Join(dir, "backups"))
+	if err != nil {
+		t.Fatalf("list backups failed: %v", err)
+	}
+	if len(manifests) != 2 || manifests[1].Name != names[2] {
+		t.Fatalf("expected manifests of %v, got %+v", names, manifests)
+	}
+	backup, err := NewStore(filepath.Join(dir, "backups", names[2]))
+	if err != nil {
+		t.Fatalf("failed to open backup: %v", err)
+	}
+	defer backup.Close()
+	value, err := backup.Get([]byte("key1"))
+	if err != nil || string(value) != "value1" {
+		t.Errorf("expected 'value1', got '%s' (%v)", value, err)
+	}
+}
+
+func TestScheduleBackupsValidation(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	driver := DirDriver(t.TempDir())
+	invalid := []BackupSchedule{
+		{Driver: driver},
+		{Interval: time.Hour, Cron: "0 * * * *", Driver: driver},
+		{Cron: "every day", Driver: driver},
+		{Interval: time.Hour},
+	}
+	for _, sched := range invalid {
+		_, err := store.ScheduleBackups(sched)
+		if err == nil {
+			t.Errorf("expected error for %+v", sched)
+		}
+	}
+}
+
+func TestSchedulePolish(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	for i := 0; i < 10; i++ {
+		store.Set([]byte("key"), []byte("value"))
+	}
+
+	// Outside its window, the scheduler leaves the log alone
+	now := time.Now()
+	later := fmt.Sprintf("%s-%s", now.Add(2*time.Hour).Format("15:04"), now.Add(3*time.Hour).Format("15:04"))
+	scheduler, err := store.SchedulePolish(PolishSchedule{
+		Windows:  []string{later},
+		Interval: 10 * time.Millisecond,
+		OnError:  func(err error) { t.Errorf("background polish failed: %v", err) },
+	})
+	if err != nil {
+		t.Fatalf("schedule failed: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	scheduler.Stop()
+	if n := store.Stats().Compactions; n != 0 {
+		t.Fatalf("expected no polish outside the window, got %d", n)
+	}
+
+	// Within it, the log is polished once fragmented enough, and only then
+	scheduler, err = store.SchedulePolish(PolishSchedule{
+		Windows:  []string{"00:00-12:00", "12:00-24:00"},
+		Interval: 10 * time.Millisecond,
+		OnError:  func(err error) { t.Errorf("background polish failed: %v", err) },
+	})
+	if err != nil {
+		t.Fatalf("schedule failed: %v", err)
+	}
+	defer scheduler.Stop()
+	deadline := time.Now().Add(5 * time.Second)
+	for store.Stats().Compactions == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for polish")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := store.Stats().Compactions; n != 1 {
+		t.Errorf("expected one polish of the fragmented log, got %d", n)
+	}
+}
+
+func TestPolishWindows(t *testing.T) {
+	at := func(clock string) time.Time {
+		t, _ := time.ParseInLocation("15:04", clock, time.Local)
+		return t
+	}
+	tests := []struct {
+		window string
+		in     []string
+		out    []string
+	}{
+		{"02:00-04:00", []string{"02:00", "03:59"}, []string{"01:59", "04:00", "14:00"}},
+		{"22:00-02:00", []string{"22:00", "23:59", "00:00", "01:59"}, []string{"02:00", "21:59", "12:00"}},
+		{"20:00-24:00", []string{"20:00", "23:59"}, []string{"00:00", "19:59"}},
+	}
+	for _, tt := range tests {
+		w, err := parseWindow(tt.window)
+		if err != nil {
+			t.Fatalf("failed to parse %q: %v", tt.window, err)
+		}
+		for _, clock := range tt.in {
+			if !w.contains(at(clock)) {
+				t.Errorf("expected %s to be in %s", clock, tt.window)
+			}
+		}
+		for _, clock := range tt.out {
+			if w.contains(at(clock)) {
+				t.Errorf("expected %s not to be in %s", clock, tt.window)
+			}
+		}
+	}
+
+	for _, invalid := range []string{"", "02:00", "2-4", "02:00-02:00", "24:00-02:00", "02:00-24:01", "25:00-02:00", "02:60-03:00"} {
+		if _, err := parseWindow(invalid); err == nil {
+			t.Errorf("expected %q to be invalid", invalid)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package stone
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Full-text search is a secondary index of the words of values, created
+// with a TextIndex, and Search, which combines the keys indexed by the
+// words of a query.
+
+// TextIndex returns an Indexer for CreateIndex indexing values by their
+// words, for Search. Words are runs of letters and digits, lowercased. If
+// field isn't nil, the words of the values it extracts are indexed rather
+// than those of the whole value, so TextIndex(JSONIndex("body")) indexes
+// the words of the body field of JSON values.
+func TextIndex(field Indexer) Indexer {
+	return func(key, value []byte) [][]byte {
+		texts := [][]byte{value}
+		if field != nil {
+			texts = field(key, value)
+		}
+		var words [][]byte
+		for _, text := range texts {
+			words = append(words, tokenize(text)...)
+		}
+		return words
+	}
+}
+
+// tokenize splits text into lowercase words.
+func tokenize(text []byte) [][]byte {
+	fields := bytes.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, f := range fields {
+		fields[i] = bytes.ToLower(f)
+	}
+	return fields
+}
+
+// Search returns the keys matching query in the secondary index of the
+// given name, created with a TextIndex, in ascending order. A query is a
+// list of terms, all of which a key must match:
+//
+//   - a word matches the keys indexed by it,
+//   - a word followed by * matches the keys indexed by a word it starts,
+//   - terms joined by OR match the keys matching any of them,
+//   - a term preceded by - matches the keys not matching it.
+//
+// So "go OR rust -java data*" matches the keys indexed by go or rust and
+// by a word starting with data, but not by java. Terms are lowercased like
+// the indexed words, and a term splitting into several words, like "e-mail",
+// matches the keys indexed by all of them.
+func (s *Store) Search(index, query string) ([][]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	x, ok := s.indexes[index]
+	if !ok {
+		return nil, fmt.Errorf("index %q not found", index)
+	}
+
+	var result map[string]struct{} // nil until the first positive clause
+	var excluded []map[string]struct{}
+	fields := strings.Fields(query)
+	for i := 0; i < len(fields); i++ {
+		negated := strings.HasPrefix(fields[i], "-")
+		clause := x.match(strings.TrimPrefix(fields[i], "-"))
+		for i+2 < len(fields) && fields[i+1] == "OR" {
+			for key := range x.match(strings.TrimPrefix(fields[i+2], "-")) {
+				clause[key] = struct{}{}
+			}
+			i += 2
+		}
+		switch {
+		case negated:
+			excluded = append(excluded, clause)
+		case result == nil:
+			result = clause
+		default:
+			for key := range result {
+				if _, ok := clause[key]; !ok {
+					delete(result, key)
+				}
+			}
+		}
+	}
+	if result == nil && len(excluded) > 0 {
+		// Only negated terms: all the keys indexed but those
+		result = make(map[string]struct{}, len(x.values))
+		for key := range x.values {
+			result[key] = struct{}{}
+		}
+	}
+
+	keys := make([]string, 0, len(result))
+	for key := range result {
+		if !slices.ContainsFunc(excluded, func(clause map[string]struct{}) bool {
+			_, ok := clause[key]
+			return ok
+		}) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	matches := make([][]byte, len(keys))
+	for i, key := range keys {
+		matches[i] = []byte(key)
+	}
+	return matches, nil
+}
+
+// match returns the keys matching a term of a query, see Search.
+func (x *secondaryIndex) match(term string) map[string]struct{} {
+	prefix := strings.HasSuffix(term, "*")
+	words := tokenize([]byte(strings.TrimSuffix(term, "*")))
+	var keys map[string]struct{}
+	for i, word := range words {
+		found := make(map[string]struct{})
+		if prefix && i == len(words)-1 {
+			for value, indexed := range x.keys {
+				if strings.HasPrefix(value, string(word)) {
+					for key := range indexed {
+						found[key] = struct{}{}
+					}
+				}
+			}
+		} else {
+			for key := range x.keys[string(word)] {
+				found[key] = struct{}{}
+			}
+		}
+		if keys != nil {
+			for key := range keys {
+				if _, ok := found[key]; !ok {
+					delete(keys, key)
+				}
+			}
+		} else {
+			keys = found
+		}
+	}
+	if keys == nil {
+		keys = make(map[string]struct{})
+	}
+	return keys
+}
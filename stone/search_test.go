@@ -0,0 +1,70 @@
+package stone
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSearch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	store.Set([]byte("doc:1"), []byte("Go is a language; Rust is another."))
+	store.Set([]byte("doc:2"), []byte("Databases in Rust, and e-mail"))
+	store.Set([]byte("doc:3"), []byte("Java and databases"))
+	store.Set([]byte("note"), []byte(`{"body": "Dataflow in Go"}`))
+
+	err = store.CreateIndex("text", TextIndex(nil))
+	if err != nil {
+		t.Fatalf("failed to create index: %v", err)
+	}
+	store.CreateIndex("body", TextIndex(JSONIndex("body")))
+	search := func(index, query string) []string {
+		t.Helper()
+		keys, err := store.Search(index, query)
+		if err != nil {
+			t.Fatalf("search failed: %v", err)
+		}
+		var strs []string
+		for _, key := range keys {
+			strs = append(strs, string(key))
+		}
+		return strs
+	}
+
+	for _, c := range []struct {
+		query string
+		want  []string
+	}{
+		{"rust", []string{"doc:1", "doc:2"}},
+		{"RUST databases", []string{"doc:2"}},
+		{"go OR java", []string{"doc:1", "doc:3", "note"}},
+		{"data*", []string{"doc:2", "doc:3", "note"}},
+		{"data* -java", []string{"doc:2", "note"}},
+		{"-rust -go", []string{"doc:3"}},
+		{"e-mail", []string{"doc:2"}},
+		{"python", nil},
+		{"", nil},
+	} {
+		if got := search("text", c.query); !slices.Equal(got, c.want) {
+			t.Errorf("expected %q to match %v, got %v", c.query, c.want, got)
+		}
+	}
+	if got := search("body", "dataflow"); !slices.Equal(got, []string{"note"}) {
+		t.Errorf("expected the body field to be indexed, got %v", got)
+	}
+
+	// Maintained by writes
+	store.Set([]byte("doc:3"), []byte("Go"))
+	if got := search("text", "go -rust"); !slices.Equal(got, []string{"doc:3", "note"}) {
+		t.Errorf("expected [doc:3 note], got %v", got)
+	}
+	_, err = store.Search("missing", "go")
+	if err == nil {
+		t.Errorf("expected searching a missing index to fail")
+	}
+}
@@ -0,0 +1,75 @@
+package stone
+
+// Sets are stored as a key per member, with an empty value, so adding or
+// removing a member writes one small record and checking membership is a
+// lookup in the index.
+
+// SAdd adds members to the set stored under key, creating the set if it
+// doesn't exist, and returns how many of them weren't in it yet. Members
+// are added under one write lock, so concurrent SAdds and SRems of the same
+// set don't race.
+func (s *Store) SAdd(key []byte, members ...[]byte) (int, error) {
+	var added int
+	err := s.write(func() error {
+		for _, member := range members {
+			element := typedKey(kindSet, key, member)
+			_, ok, err := s.find(string(element))
+			if err != nil {
+				return err
+			}
+			if ok {
+				continue
+			}
+			err = s.set(s.seq+1, element, nil)
+			if err != nil {
+				return err
+			}
+			added++
+		}
+		return nil
+	})
+	return added, err
+}
+
+// SRem removes members from the set stored under key and returns how many
+// of them were in it. A set is deleted with its last member.
+func (s *Store) SRem(key []byte, members ...[]byte) (int, error) {
+	var removed int
+	err := s.write(func() error {
+		for _, member := range members {
+			element := typedKey(kindSet, key, member)
+			_, ok, err := s.find(string(element))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			err = s.del(s.seq+1, element)
+			if err != nil {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// SIsMember reports whether member is in the set stored under key.
+func (s *Store) SIsMember(key, member []byte) (bool, error) {
+	_, ok, err := s.find(string(typedKey(kindSet, key, member)))
+	return ok, err
+}
+
+// SMembers returns the members of the set stored under key, in ascending
+// order, or none if the set doesn't exist.
+func (s *Store) SMembers(key []byte) ([][]byte, error) {
+	prefix := typedKey(kindSet, key, nil)
+	keys := s.sortedKeys(prefix)
+	members := make([][]byte, len(keys))
+	for i, k := range keys {
+		members[i] = []byte(k[len(prefix):])
+	}
+	return members, nil
+}
@@ -0,0 +1,52 @@
+package stone
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	key := []byte("tags")
+
+	n, err := store.SAdd(key, []byte("go"), []byte("kv"), []byte("go"))
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 members added, got %d, %v", n, err)
+	}
+	n, err = store.SAdd(key, []byte("kv"), []byte("db"))
+	if err != nil || n != 1 {
+		t.Errorf("expected 1 member added, got %d, %v", n, err)
+	}
+	ok, err := store.SIsMember(key, []byte("kv"))
+	if err != nil || !ok {
+		t.Errorf("expected kv to be a member, got %v, %v", ok, err)
+	}
+	ok, _ = store.SIsMember([]byte("tag"), []byte("skv"))
+	if ok {
+		t.Errorf("expected sets not to share members")
+	}
+
+	n, err = store.SRem(key, []byte("kv"), []byte("missing"))
+	if err != nil || n != 1 {
+		t.Errorf("expected 1 member removed, got %d, %v", n, err)
+	}
+	store.Close()
+
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	members, err := store.SMembers(key)
+	if err != nil || len(members) != 2 || string(members[0]) != "db" || string(members[1]) != "go" {
+		t.Errorf("expected [db go], got %q, %v", members, err)
+	}
+	members, _ = store.SMembers([]byte("missing"))
+	if len(members) != 0 {
+		t.Errorf("expected a missing set to be empty, got %q", members)
+	}
+}
@@ -0,0 +1,228 @@
+package stone
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+// Index snapshots are written next to the database, in the format
+//
+//	[magic:4][version:1][end:8][seq:8][floor:8][tailLen:1][tail:32][count:8]
+//	count × [keyLen:4][key][offset:8][seq:8][size:8][depth:1]
+//	[crc:4]
+//
+// where end is the length of the log the snapshot covers and tail holds
+// its last tailLen bytes, so a log that was replaced since is detected.
+const (
+	snapshotMagic   = "STIX"
+	snapshotVersion = 3
+	snapshotTail    = 32
+)
+
+// snapshotPath returns the path of the index snapshot of the log at path.
+func snapshotPath(path string) string {
+	return path + ".index"
+}
+
+// writeIndexSnapshot syncs the log and writes the index to its snapshot
+// file, replacing the previous snapshot. The caller must hold the store
+// lock.
+func (s *Store) writeIndexSnapshot() error {
+	err := s.file.Sync()
+	if err != nil {
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+	tail, err := s.logTail(s.end)
+	if err != nil {
+		return err
+	}
+
+	path := snapshotPath(s.file.Name())
+	tmp, err := s.backend.OpenFile(path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return fmt.Errorf("failed to create index snapshot: %w", err)
+	}
+	defer s.backend.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := crc32.NewIEEE()
+	w := bufio.NewWriterSize(io.MultiWriter(tmp, h), indexReadBuffer)
+	w.WriteString(snapshotMagic)
+	w.WriteByte(snapshotVersion)
+	var buf [8]byte
+	putUint64 := func(v uint64) {
+		binary.LittleEndian.PutUint64(buf[:], v)
+		w.Write(buf[:])
+	}
+	putUint64(uint64(s.end))
+	putUint64(s.seq)
+	putUint64(s.floor)
+	w.WriteByte(byte(len(tail)))
+	var padded [snapshotTail]byte
+	copy(padded[:], tail)
+	w.Write(padded[:])
+	putUint64(uint64(s.index.len()))
+	for key, entry := range s.index.all() {
+		binary.LittleEndian.PutUint32(buf[:4], uint32(len(key)))
+		w.Write(buf[:4])
+		w.WriteString(key)
+		putUint64(entry.offset)
+		putUint64(entry.seq)
+		putUint64(uint64(entry.size))
+		w.WriteByte(entry.depth)
+	}
+	err = w.Flush()
+	if err == nil {
+		binary.LittleEndian.PutUint32(buf[:4], h.Sum32())
+		_, err = tmp.Write(buf[:4])
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write index snapshot: %w", err)
+	}
+	err = tmp.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write index snapshot: %w", err)
+	}
+	err = s.backend.Rename(tmp.Name(), path)
+	if err != nil {
+		return fmt.Errorf("failed to replace index snapshot: %w", err)
+	}
+	return syncDirOf(s.backend, path)
+}
+
+// readIndexSnapshot fills the index from the snapshot file and returns the
+// length of the log it covers. It fails if the snapshot is missing,
+// corrupted or doesn't match the log. The caller must have the store to
+// itself.
+func (s *Store) readIndexSnapshot() (int64, error) {
+	f, err := s.backend.OpenFile(snapshotPath(s.file.Name()), os.O_RDONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	h := crc32.NewIEEE()
+	br := bufio.NewReaderSize(f, indexReadBuffer)
+	r := io.TeeReader(br, h)
+	var header [4 + 1 + 8 + 8 + 8 + 1 + snapshotTail + 8]byte
+	_, err = io.ReadFull(r, header[:])
+	if err != nil {
+		return 0, unexpected(err)
+	}
+	if string(header[:4]) != snapshotMagic || header[4] != snapshotVersion {
+		return 0, fmt.Errorf("not an index snapshot")
+	}
+	end := int64(binary.LittleEndian.Uint64(header[5:13]))
+	seq := binary.LittleEndian.Uint64(header[13:21])
+	floor := binary.LittleEndian.Uint64(header[21:29])
+	tailLen := int(header[29])
+	count := binary.LittleEndian.Uint64(header[30+snapshotTail:])
+
+	// Check the snapshot is of this log before reading the keys
+	if tailLen > snapshotTail {
+		return 0, fmt.Errorf("invalid index snapshot")
+	}
+	info, err := s.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if info.Size() < end {
+		return 0, fmt.Errorf("index snapshot is ahead of the log")
+	}
+	tail, err := s.logTail(end)
+	if err != nil {
+		return 0, err
+	}
+	if len(tail) != tailLen || !bytes.Equal(tail, header[30:30+tailLen]) {
+		return 0, fmt.Errorf("index snapshot is of another log")
+	}
+
+	s.index.clear()
+	var buf [8 + 8 + 8 + 1]byte
+	for i := uint64(0); i < count; i++ {
+		_, err = io.ReadFull(r, buf[:4])
+		if err != nil {
+			return 0, unexpected(err)
+		}
+		key, err := readN(r, nil, int(binary.LittleEndian.Uint32(buf[:4])))
+		if err == nil {
+			_, err = io.ReadFull(r, buf[:])
+		}
+		if err != nil {
+			return 0, unexpected(err)
+		}
+		k := string(key)
+		s.index.putUnlocked(k, indexEntry{
+			offset: binary.LittleEndian.Uint64(buf[:8]),
+			seq:    binary.LittleEndian.Uint64(buf[8:16]),
+			size:   int64(binary.LittleEndian.Uint64(buf[16:24])),
+			depth:  buf[24],
+		})
+	}
+	_, err = io.ReadFull(br, buf[:4])
+	if err != nil {
+		return 0, unexpected(err)
+	}
+	if binary.LittleEndian.Uint32(buf[:4]) != h.Sum32() {
+		return 0, fmt.Errorf("index snapshot checksum mismatch")
+	}
+
+	s.seq = seq
+	s.floor = floor
+	return end, nil
+}
+
+// logTail returns the last bytes of the first end bytes of the log.
+func (s *Store) logTail(end int64) ([]byte, error) {
+	tail := make([]byte, min(end, snapshotTail))
+	_, err := s.file.ReadAt(tail, end-int64(len(tail)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read log: %w", err)
+	}
+	return tail, nil
+}
+
+// removeIndexSnapshot deletes the index snapshot before the log is
+// replaced or truncated, which would make it wrong.
+func (s *Store) removeIndexSnapshot() error {
+	err := s.backend.Remove(snapshotPath(s.file.Name()))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove index snapshot: %w", err)
+	}
+	return nil
+}
+
+// snapshotIndexEvery writes an index snapshot every interval until the
+// store is closed. A failed snapshot only makes the next open slower, so
+// errors are ignored; the next tick tries again.
+func (s *Store) snapshotIndexEvery(interval time.Duration) {
+	for {
+		timer := s.clock.NewTimer(interval)
+		select {
+		case <-s.done:
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+
+		s.mu.RLock()
+		select {
+		case <-s.done:
+		default:
+			err := s.writeIndexSnapshot()
+			if err != nil {
+				s.log.Error("failed to write index snapshot", "error", err)
+			}
+		}
+		s.mu.RUnlock()
+	}
+}
@@ -0,0 +1,101 @@
+package stone
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIndexSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	opts := Options{IndexSnapshot: true}
+	store, err := NewStoreWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		store.Set([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)))
+	}
+	store.Delete([]byte("key0"))
+	if err = store.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	if _, err = os.Stat(snapshotPath(path)); err != nil {
+		t.Fatalf("expected Close to write a snapshot: %v", err)
+	}
+
+	// Records appended after the snapshot, e.g. by a store opened without
+	// snapshots, are replayed on top of it
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	store.Set([]byte("key1"), []byte("updated"))
+	store.Delete([]byte("key2"))
+	store.Close()
+
+	store, err = NewStoreWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	check := func(store *Store) {
+		t.Helper()
+		stats := store.Stats()
+		if stats.Keys != 98 || stats.Seq != 103 {
+			t.Errorf("expected 98 keys up to seq 103, got %d up to %d", stats.Keys, stats.Seq)
+		}
+		for key, want := range map[string]string{"key1": "updated", "key99": "value99", "key0": "", "key2": ""} {
+			value, err := store.Get([]byte(key))
+			if string(value) != want || (want == "") != (err != nil) {
+				t.Errorf("expected %s=%q, got %q (%v)", key, want, value, err)
+			}
+		}
+	}
+	check(store)
+
+	// Compaction replaces the log, so the snapshot is dropped
+	if err = store.Polish(); err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	if _, err = os.Stat(snapshotPath(path)); !os.IsNotExist(err) {
+		t.Errorf("expected Polish to remove the snapshot, got %v", err)
+	}
+	store.Close()
+
+	// A snapshot that doesn't match the log is ignored
+	snapshot, _ := os.ReadFile(snapshotPath(path))
+	snapshot[30] ^= 0xFF // First byte of the tail
+	os.WriteFile(snapshotPath(path), snapshot, 0666)
+	store, err = NewStoreWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	check(store)
+	if _, err = store.readIndexSnapshot(); err == nil {
+		t.Error("expected mismatched snapshot to be rejected")
+	}
+}
+
+func TestIndexSnapshotInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewStoreWithOptions(path, Options{IndexSnapshot: true, IndexSnapshotInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	store.Set([]byte("key"), []byte("value"))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err = os.Stat(snapshotPath(path)); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected a snapshot to be written periodically")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
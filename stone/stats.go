@@ -0,0 +1,57 @@
+package stone
+
+import "time"
+
+// Stats is a snapshot of the size and maintenance of a store.
+type Stats struct {
+	Keys           int       // Live keys
+	Seq            uint64    // Sequence number of the last record written
+	FileSize       int64     // Size of the log in bytes
+	LiveBytes      int64     // Bytes of the log holding the records of live keys
+	DeadBytes      int64     // Bytes of the log Polish would reclaim, kept current by every write
+	Fragmentation  float64   // Share of the log that is dead, from 0 to 1
+	IndexMemory    int64     // Estimated bytes of memory the index takes
+	Compactions    int       // Polish runs since the store was opened
+	LastCompaction time.Time // When the last of them finished, zero if none
+	Evictions      int       // Keys evicted by Options.CacheSize or EvictOnQuota
+	ColdKeys       int       // Keys in the cold tier of Options.ColdPath
+	ColdFileSize   int64     // Size of the cold tier in bytes
+	ColdDeadBytes  int64     // Bytes of the cold tier its next Polish would reclaim
+
+	// Latency holds the percentiles of the time Get, Set, Delete, Scan,
+	// Polish and Backup took since the store was opened, by the operation
+	// names "get", "set", "delete", "scan", "polish" and "backup".
+	Latency map[string]Latency
+}
+
+// Stats returns the current statistics of the store. It only reads
+// counters kept up to date by writes, so it is cheap enough to poll.
+func (s *Store) Stats() Stats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var cold Stats
+	if s.cold != nil {
+		cold = s.cold.Stats()
+	}
+	dead := s.end - s.index.live
+	var fragmentation float64
+	if s.end > 0 {
+		fragmentation = float64(dead) / float64(s.end)
+	}
+	return Stats{
+		Keys:           s.index.len(),
+		Seq:            s.seq,
+		FileSize:       s.end,
+		LiveBytes:      s.index.live,
+		DeadBytes:      dead,
+		Fragmentation:  fragmentation,
+		IndexMemory:    s.index.memory(),
+		Compactions:    s.compactions,
+		LastCompaction: s.lastPolish,
+		Evictions:      s.evictions,
+		ColdKeys:       cold.Keys,
+		ColdFileSize:   cold.FileSize,
+		ColdDeadBytes:  cold.DeadBytes,
+		Latency:        s.latency.summaries(),
+	}
+}
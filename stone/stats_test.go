@@ -0,0 +1,76 @@
+package stone
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	store.Set([]byte("a"), []byte("1"))
+	store.Set([]byte("a"), []byte("2"))
+	store.Set([]byte("b"), []byte("3"))
+	store.Delete([]byte("b"))
+
+	stats := store.Stats()
+	info, _ := os.Stat(path)
+	if stats.Keys != 1 || stats.Seq != 4 || stats.FileSize != info.Size() || stats.Compactions != 0 || !stats.LastCompaction.IsZero() {
+		t.Errorf("unexpected stats before polish %+v", stats)
+	}
+	live := setRecordSize(1, 1)
+	if stats.LiveBytes != live || stats.DeadBytes != stats.FileSize-live || stats.Fragmentation != float64(stats.DeadBytes)/float64(stats.FileSize) {
+		t.Errorf("expected %d live bytes and the rest dead, got %+v", live, stats)
+	}
+	if stats.IndexMemory <= 0 {
+		t.Errorf("expected an estimate of the index memory, got %d", stats.IndexMemory)
+	}
+
+	err = store.Polish()
+	if err != nil {
+		t.Fatalf("Polish failed: %v", err)
+	}
+	stats = store.Stats()
+	info, _ = os.Stat(path)
+	if stats.Keys != 1 || stats.FileSize != info.Size() || stats.Compactions != 1 || stats.LastCompaction.IsZero() {
+		t.Errorf("unexpected stats after polish %+v", stats)
+	}
+	if stats.LiveBytes != live || stats.DeadBytes != stats.FileSize-live {
+		t.Errorf("expected only the checkpoint to be dead after polish, got %+v", stats)
+	}
+}
+
+func TestDeadBytes(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	// Every write updates the estimate as it lands
+	set := setRecordSize(1, 1)
+	del := int64(1 + 8 + 4 + 1 + 4)
+	steps := []struct {
+		write func() error
+		dead  int64
+	}{
+		{func() error { return store.Set([]byte("a"), []byte("1")) }, 0},
+		{func() error { return store.Set([]byte("b"), []byte("1")) }, 0},
+		{func() error { return store.Set([]byte("a"), []byte("2")) }, set},
+		{func() error { return store.Delete([]byte("b")) }, 2*set + del},
+		{func() error { return <-store.SetAsync([]byte("a"), []byte("3")) }, 3*set + del},
+	}
+	for i, step := range steps {
+		if err := step.write(); err != nil {
+			t.Fatalf("write %d failed: %v", i, err)
+		}
+		if dead := store.Stats().DeadBytes; dead != step.dead {
+			t.Errorf("expected %d dead bytes after write %d, got %d", step.dead, i, dead)
+		}
+	}
+}
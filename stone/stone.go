@@ -1,338 +1,1083 @@
 package stone
 
 import (
+	"bufio"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Store represents the StoneKV key/value store with on-disk persistence.
 type Store struct {
-	file  *os.File          // File handle for the database
-	index map[string]uint64 // In-memory index mapping keys to value offsets
-	mu    sync.RWMutex      // Mutex for concurrent access
+	file   File          // File handle for the database
+	index  *index        // In-memory index mapping keys to value offsets
+	mu     sync.RWMutex  // Serializes writes; see index for Gets
+	seq    uint64        // Sequence number of the last record written
+	delSeq atomic.Uint64 // Sequence number of the last delete, see Txn
+	floor  uint64        // Sequence number up to which history was compacted
+	end    int64         // Offset of the end of the log
+	gen    uint64        // Incremented whenever the log file is replaced
+	notify chan struct{} // Closed and replaced whenever the log changes
+	done   chan struct{} // Closed when the store is closed
+	opts   Options       // Options the store was opened with
+	log    storeLogger   // Options.Logger
+	wal    *walWriter    // Record archive, if Options.WALDir is set
+	mmap   *mmapLog      // Memory mapping of the log, if Options.MMap is set
+
+	backend Backend   // Options.Backend, or the file system
+	clock   Clock     // Options.Clock, or the time package
+	rand    io.Reader // Options.Rand, or crypto/rand
+	readers *readPool // Read handles on the log, if Options.ReadHandles is set
+	tempDir string    // Directory Close removes, see NewTempStore
+
+	compactions int       // Polish runs since the store was opened
+	lastPolish  time.Time // When the last of them finished
+	polishSpeed float64   // Bytes per second the last of them wrote, see PolishPlan
+	writeErr    error     // Error of the last write to the log, if it failed
+	tornErr     error     // Set if a failed write left bytes in the log, see dropTorn
+
+	written   uint64        // Records appended since the store was opened
+	allocated int64         // Offset up to which disk space is preallocated
+	commit    committer     // Syncs writes to disk with Options.SyncWrites
+	queue     writeQueue    // Writes queued by SetAsync
+	polishMu  sync.Mutex    // Serializes Polish
+	evictMu   sync.Mutex    // Serializes eviction with Options.EvictOnQuota
+	evictions int           // Keys evicted since the store was opened
+	cacheWake chan struct{} // Wakes cacheLoop, with Options.CacheSize
+	cold      *Store        // Cold tier, if Options.ColdPath is set
+	coldMark  uint64        // First tick of the index clock after Polish last started
+	polishing atomic.Bool   // Set while Polish runs, see deltaFor
+
+	hooksMu sync.Mutex               // Serializes changes to hooks
+	hooks   atomic.Pointer[[]*Hooks] // Registered with Hook
+	events  []event                  // Writes the hooks are yet to be called for
+
+	subsMu sync.Mutex     // Guards subs
+	subs   map[string]int // Subscriptions by channel, see Subscribe
+
+	indexes map[string]*secondaryIndex // Created with CreateIndex
+
+	latency latencies // Durations of operations, see Stats.Latency
+
+	readLimit  *limiter // Options.ReadRate, nil without a limit
+	writeLimit *limiter // Options.WriteRate, nil without a limit
+}
+
+// Options configures a store opened with NewStoreWithOptions. The zero value
+// gives the same behavior as NewStore.
+type Options struct {
+	// WALDir enables record retention for point-in-time recovery: every
+	// record written is also appended, with its timestamp, to segment files
+	// in this directory, which Polish leaves untouched. See RestoreToTime.
+	WALDir string
+	// WALSegmentSize is the size at which a new WAL segment is started.
+	// Defaults to 64 MiB.
+	WALSegmentSize int64
+	// WALRetention is how long WAL segments are kept; older segments are
+	// deleted when a new one is started. 0 keeps them all.
+	WALRetention time.Duration
+	// SyncWrites makes every write wait until its record is synced to disk,
+	// so it survives a power loss once acknowledged. Concurrent writes are
+	// group committed: they share one fsync rather than paying one each.
+	SyncWrites bool
+	// IndexSnapshot keeps a snapshot of the index in a file next to the
+	// database, named after it with an ".index" suffix, so opening the store
+	// only reads the log written since the snapshot. It is written on Close
+	// and every IndexSnapshotInterval, if set.
+	IndexSnapshot         bool
+	IndexSnapshotInterval time.Duration
+	// Preallocate reserves disk space for the log in extents of this many
+	// bytes ahead of the writes that need it, so sustained appends cause
+	// less fragmentation and fewer file system metadata updates. The size
+	// of the file doesn't change, but up to one extent more disk space is
+	// used. It uses fallocate, so it only has an effect on Linux. 0
+	// disables it.
+	Preallocate int64
+	// MMap makes Gets read values from a read-only memory mapping of the
+	// log rather than with system calls, and lets GetNoCopy return values
+	// without copying them. It is only supported on Unix systems.
+	MMap bool
+	// PolishRate limits how many bytes per second Polish writes, to the
+	// polished file and its backup together, so compacting a large store
+	// doesn't take the disk bandwidth reads and writes need. 0 means no
+	// limit.
+	PolishRate int64
+	// ReadRate and WriteRate limit the bytes per second the store reads
+	// and writes, so an embedded store can't starve the I/O of the rest of
+	// the application on a shared volume. Operations over the limit are
+	// delayed, without holding locks. ReadRate covers the values read by
+	// Gets, scans, copies and Polish; WriteRate the log appended by writes
+	// and the files Polish writes. Backups and replication aren't limited.
+	// 0 means no limit.
+	ReadRate  int64
+	WriteRate int64
+	// MaxSize caps the size of the log file in bytes: writes that would
+	// grow it further fail with ErrQuotaExceeded, while deletes still
+	// succeed so space can be reclaimed with Polish. 0 means no cap.
+	MaxSize int64
+	// EvictOnQuota makes Set and SetAsync make room rather than fail when
+	// MaxSize is reached: the log is polished and, if that doesn't free
+	// enough, the keys written longest ago are deleted and the log is
+	// polished again, down to 90% of MaxSize.
+	EvictOnQuota bool
+	// CacheSize makes the store a persistent cache holding at most about
+	// this many bytes of live records: when writes take it over, the least
+	// recently read or written keys are evicted in the background, down to
+	// 90% of CacheSize, and the log is polished once the records evicted or
+	// overwritten take up more than CacheSize too. 0 disables it.
+	CacheSize int64
+	// ColdPath enables tiered storage: it is the path of a second log,
+	// e.g. on slower and cheaper storage, to which Polish moves the keys
+	// that weren't read or written since the Polish before, keeping the
+	// main log small. Reads and scans look in both, and writing a cold key
+	// moves it back. Uses are tracked in memory from when the store is
+	// opened, so the first Polish after opening it moves nothing.
+	ColdPath string
+	// DeltaValues stores a value overwriting a similar one as a diff
+	// against it, when that takes at most half the space, shrinking the
+	// log of workloads that repeatedly update large documents. Reading such
+	// a value reads the values it is a diff against too, up to 8 of them;
+	// Polish stores every value whole again.
+	DeltaValues bool
+	// Interceptors wrap every Get, GetNoCopy, Set, SetAsync, Delete, Scan,
+	// Polish, Backup and BackupTo, the first outermost, see Interceptor.
+	Interceptors []Interceptor
+	// Logger, if set, receives warnings, the results of Polish and the
+	// errors of background work, which the store otherwise keeps to
+	// itself. A *slog.Logger is a Logger.
+	Logger Logger
+	// Expvar publishes the Stats of the store in expvar under this name,
+	// so services serving /debug/vars show them. Once the store is closed
+	// the name shows null, until a store is opened with it again. Empty
+	// disables it.
+	Expvar string
+	// Backend is where the log and the files next to it are kept, see
+	// Backend. nil means the file system, like os.OpenFile.
+	Backend Backend
+	// Clock is the source of time of the store, see Clock. nil means the
+	// time package.
+	Clock Clock
+	// Rand is the source of randomness of the store, for the names of
+	// temporary files and the salts and nonces of encrypted backups. nil
+	// means crypto/rand. Only set it to a seeded source in tests: it makes
+	// encryption predictable.
+	Rand io.Reader
+	// ReadOnly opens an existing store without writing to it, e.g. to
+	// inspect the log of a store on read-only media: writes and Polish fail
+	// with ErrReadOnly. WALDir and CacheSize are ignored, and IndexSnapshot
+	// only reads the snapshot.
+	ReadOnly bool
+	// RedactKeys leaves keys out of errors, see OpError, so logging them
+	// doesn't leak keys holding e.g. email addresses or tokens.
+	RedactKeys bool
+	// ValidateKey, if set, is called with the key of every Set, SetAsync,
+	// SetIfVersion, Delete and DeleteMulti, and of the writes of
+	// transactions; a non-nil error fails the write with ErrInvalidKey, e.g.
+	// to restrict keys to a charset or length. Writes storing a value always
+	// reject empty keys, with ErrEmptyKey; deletes accept them without
+	// calling ValidateKey, see Delete.
+	ValidateKey func(key []byte) error
+	// MaxKeySize and MaxValueSize limit the sizes of the keys and values
+	// that Set, SetAsync, SetIfVersion and transactions write: larger ones
+	// fail with ErrKeyTooLarge or ErrValueTooLarge rather than make a record
+	// other tools can't read. 0 means DefaultMaxKeySize and
+	// DefaultMaxValueSize; neither can exceed the 4 GiB the log can frame.
+	MaxKeySize   int
+	MaxValueSize int
+	// ZeroCopyReads makes Get return values like GetNoCopy does: with MMap,
+	// straight from the memory mapping of the log, without the allocation
+	// but with the rules of GetNoCopy. By default Get returns a copy the
+	// caller owns. Without MMap it has no effect.
+	ZeroCopyReads bool
+	// ReadHandles opens this many more read-only handles on the log, which
+	// Gets and scans take turns reading values through, so heavy parallel
+	// reads don't contend for the one handle writes use. 0 reads through
+	// that one.
+	ReadHandles int
+}
+
+// indexEntry locates the live record for a key.
+type indexEntry struct {
+	offset uint64 // Offset of the value length field
+	seq    uint64 // Sequence number of the record
+	size   int64  // Size of the record
+	depth  uint8  // Deltas to apply to read the value, see delta.go
 }
 
 // NewStore initializes or opens a StoneKV store at the given file path.
 func NewStore(path string) (*Store, error) {
-	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	return NewStoreWithOptions(path, Options{})
+}
+
+// NewStoreWithOptions initializes or opens a StoneKV store at the given file
+// path, configured by opts.
+func NewStoreWithOptions(path string, opts Options) (*Store, error) {
+	backend := backendOf(opts)
+	flag := os.O_RDWR | os.O_CREATE | os.O_APPEND
+	if opts.ReadOnly {
+		flag = os.O_RDONLY
+	}
+	_, statErr := os.Stat(path)
+	file, err := backend.OpenFile(path, flag, 0666)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open file: %v", err)
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	if os.IsNotExist(statErr) && !opts.ReadOnly {
+		// Make the creation of the log durable, not just what it holds
+		err = syncDirOf(backend, path)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
 	}
 
 	store := &Store{
-		file:  file,
-		index: make(map[string]uint64),
+		file:    file,
+		backend: backend,
+		clock:   clockOf(opts),
+		rand:    randOf(opts),
+		index:   newIndex(),
+		notify:  make(chan struct{}),
+		done:    make(chan struct{}),
+		opts:    opts,
+		log:     storeLogger{opts.Logger, path},
+
+		readLimit:  newLimiter(opts.ReadRate),
+		writeLimit: newLimiter(opts.WriteRate),
+	}
+	store.commit.cond = sync.NewCond(&store.commit.mu)
+	defer func() {
+		if err != nil {
+			store.closeOpened() // Every failure below sets err
+		}
+	}()
+	if opts.MMap {
+		store.mmap = &mmapLog{}
+		_, err = store.mmap.grow(file, 0)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	err = store.buildIndex()
+	if opts.ReadHandles > 0 {
+		store.readers, err = openReadPool(backend, path, opts.ReadHandles)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = store.loadIndex()
 	if err != nil {
-		file.Close()
-		return nil, fmt.Errorf("failed to build index: %v", err)
+		return nil, fmt.Errorf("failed to build index: %w", err)
+	}
+
+	if opts.WALDir != "" && !opts.ReadOnly {
+		store.wal, err = openWAL(opts, store.seq+1)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open WAL: %w", err)
+		}
+	}
+	if opts.ColdPath != "" {
+		store.cold, err = openCold(opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.Expvar != "" {
+		err = store.publish(opts.Expvar)
+		if err != nil {
+			return nil, err
+		}
 	}
 
+	if opts.IndexSnapshot && opts.IndexSnapshotInterval > 0 && !opts.ReadOnly {
+		go store.snapshotIndexEvery(opts.IndexSnapshotInterval)
+	}
+	if opts.CacheSize > 0 && !opts.ReadOnly {
+		store.cacheWake = make(chan struct{}, 1)
+		go store.cacheLoop()
+		store.wakeCache() // In case it was opened with a smaller cap
+	}
 	return store, nil
 }
 
-// buildIndex reads the file and constructs the in-memory index.
+// closeOpened closes what NewStoreWithOptions opened for a store it failed
+// to open.
+func (s *Store) closeOpened() {
+	if s.cold != nil {
+		s.cold.Close()
+	}
+	if s.wal != nil {
+		s.wal.close()
+	}
+	s.readers.close()
+	if s.mmap != nil {
+		s.mmap.unmap()
+	}
+	s.file.Close()
+}
+
+// indexReadBuffer is the size of the buffer buildIndex reads the log
+// through, so records cost no system calls of their own.
+const indexReadBuffer = 1 << 20
+
+// buildIndex reads the file and constructs the in-memory index. The caller
+// must hold every shard lock of the index or have the store to itself.
 func (s *Store) buildIndex() error {
-	_, err := s.file.Seek(0, io.SeekStart)
-	if err != nil {
+	s.index.clear()
+	s.seq = 0
+	s.floor = 0
+	return s.replay(0)
+}
+
+// loadIndex builds the index at open. With Options.IndexSnapshot, it loads
+// the index snapshot and only replays the log written after it, falling
+// back to reading the whole log if there is no usable snapshot.
+func (s *Store) loadIndex() error {
+	if s.opts.IndexSnapshot {
+		end, err := s.readIndexSnapshot()
+		if err == nil {
+			err = s.replay(end)
+		}
+		if err == nil || errors.Is(err, errTorn) {
+			return s.cutTorn(err)
+		}
+		if !os.IsNotExist(err) {
+			s.log.Warn("index snapshot unusable, reading the whole log", "error", err)
+		}
+	}
+	return s.cutTorn(s.buildIndex())
+}
+
+// cutTorn truncates the record cut short at the end of the log that replay
+// failed with, as a crash mid-write leaves it, so the store opens with the
+// records before it. The write of such a record never returned, so nothing
+// acknowledged is lost. Read-only stores leave it, and read the log up to
+// it. Other errors are returned as is.
+func (s *Store) cutTorn(err error) error {
+	if !errors.Is(err, errTorn) {
 		return err
 	}
+	if s.opts.ReadOnly {
+		s.log.Warn("ignoring a record cut short at the end of the log", "offset", s.end)
+		return nil
+	}
+	s.log.Warn("truncating a record cut short at the end of the log", "offset", s.end)
+	err = s.file.Truncate(s.end)
+	if err == nil {
+		err = s.file.Sync()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to truncate a record cut short: %w", err)
+	}
+	return nil
+}
 
+// replay reads the log from offset, which must be the start of a record,
+// into the index. If the log ends in a record cut short, it fails with
+// errTorn, with the index and s.end covering the records before it. The
+// caller must hold every shard lock of the index or have the store to
+// itself.
+func (s *Store) replay(offset int64) error {
+	r := bufio.NewReaderSize(io.NewSectionReader(s.file, offset, math.MaxInt64-offset), indexReadBuffer)
 	for {
-		startOffset, err := s.file.Seek(0, io.SeekCurrent)
-		if err != nil {
-			return err
-		}
-
-		var typeByte byte
-		err = binary.Read(s.file, binary.LittleEndian, &typeByte)
+		rec, err := readRecord(r)
 		if err == io.EOF {
 			break
 		}
-		if err != nil {
-			return err
+		if err == io.ErrUnexpectedEOF {
+			s.end = offset
+			return s.opError("read", nil, offset, errTorn)
 		}
-
-		var keyLen uint32
-		err = binary.Read(s.file, binary.LittleEndian, &keyLen)
 		if err != nil {
-			return err
+			return s.opError("read", nil, offset, err)
 		}
 
-		keyBytes := make([]byte, keyLen)
-		_, err = s.file.Read(keyBytes)
-		if err != nil {
-			return err
+		// Legacy records have no sequence number; number them in log order.
+		if rec.kind == typeSetLegacy || rec.kind == typeDeleteLegacy {
+			rec.seq = s.seq + 1
+		}
+		if rec.seq > s.seq {
+			s.seq = rec.seq
 		}
-		keyStr := string(keyBytes)
-
-		if typeByte == 0 { // Set record
-			valLenOffset := uint64(startOffset) + 1 + 4 + uint64(keyLen)
-			s.index[keyStr] = valLenOffset
 
-			var valLen uint32
-			err = binary.Read(s.file, binary.LittleEndian, &valLen)
+		switch rec.kind {
+		case typeSet, typeSetLegacy, typeDelta:
+			key := string(rec.key)
+			entry := indexEntry{
+				offset: uint64(offset + rec.valLenOffset()),
+				seq:    rec.seq,
+				size:   rec.size,
+			}
+			if rec.kind == typeDelta {
+				// A delta is always against the value it replaces
+				base, _ := s.index.shard(key).get(key)
+				entry.depth = base.depth + 1
+			}
+			s.index.putUnlocked(key, entry)
+		case typeDelete, typeDeleteLegacy:
+			s.index.removeUnlocked(string(rec.key))
+		case typeDeleteMulti:
+			keys, err := rec.keys()
 			if err != nil {
-				return err
+				return s.opError("read", nil, offset, err)
 			}
-			_, err = s.file.Seek(int64(valLen), io.SeekCurrent)
+			for _, key := range keys {
+				s.index.removeUnlocked(string(key))
+			}
+		case typeBatch:
+			writes, err := rec.writes()
 			if err != nil {
-				return err
+				return s.opError("read", nil, offset, err)
 			}
-		} else if typeByte == 1 { // Delete record
-			delete(s.index, keyStr)
-		} else {
-			return fmt.Errorf("invalid record type: %d", typeByte)
+			at := offset + rec.valLenOffset() - int64(len(rec.key)) // Start of the writes
+			for _, w := range writes {
+				if w.kind == typeDelete {
+					s.index.removeUnlocked(string(w.key))
+				} else {
+					s.index.putUnlocked(string(w.key), indexEntry{
+						offset: uint64(at + 1 + 4 + int64(len(w.key))),
+						seq:    w.seq,
+						size:   w.size,
+					})
+				}
+				at += w.size
+			}
+		case typeCheckpoint:
+			s.floor = rec.seq
 		}
+		offset += rec.size
 	}
+	s.end = offset
 	return nil
 }
 
-// Set stores a key/value pair in the database.
-func (s *Store) Set(key, value []byte) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	record := make([]byte, 1+4+len(key)+4+len(value))
-	record[0] = 0
-	binary.LittleEndian.PutUint32(record[1:5], uint32(len(key)))
-	copy(record[5:5+len(key)], key)
-	binary.LittleEndian.PutUint32(record[5+len(key):9+len(key)], uint32(len(value)))
-	copy(record[9+len(key):], value)
+// Seq returns the sequence number of the last record written to the store.
+func (s *Store) Seq() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.seq
+}
 
-	_, err := s.file.Write(record)
+// appendRecord writes an encoded record at the end of the log and wakes any
+// goroutines tailing it. It returns the offset the record was written at.
+func (s *Store) appendRecord(record []byte) (int64, error) {
+	if s.tornErr != nil {
+		return 0, s.tornErr
+	}
+	s.preallocate(int64(len(record)))
+	n, err := writeRecord(s.file, record)
+	s.writeErr = err
 	if err != nil {
-		return fmt.Errorf("failed to write record: %v", err)
+		if n > 0 {
+			s.dropTorn(n)
+		}
+		return 0, err
 	}
+	offset := s.end
+	s.end += int64(len(record))
+	s.written++
+	s.signal()
+	return offset, nil
+}
 
-	stat, err := s.file.Stat()
+// dropTorn truncates the n bytes of a record a failed write left at the
+// end of the log, so the next record is written where the index expects
+// it. If they can't be dropped, every later write fails. The caller must
+// hold the write lock.
+func (s *Store) dropTorn(n int) {
+	err := s.file.Truncate(s.end)
 	if err != nil {
-		return fmt.Errorf("failed to get file stat: %v", err)
+		s.tornErr = fmt.Errorf("failed to truncate %d bytes of a failed write: %w", n, err)
+		s.writeErr = s.tornErr
+		return
 	}
-	startOffset := stat.Size() - int64(len(record))
-	valLenOffset := uint64(startOffset) + 1 + 4 + uint64(len(key))
+	s.allocated = min(s.allocated, s.end) // Truncating frees what was reserved past the end
+}
 
-	s.index[string(key)] = valLenOffset
-	return nil
+// preallocate reserves the next extent of disk space, with
+// Options.Preallocate, if the log is about to grow past the space reserved.
+// It is best effort: where it fails, the write it is done for reports any
+// lack of space. The caller must hold the write lock.
+func (s *Store) preallocate(n int64) {
+	extent := s.opts.Preallocate
+	if extent <= 0 || s.end+n <= s.allocated {
+		return
+	}
+	start := max(s.end, s.allocated)
+	length := (s.end + n - start + extent - 1) / extent * extent
+	f, ok := s.file.(*os.File)
+	if ok && preallocate(f, start, length) == nil {
+		s.allocated = start + length
+	}
 }
 
-// Get retrieves the value associated with a key.
-func (s *Store) Get(key []byte) ([]byte, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// signal wakes goroutines waiting for the log to change.
+func (s *Store) signal() {
+	close(s.notify)
+	s.notify = make(chan struct{})
+}
 
-	offset, ok := s.index[string(key)]
-	if !ok {
-		return nil, fmt.Errorf("key not found")
+// Set stores a key/value pair in the database. It fails with
+// ErrInvalidKey if the key is empty, starts with a zero byte, is longer
+// than Options.MaxKeySize or is rejected by Options.ValidateKey, with
+// ErrValueTooLarge if the value is longer than Options.MaxValueSize, and
+// with ErrQuotaExceeded if the log has reached Options.MaxSize, unless
+// Options.EvictOnQuota makes room for the pair.
+func (s *Store) Set(key, value []byte) error {
+	defer s.latency.observe(opSet, time.Now())
+	if !s.intercepted() {
+		return s.setEvicting(key, value)
 	}
+	return s.intercept(&Operation{Name: "set", Key: key, Value: value}, func(op *Operation) error {
+		return s.setEvicting(op.Key, op.Value)
+	})
+}
 
-	_, err := s.file.Seek(int64(offset), io.SeekStart)
-	if err != nil {
-		return nil, fmt.Errorf("failed to seek: %v", err)
-	}
+// set appends a set record with the given sequence number and updates the
+// index. The caller must hold the write lock.
+func (s *Store) set(seq uint64, key, value []byte) error {
+	return s.setDiffing(seq, key, value, s.opts.DeltaValues)
+}
 
-	var valLen uint32
-	err = binary.Read(s.file, binary.LittleEndian, &valLen)
+// setDiffing is set, storing value as a diff against the current value of
+// key if diff is set and the diff is worth it, see deltaFor.
+func (s *Store) setDiffing(seq uint64, key, value []byte, diff bool) error {
+	kind, stored := typeSet, value
+	var delta []byte
+	var depth uint8
+	var err error
+	if diff {
+		delta, depth, err = s.deltaFor(string(key), value)
+		if err != nil {
+			return err
+		}
+	}
+	if delta != nil {
+		kind, stored = typeDelta, delta
+	}
+	buf := getBuffer()
+	defer putBuffer(buf)
+	*buf = encodeRecordInto(*buf, kind, seq, 0, key, stored)
+	err = s.checkQuota(len(*buf))
 	if err != nil {
-		return nil, fmt.Errorf("failed to read value length: %v", err)
+		return err
 	}
-
-	value := make([]byte, valLen)
-	_, err = s.file.Read(value)
+	startOffset, err := s.appendRecord(*buf)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read value: %v", err)
+		return s.opError("write", key, s.end, fmt.Errorf("failed to write record: %w", err))
 	}
+	if s.tracksUse() {
+		s.index.touch(string(key))
+	}
+	valLenOffset := uint64(startOffset) + 1 + 8 + 4 + uint64(len(key))
 
-	return value, nil
+	s.index.put(string(key), indexEntry{offset: valLenOffset, seq: seq, size: int64(len(*buf)), depth: depth})
+	s.updateIndexes(key, value, false)
+	if seq > s.seq {
+		s.seq = seq
+	}
+	if s.cold != nil {
+		// Only now that the new value shadows the cold one
+		err = s.cold.deleteIfPresent(key)
+		if err != nil {
+			return err
+		}
+	}
+	s.queueEvent(event{key: key, value: value})
+	return s.archive(typeSet, seq, key, value)
 }
 
-// Delete removes a key from the database.
-func (s *Store) Delete(key []byte) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	record := make([]byte, 1+4+len(key))
-	record[0] = 1
-	binary.LittleEndian.PutUint32(record[1:5], uint32(len(key)))
-	copy(record[5:], key)
+// Get retrieves the value associated with a key. It only locks the shard of
+// the index holding the key, so Gets don't wait for writes of other keys.
+// The value is a copy the caller may keep and modify, unless
+// Options.ZeroCopyReads makes it a value like GetNoCopy returns.
+func (s *Store) Get(key []byte) ([]byte, error) {
+	if s.opts.ZeroCopyReads {
+		return s.GetNoCopy(key)
+	}
+	defer s.latency.observe(opGet, time.Now())
+	if !s.intercepted() {
+		return s.get(key)
+	}
+	op := &Operation{Name: "get", Key: key}
+	err := s.intercept(op, func(op *Operation) error {
+		var err error
+		op.Value, err = s.get(op.Key)
+		return err
+	})
+	return op.Value, err
+}
 
-	_, err := s.file.Write(record)
-	if err != nil {
-		return fmt.Errorf("failed to write delete record: %v", err)
+// get is Get without the interceptors.
+func (s *Store) get(key []byte) ([]byte, error) {
+	value, ok, err := s.find(string(key))
+	if err == ErrClosed {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	if s.tracksUse() {
+		s.index.touch(string(key))
 	}
 
-	delete(s.index, string(key))
-	return nil
+	s.readLimit.wait(len(value))
+	return value, err
 }
 
-// Polish compacts the database by creating a new file with only active key/value pairs.
-// It backs up the original file before replacing it with the polished version.
-func (s *Store) Polish() error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	// Get the current file path
-	origPath := s.file.Name()
+// lookup reads the value of key, reporting whether the key exists. It
+// fails with ErrClosed once the store is closed.
+func (s *Store) lookup(key string) ([]byte, bool, error) {
+	sh := s.index.shard(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	if s.closed() {
+		return nil, false, ErrClosed
+	}
 
-	// Create a backup before polishing
-	backupPath := origPath + ".backup"
-	err := s.backupTo(backupPath, false) // Full backup
-	if err != nil {
-		return fmt.Errorf("failed to create backup before polish: %v", err)
+	entry, ok := sh.get(key)
+	if !ok {
+		return nil, false, nil
 	}
+	value, err := s.readValue(entry)
+	return value, true, s.opError("read", []byte(key), int64(entry.offset), err)
+}
 
-	// Create a temporary file for the polished database
-	tempPath := origPath + ".tmp"
-	tempFile, err := os.OpenFile(tempPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
-	if err != nil {
-		return fmt.Errorf("failed to create temp file: %v", err)
+// Scan calls fn for every key starting with prefix and its value, in
+// ascending key order, until fn returns an error, which Scan then returns.
+// The store is not locked while fn runs, so fn may modify it; keys written
+// or deleted during the scan may or may not be visited.
+func (s *Store) Scan(prefix []byte, fn func(key, value []byte) error) error {
+	defer s.latency.observe(opScan, time.Now())
+	if !s.intercepted() {
+		return s.scan(prefix, fn)
 	}
-	defer tempFile.Close()
+	return s.intercept(&Operation{Name: "scan", Key: prefix}, func(op *Operation) error {
+		return s.scan(op.Key, fn)
+	})
+}
 
-	// Write only active key/value pairs from the index
-	for key, offset := range s.index {
-		// Seek to the value in the original file
-		_, err = s.file.Seek(int64(offset), io.SeekStart)
+// scan is Scan without the interceptors.
+func (s *Store) scan(prefix []byte, fn func(key, value []byte) error) error {
+	for _, key := range s.sortedKeys(prefix) {
+		value, ok, err := s.find(key)
 		if err != nil {
-			return fmt.Errorf("failed to seek to value offset: %v", err)
+			return err
 		}
-
-		// Read value length and value
-		var valLen uint32
-		err = binary.Read(s.file, binary.LittleEndian, &valLen)
-		if err != nil {
-			return fmt.Errorf("failed to read value length: %v", err)
+		if !ok {
+			continue // Deleted since the scan started
 		}
-		value := make([]byte, valLen)
-		_, err = s.file.Read(value)
+		s.readLimit.wait(len(value))
+
+		err = fn([]byte(key), value)
 		if err != nil {
-			return fmt.Errorf("failed to read value: %v", err)
+			return err
 		}
+	}
+	return nil
+}
+
+// sortedKeys returns the live keys starting with prefix in both tiers, in
+// ascending order.
+func (s *Store) sortedKeys(prefix []byte) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sortedKeysLocked(prefix)
+}
+
+// sortedKeysLocked is sortedKeys for callers holding the write lock or its
+// read side.
+func (s *Store) sortedKeysLocked(prefix []byte) []string {
+	keys := s.keysLocked(prefix)
+	if s.cold != nil {
+		keys = append(keys, s.cold.keys(prefix)...)
+	}
+	sort.Strings(keys)
+	return slices.Compact(keys)
+}
 
-		// Write set record to temp file
-		keyBytes := []byte(key)
-		record := make([]byte, 1+4+len(keyBytes)+4+len(value))
-		record[0] = 0
-		binary.LittleEndian.PutUint32(record[1:5], uint32(len(keyBytes)))
-		copy(record[5:5+len(keyBytes)], keyBytes)
-		binary.LittleEndian.PutUint32(record[5+len(keyBytes):9+len(keyBytes)], valLen)
-		copy(record[9+len(keyBytes):], value)
+// keys returns the live keys starting with prefix, in no particular order.
+func (s *Store) keys(prefix []byte) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.keysLocked(prefix)
+}
+
+// keysLocked is keys for callers holding the write lock or its read side.
+func (s *Store) keysLocked(prefix []byte) []string {
+	keys := make([]string, 0, s.index.len())
+	for key := range s.index.all() {
+		if strings.HasPrefix(key, string(prefix)) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
 
-		_, err = tempFile.Write(record)
+// readValue reads the value of an index entry. It uses ReadAt rather than
+// the file's seek position, so any number of goroutines holding the read
+// lock can call it at once.
+func (s *Store) readValue(entry indexEntry) ([]byte, error) {
+	if s.mmap != nil && entry.depth == 0 {
+		mapped, err := s.mappedValue(entry.offset)
 		if err != nil {
-			return fmt.Errorf("failed to write polished record: %v", err)
+			return nil, err
 		}
+		value := make([]byte, len(mapped))
+		copy(value, mapped)
+		return value, nil
 	}
+	return s.readValueInto(nil, entry)
+}
 
-	// Close the original file and replace it with the temp file
-	err = s.file.Close()
+// readValueInto is readValue reading the value into buf if it is large
+// enough, e.g. a buffer from bufPool. It always reads from the file, or
+// one of the handles of Options.ReadHandles.
+func (s *Store) readValueInto(buf []byte, entry indexEntry) ([]byte, error) {
+	return readValueAt(s.reader(), buf, entry.offset, entry.depth)
+}
+
+// readValueAt reads the length-prefixed value stored at offset in the log
+// r, applying the chain of depth deltas it ends, into buf if it is large
+// enough.
+func readValueAt(r io.ReaderAt, buf []byte, offset uint64, depth uint8) ([]byte, error) {
+	var lenBuf [4]byte
+	_, err := r.ReadAt(lenBuf[:], int64(offset))
 	if err != nil {
-		return fmt.Errorf("failed to close original file: %v", err)
+		return nil, fmt.Errorf("failed to read value length: %w", err)
+	}
+	valLen := int(binary.LittleEndian.Uint32(lenBuf[:]))
+
+	var value []byte
+	if valLen <= cap(buf) || valLen <= readChunk {
+		if cap(buf) < valLen {
+			buf = make([]byte, valLen)
+		}
+		value = buf[:valLen]
+		_, err = r.ReadAt(value, int64(offset)+4)
+	} else {
+		// Too large to trust the length, e.g. read at the offset of a
+		// corrupt delta, before the bytes arrive
+		value, err = readN(io.NewSectionReader(r, int64(offset)+4, int64(valLen)), buf, valLen)
 	}
-	err = os.Rename(tempPath, origPath)
 	if err != nil {
-		return fmt.Errorf("failed to replace original file: %v", err)
+		return nil, fmt.Errorf("failed to read value: %w", err)
 	}
+	if depth > 0 {
+		return applyDelta(r, value)
+	}
+	return value, nil
+}
 
-	// Reopen the polished file
-	s.file, err = os.OpenFile(origPath, os.O_RDWR|os.O_APPEND, 0666)
-	if err != nil {
-		return fmt.Errorf("failed to reopen polished file: %v", err)
+// Delete removes a key from the database. Like Set, it fails with
+// ErrInvalidKey for a key Options.ValidateKey rejects, but it accepts empty
+// keys and keys starting with a zero byte, which Set rejects, so those
+// written before Set did can still be removed. A key starting with a zero
+// byte may hold an element of a data type, which Delete then removes.
+func (s *Store) Delete(key []byte) error {
+	defer s.latency.observe(opDelete, time.Now())
+	if !s.intercepted() {
+		return s.deleteKey(key)
 	}
+	return s.intercept(&Operation{Name: "delete", Key: key}, func(op *Operation) error {
+		return s.deleteKey(op.Key)
+	})
+}
 
-	// Rebuild the index (optional, since it’s still valid, but ensures consistency)
-	err = s.buildIndex()
+// deleteKey is Delete without the interceptors.
+func (s *Store) deleteKey(key []byte) error {
+	err := s.checkDeleted(key)
 	if err != nil {
-		return fmt.Errorf("failed to rebuild index after polish: %v", err)
+		return err
 	}
-
-	return nil
+	return s.write(func() error {
+		return s.del(s.seq+1, key)
+	})
 }
 
-// Backup creates a backup of the database at the specified path.
-// If polished is true, only active key/value pairs are included; otherwise, it’s a full copy.
-func (s *Store) Backup(path string, polished bool) error {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	return s.backupTo(path, polished)
+// DeleteMulti removes keys from the database with a single log record, so
+// that, even across a crash, either all of them are removed or none are.
+// Gets may see some of them removed before the others. Keys are checked like
+// those of Delete.
+func (s *Store) DeleteMulti(keys [][]byte) error {
+	defer s.latency.observe(opDelete, time.Now())
+	if len(keys) == 0 {
+		return nil
+	}
+	for _, key := range keys {
+		err := s.checkDeleted(key)
+		if err != nil {
+			return err
+		}
+	}
+	return s.write(func() error {
+		return s.delMulti(s.seq+1, keys)
+	})
 }
 
-// backupTo is a helper function to create a backup (locked separately for Polish).
-func (s *Store) backupTo(path string, polished bool) error {
-	if polished {
-		// Create a temp store at the backup path and write only active records
-		backupFile, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+// delMulti appends a delete multi record with the given sequence number and
+// updates the index. The caller must hold the write lock.
+func (s *Store) delMulti(seq uint64, keys [][]byte) error {
+	s.delSeq.Store(seq) // Before the keys are gone, see Txn.Get
+	packed := packKeys(keys)
+	if s.cold != nil {
+		// First, so a crash in between can't bring the cold values back
+		err := s.cold.deleteIfPresent(keys...)
 		if err != nil {
-			return fmt.Errorf("failed to create backup file: %v", err)
+			return err
 		}
-		defer backupFile.Close()
+	}
+	_, err := s.appendRecord(encodeRecord(typeDeleteMulti, seq, packed, nil))
+	if err != nil {
+		return s.opError("delete", nil, s.end, fmt.Errorf("failed to write delete record: %w", err))
+	}
 
-		for key, offset := range s.index {
-			_, err = s.file.Seek(int64(offset), io.SeekStart)
-			if err != nil {
-				return fmt.Errorf("failed to seek to value offset: %v", err)
-			}
+	for _, key := range keys {
+		s.index.remove(string(key))
+		s.updateIndexes(key, nil, true)
+		s.queueEvent(event{key: key, deleted: true})
+	}
+	if seq > s.seq {
+		s.seq = seq
+	}
+	return s.archive(typeDeleteMulti, seq, packed, nil)
+}
 
-			var valLen uint32
-			err = binary.Read(s.file, binary.LittleEndian, &valLen)
-			if err != nil {
-				return fmt.Errorf("failed to read value length: %v", err)
-			}
-			value := make([]byte, valLen)
-			_, err = s.file.Read(value)
-			if err != nil {
-				return fmt.Errorf("failed to read value: %v", err)
+// batch appends a batch record with the given sequence number, carrying
+// the set and delete records writes, and updates the index, so that even
+// across a crash either all of the writes are made or none are. The caller
+// must hold the write lock.
+func (s *Store) batch(seq uint64, writes []*record) error {
+	if slices.ContainsFunc(writes, func(w *record) bool { return w.kind == typeDelete }) {
+		s.delSeq.Store(seq) // Before the keys are gone, see Txn.Get
+	}
+	packed := packBatch(writes)
+	record := encodeRecord(typeBatch, seq, packed, nil)
+	err := s.checkQuota(len(record))
+	if err != nil {
+		return err
+	}
+	if s.cold != nil {
+		// First, so a crash in between can't bring the cold values back
+		for _, w := range writes {
+			if w.kind == typeDelete {
+				err = s.cold.deleteIfPresent(w.key)
+				if err != nil {
+					return err
+				}
 			}
+		}
+	}
+	startOffset, err := s.appendRecord(record)
+	if err != nil {
+		return s.opError("write", nil, s.end, fmt.Errorf("failed to write batch record: %w", err))
+	}
 
-			keyBytes := []byte(key)
-			record := make([]byte, 1+4+len(keyBytes)+4+len(value))
-			record[0] = 0
-			binary.LittleEndian.PutUint32(record[1:5], uint32(len(keyBytes)))
-			copy(record[5:5+len(keyBytes)], keyBytes)
-			binary.LittleEndian.PutUint32(record[5+len(keyBytes):9+len(keyBytes)], valLen)
-			copy(record[9+len(keyBytes):], value)
-
-			_, err = backupFile.Write(record)
-			if err != nil {
-				return fmt.Errorf("failed to write backup record: %v", err)
+	at := startOffset + 1 + 8 + 4 // Start of the writes
+	for _, w := range writes {
+		key := string(w.key)
+		if w.kind == typeDelete {
+			s.index.remove(key)
+			s.updateIndexes(w.key, nil, true)
+			s.queueEvent(event{key: w.key, deleted: true})
+		} else {
+			if s.tracksUse() {
+				s.index.touch(key)
 			}
+			s.index.put(key, indexEntry{offset: uint64(at + 1 + 4 + int64(len(w.key))), seq: seq, size: w.batchSize()})
+			s.updateIndexes(w.key, w.value, false)
+			s.queueEvent(event{key: w.key, value: w.value})
 		}
-	} else {
-		// Full backup: copy the entire file
-		src, err := os.Open(s.file.Name())
+		at += w.batchSize()
+	}
+	if seq > s.seq {
+		s.seq = seq
+	}
+	if s.cold != nil {
+		// Only now that the new values shadow the cold ones
+		for _, w := range writes {
+			if w.kind == typeSet {
+				err = s.cold.deleteIfPresent(w.key)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return s.archive(typeBatch, seq, packed, nil)
+}
+
+// del appends a delete record with the given sequence number and updates the
+// index. The caller must hold the write lock.
+func (s *Store) del(seq uint64, key []byte) error {
+	s.delSeq.Store(seq) // Before the key is gone, see Txn.Get
+	buf := getBuffer()
+	defer putBuffer(buf)
+	*buf = encodeRecordInto(*buf, typeDelete, seq, 0, key, nil)
+	if s.cold != nil {
+		// First, so a crash in between can't bring the cold value back
+		err := s.cold.deleteIfPresent(key)
 		if err != nil {
-			return fmt.Errorf("failed to open source file: %v", err)
+			return err
 		}
-		defer src.Close()
+	}
+	_, err := s.appendRecord(*buf)
+	if err != nil {
+		return s.opError("delete", key, s.end, fmt.Errorf("failed to write delete record: %w", err))
+	}
 
-		dst, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	s.index.remove(string(key))
+	s.updateIndexes(key, nil, true)
+	if seq > s.seq {
+		s.seq = seq
+	}
+	s.queueEvent(event{key: key, deleted: true})
+	return s.archive(typeDelete, seq, key, nil)
+}
+
+// writeCompacted writes a checkpoint followed by a set record for every
+// active key/value pair. The checkpoint marks that history up to the current
+// sequence number has been compacted away.
+func (s *Store) writeCompacted(w io.Writer) error {
+	_, err := w.Write(encodeRecord(typeCheckpoint, s.seq, nil, nil))
+	if err != nil {
+		return err
+	}
+
+	value, record := getBuffer(), getBuffer()
+	defer putBuffer(value)
+	defer putBuffer(record)
+	for key, entry := range s.index.all() {
+		*value, err = s.readValueInto(*value, entry)
 		if err != nil {
-			return fmt.Errorf("failed to create backup file: %v", err)
+			return err
 		}
-		defer dst.Close()
 
-		_, err = io.Copy(dst, src)
+		*record = encodeRecordInto(*record, typeSet, entry.seq, 0, []byte(key), *value)
+		_, err = w.Write(*record)
 		if err != nil {
-			return fmt.Errorf("failed to copy file: %v", err)
+			return err
 		}
 	}
-
 	return nil
 }
 
-// Close closes the store and releases resources.
+// Backup creates a backup of the database at the specified path.
+// If opts.Polished is true, only active key/value pairs are included; otherwise, it’s a full copy.
+// A manifest describing the backup is written next to it, see ReadManifest.
+func (s *Store) Backup(path string, opts BackupOptions) error {
+	defer s.latency.observe(opBackup, time.Now())
+	backup := func(*Operation) error {
+		s.mu.RLock()
+		m, err := s.backupTo(path, opts)
+		if err == nil {
+			err = writeManifest(path, m)
+		}
+		s.mu.RUnlock()
+
+		s.backedUp(m, err)
+		return err
+	}
+	if !s.intercepted() {
+		return backup(nil)
+	}
+	return s.intercept(&Operation{Name: "backup"}, backup)
+}
+
+// backupTo is a helper function to create a backup at path for Backup.
+func (s *Store) backupTo(path string, opts BackupOptions) (Manifest, error) {
+	dst, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("failed to create backup file: %w", err)
+	}
+	defer dst.Close()
+
+	m, err := s.writeBackup(dst, opts)
+	m.Name = filepath.Base(path)
+	return m, err
+}
+
+// closed reports whether the store is closed. Close closes it holding the
+// write lock and every shard lock of the index, so the answer holds for as
+// long as the caller holds either.
+func (s *Store) closed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close closes the store and releases resources. Operations in flight and
+// writes queued by SetAsync finish first, the log is synced to disk so
+// every acknowledged write is durable, and later operations fail with
+// ErrClosed. Change feeds and followers are ended. The directory of a
+// store created with NewTempStore is removed.
 func (s *Store) Close() error {
+	if s.tempDir != "" {
+		defer os.RemoveAll(s.tempDir)
+	}
+	s.closeQueue()
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	err := s.file.Close()
+	s.index.lock()
+	defer s.index.unlock()
+	select {
+	case <-s.done:
+		return ErrClosed
+	default:
+		close(s.done)
+	}
+	s.unpublish()
+	if s.opts.IndexSnapshot && !s.opts.ReadOnly {
+		err := s.writeIndexSnapshot()
+		if err != nil {
+			s.file.Close()
+			return err
+		}
+	}
+	if s.mmap != nil {
+		err := s.mmap.unmap()
+		if err != nil {
+			s.file.Close()
+			return err
+		}
+	}
+	s.readers.close()
+	if s.wal != nil {
+		err := s.wal.close()
+		if err != nil {
+			s.file.Close()
+			return fmt.Errorf("failed to close WAL: %w", err)
+		}
+	}
+	if s.cold != nil {
+		err := s.cold.Close()
+		if err != nil {
+			s.file.Close()
+			return err
+		}
+	}
+	err := s.file.Sync()
 	if err != nil {
-		return fmt.Errorf("failed to close file: %v", err)
+		s.file.Close()
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+	err = s.file.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close file: %w", err)
 	}
 	return nil
-}
\ No newline at end of file
+}
@@ -1,13 +1,15 @@
 package stone
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 )
 
 func TestStore(t *testing.T) {
-	path := "test.db"
-	os.Remove(path)
+	path := filepath.Join(t.TempDir(), "test.db")
 
 	store, err := NewStore(path)
 	if err != nil {
@@ -55,8 +57,7 @@ func TestStore(t *testing.T) {
 }
 
 func TestPersistence(t *testing.T) {
-	path := "test.db"
-	os.Remove(path)
+	path := filepath.Join(t.TempDir(), "test.db")
 
 	store, err := NewStore(path)
 	if err != nil {
@@ -84,8 +85,7 @@ func TestPersistence(t *testing.T) {
 }
 
 func TestPolish(t *testing.T) {
-	path := "test.db"
-	os.Remove(path)
+	path := filepath.Join(t.TempDir(), "test.db")
 
 	store, err := NewStore(path)
 	if err != nil {
@@ -137,12 +137,10 @@ func TestPolish(t *testing.T) {
 }
 
 func TestBackup(t *testing.T) {
-	path := "test.db"
-	backupFull := "test_full_backup.db"
-	backupPolished := "test_polished_backup.db"
-	os.Remove(path)
-	os.Remove(backupFull)
-	os.Remove(backupPolished)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.db")
+	backupFull := filepath.Join(dir, "test_full_backup.db")
+	backupPolished := filepath.Join(dir, "test_polished_backup.db")
 
 	store, err := NewStore(path)
 	if err != nil {
@@ -165,7 +163,7 @@ func TestBackup(t *testing.T) {
 	}
 
 	// Full backup
-	err = store.Backup(backupFull, false)
+	err = store.Backup(backupFull, BackupOptions{})
 	if err != nil {
 		t.Fatalf("full backup failed: %v", err)
 	}
@@ -183,7 +181,7 @@ func TestBackup(t *testing.T) {
 	}
 
 	// Polished backup
-	err = store.Backup(backupPolished, true)
+	err = store.Backup(backupPolished, BackupOptions{Polished: true})
 	if err != nil {
 		t.Fatalf("polished backup failed: %v", err)
 	}
@@ -203,4 +201,151 @@ func TestBackup(t *testing.T) {
 	if string(value) != "value2" {
 		t.Errorf("expected 'value2' in polished backup, got '%s'", value)
 	}
-}
\ No newline at end of file
+}
+
+func TestScan(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	store.Set([]byte("user/2"), []byte("bob"))
+	store.Set([]byte("user/1"), []byte("alice"))
+	store.Set([]byte("user/3"), []byte("carol"))
+	store.Set([]byte("group/1"), []byte("admins"))
+	store.Delete([]byte("user/3"))
+
+	var got []string
+	err = store.Scan([]byte("user/"), func(key, value []byte) error {
+		got = append(got, string(key)+"="+string(value))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	if fmt.Sprint(got) != "[user/1=alice user/2=bob]" {
+		t.Errorf("unexpected scan result: %v", got)
+	}
+
+	// An error from fn stops the scan
+	stop := fmt.Errorf("stop")
+	count := 0
+	err = store.Scan(nil, func(key, value []byte) error {
+		count++
+		return stop
+	})
+	if err != stop || count != 1 {
+		t.Errorf("expected scan to stop after the first key, got %d keys (%v)", count, err)
+	}
+}
+
+func TestDeleteMulti(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		store.Set([]byte(key), []byte(key))
+	}
+	changes, err := store.Changes(store.Seq())
+	if err != nil {
+		t.Fatalf("changes failed: %v", err)
+	}
+	err = store.DeleteMulti([][]byte{[]byte("a"), []byte("b"), []byte("missing")})
+	if err != nil {
+		t.Fatalf("delete multi failed: %v", err)
+	}
+	for _, key := range []string{"a", "b", "missing"} {
+		rec := nextRecord(t, changes)
+		if rec.Seq != 4 || rec.Type != RecordDelete || string(rec.Key) != key {
+			t.Errorf("expected a delete of %s in the feed, got %+v", key, rec)
+		}
+	}
+	if _, err := store.Get([]byte("a")); err == nil {
+		t.Errorf("expected a to be deleted")
+	}
+	if err := store.DeleteMulti(nil); err != nil || store.Seq() != 4 {
+		t.Errorf("expected deleting no keys to write nothing, got %v", err)
+	}
+	store.Close()
+
+	// The keys are deleted with one record, which a crash can tear but
+	// not split
+	var types []string
+	Dump(path, func(rec DumpRecord) error {
+		types = append(types, rec.Type)
+		return nil
+	})
+	if fmt.Sprint(types) != "[set set set delete_multi]" {
+		t.Errorf("expected one delete_multi record, got %v", types)
+	}
+	info, _ := os.Stat(path)
+	os.Truncate(path, info.Size()-1)
+	report, err := Check(path)
+	if err != nil || !report.Torn {
+		t.Fatalf("expected a torn record, got %+v, %v", report, err)
+	}
+	store, err = NewStore(path) // Cuts the torn record off
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if _, err := store.Get([]byte(key)); err != nil {
+			t.Errorf("expected %s to survive the torn delete, got %v", key, err)
+		}
+	}
+
+	// and replayed in full otherwise
+	store.DeleteMulti([][]byte{[]byte("a"), []byte("b")})
+	store.Close()
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	var keys []string
+	store.Scan(nil, func(key, value []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	})
+	if fmt.Sprint(keys) != "[c]" {
+		t.Errorf("expected only c after reopening, got %v", keys)
+	}
+}
+
+func TestConcurrentGet(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	for i := 0; i < 100; i++ {
+		store.Set([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)))
+	}
+
+	// Readers sharing the file must not disturb each other's reads
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				n := (i*7 + g) % 100
+				value, err := store.Get([]byte(fmt.Sprintf("key%d", n)))
+				if err != nil || string(value) != fmt.Sprintf("value%d", n) {
+					errs <- fmt.Errorf("get key%d returned %q (%v)", n, value, err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
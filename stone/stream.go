@@ -0,0 +1,79 @@
+package stone
+
+import (
+	"encoding/binary"
+)
+
+// Streams are stored as a key per entry, named after its ID, see nextID,
+// and a key per consumer holding the ID of the last entry it committed.
+// Unlike queue messages, entries stay in the stream once read, so any
+// number of consumers can read them, each at its own pace.
+
+// StreamEntry is an entry of a stream.
+type StreamEntry struct {
+	ID    uint64 // Assigned by XAdd, in increasing order
+	Value []byte
+}
+
+// XAdd appends an entry with value to the stream stored under stream,
+// creating the stream if it doesn't exist, and returns its ID.
+func (s *Store) XAdd(stream, value []byte) (uint64, error) {
+	var id uint64
+	err := s.write(func() error {
+		var err error
+		id, err = s.nextID(kindStream, stream)
+		if err != nil {
+			return err
+		}
+		return s.set(s.seq+1, idKey(kindStream, stream, id), value)
+	})
+	return id, err
+}
+
+// XRead returns up to count entries of stream with IDs greater than after,
+// in order, or all of them if count is 0. A consumer reads from the offset
+// it last committed, see XOffset.
+func (s *Store) XRead(stream []byte, after uint64, count int) ([]StreamEntry, error) {
+	prefix := typedKey(kindStream, stream, nil)
+	var entries []StreamEntry
+	for _, key := range s.sortedKeys(prefix) {
+		if len(key) != len(prefix)+8 {
+			continue // The next ID
+		}
+		id := binary.BigEndian.Uint64([]byte(key[len(prefix):]))
+		if id <= after {
+			continue
+		}
+		value, ok, err := s.find(key)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		entries = append(entries, StreamEntry{ID: id, Value: value})
+		if len(entries) == count {
+			break
+		}
+	}
+	return entries, nil
+}
+
+// XCommit records that consumer has processed the entries of stream up to
+// and including id, so it resumes after it, e.g. once restarted. Consumers
+// are named by the application and created by their first commit.
+func (s *Store) XCommit(stream, consumer []byte, id uint64) error {
+	return s.write(func() error {
+		return s.set(s.seq+1, typedKey(kindOffset, stream, consumer), binary.BigEndian.AppendUint64(nil, id))
+	})
+}
+
+// XOffset returns the ID of the last entry of stream consumer committed, or
+// 0 if it never committed one, which XRead then reads from the start.
+func (s *Store) XOffset(stream, consumer []byte) (uint64, error) {
+	offset, ok, err := s.find(string(typedKey(kindOffset, stream, consumer)))
+	if err != nil || !ok || len(offset) != 8 {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(offset), nil
+}
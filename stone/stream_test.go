@@ -0,0 +1,58 @@
+package stone
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	stream := []byte("events")
+	for _, value := range []string{"a", "b", "c"} {
+		_, err := store.XAdd(stream, []byte(value))
+		if err != nil {
+			t.Fatalf("add failed: %v", err)
+		}
+	}
+
+	entries, err := store.XRead(stream, 0, 2)
+	if err != nil || len(entries) != 2 || entries[0].ID != 1 || string(entries[1].Value) != "b" {
+		t.Fatalf("expected the first two entries, got %+v, %v", entries, err)
+	}
+	err = store.XCommit(stream, []byte("mailer"), entries[1].ID)
+	if err != nil {
+		t.Fatalf("commit failed: %v", err)
+	}
+	store.Close()
+
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	// Every consumer has an offset of its own
+	offset, err := store.XOffset(stream, []byte("mailer"))
+	if err != nil || offset != 2 {
+		t.Errorf("expected the committed offset 2, got %d, %v", offset, err)
+	}
+	offset, _ = store.XOffset(stream, []byte("audit"))
+	if offset != 0 {
+		t.Errorf("expected a new consumer to start from 0, got %d", offset)
+	}
+	entries, _ = store.XRead(stream, 2, 0)
+	if len(entries) != 1 || entries[0].ID != 3 || string(entries[0].Value) != "c" {
+		t.Errorf("expected the entry after the offset, got %+v", entries)
+	}
+	entries, _ = store.XRead(stream, 0, 0)
+	if len(entries) != 3 {
+		t.Errorf("expected entries to stay in the stream once read, got %+v", entries)
+	}
+	id, _ := store.XAdd(stream, []byte("d"))
+	if id != 4 {
+		t.Errorf("expected the next ID to be 4, got %d", id)
+	}
+}
@@ -0,0 +1,365 @@
+package stone
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"time"
+)
+
+// SyncState records how far two stores have been synchronized: the
+// sequence number of each store up to which its changes have been
+// exchanged. The zero value means the stores have never been synchronized.
+// Applications keep it between syncs, e.g. in a file next to the store.
+type SyncState struct {
+	Local  uint64 // Sequence number of the store SyncWith is called on
+	Remote uint64 // Sequence number of the peer
+}
+
+// syncTimeout bounds a whole network sync, during which the stores are
+// locked.
+const syncTimeout = time.Minute
+
+// SyncWith exchanges the changes s and peer made since state with each
+// other, so two stores that diverged, e.g. while offline, converge to the
+// same contents. Changes include deletes, so a key deleted on one side is
+// deleted on the other. Keys changed on both sides are resolved by policy;
+// for a deleted key Conflict holds a nil value, and returning nil deletes
+// the key. The returned state must be passed to the next sync of the same
+// two stores.
+//
+// Both stores are locked for the duration of the sync. A store that was
+// polished since the last sync has lost the history needed to sync it and
+// returns an error.
+func (s *Store) SyncWith(peer *Store, state SyncState, policy ConflictPolicy) (SyncState, error) {
+	if peer == s {
+		return state, nil
+	}
+
+	// Lock in a fixed order, so concurrent syncs in both directions can't
+	// deadlock
+	first, second := s, peer
+	if first.file.Name() > second.file.Name() {
+		first, second = second, first
+	}
+	first.mu.Lock()
+	second.mu.Lock()
+	next, err := s.syncWithLocked(peer, state, policy)
+	events, peerEvents := s.takeEvents(), peer.takeEvents()
+	second.mu.Unlock()
+	first.mu.Unlock()
+
+	s.emit(events)
+	peer.emit(peerEvents)
+	return next, err
+}
+
+// syncWithLocked is SyncWith for a caller holding the write locks of both
+// stores.
+func (s *Store) syncWithLocked(peer *Store, state SyncState, policy ConflictPolicy) (SyncState, error) {
+	remote, err := peer.delta(state.Remote)
+	if err != nil {
+		return state, err
+	}
+	send, err := s.reconcile(state.Local, remote, policy)
+	if err != nil {
+		return state, err
+	}
+	err = peer.applyChanges(send)
+	if err != nil {
+		return state, err
+	}
+	return SyncState{Local: s.seq, Remote: peer.seq}, nil
+}
+
+// ServeSync accepts sync connections from SyncWithRemote on ln, resolving
+// conflicting changes with policy. It returns when ln is closed.
+func (s *Store) ServeSync(ln net.Listener, policy ConflictPolicy) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return fmt.Errorf("failed to accept sync peer: %w", err)
+		}
+		go s.serveSync(conn, policy)
+	}
+}
+
+// SyncWithRemote is SyncWith for a peer serving ServeSync at addr, where
+// conflicts are resolved by the peer's policy. state.Local refers to s and
+// state.Remote to the peer.
+//
+// The protocol is a request carrying the peer's sequence number from state
+// and the changes of s, answered with the resolved changes for s and the
+// peer's new sequence number. s stays locked until the exchange completes
+// or times out, the peer only while it resolves the changes.
+func (s *Store) SyncWithRemote(addr string, state SyncState) (SyncState, error) {
+	conn, err := net.DialTimeout("tcp", addr, syncTimeout)
+	if err != nil {
+		return state, fmt.Errorf("failed to connect to sync peer: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(syncTimeout))
+
+	next := state
+	err = s.write(func() error {
+		changes, err := s.delta(state.Local)
+		if err != nil {
+			return err
+		}
+		w := bufio.NewWriter(conn)
+		err = writeChanges(w, state.Remote, changes)
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to send changes: %w", err)
+		}
+
+		r := bufio.NewReader(conn)
+		status, err := r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("failed to read sync response: %w", unexpected(err))
+		}
+		if status != 0 {
+			msg, _ := io.ReadAll(io.LimitReader(r, 4096))
+			return fmt.Errorf("sync peer failed: %s", msg)
+		}
+		remoteSeq, received, err := readChanges(r)
+		if err != nil {
+			return fmt.Errorf("failed to read sync response: %w", err)
+		}
+
+		err = s.applyChanges(received)
+		if err != nil {
+			return err
+		}
+		next = SyncState{Local: s.seq, Remote: remoteSeq}
+		return nil
+	})
+	if err != nil {
+		return state, err
+	}
+	return next, nil
+}
+
+// serveSync handles a single SyncWithRemote request.
+func (s *Store) serveSync(conn net.Conn, policy ConflictPolicy) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(syncTimeout))
+
+	since, remote, err := readChanges(bufio.NewReader(conn))
+	if err != nil {
+		return
+	}
+
+	var send []*record
+	var seq uint64
+	err = s.write(func() error {
+		var err error
+		send, err = s.reconcile(since, remote, policy)
+		seq = s.seq
+		return err
+	})
+
+	w := bufio.NewWriter(conn)
+	if err != nil {
+		s.log.Warn("failed to sync with remote", "remote", conn.RemoteAddr().String(), "error", err)
+		w.WriteByte(1)
+		w.WriteString(err.Error())
+	} else {
+		w.WriteByte(0)
+		writeChanges(w, seq, send)
+	}
+	w.Flush()
+}
+
+// writeChanges writes seq followed by changes, terminated by a checkpoint
+// record.
+func writeChanges(w io.Writer, seq uint64, changes []*record) error {
+	err := binary.Write(w, binary.LittleEndian, seq)
+	if err != nil {
+		return err
+	}
+	for _, rec := range changes {
+		_, err = w.Write(rec.encode())
+		if err != nil {
+			return err
+		}
+	}
+	_, err = w.Write(encodeRecord(typeCheckpoint, 0, nil, nil))
+	return err
+}
+
+// readChanges reads what writeChanges wrote.
+func readChanges(r io.Reader) (uint64, []*record, error) {
+	var seq uint64
+	err := binary.Read(r, binary.LittleEndian, &seq)
+	if err != nil {
+		return 0, nil, unexpected(err)
+	}
+	var changes []*record
+	for {
+		rec, err := readRecord(r)
+		if err != nil {
+			return 0, nil, unexpected(err)
+		}
+		switch rec.kind {
+		case typeCheckpoint:
+			return seq, changes, nil
+		case typeSet, typeDelete:
+			changes = append(changes, rec)
+		default:
+			return 0, nil, fmt.Errorf("invalid record type: %d", rec.kind)
+		}
+	}
+}
+
+// delta returns the latest change of every key changed after since, in log
+// order. From since 0 it includes the pairs of the cold tier, first. The
+// caller must hold at least the read lock.
+func (s *Store) delta(since uint64) ([]*record, error) {
+	if since != 0 && since < s.floor {
+		return nil, fmt.Errorf("changes since seq %d have been polished away, the oldest available is %d", since, s.floor)
+	}
+
+	file, err := s.backend.OpenFile(s.file.Name(), os.O_RDONLY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	latest := make(map[string]*record)
+	err = scanRecords(io.NewSectionReader(file, 0, s.end), func(rec *record) error {
+		if rec.kind == typeCheckpoint || rec.seq <= since {
+			return nil
+		}
+		recs, err := rec.split()
+		for _, rec := range recs {
+			latest[string(rec.key)] = rec
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if since == 0 && s.cold != nil {
+		// Numbered 0, like the cold values MergeFrom resolves
+		err = s.eachCold(func(key string, value []byte) error {
+			latest[key] = &record{kind: typeSet, key: []byte(key), value: value}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	changes := make([]*record, 0, len(latest))
+	for _, rec := range latest {
+		err = rec.materialize(file)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, rec)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].seq < changes[j].seq })
+	return changes, nil
+}
+
+// reconcile applies the changes a peer made since its last sync to s,
+// resolving keys that s changed since seq since as well with policy. It
+// returns the changes the peer needs to converge with s. The caller must
+// hold the write lock.
+func (s *Store) reconcile(since uint64, remote []*record, policy ConflictPolicy) ([]*record, error) {
+	local, err := s.delta(since)
+	if err != nil {
+		return nil, err
+	}
+	changed := make(map[string]*record, len(local))
+	for _, rec := range local {
+		changed[string(rec.key)] = rec
+	}
+
+	var apply, send []*record
+	for _, theirs := range remote {
+		ours, ok := changed[string(theirs.key)]
+		if !ok {
+			apply = append(apply, theirs)
+			continue
+		}
+		delete(changed, string(theirs.key))
+		if sameChange(ours, theirs) {
+			continue
+		}
+
+		value, err := policy(Conflict{
+			Key:    theirs.key,
+			Dst:    changeValue(ours),
+			Src:    changeValue(theirs),
+			DstSeq: ours.seq,
+			SrcSeq: theirs.seq,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to sync key %q: %w", theirs.key, err)
+		}
+		resolved := &record{kind: typeSet, key: theirs.key, value: value}
+		if value == nil {
+			resolved.kind = typeDelete
+		}
+		if !sameChange(resolved, ours) {
+			apply = append(apply, resolved)
+		}
+		if !sameChange(resolved, theirs) {
+			send = append(send, resolved)
+		}
+	}
+	for _, rec := range local {
+		if changed[string(rec.key)] == rec {
+			send = append(send, rec)
+		}
+	}
+
+	err = s.applyChanges(apply)
+	if err != nil {
+		return nil, err
+	}
+	return send, nil
+}
+
+// applyChanges writes changes received from a peer as new records. The
+// caller must hold the write lock.
+func (s *Store) applyChanges(changes []*record) error {
+	for _, rec := range changes {
+		var err error
+		if changeValue(rec) != nil {
+			err = s.set(s.seq+1, rec.key, rec.value)
+		} else if s.version(string(rec.key)) != 0 {
+			err = s.del(s.seq+1, rec.key) // From either tier
+		}
+		if err != nil {
+			return fmt.Errorf("failed to apply change: %w", err)
+		}
+	}
+	return s.syncLocked()
+}
+
+// changeValue returns the value a set record stores, or nil for a delete.
+func changeValue(rec *record) []byte {
+	if rec.kind == typeDelete || rec.kind == typeDeleteLegacy {
+		return nil
+	}
+	if rec.value == nil {
+		return []byte{}
+	}
+	return rec.value
+}
+
+// sameChange reports whether a and b leave their key in the same state.
+func sameChange(a, b *record) bool {
+	va, vb := changeValue(a), changeValue(b)
+	return (va == nil) == (vb == nil) && bytes.Equal(va, vb)
+}
@@ -0,0 +1,188 @@
+package stone
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+// diverge makes conflicting offline changes to two synchronized stores.
+func diverge(a, b *Store) {
+	a.Set([]byte("only-a"), []byte("a"))
+	a.Delete([]byte("deleted-on-a"))
+	a.Set([]byte("both"), []byte("a"))
+	b.Set([]byte("only-b"), []byte("b"))
+	b.Set([]byte("both"), []byte("b"))
+	b.Set([]byte("both"), []byte("b2"))
+}
+
+func TestSyncWith(t *testing.T) {
+	dir := t.TempDir()
+	a, err := NewStore(filepath.Join(dir, "a.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer a.Close()
+	b, err := NewStore(filepath.Join(dir, "b.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer b.Close()
+
+	a.Set([]byte("deleted-on-a"), []byte("value"))
+	state, err := a.SyncWith(b, SyncState{}, LastWriteWins)
+	if err != nil {
+		t.Fatalf("initial sync failed: %v", err)
+	}
+	if value, err := b.Get([]byte("deleted-on-a")); err != nil || string(value) != "value" {
+		t.Fatalf("expected initial sync to copy key, got %q (%v)", value, err)
+	}
+
+	diverge(a, b)
+	state, err = a.SyncWith(b, state, PreferDst)
+	if err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	result, err := Diff(a, b)
+	if err != nil || !result.Equal() {
+		t.Fatalf("expected stores to converge, got %+v (%v)", result, err)
+	}
+	if _, err = b.Get([]byte("deleted-on-a")); err == nil {
+		t.Error("expected delete to be synced")
+	}
+	if value, _ := b.Get([]byte("both")); string(value) != "a" {
+		t.Errorf("expected conflict to resolve to 'a', got %q", value)
+	}
+
+	// Changes received in a sync are not sent back in the next one
+	seqA, seqB := a.Seq(), b.Seq()
+	state, err = a.SyncWith(b, state, PreferSrc)
+	if err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	if a.Seq() != seqA || b.Seq() != seqB {
+		t.Errorf("expected a sync without changes to write nothing")
+	}
+
+	// Polishing loses the history a sync needs
+	b.Set([]byte("after"), []byte("polish"))
+	err = b.Polish()
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	_, err = a.SyncWith(b, state, PreferDst)
+	if err == nil {
+		t.Error("expected sync across a polish to fail")
+	}
+}
+
+func TestSyncWithRemote(t *testing.T) {
+	dir := t.TempDir()
+	server, err := NewStore(filepath.Join(dir, "server.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer server.Close()
+	client, err := NewStore(filepath.Join(dir, "client.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer client.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go server.ServeSync(ln, PreferSrc)
+
+	client.Set([]byte("deleted-on-a"), []byte("value"))
+	state, err := client.SyncWithRemote(ln.Addr().String(), SyncState{})
+	if err != nil {
+		t.Fatalf("initial sync failed: %v", err)
+	}
+
+	diverge(client, server)
+	state, err = client.SyncWithRemote(ln.Addr().String(), state)
+	if err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	result, err := Diff(client, server)
+	if err != nil || !result.Equal() {
+		t.Fatalf("expected stores to converge, got %+v (%v)", result, err)
+	}
+	// The client's change wins under the server's PreferSrc policy
+	if value, _ := server.Get([]byte("both")); string(value) != "a" {
+		t.Errorf("expected conflict to resolve to 'a', got %q", value)
+	}
+
+	// Errors on the server are reported to the client
+	server.Set([]byte("before"), []byte("polish"))
+	server.Polish()
+	client.Set([]byte("more"), []byte("changes"))
+	_, err = client.SyncWithRemote(ln.Addr().String(), state)
+	if err == nil {
+		t.Error("expected sync across a polish to fail")
+	}
+}
+
+// chill moves every key of a store with Options.ColdPath to the cold tier:
+// the first Polish only starts the clock, the second moves the keys not
+// used in between.
+func chill(t *testing.T, store *Store) {
+	t.Helper()
+	for range 2 {
+		if err := store.Polish(); err != nil {
+			t.Fatalf("polish failed: %v", err)
+		}
+	}
+	if stats := store.Stats(); stats.Keys != 0 {
+		t.Fatalf("expected every key in the cold tier, %d left", stats.Keys)
+	}
+}
+
+func TestSyncColdPath(t *testing.T) {
+	dir := t.TempDir()
+	open := func(name string) *Store {
+		store, err := NewStoreWithOptions(filepath.Join(dir, name+".db"), Options{ColdPath: filepath.Join(dir, name+".cold")})
+		if err != nil {
+			t.Fatalf("failed to create store: %v", err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return store
+	}
+	a, b := open("a"), open("b")
+	b.Set([]byte("key"), []byte("value"))
+	chill(t, b)
+	state, err := a.SyncWith(b, SyncState{}, LastWriteWins)
+	if err != nil {
+		t.Fatalf("initial sync failed: %v", err)
+	}
+	if value, err := a.Get([]byte("key")); err != nil || string(value) != "value" {
+		t.Errorf("expected the initial sync to copy the cold key, got %q, %v", value, err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go b.ServeSync(ln, PreferSrc)
+	c := open("c")
+	if _, err := c.SyncWithRemote(ln.Addr().String(), SyncState{}); err != nil {
+		t.Fatalf("initial sync failed: %v", err)
+	}
+	if value, err := c.Get([]byte("key")); err != nil || string(value) != "value" {
+		t.Errorf("expected the initial remote sync to copy the cold key, got %q, %v", value, err)
+	}
+
+	// A delete reaches a key in the cold tier of the peer
+	a.Delete([]byte("key"))
+	state, err = a.SyncWith(b, state, LastWriteWins)
+	if err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	if _, err := b.Get([]byte("key")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected the delete to reach the cold tier, got %v", err)
+	}
+}
@@ -0,0 +1,24 @@
+package stone
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// NewTempStore creates an empty store in a new temporary directory, which
+// Close removes with everything in it, e.g. for tests and scratch
+// computations.
+func NewTempStore() (*Store, error) {
+	dir, err := os.MkdirTemp("", "stonekv-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	store, err := NewStore(filepath.Join(dir, "temp.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	store.tempDir = dir
+	return store, nil
+}
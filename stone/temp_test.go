@@ -0,0 +1,28 @@
+package stone
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTempStore(t *testing.T) {
+	store, err := NewTempStore()
+	if err != nil {
+		t.Fatalf("failed to create temp store: %v", err)
+	}
+	dir := filepath.Dir(store.file.Name())
+	store.Set([]byte("key"), []byte("value"))
+	if err := store.Polish(); err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	if value, err := store.Get([]byte("key")); err != nil || string(value) != "value" {
+		t.Errorf("expected value, got %q (%v)", value, err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("failed to close store: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected Close to remove %s, got %v", dir, err)
+	}
+}
@@ -0,0 +1,78 @@
+package stone
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// throttleChunk is the most a throttled writer writes before it waits, so
+// large writes are spread out rather than sent in one burst.
+const throttleChunk = 64 << 10
+
+// limiter paces I/O to a number of bytes per second. Every call to wait
+// books its bytes right after those booked before it and sleeps until
+// their turn comes, so goroutines sharing a limiter share its rate.
+type limiter struct {
+	mu   sync.Mutex
+	rate float64   // Bytes per second
+	next time.Time // When the bytes booked so far are paid off
+}
+
+// newLimiter returns a limiter of rate bytes per second, or nil, which
+// doesn't limit, if rate is not positive.
+func newLimiter(rate int64) *limiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &limiter{rate: float64(rate)}
+}
+
+// wait books n bytes and sleeps until they are within the rate.
+func (l *limiter) wait(n int) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now // Idle time isn't saved up for bursts
+	}
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	delay := l.next.Sub(now)
+	l.mu.Unlock()
+	time.Sleep(delay)
+}
+
+// throttledWriter writes to w at the rate of l.
+type throttledWriter struct {
+	w io.Writer
+	l *limiter
+}
+
+func (tw throttledWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		chunk := p[:min(len(p), throttleChunk)]
+		n, err := tw.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		tw.l.wait(n)
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// throttledReaderAt reads from r at the rate of l.
+type throttledReaderAt struct {
+	r io.ReaderAt
+	l *limiter
+}
+
+func (tr throttledReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := tr.r.ReadAt(p, off)
+	tr.l.wait(n)
+	return n, err
+}
@@ -0,0 +1,52 @@
+package stone
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadWriteRate(t *testing.T) {
+	store, err := NewStoreWithOptions(filepath.Join(t.TempDir(), "test.db"), Options{
+		ReadRate:  1 << 20,
+		WriteRate: 2 << 20,
+	})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	// 10 × 50 KiB, about half a second at 1 MiB/s and a quarter at 2 MiB/s
+	value := make([]byte, 50<<10)
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		if err := store.Set([]byte(fmt.Sprintf("key%d", i)), value); err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > time.Second {
+		t.Errorf("expected writes to take about 250ms at 2 MiB/s, took %v", elapsed)
+	}
+
+	start = time.Now()
+	for i := 0; i < 10; i++ {
+		if _, err := store.Get([]byte(fmt.Sprintf("key%d", i))); err != nil {
+			t.Fatalf("get failed: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("expected reads to take about 500ms at 1 MiB/s, took %v", elapsed)
+	}
+
+	// Reads waiting for their turn don't hold up writes
+	go store.Scan(nil, func(key, value []byte) error { return nil })
+	time.Sleep(50 * time.Millisecond)
+	start = time.Now()
+	if err := store.Set([]byte("small"), []byte("value")); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("expected a write during a throttled scan not to wait, took %v", elapsed)
+	}
+}
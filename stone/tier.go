@@ -0,0 +1,149 @@
+package stone
+
+import (
+	"fmt"
+	"io"
+)
+
+// openCold opens the cold tier of a store opened with Options.ColdPath.
+func openCold(opts Options) (*Store, error) {
+	cold, err := NewStoreWithOptions(opts.ColdPath, Options{
+		SyncWrites: opts.SyncWrites,
+		ReadRate:   opts.ReadRate,
+		WriteRate:  opts.WriteRate,
+		Logger:     opts.Logger,
+		Backend:    opts.Backend,
+		Clock:      opts.Clock,
+		Rand:       opts.Rand,
+		ReadOnly:   opts.ReadOnly,
+		RedactKeys: opts.RedactKeys,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cold tier: %w", err)
+	}
+	return cold, nil
+}
+
+// tracksUse reports whether the store records when keys are used, to evict
+// them with Options.CacheSize or move them to the cold tier.
+func (s *Store) tracksUse() bool {
+	return s.opts.CacheSize > 0 || s.cold != nil
+}
+
+// find reads the value of key from whichever tier holds it, reporting
+// whether the key exists.
+func (s *Store) find(key string) ([]byte, bool, error) {
+	value, ok, err := s.lookup(key)
+	if ok || s.cold == nil {
+		return value, ok, err
+	}
+	value, ok, err = s.cold.lookup(key)
+	if ok {
+		return value, ok, err
+	}
+	// A Set moves a key back from the cold tier by writing it here before
+	// deleting it there, so it may have moved in between
+	return s.lookup(key)
+}
+
+// findSeq is find, also returning the sequence number the value was
+// written with, or 0 if it is in the cold tier, whose records are numbered
+// apart from the store's.
+func (s *Store) findSeq(key string) ([]byte, uint64, bool, error) {
+	value, version, ok, err := s.findVersion(key)
+	if version&coldVersion != 0 {
+		version = 0
+	}
+	return value, version, ok, err
+}
+
+// keyCount returns the number of live keys in both tiers. The caller must
+// hold at least the read lock.
+func (s *Store) keyCount() int {
+	n := s.index.len()
+	if s.cold == nil {
+		return n
+	}
+	s.cold.mu.RLock()
+	defer s.cold.mu.RUnlock()
+	for key := range s.cold.index.all() {
+		if _, ok := s.index.get(key); !ok {
+			n++ // Not moving back, see eachCold
+		}
+	}
+	return n
+}
+
+// writeCold writes the pairs of the cold tier to w as set records, each
+// numbered by next. The caller must hold at least the read lock.
+func (s *Store) writeCold(w io.Writer, next func() uint64) error {
+	return s.eachCold(func(key string, value []byte) error {
+		_, err := w.Write(encodeRecord(typeSet, next(), []byte(key), value))
+		return err
+	})
+}
+
+// eachCold calls fn for every pair of the cold tier. The caller must hold
+// at least the read lock.
+func (s *Store) eachCold(fn func(key string, value []byte) error) error {
+	c := s.cold
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for key, entry := range c.index.all() {
+		if _, ok := s.index.get(key); ok {
+			continue // Moving back; the store has the current value
+		}
+		value, err := c.readValue(entry)
+		if err != nil {
+			return err
+		}
+		err = fn(key, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteIfPresent deletes those of keys the cold tier holds from it, with
+// a single record.
+func (s *Store) deleteIfPresent(keys ...[]byte) error {
+	return s.write(func() error {
+		var present [][]byte
+		for _, key := range keys {
+			if _, ok := s.index.get(string(key)); ok {
+				present = append(present, key)
+			}
+		}
+		var err error
+		switch len(present) {
+		case 0:
+			return nil
+		case 1:
+			err = s.del(s.seq+1, present[0])
+		default:
+			err = s.delMulti(s.seq+1, present)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to delete from cold tier: %w", err)
+		}
+		return nil
+	})
+}
+
+// isCold reports whether Polish should move key to the cold tier: it
+// hasn't been read or written since the polish before, after which the
+// clock ticked from mark.
+func (s *Store) isCold(key string, mark uint64) bool {
+	return s.cold != nil && s.index.lastUsed(key) < mark
+}
+
+// polishCold polishes the cold tier once the records deleted or moved back
+// from it take up more space than those it holds.
+func (s *Store) polishCold() error {
+	stats := s.cold.Stats()
+	if stats.DeadBytes <= stats.LiveBytes {
+		return nil
+	}
+	return s.cold.Polish()
+}
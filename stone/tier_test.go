@@ -0,0 +1,134 @@
+package stone
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestColdPath(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{ColdPath: filepath.Join(dir, "cold.db")}
+	store, err := NewStoreWithOptions(filepath.Join(dir, "test.db"), opts)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer func() { store.Close() }()
+
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key%02d", i)) }
+	for i := 0; i < 100; i++ {
+		if err := store.Set(key(i), []byte(fmt.Sprintf("value%d", i))); err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+	}
+	// The first Polish only starts the clock; the second moves the keys
+	// not used in between
+	if err := store.Polish(); err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		store.Get(key(i))
+	}
+	if err := store.Polish(); err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	stats := store.Stats()
+	if stats.Keys != 10 || stats.ColdKeys != 90 {
+		t.Fatalf("expected 10 hot and 90 cold keys, got %d and %d", stats.Keys, stats.ColdKeys)
+	}
+	if stats.ColdDeadBytes != 0 {
+		t.Errorf("expected nothing dead in the cold tier yet, got %d bytes", stats.ColdDeadBytes)
+	}
+	if stats.FileSize >= stats.ColdFileSize {
+		t.Errorf("expected the hot log to be the smaller, got %d and %d bytes", stats.FileSize, stats.ColdFileSize)
+	}
+
+	// Writes move keys back or delete them from the cold tier
+	if err := store.Set(key(50), []byte("hot again")); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if err := store.Delete(key(60)); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if stats := store.Stats(); stats.Keys != 11 || stats.ColdKeys != 88 {
+		t.Errorf("expected 11 hot and 88 cold keys, got %d and %d", stats.Keys, stats.ColdKeys)
+	}
+	// Both records and the deletes taking them out of the cold tier
+	dead := 2*setRecordSize(5, 7) + 2*(1+8+4+5+4)
+	if stats := store.Stats(); stats.ColdDeadBytes != dead {
+		t.Errorf("expected %d dead bytes in the cold tier, got %d", dead, stats.ColdDeadBytes)
+	}
+
+	check := func() {
+		t.Helper()
+		for i := 0; i < 100; i++ {
+			want := fmt.Sprintf("value%d", i)
+			if i == 50 {
+				want = "hot again"
+			}
+			value, err := store.Get(key(i))
+			if i == 60 {
+				if err == nil {
+					t.Errorf("expected deleted key60 to stay deleted")
+				}
+				continue
+			}
+			if err != nil || string(value) != want {
+				t.Errorf("expected %s to be %q, got %q (%v)", key(i), want, value, err)
+			}
+		}
+		var scanned []string
+		store.Scan([]byte("key"), func(key, value []byte) error {
+			scanned = append(scanned, string(key))
+			return nil
+		})
+		if len(scanned) != 99 || scanned[0] != "key00" || scanned[98] != "key99" {
+			t.Errorf("expected a scan of 99 keys in order, got %d: %v", len(scanned), scanned)
+		}
+	}
+	check()
+	store.Close()
+	store, err = NewStoreWithOptions(filepath.Join(dir, "test.db"), opts)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	check()
+
+	// Comparing, merging, copying and backing up cover both tiers
+	merged, err := NewStore(filepath.Join(dir, "merged.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer merged.Close()
+	if err := merged.MergeFrom(store, PreferSrc); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	if diff, err := Diff(store, merged); err != nil || !diff.Equal() {
+		t.Errorf("expected the merged store to hold both tiers, got %+v, %v", diff, err)
+	}
+	copied, err := NewStore(filepath.Join(dir, "copied.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer copied.Close()
+	if err := store.CopyTo(copied, nil); err != nil {
+		t.Fatalf("copy failed: %v", err)
+	}
+	if diff, err := Diff(store, copied); err != nil || !diff.Equal() {
+		t.Errorf("expected the copy to hold both tiers, got %+v, %v", diff, err)
+	}
+	for _, polished := range []bool{false, true} {
+		path := filepath.Join(dir, fmt.Sprintf("backup-%v.db", polished))
+		if err := store.Backup(path, BackupOptions{Polished: polished}); err != nil {
+			t.Fatalf("backup failed: %v", err)
+		}
+		m, err := ReadManifest(path)
+		if err != nil || m.Keys != 99 || m.ToSeq != store.Seq() {
+			t.Errorf("expected a manifest of 99 keys up to seq %d, got %+v, %v", store.Seq(), m, err)
+		}
+		report, err := VerifyBackup(path, VerifyOptions{Store: store})
+		if err != nil || report.Keys != 99 || report.Seq != store.Seq() {
+			t.Errorf("expected a backup of 99 keys up to seq %d, got %+v, %v", store.Seq(), report, err)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package stone
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Time series are stored as a key per sample, named after its timestamp so
+// samples sort in time order, and the key of the series holds its
+// retention, if it has one. Polish drops the samples older than the
+// retention of their series, without writing deletes for them.
+
+// Sample is a sample of a time series.
+type Sample struct {
+	Time  time.Time
+	Value float64
+}
+
+// sampleKey returns the key of the sample of series at t. Timestamps are
+// encoded so that the keys sort in time order; those out of the range of
+// UnixNano, like the zero time, are clamped to it.
+func sampleKey(series []byte, t time.Time) []byte {
+	ns := t.UnixNano()
+	switch {
+	case t.Before(time.Unix(0, math.MinInt64)):
+		ns = math.MinInt64
+	case t.After(time.Unix(0, math.MaxInt64)):
+		ns = math.MaxInt64
+	}
+	return typedKey(kindSeries, series, binary.BigEndian.AppendUint64(nil, uint64(ns)^1<<63))
+}
+
+// sampleTime decodes the timestamp of a sample from its key element.
+func sampleTime(element string) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64([]byte(element))^1<<63))
+}
+
+// retention returns the retention of series, 0 if it has none.
+func (s *Store) retention(series []byte) (time.Duration, error) {
+	value, ok, err := s.find(string(typedKey(kindSeries, series, nil)))
+	if err != nil || !ok || len(value) != 8 {
+		return 0, err
+	}
+	return time.Duration(binary.BigEndian.Uint64(value)), nil
+}
+
+// TSSetRetention sets how long the samples of series are kept: older ones
+// aren't returned by TSRange and are dropped by Polish. 0, the default,
+// keeps them all.
+func (s *Store) TSSetRetention(series []byte, retention time.Duration) error {
+	if retention < 0 {
+		return fmt.Errorf("negative retention %v", retention)
+	}
+	return s.write(func() error {
+		key := typedKey(kindSeries, series, nil)
+		if retention == 0 {
+			_, ok, err := s.find(string(key))
+			if err != nil || !ok {
+				return err
+			}
+			return s.del(s.seq+1, key)
+		}
+		return s.set(s.seq+1, key, binary.BigEndian.AppendUint64(nil, uint64(retention)))
+	})
+}
+
+// TSAdd adds a sample with value at t to the time series stored under
+// series, creating the series if it doesn't exist, or replaces the sample
+// at t. Samples can be added in any order, but not older than the
+// retention of the series.
+func (s *Store) TSAdd(series []byte, t time.Time, value float64) error {
+	if t.Before(time.Unix(0, math.MinInt64)) || t.After(time.Unix(0, math.MaxInt64)) {
+		return fmt.Errorf("sample at %v is out of the range of timestamps", t)
+	}
+	return s.write(func() error {
+		retention, err := s.retention(series)
+		if err != nil {
+			return err
+		}
+		if retention > 0 && t.Before(s.clock.Now().Add(-retention)) {
+			return fmt.Errorf("sample at %v is older than the retention of the series", t)
+		}
+		return s.set(s.seq+1, sampleKey(series, t), binary.BigEndian.AppendUint64(nil, math.Float64bits(value)))
+	})
+}
+
+// TSRange returns the samples of the time series stored under series from
+// from to to, both included, in time order. Like Scan, it doesn't lock the
+// store, so samples added meanwhile may or may not be returned.
+func (s *Store) TSRange(series []byte, from, to time.Time) ([]Sample, error) {
+	retention, err := s.retention(series)
+	if err != nil {
+		return nil, err
+	}
+	if retention > 0 {
+		if oldest := s.clock.Now().Add(-retention); from.Before(oldest) {
+			from = oldest
+		}
+	}
+	prefix := typedKey(kindSeries, series, nil)
+	first, last := string(sampleKey(series, from)), string(sampleKey(series, to))
+	var samples []Sample
+	for _, key := range s.sortedKeys(prefix) {
+		if key < first || key > last || len(key) != len(prefix)+8 {
+			continue
+		}
+		value, ok, err := s.find(key)
+		if err != nil {
+			return nil, err
+		}
+		if !ok || len(value) != 8 {
+			continue
+		}
+		samples = append(samples, Sample{
+			Time:  sampleTime(key[len(prefix):]),
+			Value: math.Float64frombits(binary.BigEndian.Uint64(value)),
+		})
+	}
+	return samples, nil
+}
+
+// expired reports whether key is a sample older than the retention of its
+// series, for Polish to drop. Retentions are looked up once per series and
+// polish. The caller must hold the read lock.
+func (p *polisher) expired(key string) bool {
+	kind, series, element, ok := parseTypedKey(key)
+	if !ok || kind != kindSeries || len(element) != 8 {
+		return false
+	}
+	retention, ok := p.retentions[series]
+	if !ok {
+		retention, _ = p.s.retention([]byte(series))
+		if p.retentions == nil {
+			p.retentions = make(map[string]time.Duration)
+		}
+		p.retentions[series] = retention
+	}
+	return retention > 0 && sampleTime(element).Before(p.started.Add(-retention))
+}
@@ -0,0 +1,80 @@
+package stone
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTimeSeries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	key := []byte("cpu")
+	now := time.Now()
+	at := func(ago time.Duration) time.Time { return now.Add(-ago) }
+
+	// Out of order, and across the epoch
+	for _, ago := range []time.Duration{time.Minute, 3 * time.Hour, time.Hour, 0} {
+		err = store.TSAdd(key, at(ago), float64(ago/time.Minute))
+		if err != nil {
+			t.Fatalf("add failed: %v", err)
+		}
+	}
+	store.TSAdd(key, time.Unix(-1, 0), -1)
+	store.TSAdd([]byte("cp"), now, 42)
+
+	samples, err := store.TSRange(key, at(2*time.Hour), now)
+	if err != nil {
+		t.Fatalf("range failed: %v", err)
+	}
+	if len(samples) != 3 || samples[0].Value != 60 || samples[1].Value != 1 || samples[2].Value != 0 ||
+		!samples[2].Time.Equal(now) {
+		t.Errorf("expected the samples of the last 2 hours in order, got %+v", samples)
+	}
+	samples, _ = store.TSRange(key, time.Unix(-10, 0), time.Unix(0, 0))
+	if len(samples) != 1 || samples[0].Value != -1 {
+		t.Errorf("expected the sample before the epoch, got %+v", samples)
+	}
+
+	err = store.TSSetRetention(key, 2*time.Hour)
+	if err != nil {
+		t.Fatalf("failed to set the retention: %v", err)
+	}
+	err = store.TSAdd(key, at(3*time.Hour), 1)
+	if err == nil {
+		t.Errorf("expected adding a sample older than the retention to fail")
+	}
+	samples, _ = store.TSRange(key, time.Time{}, now)
+	if len(samples) != 3 {
+		t.Errorf("expected the expired samples to be hidden, got %+v", samples)
+	}
+
+	// Polish drops the expired samples, and only them
+	keys := store.Stats().Keys
+	err = store.Polish()
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	if got := store.Stats().Keys; got != keys-2 {
+		t.Errorf("expected polish to drop 2 samples of %d keys, got %d keys", keys, got)
+	}
+	store.Close()
+
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	samples, _ = store.TSRange(key, time.Time{}, now)
+	if len(samples) != 3 {
+		t.Errorf("expected 3 samples after reopening, got %+v", samples)
+	}
+	store.TSSetRetention(key, 0)
+	samples, _ = store.TSRange([]byte("cp"), time.Time{}, now)
+	if len(samples) != 1 || samples[0].Value != 42 {
+		t.Errorf("expected the other series to be left alone, got %+v", samples)
+	}
+}
@@ -0,0 +1,361 @@
+package stone
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Transactions are optimistic: they read without locking, buffer their
+// writes, and only take the write lock to commit, checking under it that
+// none of the keys they read was written since, by the sequence numbers of
+// their records, and then writing their changes. The store keeps only the
+// latest value of each key, so snapshot transactions can't read older
+// ones: they fail instead when a key was written since they began.
+
+// ErrConflict is returned by Txn.Commit when a key the transaction read was
+// written by someone else before it committed, and by Txn.Get in snapshot
+// transactions, see IsolationSnapshot. The transaction can be run again
+// from the start.
+var ErrConflict = errors.New("transaction conflict")
+
+// coldVersion marks the versions of keys in the cold tier, whose sequence
+// numbers are those of the cold log.
+const coldVersion = 1 << 63
+
+// Isolation selects what a transaction guarantees about the writes of
+// others, see TxnOptions.
+type Isolation int
+
+const (
+	// IsolationSerializable transactions commit as if no other write came
+	// in between their reads and their commit: Commit fails if any key they
+	// read was written since.
+	IsolationSerializable Isolation = iota
+	// IsolationSnapshot transactions read the store as it was when they
+	// began, and Commit fails if a key they write was written since, so no
+	// update is lost. Commit doesn't check the keys they only read, so two
+	// of them can each write what the other read.
+	IsolationSnapshot
+	// IsolationReadCommitted transactions read the latest committed values
+	// and Commit checks nothing, so their writes are applied together but
+	// overwrite whatever was written since they read.
+	IsolationReadCommitted
+)
+
+func (i Isolation) String() string {
+	switch i {
+	case IsolationSerializable:
+		return "serializable"
+	case IsolationSnapshot:
+		return "snapshot"
+	case IsolationReadCommitted:
+		return "read committed"
+	default:
+		return fmt.Sprintf("Isolation(%d)", int(i))
+	}
+}
+
+// TxnOptions configures a transaction started with BeginTx.
+type TxnOptions struct {
+	Isolation Isolation // IsolationSerializable by default
+}
+
+// Txn is a transaction, started with Begin or BeginTx. Its reads see its
+// own writes, which the rest of the store only sees once it commits. A Txn
+// is not safe for concurrent use.
+type Txn struct {
+	s          *Store
+	isolation  Isolation
+	start      uint64            // Sequence number when it began, with IsolationSnapshot
+	reads      map[string]uint64 // Versions of the keys read, see version
+	writes     []txnWrite        // In the order they were made
+	pending    map[string]int    // Index in writes of the last write of each key
+	savepoints []txnSavepoint    // Live savepoints, oldest first
+	nextID     int               // ID of the next savepoint
+	done       bool              // Committed or rolled back
+}
+
+// txnWrite is a write buffered by a transaction.
+type txnWrite struct {
+	key     string
+	value   []byte
+	deleted bool
+}
+
+// Begin starts a serializable transaction. It must be ended with Commit or
+// Rollback.
+func (s *Store) Begin() *Txn {
+	return s.BeginTx(TxnOptions{})
+}
+
+// BeginTx starts a transaction with the given options. It must be ended
+// with Commit or Rollback.
+func (s *Store) BeginTx(opts TxnOptions) *Txn {
+	tx := &Txn{
+		s:         s,
+		isolation: opts.Isolation,
+		reads:     make(map[string]uint64),
+		pending:   make(map[string]int),
+	}
+	if tx.isolation == IsolationSnapshot {
+		tx.start = s.Seq()
+	}
+	return tx
+}
+
+// errTxnDone is the error of using a transaction that has ended.
+var errTxnDone = errors.New("transaction already committed or rolled back")
+
+// Get returns the value of key as the transaction sees it: as last written
+// by the transaction, or else as stored. In serializable transactions,
+// Commit then fails with ErrConflict if the key is written by someone else
+// before the transaction commits; a key that doesn't exist is read too, so
+// its creation conflicts as well. In snapshot transactions, Get fails with
+// ErrConflict if the key was written since the transaction began, as its
+// value then is gone.
+func (tx *Txn) Get(key []byte) ([]byte, error) {
+	if tx.done {
+		return nil, errTxnDone
+	}
+	if i, ok := tx.pending[string(key)]; ok {
+		if tx.writes[i].deleted {
+			return nil, ErrKeyNotFound
+		}
+		return bytes.Clone(tx.writes[i].value), nil
+	}
+	value, version, ok, err := tx.s.findVersion(string(key))
+	if err != nil {
+		return nil, err
+	}
+	if tx.isolation == IsolationSnapshot && tx.s.changedSince(version, tx.start) {
+		return nil, ErrConflict
+	}
+	if _, read := tx.reads[string(key)]; !read && tx.isolation != IsolationReadCommitted {
+		tx.reads[string(key)] = version
+	}
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	if tx.s.tracksUse() {
+		tx.s.index.touch(string(key))
+	}
+	tx.s.readLimit.wait(len(value))
+	return value, nil
+}
+
+// Set sets key to value in the transaction. It fails with ErrInvalidKey
+// or ErrValueTooLarge for pairs Set would reject.
+func (tx *Txn) Set(key, value []byte) error {
+	return tx.write(txnWrite{key: string(key), value: bytes.Clone(value)})
+}
+
+// Delete deletes key in the transaction. It fails with ErrInvalidKey for
+// keys Delete would reject.
+func (tx *Txn) Delete(key []byte) error {
+	return tx.write(txnWrite{key: string(key), deleted: true})
+}
+
+func (tx *Txn) write(w txnWrite) error {
+	if tx.done {
+		return errTxnDone
+	}
+	var err error
+	if w.deleted {
+		err = tx.s.checkDeleted([]byte(w.key))
+	} else {
+		err = tx.s.checkPair([]byte(w.key), w.value)
+	}
+	if err != nil {
+		return err
+	}
+	tx.pending[w.key] = len(tx.writes)
+	tx.writes = append(tx.writes, w)
+	return nil
+}
+
+// Commit writes the changes of the transaction to the store, unless a key
+// it read was written since, or with IsolationSnapshot a key it writes, in
+// which case it writes nothing and fails with ErrConflict. Either way the
+// transaction ends. The changes are
+// written as a single log record, so that, even across a crash, either all
+// of them are made or none are, and under one hold of the write lock, so
+// no other write comes in between and a transaction reading some of them
+// before they are all in the index fails to commit; Gets outside
+// transactions may see some before the others. With Options.SyncWrites
+// they are synced before Commit returns.
+func (tx *Txn) Commit() error {
+	if tx.done {
+		return errTxnDone
+	}
+	tx.done = true
+	s := tx.s
+	return s.write(func() error {
+		switch tx.isolation {
+		case IsolationSerializable:
+			for key, version := range tx.reads {
+				if s.version(key) != version {
+					return ErrConflict
+				}
+			}
+		case IsolationSnapshot:
+			for key := range tx.pending {
+				if tx.written(key) {
+					return ErrConflict
+				}
+			}
+		}
+		var writes []*record
+		for i, w := range tx.writes {
+			if tx.pending[w.key] != i {
+				continue // Overwritten later in the transaction
+			}
+			rec := &record{kind: typeSet, key: []byte(w.key), value: w.value}
+			if w.deleted {
+				rec.kind, rec.value = typeDelete, nil
+			}
+			writes = append(writes, rec)
+		}
+		switch {
+		case len(writes) == 0:
+			return nil
+		case len(writes) > 1:
+			return s.batch(s.seq+1, writes)
+		case writes[0].kind == typeDelete:
+			return s.del(s.seq+1, writes[0].key)
+		default:
+			return s.set(s.seq+1, writes[0].key, writes[0].value)
+		}
+	})
+}
+
+// Savepoint marks a point in a transaction to roll back to, returned by
+// Txn.Savepoint.
+type Savepoint struct {
+	tx *Txn
+	id int
+}
+
+// txnSavepoint is a live savepoint of a transaction.
+type txnSavepoint struct {
+	id     int
+	writes int // Writes made before it
+}
+
+// errBadSavepoint is the error of rolling back to a savepoint that isn't
+// live in the transaction.
+var errBadSavepoint = errors.New("savepoint not found in transaction")
+
+// Savepoint marks the current point in the transaction, so that RollbackTo
+// can later undo the writes made after it, keeping those made before.
+func (tx *Txn) Savepoint() Savepoint {
+	sp := txnSavepoint{id: tx.nextID, writes: len(tx.writes)}
+	tx.nextID++
+	tx.savepoints = append(tx.savepoints, sp)
+	return Savepoint{tx: tx, id: sp.id}
+}
+
+// RollbackTo undoes the writes made in the transaction since sp, which
+// stays, so it can be rolled back to again; savepoints made after it are
+// released. The keys read since are still checked on commit, as what was
+// read may have shaped the writes kept. It fails if the transaction has
+// ended, or sp was released or made by another transaction.
+func (tx *Txn) RollbackTo(sp Savepoint) error {
+	if tx.done {
+		return errTxnDone
+	}
+	i := slices.IndexFunc(tx.savepoints, func(live txnSavepoint) bool {
+		return live.id == sp.id
+	})
+	if sp.tx != tx || i < 0 {
+		return errBadSavepoint
+	}
+	n := tx.savepoints[i].writes
+	tx.savepoints = tx.savepoints[:i+1]
+	clear(tx.writes[n:])
+	tx.writes = tx.writes[:n]
+	clear(tx.pending)
+	for i, w := range tx.writes {
+		tx.pending[w.key] = i
+	}
+	return nil
+}
+
+// Rollback ends the transaction without writing its changes. It does
+// nothing if the transaction has ended already, so it can be deferred.
+func (tx *Txn) Rollback() {
+	tx.done = true
+	tx.writes, tx.pending, tx.savepoints = nil, nil, nil
+}
+
+// written reports whether key was written since the snapshot transaction
+// began. The caller must hold the write lock.
+func (tx *Txn) written(key string) bool {
+	version := tx.s.version(key)
+	if read, ok := tx.reads[key]; ok {
+		return version != read // Read unchanged since it began
+	}
+	return tx.s.changedSince(version, tx.start)
+}
+
+// changedSince reports whether a key with the given version, see version,
+// may have been written after the sequence number seq. Only the records of
+// the hot tier tell when they were written, so a key in the cold tier or
+// missing counts as written if anything was deleted since, which moving it
+// to the cold tier and deleting it both do. The version must be read
+// before delSeq, which del sets before removing the key.
+func (s *Store) changedSince(version, seq uint64) bool {
+	if version != 0 && version&coldVersion == 0 {
+		return version > seq
+	}
+	return s.delSeq.Load() > seq
+}
+
+// version returns the version of key: the sequence number of its record,
+// marked with coldVersion if it is in the cold tier, or 0 if it doesn't
+// exist. The caller must hold the write lock.
+func (s *Store) version(key string) uint64 {
+	if entry, ok := s.index.get(key); ok {
+		return entry.seq
+	}
+	if s.cold != nil {
+		sh := s.cold.index.shard(key)
+		sh.mu.RLock()
+		defer sh.mu.RUnlock()
+		if entry, ok := sh.get(key); ok {
+			return entry.seq | coldVersion
+		}
+	}
+	return 0
+}
+
+// findVersion is find, also returning the version of key, see version.
+func (s *Store) findVersion(key string) ([]byte, uint64, bool, error) {
+	value, seq, ok, err := s.lookupVersion(key)
+	if ok || s.cold == nil {
+		return value, seq, ok, err
+	}
+	value, seq, ok, err = s.cold.lookupVersion(key)
+	if ok {
+		return value, seq | coldVersion, ok, err
+	}
+	return s.lookupVersion(key) // Moved back meanwhile, see find
+}
+
+// lookupVersion is lookup, also returning the sequence number of the
+// record of key.
+func (s *Store) lookupVersion(key string) ([]byte, uint64, bool, error) {
+	sh := s.index.shard(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	if s.closed() {
+		return nil, 0, false, ErrClosed
+	}
+	entry, ok := sh.get(key)
+	if !ok {
+		return nil, 0, false, nil
+	}
+	value, err := s.readValue(entry)
+	return value, entry.seq, true, s.opError("read", []byte(key), int64(entry.offset), err)
+}
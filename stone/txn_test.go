@@ -0,0 +1,445 @@
+package stone
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestTxn(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	store.Set([]byte("a"), []byte("1"))
+	store.Set([]byte("b"), []byte("2"))
+
+	tx := store.Begin()
+	tx.Set([]byte("a"), []byte("10"))
+	tx.Delete([]byte("b"))
+	if value, err := tx.Get([]byte("a")); err != nil || string(value) != "10" {
+		t.Errorf("expected the transaction to see its write, got %q, %v", value, err)
+	}
+	if _, err := tx.Get([]byte("b")); err == nil {
+		t.Errorf("expected the transaction to see its delete")
+	}
+	if value, _ := store.Get([]byte("a")); string(value) != "1" {
+		t.Errorf("expected the store not to see the write before commit, got %q", value)
+	}
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("commit failed: %v", err)
+	}
+	if value, _ := store.Get([]byte("a")); string(value) != "10" {
+		t.Errorf("expected 10 after commit, got %q", value)
+	}
+	if _, err := store.Get([]byte("b")); err == nil {
+		t.Errorf("expected b to be deleted after commit")
+	}
+	if err := tx.Set([]byte("c"), nil); err == nil {
+		t.Errorf("expected using a committed transaction to fail")
+	}
+
+	tx = store.Begin()
+	tx.Set([]byte("a"), []byte("rolled back"))
+	tx.Rollback()
+	if err := tx.Commit(); err == nil {
+		t.Errorf("expected committing a rolled back transaction to fail")
+	}
+	if value, _ := store.Get([]byte("a")); string(value) != "10" {
+		t.Errorf("expected 10 after rollback, got %q", value)
+	}
+
+	// A key read and written by someone else before commit conflicts
+	tx = store.Begin()
+	tx.Get([]byte("a"))
+	tx.Set([]byte("c"), []byte("3"))
+	store.Set([]byte("a"), []byte("11"))
+	if err := tx.Commit(); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected a conflict, got %v", err)
+	}
+	if _, err := store.Get([]byte("c")); err == nil {
+		t.Errorf("expected a conflicting transaction to write nothing")
+	}
+
+	// So does a key read as missing and created meanwhile
+	tx = store.Begin()
+	tx.Get([]byte("d"))
+	tx.Set([]byte("e"), []byte("5"))
+	store.Set([]byte("d"), []byte("4"))
+	if err := tx.Commit(); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected a conflict on a created key, got %v", err)
+	}
+
+	// Writes to keys the transaction didn't read don't
+	tx = store.Begin()
+	tx.Get([]byte("a"))
+	tx.Set([]byte("d"), []byte("40"))
+	store.Set([]byte("d"), []byte("41"))
+	err = tx.Commit()
+	if err != nil {
+		t.Errorf("expected a blind write to commit, got %v", err)
+	}
+	if value, _ := store.Get([]byte("d")); string(value) != "40" {
+		t.Errorf("expected the transaction to win, got %q", value)
+	}
+}
+
+func TestTxnBatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	store.Set([]byte("a"), []byte("1"))
+	store.Set([]byte("b"), []byte("2"))
+	changes, err := store.Changes(store.Seq())
+	if err != nil {
+		t.Fatalf("changes failed: %v", err)
+	}
+	commit := func() {
+		tx := store.Begin()
+		tx.Set([]byte("a"), []byte("10"))
+		tx.Delete([]byte("b"))
+		tx.Set([]byte("c"), []byte("30"))
+		err := tx.Commit()
+		if err != nil {
+			t.Fatalf("commit failed: %v", err)
+		}
+	}
+	commit()
+	for _, want := range []string{"3 set a=10", "3 delete b=", "3 set c=30"} {
+		rec := nextRecord(t, changes)
+		if got := fmt.Sprintf("%d %v %s=%s", rec.Seq, rec.Type, rec.Key, rec.Value); got != want {
+			t.Errorf("expected %s in the feed, got %s", want, got)
+		}
+	}
+	store.Close()
+
+	// The writes are made with one record, which a crash can tear but not
+	// split
+	var types []string
+	Dump(path, func(rec DumpRecord) error {
+		types = append(types, rec.Type)
+		return nil
+	})
+	if fmt.Sprint(types) != "[set set batch]" {
+		t.Errorf("expected one batch record, got %v", types)
+	}
+	info, _ := os.Stat(path)
+	os.Truncate(path, info.Size()-1)
+	store, err = NewStore(path) // Cuts the torn record off
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	contents := func() string {
+		var pairs []string
+		store.Scan(nil, func(key, value []byte) error {
+			pairs = append(pairs, string(key)+"="+string(value))
+			return nil
+		})
+		return fmt.Sprint(pairs)
+	}
+	if got := contents(); got != "[a=1 b=2]" {
+		t.Errorf("expected none of the writes to survive the torn commit, got %v", got)
+	}
+
+	// and replayed in full otherwise
+	commit()
+	store.Close()
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if got := contents(); got != "[a=10 c=30]" {
+		t.Errorf("expected all of the writes after reopening, got %v", got)
+	}
+	report, err := Check(path)
+	if err != nil || report.Batches != 1 || report.Keys != 2 || report.NeedsRepair() {
+		t.Errorf("expected one batch record leaving 2 keys, got %+v, %v", report, err)
+	}
+}
+
+func TestTxnConcurrent(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	key := []byte("counter")
+	store.Set(key, []byte("0"))
+
+	// Increments retried on conflict add up
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				for {
+					tx := store.Begin()
+					value, err := tx.Get(key)
+					if err != nil {
+						t.Errorf("get failed: %v", err)
+						return
+					}
+					n, _ := strconv.Atoi(string(value))
+					tx.Set(key, []byte(strconv.Itoa(n+1)))
+					err = tx.Commit()
+					if err == nil {
+						break
+					}
+					if !errors.Is(err, ErrConflict) {
+						t.Errorf("commit failed: %v", err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if value, _ := store.Get(key); string(value) != "400" {
+		t.Errorf("expected 400, got %s", value)
+	}
+}
+
+func TestTxnSavepoint(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	store.Set([]byte("a"), []byte("1"))
+
+	tx := store.Begin()
+	tx.Set([]byte("a"), []byte("2"))
+	first := tx.Savepoint()
+	tx.Set([]byte("a"), []byte("3"))
+	tx.Set([]byte("b"), []byte("3"))
+	second := tx.Savepoint()
+	tx.Delete([]byte("a"))
+	if err := tx.RollbackTo(second); err != nil {
+		t.Fatalf("rollback to savepoint failed: %v", err)
+	}
+	if value, err := tx.Get([]byte("a")); err != nil || string(value) != "3" {
+		t.Errorf("expected the delete to be undone, got %q, %v", value, err)
+	}
+	if err := tx.RollbackTo(first); err != nil {
+		t.Fatalf("rollback to savepoint failed: %v", err)
+	}
+	if value, _ := tx.Get([]byte("a")); string(value) != "2" {
+		t.Errorf("expected the write before the savepoint to stay, got %q", value)
+	}
+	if _, err := tx.Get([]byte("b")); err == nil {
+		t.Errorf("expected the write after the savepoint to be undone")
+	}
+	if err := tx.RollbackTo(second); err == nil {
+		t.Errorf("expected rolling back to a released savepoint to fail")
+	}
+	if err := store.Begin().RollbackTo(first); err == nil {
+		t.Errorf("expected rolling back to another transaction's savepoint to fail")
+	}
+
+	// The savepoint stays, so it can be rolled back to again
+	tx.Set([]byte("c"), []byte("4"))
+	if err := tx.RollbackTo(first); err != nil {
+		t.Fatalf("rollback to savepoint failed: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("commit failed: %v", err)
+	}
+	if value, _ := store.Get([]byte("a")); string(value) != "2" {
+		t.Errorf("expected 2 after commit, got %q", value)
+	}
+	for _, key := range []string{"b", "c"} {
+		if _, err := store.Get([]byte(key)); err == nil {
+			t.Errorf("expected %s not to be written", key)
+		}
+	}
+}
+
+func TestTxnIsolation(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	store.Set([]byte("a"), []byte("1"))
+	store.Set([]byte("b"), []byte("1"))
+	snapshot := TxnOptions{Isolation: IsolationSnapshot}
+	readCommitted := TxnOptions{Isolation: IsolationReadCommitted}
+
+	// Write skew: each transaction writes the key the other read. Only
+	// snapshot isolation lets both commit.
+	for _, isolation := range []Isolation{IsolationSerializable, IsolationSnapshot} {
+		tx1 := store.BeginTx(TxnOptions{Isolation: isolation})
+		tx2 := store.BeginTx(TxnOptions{Isolation: isolation})
+		tx1.Get([]byte("a"))
+		tx2.Get([]byte("b"))
+		tx1.Set([]byte("b"), []byte("0"))
+		tx2.Set([]byte("a"), []byte("0"))
+		err1, err2 := tx1.Commit(), tx2.Commit()
+		if err1 != nil {
+			t.Errorf("%v: expected the first commit to succeed, got %v", isolation, err1)
+		}
+		if isolation == IsolationSnapshot && err2 != nil {
+			t.Errorf("%v: expected write skew to commit, got %v", isolation, err2)
+		}
+		if isolation == IsolationSerializable && !errors.Is(err2, ErrConflict) {
+			t.Errorf("%v: expected write skew to conflict, got %v", isolation, err2)
+		}
+		store.Set([]byte("a"), []byte("1"))
+		store.Set([]byte("b"), []byte("1"))
+	}
+
+	// Snapshot reads fail once a key was written since the transaction
+	// began, created and deleted keys included
+	tx := store.BeginTx(snapshot)
+	if value, err := tx.Get([]byte("a")); err != nil || string(value) != "1" {
+		t.Errorf("expected 1, got %q, %v", value, err)
+	}
+	store.Set([]byte("b"), []byte("2"))
+	store.Set([]byte("c"), []byte("2"))
+	if _, err := tx.Get([]byte("b")); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected reading a changed key to conflict, got %v", err)
+	}
+	if _, err := tx.Get([]byte("c")); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected reading a created key to conflict, got %v", err)
+	}
+	tx.Rollback()
+	tx = store.BeginTx(snapshot)
+	store.Delete([]byte("c"))
+	if _, err := tx.Get([]byte("c")); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected reading a deleted key to conflict, got %v", err)
+	}
+	tx.Rollback()
+
+	// and commits fail if a key they write was written since, even
+	// without reading it, so no update is lost
+	tx = store.BeginTx(snapshot)
+	tx.Set([]byte("a"), []byte("3"))
+	store.Set([]byte("a"), []byte("4"))
+	if err := tx.Commit(); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected a write-write conflict, got %v", err)
+	}
+	tx = store.BeginTx(snapshot)
+	tx.Set([]byte("d"), []byte("3"))
+	store.Set([]byte("a"), []byte("5"))
+	if err := tx.Commit(); err != nil {
+		t.Errorf("expected writes to other keys not to conflict, got %v", err)
+	}
+
+	// Read committed transactions see the latest values and never conflict
+	tx = store.BeginTx(readCommitted)
+	tx.Get([]byte("a"))
+	store.Set([]byte("a"), []byte("6"))
+	if value, _ := tx.Get([]byte("a")); string(value) != "6" {
+		t.Errorf("expected the latest value, got %q", value)
+	}
+	tx.Set([]byte("a"), []byte("7"))
+	store.Set([]byte("a"), []byte("8"))
+	if err := tx.Commit(); err != nil {
+		t.Errorf("expected read committed not to conflict, got %v", err)
+	}
+	if value, _ := store.Get([]byte("a")); string(value) != "7" {
+		t.Errorf("expected the transaction to win, got %q", value)
+	}
+}
+
+func TestTxnIsolationConcurrent(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	// Snapshot transactions don't lose increments either, and transfers
+	// between accounts keep their total, as every read is of the same
+	// snapshot
+	keys := [][]byte{[]byte("x"), []byte("y"), []byte("z")}
+	for _, key := range keys {
+		store.Set(key, []byte("100"))
+	}
+	var wg sync.WaitGroup
+	for g := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 50 {
+				from, to := keys[(g+i)%3], keys[(g+i+1)%3]
+				for {
+					err := transfer(store, from, to)
+					if err == nil {
+						break
+					}
+					if !errors.Is(err, ErrConflict) {
+						t.Errorf("transfer failed: %v", err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				tx := store.BeginTx(TxnOptions{Isolation: IsolationSnapshot})
+				total := 0
+				for _, key := range keys {
+					value, err := tx.Get(key)
+					if errors.Is(err, ErrConflict) {
+						total = -1
+						break
+					}
+					if err != nil {
+						t.Errorf("get failed: %v", err)
+						return
+					}
+					n, _ := strconv.Atoi(string(value))
+					total += n
+				}
+				tx.Rollback()
+				if total != -1 && total != 300 {
+					t.Errorf("expected a snapshot to total 300, got %d", total)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	total := 0
+	for _, key := range keys {
+		value, _ := store.Get(key)
+		n, _ := strconv.Atoi(string(value))
+		total += n
+	}
+	if total != 300 {
+		t.Errorf("expected a total of 300, got %d", total)
+	}
+}
+
+// transfer moves 1 from one account to another in a snapshot transaction.
+func transfer(store *Store, from, to []byte) error {
+	tx := store.BeginTx(TxnOptions{Isolation: IsolationSnapshot})
+	defer tx.Rollback()
+	for _, move := range []struct {
+		key   []byte
+		delta int
+	}{{from, -1}, {to, 1}} {
+		value, err := tx.Get(move.key)
+		if err != nil {
+			return err
+		}
+		n, _ := strconv.Atoi(string(value))
+		tx.Set(move.key, []byte(strconv.Itoa(n+move.delta)))
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,94 @@
+package stone
+
+import (
+	"encoding/binary"
+)
+
+// Lists and the other data types are stored as ordinary keys in the log,
+// one per element, so updating one element writes one small record rather
+// than the whole collection, and backups, replication and Polish handle
+// them like any other key. Their keys start with a zero byte and a byte
+// naming the type, followed by the length of the name of the collection,
+// so no two collections share a key, and then the element:
+//
+//	0x00 kind uvarint(len(name)) name element
+//
+// The key without the element, the prefix of every element, holds the
+// metadata of the collection, if it has any. Scan visits these keys like
+// any other, but plain sets of keys starting with a zero byte fail with
+// ErrReservedKey.
+const typedKeyMarker = 0x00
+
+// Kinds of typed keys.
+const (
+	kindList    = 'l'
+	kindSet     = 's'
+	kindHash    = 'h'
+	kindZSet    = 'z' // Scores of the members of sorted sets
+	kindZRank   = 'Z' // Members of sorted sets ordered by score
+	kindQueue   = 'q' // Messages of queues
+	kindClaim   = 'Q' // Deadlines of the messages of queues being processed
+	kindStream  = 'x' // Entries of streams
+	kindOffset  = 'X' // Offsets committed by the consumers of streams
+	kindChannel = 'c' // Last messages published on channels
+	kindBitmap  = 'b' // Chunks of bitmaps
+	kindHLL     = 'p' // HyperLogLog sketches
+	kindSeries  = 't' // Samples of time series
+	kindGeo     = 'g' // Positions of the members of geo sets
+	kindGeoHash = 'G' // Members of geo sets by geohash
+	kindLock    = 'k' // Advisory locks
+)
+
+// typedKey returns the key of element of the collection of the given kind
+// and name.
+func typedKey(kind byte, name, element []byte) []byte {
+	key := make([]byte, 0, 2+binary.MaxVarintLen64+len(name)+len(element))
+	key = append(key, typedKeyMarker, kind)
+	key = binary.AppendUvarint(key, uint64(len(name)))
+	key = append(key, name...)
+	return append(key, element...)
+}
+
+// idKey returns the key of element id of the collection of the given kind
+// and name, for collections whose elements are numbered by nextID. IDs are
+// encoded so that the keys sort in ID order.
+func idKey(kind byte, name []byte, id uint64) []byte {
+	return typedKey(kind, name, binary.BigEndian.AppendUint64(nil, id))
+}
+
+// nextID returns the ID of the next element of the collection of the given
+// kind and name, starting from 1, and stores the one after it in the key of
+// the collection, so IDs are never reused, even if the collection is
+// emptied. It is written before the element, so a crash in between can't
+// leave an element whose ID is given to another. The caller must hold the
+// write lock.
+func (s *Store) nextID(kind byte, name []byte) (uint64, error) {
+	key := typedKey(kind, name, nil)
+	next, ok, err := s.find(string(key))
+	if err != nil {
+		return 0, err
+	}
+	id := uint64(1)
+	if ok && len(next) == 8 {
+		id = binary.BigEndian.Uint64(next)
+	}
+	err = s.set(s.seq+1, key, binary.BigEndian.AppendUint64(nil, id+1))
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// parseTypedKey splits a typed key into the kind and name of its collection
+// and its element. ok is false for keys that aren't typed.
+func parseTypedKey(key string) (kind byte, name, element string, ok bool) {
+	if len(key) < 3 || key[0] != typedKeyMarker {
+		return 0, "", "", false
+	}
+	n, size := binary.Uvarint([]byte(key[2:min(len(key), 2+binary.MaxVarintLen64)]))
+	if size <= 0 || n > uint64(len(key)-2-size) {
+		return 0, "", "", false
+	}
+	start := 2 + size
+	return key[1], key[start : start+int(n)], key[start+int(n):], true
+}
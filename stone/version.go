@@ -0,0 +1,83 @@
+package stone
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrVersionMismatch is returned by SetIfVersion when the key doesn't have
+// the expected version.
+var ErrVersionMismatch = errors.New("version mismatch")
+
+// GetVersion is Get, also returning the version of the key: a number that
+// changes whenever the key is written, e.g. to serve as an HTTP ETag and
+// pass to SetIfVersion. Versions are the sequence numbers of the records
+// of the keys, so they only grow, but a key moving to or from the cold
+// tier gets a new version too, as it is written there anew.
+func (s *Store) GetVersion(key []byte) ([]byte, uint64, error) {
+	defer s.latency.observe(opGet, time.Now())
+	var version uint64
+	get := func(op *Operation) error {
+		var err error
+		op.Value, version, err = s.getVersion(op.Key)
+		return err
+	}
+	op := &Operation{Name: "get", Key: key}
+	var err error
+	if !s.intercepted() {
+		err = get(op)
+	} else {
+		err = s.intercept(op, get)
+	}
+	return op.Value, version, err
+}
+
+// getVersion is GetVersion without the interceptors.
+func (s *Store) getVersion(key []byte) ([]byte, uint64, error) {
+	value, version, ok, err := s.findVersion(string(key))
+	if err == ErrClosed {
+		return nil, 0, err
+	}
+	if !ok {
+		return nil, 0, ErrKeyNotFound
+	}
+	if s.tracksUse() {
+		s.index.touch(string(key))
+	}
+	s.readLimit.wait(len(value))
+	return value, version, err
+}
+
+// SetIfVersion sets key to value if the key has the given version, see
+// GetVersion, or if version is 0 and the key doesn't exist, and returns
+// its new version. Otherwise it writes nothing and fails with
+// ErrVersionMismatch, so concurrent read-modify-write cycles don't
+// overwrite each other, like HTTP requests with If-Match.
+func (s *Store) SetIfVersion(key, value []byte, version uint64) (uint64, error) {
+	defer s.latency.observe(opSet, time.Now())
+	var written uint64
+	set := func(op *Operation) error {
+		err := s.checkPair(op.Key, op.Value)
+		if err != nil {
+			return err
+		}
+		return s.write(func() error {
+			if s.version(string(op.Key)) != version {
+				return ErrVersionMismatch
+			}
+			written = s.seq + 1
+			return s.set(written, op.Key, op.Value)
+		})
+	}
+	op := &Operation{Name: "set", Key: key, Value: value}
+	var err error
+	if !s.intercepted() {
+		err = set(op)
+	} else {
+		err = s.intercept(op, set)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return written, nil
+}
@@ -0,0 +1,54 @@
+package stone
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetIfVersion(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	key := []byte("doc")
+
+	// Version 0 creates the key only if it doesn't exist
+	v1, err := store.SetIfVersion(key, []byte("v1"), 0)
+	if err != nil || v1 == 0 {
+		t.Fatalf("expected to create the key, got %d, %v", v1, err)
+	}
+	if _, err := store.SetIfVersion(key, []byte("again"), 0); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("expected creating an existing key to fail, got %v", err)
+	}
+	value, version, err := store.GetVersion(key)
+	if err != nil || string(value) != "v1" || version != v1 {
+		t.Errorf("expected v1 at version %d, got %q at %d, %v", v1, value, version, err)
+	}
+
+	// Writes with a stale version fail
+	v2, err := store.SetIfVersion(key, []byte("v2"), v1)
+	if err != nil || v2 <= v1 {
+		t.Fatalf("expected a newer version, got %d, %v", v2, err)
+	}
+	if _, err := store.SetIfVersion(key, []byte("stale"), v1); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("expected a stale version to fail, got %v", err)
+	}
+	store.Set(key, []byte("v3"))
+	if _, err := store.SetIfVersion(key, []byte("stale"), v2); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("expected a version changed by Set to fail, got %v", err)
+	}
+	if value, _ := store.Get(key); string(value) != "v3" {
+		t.Errorf("expected failed writes to write nothing, got %q", value)
+	}
+
+	// A deleted key has no version
+	store.Delete(key)
+	if _, _, err := store.GetVersion(key); err == nil {
+		t.Errorf("expected getting a deleted key to fail")
+	}
+	if _, err := store.SetIfVersion(key, []byte("v4"), 0); err != nil {
+		t.Errorf("expected to recreate the key, got %v", err)
+	}
+}
@@ -0,0 +1,232 @@
+package stone
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The WAL archives every record with its timestamp in segment files named
+// after the sequence number of their first record, so a backup can be rolled
+// forward to any point in time. A new segment is started whenever the store
+// is opened, so a torn record can only be found at the end of a segment.
+// Records reach the WAL after the main log; if a crash falls in between, the
+// missing record is reported as a gap when replaying past it.
+const (
+	walPrefix             = "wal-"
+	walSuffix             = ".log"
+	defaultWALSegmentSize = 64 << 20
+)
+
+// walWriter appends records to the current WAL segment.
+type walWriter struct {
+	dir       string
+	opts      Options
+	file      *os.File
+	size      int64
+	firstSeq  uint64 // Sequence number the current segment was named after
+	lastStamp int64  // Timestamp of the last record, kept monotonic
+}
+
+// openWAL starts a new segment in opts.WALDir for records from seq onwards.
+func openWAL(opts Options, seq uint64) (*walWriter, error) {
+	err := os.MkdirAll(opts.WALDir, 0777)
+	if err != nil {
+		return nil, err
+	}
+	w := &walWriter{dir: opts.WALDir, opts: opts}
+	err = w.rotate(seq)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// archive appends a record to the WAL, if one is configured. The caller must
+// hold the write lock.
+func (s *Store) archive(kind byte, seq uint64, key, value []byte) error {
+	if s.wal == nil {
+		return nil
+	}
+	err := s.wal.append(kind, seq, key, value)
+	if err != nil {
+		return fmt.Errorf("failed to write WAL record: %w", err)
+	}
+	return nil
+}
+
+func (w *walWriter) append(kind byte, seq uint64, key, value []byte) error {
+	limit := w.opts.WALSegmentSize
+	if limit <= 0 {
+		limit = defaultWALSegmentSize
+	}
+	if w.size >= limit && seq != w.firstSeq {
+		err := w.rotate(seq)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Timestamps never go backwards, so a prefix of the WAL is also
+	// everything written up to some point in time.
+	ts := clockOf(w.opts).Now().UnixNano()
+	if ts <= w.lastStamp {
+		ts = w.lastStamp + 1
+	}
+	w.lastStamp = ts
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+	record := encodeRecordInto(*buf, kind, seq, ts, key, value)
+	*buf = record
+	_, err := w.file.Write(record)
+	if err != nil {
+		return err
+	}
+	w.size += int64(len(record))
+	return nil
+}
+
+// rotate closes the current segment, starts one for records from seq
+// onwards and deletes segments past the retention period.
+func (w *walWriter) rotate(seq uint64) error {
+	if w.file != nil {
+		err := w.file.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	path := filepath.Join(w.dir, fmt.Sprintf("%s%020d%s", walPrefix, seq, walSuffix))
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file, w.size, w.firstSeq = file, stat.Size(), seq
+
+	if w.opts.WALRetention > 0 {
+		return w.prune(clockOf(w.opts).Now().Add(-w.opts.WALRetention))
+	}
+	return nil
+}
+
+// prune deletes segments, other than the current one, last written before
+// cutoff.
+func (w *walWriter) prune(cutoff time.Time) error {
+	segments, err := walSegments(w.dir)
+	if err != nil {
+		return err
+	}
+	for _, path := range segments {
+		if path == w.file.Name() {
+			continue
+		}
+		stat, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if stat.ModTime().Before(cutoff) {
+			err = os.Remove(path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *walWriter) close() error {
+	err := w.file.Sync()
+	if err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// walSegments returns the paths of the segments in dir in log order.
+func walSegments(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, walPrefix) && strings.HasSuffix(name, walSuffix) {
+			segments = append(segments, filepath.Join(dir, name))
+		}
+	}
+	sort.Strings(segments)
+	return segments, nil
+}
+
+// replayWAL appends to w every record in the WAL at dir with a sequence
+// number above seq and a timestamp no later than until (or every one, if
+// until is zero), stopping at the first record past until. It returns an
+// error if the WAL does not start right after seq.
+func replayWAL(w io.Writer, dir string, seq uint64, until time.Time) error {
+	segments, err := walSegments(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read WAL: %w", err)
+	}
+
+	next := seq + 1
+	for _, path := range segments {
+		done, err := replaySegment(w, path, &next, until)
+		if err != nil {
+			return fmt.Errorf("WAL segment %s: %w", filepath.Base(path), err)
+		}
+		if done {
+			return nil
+		}
+	}
+	return nil
+}
+
+// replaySegment replays one segment, advancing *next past every record
+// written. It reports whether the replay has reached until.
+func replaySegment(w io.Writer, path string, next *uint64, until time.Time) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	for {
+		rec, err := readRecord(r)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// A torn record can only end a segment; it was never acknowledged
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if rec.seq < *next {
+			continue
+		}
+		if rec.seq > *next {
+			return false, fmt.Errorf("missing records %d to %d", *next, rec.seq-1)
+		}
+		if !until.IsZero() && rec.ts > until.UnixNano() {
+			return true, nil
+		}
+
+		_, err = w.Write(encodeRecord(rec.kind, rec.seq, rec.key, rec.value))
+		if err != nil {
+			return false, err
+		}
+		*next = rec.seq + 1
+	}
+}
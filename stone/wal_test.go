@@ -0,0 +1,113 @@
+package stone
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRestoreToTime(t *testing.T) {
+	dir := t.TempDir()
+	walDir := filepath.Join(dir, "wal")
+	store, err := NewStoreWithOptions(filepath.Join(dir, "test.db"), Options{
+		WALDir:         walDir,
+		WALSegmentSize: 64, // Rotate every couple of records
+	})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	store.Set([]byte("key1"), []byte("value1"))
+	store.Set([]byte("key2"), []byte("value2"))
+	backupPath := filepath.Join(dir, "backup.db")
+	err = store.Backup(backupPath, BackupOptions{Polished: true})
+	if err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+
+	store.Set([]byte("key3"), []byte("value3"))
+	time.Sleep(10 * time.Millisecond)
+	beforeOops := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	store.Delete([]byte("key1"))
+	store.Set([]byte("key4"), []byte("value4"))
+
+	// Compaction does not affect the WAL
+	err = store.Polish()
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	store.Close()
+
+	segments, _ := walSegments(walDir)
+	if len(segments) < 2 {
+		t.Errorf("expected the WAL to be split into segments, got %v", segments)
+	}
+
+	// Recover the state just before key1 was deleted
+	restoredPath := filepath.Join(dir, "restored.db")
+	err = RestoreToTime(backupPath, walDir, restoredPath, beforeOops)
+	if err != nil {
+		t.Fatalf("restore to time failed: %v", err)
+	}
+	restored, err := NewStore(restoredPath)
+	if err != nil {
+		t.Fatalf("failed to open restored store: %v", err)
+	}
+	for _, key := range []string{"key1", "key2", "key3"} {
+		_, err = restored.Get([]byte(key))
+		if err != nil {
+			t.Errorf("expected %s after restore: %v", key, err)
+		}
+	}
+	_, err = restored.Get([]byte("key4"))
+	if err == nil {
+		t.Error("expected key4 to be absent at the restore time")
+	}
+	if restored.Seq() != 3 {
+		t.Errorf("expected seq 3, got %d", restored.Seq())
+	}
+	restored.Close()
+
+	// Without a time the whole WAL is replayed
+	err = Restore(backupPath, restoredPath, RestoreOptions{WALDir: walDir})
+	if err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	restored, err = NewStore(restoredPath)
+	if err != nil {
+		t.Fatalf("failed to open restored store: %v", err)
+	}
+	defer restored.Close()
+	_, err = restored.Get([]byte("key1"))
+	if err == nil {
+		t.Error("expected key1 to be deleted after full replay")
+	}
+	value, err := restored.Get([]byte("key4"))
+	if err != nil || string(value) != "value4" {
+		t.Errorf("expected 'value4', got '%s' (%v)", value, err)
+	}
+}
+
+func TestRestoreToTimeGap(t *testing.T) {
+	dir := t.TempDir()
+	walDir := filepath.Join(dir, "wal")
+	store, err := NewStoreWithOptions(filepath.Join(dir, "test.db"), Options{WALDir: walDir, WALSegmentSize: 1})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	store.Set([]byte("key1"), []byte("value1"))
+	backupPath := filepath.Join(dir, "backup.db")
+	store.Backup(backupPath, BackupOptions{})
+	store.Set([]byte("key2"), []byte("value2"))
+	store.Set([]byte("key3"), []byte("value3"))
+	store.Close()
+
+	// Losing the segment holding seq 2 makes later records unusable
+	os.Remove(filepath.Join(walDir, "wal-00000000000000000002.log"))
+	err = RestoreToTime(backupPath, walDir, filepath.Join(dir, "restored.db"), time.Now())
+	if err == nil {
+		t.Error("expected error for missing WAL records, got nil")
+	}
+}
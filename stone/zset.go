@@ -0,0 +1,172 @@
+package stone
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Sorted sets are stored as two keys per member: one holding its score,
+// for lookups by member, and one named after the score and the member,
+// which sorts in score order, for ranges. Updating a score deletes the old
+// ranking key, which Polish then drops like any overwritten record.
+
+// ZMember is a member of a sorted set and its score.
+type ZMember struct {
+	Member []byte
+	Score  float64
+}
+
+// encodeScore encodes score so that the encodings sort like the scores.
+func encodeScore(score float64) []byte {
+	bits := math.Float64bits(score)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	return binary.BigEndian.AppendUint64(nil, bits)
+}
+
+// decodeScore reverses encodeScore.
+func decodeScore(buf []byte) float64 {
+	bits := binary.BigEndian.Uint64(buf)
+	if bits&(1<<63) != 0 {
+		bits &^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits)
+}
+
+// zRankKey returns the ranking key of member with score in sorted set name.
+func zRankKey(name, member []byte, score []byte) []byte {
+	return typedKey(kindZRank, name, append(append([]byte{}, score...), member...))
+}
+
+// ZAdd adds members to the sorted set stored under key, creating the set if
+// it doesn't exist, or updates their scores if they are in it already. It
+// returns how many members were added.
+func (s *Store) ZAdd(key []byte, members ...ZMember) (int, error) {
+	var added int
+	err := s.write(func() error {
+		for _, m := range members {
+			if math.IsNaN(m.Score) {
+				return fmt.Errorf("score of %q is NaN", m.Member)
+			}
+			scoreKey := typedKey(kindZSet, key, m.Member)
+			old, ok, err := s.find(string(scoreKey))
+			if err != nil {
+				return err
+			}
+			score := encodeScore(m.Score)
+			if ok && string(old) == string(score) {
+				continue
+			}
+			// The old ranking first, so a crash in between leaves the
+			// member out of ranges, rather than in them twice, until it is
+			// added again
+			if ok {
+				err = s.del(s.seq+1, zRankKey(key, m.Member, old))
+				if err != nil {
+					return err
+				}
+			} else {
+				added++
+			}
+			err = s.set(s.seq+1, scoreKey, score)
+			if err != nil {
+				return err
+			}
+			err = s.set(s.seq+1, zRankKey(key, m.Member, score), nil)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return added, err
+}
+
+// ZRem removes members from the sorted set stored under key and returns how
+// many of them were in it.
+func (s *Store) ZRem(key []byte, members ...[]byte) (int, error) {
+	var removed int
+	err := s.write(func() error {
+		for _, member := range members {
+			scoreKey := typedKey(kindZSet, key, member)
+			score, ok, err := s.find(string(scoreKey))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			err = s.del(s.seq+1, zRankKey(key, member, score))
+			if err != nil {
+				return err
+			}
+			err = s.del(s.seq+1, scoreKey)
+			if err != nil {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// zMembers returns the members of sorted set name in ascending order of
+// score, ties broken by member.
+func (s *Store) zMembers(name []byte) []ZMember {
+	prefix := typedKey(kindZRank, name, nil)
+	keys := s.sortedKeys(prefix)
+	members := make([]ZMember, len(keys))
+	for i, k := range keys {
+		k = k[len(prefix):]
+		members[i] = ZMember{Member: []byte(k[8:]), Score: decodeScore([]byte(k[:8]))}
+	}
+	return members
+}
+
+// ZRange returns the members of the sorted set stored under key ranked
+// from start to stop, both included, in ascending order of score, ties
+// broken by member. Negative ranks count from the end, as with LRange, and
+// a set that doesn't exist is empty.
+func (s *Store) ZRange(key []byte, start, stop int) ([]ZMember, error) {
+	members := s.zMembers(key)
+	n := len(members)
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	start, stop = max(start, 0), min(stop, n-1)
+	if start > stop {
+		return nil, nil
+	}
+	return members[start : stop+1], nil
+}
+
+// ZRank returns the rank of member in the sorted set stored under key, 0
+// for the member with the lowest score. It fails with ErrKeyNotFound if
+// the member isn't in the set.
+func (s *Store) ZRank(key, member []byte) (int, error) {
+	score, ok, err := s.find(string(typedKey(kindZSet, key, member)))
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, ErrKeyNotFound
+	}
+	rankKey := string(zRankKey(key, member, score))
+	keys := s.sortedKeys(typedKey(kindZRank, key, nil))
+	i := sort.SearchStrings(keys, rankKey)
+	if i == len(keys) || keys[i] != rankKey {
+		return 0, ErrKeyNotFound // Removed meanwhile
+	}
+	return i, nil
+}
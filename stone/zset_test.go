@@ -0,0 +1,90 @@
+package stone
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestSortedSet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	key := []byte("board")
+
+	n, err := store.ZAdd(key,
+		ZMember{[]byte("carol"), 12.5},
+		ZMember{[]byte("alice"), -3},
+		ZMember{[]byte("bob"), 12.5},
+		ZMember{[]byte("dave"), math.Inf(1)},
+	)
+	if err != nil || n != 4 {
+		t.Fatalf("expected 4 members added, got %d, %v", n, err)
+	}
+	// Updating a score moves the member
+	n, err = store.ZAdd(key, ZMember{[]byte("alice"), 20})
+	if err != nil || n != 0 {
+		t.Errorf("expected no member added, got %d, %v", n, err)
+	}
+	_, err = store.ZAdd(key, ZMember{[]byte("eve"), math.NaN()})
+	if err == nil {
+		t.Errorf("expected a NaN score to be rejected")
+	}
+
+	check := func(store *Store, want ...ZMember) {
+		t.Helper()
+		members, err := store.ZRange(key, 0, -1)
+		if err != nil || len(members) != len(want) {
+			t.Fatalf("expected %d members, got %v, %v", len(want), members, err)
+		}
+		for i, m := range members {
+			if string(m.Member) != string(want[i].Member) || m.Score != want[i].Score {
+				t.Errorf("expected member %d to be %s=%v, got %s=%v", i, want[i].Member, want[i].Score, m.Member, m.Score)
+			}
+			rank, err := store.ZRank(key, m.Member)
+			if err != nil || rank != i {
+				t.Errorf("expected %s to rank %d, got %d, %v", m.Member, i, rank, err)
+			}
+		}
+	}
+	check(store,
+		ZMember{[]byte("bob"), 12.5},
+		ZMember{[]byte("carol"), 12.5},
+		ZMember{[]byte("alice"), 20},
+		ZMember{[]byte("dave"), math.Inf(1)},
+	)
+	members, _ := store.ZRange(key, -2, 10)
+	if len(members) != 2 || string(members[0].Member) != "alice" {
+		t.Errorf("expected the top two, got %v", members)
+	}
+
+	n, err = store.ZRem(key, []byte("carol"), []byte("eve"))
+	if err != nil || n != 1 {
+		t.Errorf("expected 1 member removed, got %d, %v", n, err)
+	}
+	_, err = store.ZRank(key, []byte("carol"))
+	if err == nil {
+		t.Errorf("expected a removed member to have no rank")
+	}
+	err = store.Polish()
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	store.Close()
+
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	check(store,
+		ZMember{[]byte("bob"), 12.5},
+		ZMember{[]byte("alice"), 20},
+		ZMember{[]byte("dave"), math.Inf(1)},
+	)
+	if stats := store.Stats(); stats.Keys != 6 {
+		t.Errorf("expected two keys per member, got %d", stats.Keys)
+	}
+}
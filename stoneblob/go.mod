@@ -0,0 +1,24 @@
+module github.com/cryptrunner49/stonekv/stoneblob
+
+go 1.24
+
+require (
+	github.com/cryptrunner49/stonekv v0.0.0
+	gocloud.dev v0.42.0
+)
+
+require (
+	github.com/golang/groupcache v0.0.0-20241129210726-2c02b8208cf8 // indirect
+	github.com/googleapis/gax-go/v2 v2.14.2 // indirect
+	go.opencensus.io v0.24.0 // indirect
+	golang.org/x/net v0.41.0 // indirect
+	golang.org/x/sys v0.33.0 // indirect
+	golang.org/x/text v0.26.0 // indirect
+	golang.org/x/xerrors v0.0.0-20240903120638-7835f813f4da // indirect
+	google.golang.org/api v0.235.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20250603155806-513f23925822 // indirect
+	google.golang.org/grpc v1.73.0 // indirect
+	google.golang.org/protobuf v1.36.6 // indirect
+)
+
+replace github.com/cryptrunner49/stonekv => ../
@@ -0,0 +1,447 @@
+// Package stoneblob is a gocloud.dev/blob driver backed by a StoneKV store,
+// so applications written against the portable Go Cloud blob API can run
+// locally on StoneKV:
+//
+//	bucket, err := stoneblob.OpenBucket(store, nil)
+//	defer bucket.Close()
+//	err = bucket.WriteAll(ctx, "photos/cat.jpg", data, nil)
+//
+// Or with a URL, once the package is imported, opening the store at the
+// path and closing it with the bucket:
+//
+//	bucket, err := blob.OpenBucket(ctx, "stonekv:///var/lib/app/blobs.db")
+//
+// Every blob is a key, made of a prefix, "blob/" by default, and the key of
+// the blob, whose value holds its attributes and its content. Writers
+// buffer the blob in memory and set the key when they are closed, so
+// readers see either the whole previous blob or the whole new one. Signed
+// URLs aren't supported.
+//
+// It is a module of its own, so the stone package stays free of
+// dependencies for programs that don't use Go Cloud.
+package stoneblob
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/gcerrors"
+
+	"github.com/cryptrunner49/stonekv/stone"
+)
+
+// Scheme is the URL scheme stoneblob registers its URLOpener under with
+// blob.DefaultURLMux.
+const Scheme = "stonekv"
+
+func init() {
+	blob.DefaultURLMux().RegisterBucket(Scheme, &URLOpener{})
+}
+
+// URLOpener opens buckets from URLs like "stonekv:///path/to/store.db",
+// opening the store at the path, which the bucket closes when it is
+// closed. The prefix query parameter sets Options.Prefix.
+type URLOpener struct{}
+
+// OpenBucketURL opens the bucket of u.
+func (o *URLOpener) OpenBucketURL(ctx context.Context, u *url.URL) (*blob.Bucket, error) {
+	opts := &Options{}
+	for param, values := range u.Query() {
+		switch param {
+		case "prefix":
+			opts.Prefix = values[0]
+		default:
+			return nil, fmt.Errorf("open bucket %v: invalid query parameter %q", u, param)
+		}
+	}
+	store, err := stone.NewStore(u.Path)
+	if err != nil {
+		return nil, fmt.Errorf("open bucket %v: %w", u, err)
+	}
+	b := newBucket(store, opts)
+	b.closeStore = true
+	return blob.NewBucket(b), nil
+}
+
+// Options configures a bucket.
+type Options struct {
+	// Prefix is the prefix of the keys blobs are stored under, so a
+	// bucket can share a store with other data or other buckets.
+	// Defaults to "blob/".
+	Prefix string
+}
+
+// OpenBucket returns a bucket storing blobs in store. Closing the bucket
+// leaves store open. opts may be nil.
+func OpenBucket(store *stone.Store, opts *Options) (*blob.Bucket, error) {
+	return blob.NewBucket(newBucket(store, opts)), nil
+}
+
+var (
+	errNotFound    = errors.New("blob not found")
+	errExists      = errors.New("blob already exists")
+	errUnsupported = errors.New("not supported by stoneblob")
+)
+
+var _ driver.Bucket = (*bucket)(nil)
+
+// bucket is a driver.Bucket backed by a stone.Store.
+type bucket struct {
+	store      *stone.Store
+	prefix     string
+	closeStore bool // Whether Close closes store, for buckets opened by URL
+}
+
+func newBucket(store *stone.Store, opts *Options) *bucket {
+	b := &bucket{store: store, prefix: "blob/"}
+	if opts != nil && opts.Prefix != "" {
+		b.prefix = opts.Prefix
+	}
+	return b
+}
+
+// header holds the attributes of a blob, stored as JSON before its
+// content:
+//
+//	uvarint(len(header)) header content
+type header struct {
+	CacheControl       string            `json:"cache_control,omitempty"`
+	ContentDisposition string            `json:"content_disposition,omitempty"`
+	ContentEncoding    string            `json:"content_encoding,omitempty"`
+	ContentLanguage    string            `json:"content_language,omitempty"`
+	ContentType        string            `json:"content_type,omitempty"`
+	Metadata           map[string]string `json:"metadata,omitempty"`
+	CreateTime         time.Time         `json:"create_time"`
+	ModTime            time.Time         `json:"mod_time"`
+	MD5                []byte            `json:"md5,omitempty"`
+}
+
+func encodeBlob(h *header, content []byte) ([]byte, error) {
+	hdr, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	buf := binary.AppendUvarint(nil, uint64(len(hdr)))
+	buf = append(buf, hdr...)
+	return append(buf, content...), nil
+}
+
+func decodeBlob(value []byte) (*header, []byte, error) {
+	n, size := binary.Uvarint(value)
+	if size <= 0 || n > uint64(len(value)-size) {
+		return nil, nil, fmt.Errorf("invalid blob")
+	}
+	var h header
+	err := json.Unmarshal(value[size:size+int(n)], &h)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid blob: %w", err)
+	}
+	return &h, value[size+int(n):], nil
+}
+
+// read reads the blob stored under key.
+func (b *bucket) read(key string) (*header, []byte, error) {
+	value, err := b.store.Get([]byte(b.prefix + key))
+	if err != nil {
+		if errors.Is(err, stone.ErrKeyNotFound) {
+			return nil, nil, errNotFound
+		}
+		return nil, nil, err
+	}
+	return decodeBlob(value)
+}
+
+// etag returns the ETag of a blob, from its modification time.
+func etag(h *header) string {
+	return fmt.Sprintf("W/\"%x\"", h.ModTime.UnixNano())
+}
+
+// ErrorCode maps errors of the bucket to error codes.
+func (b *bucket) ErrorCode(err error) gcerrors.ErrorCode {
+	switch {
+	case errors.Is(err, errNotFound):
+		return gcerrors.NotFound
+	case errors.Is(err, errExists):
+		return gcerrors.FailedPrecondition
+	case errors.Is(err, errUnsupported):
+		return gcerrors.Unimplemented
+	}
+	return gcerrors.Unknown
+}
+
+// As exposes the *stone.Store of the bucket.
+func (b *bucket) As(i any) bool {
+	p, ok := i.(**stone.Store)
+	if ok {
+		*p = b.store
+	}
+	return ok
+}
+
+// ErrorAs doesn't expose any errors.
+func (b *bucket) ErrorAs(err error, i any) bool {
+	return false
+}
+
+// Attributes returns the attributes of the blob stored under key.
+func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+	h, content, err := b.read(key)
+	if err != nil {
+		return nil, err
+	}
+	return &driver.Attributes{
+		CacheControl:       h.CacheControl,
+		ContentDisposition: h.ContentDisposition,
+		ContentEncoding:    h.ContentEncoding,
+		ContentLanguage:    h.ContentLanguage,
+		ContentType:        h.ContentType,
+		Metadata:           h.Metadata,
+		CreateTime:         h.CreateTime,
+		ModTime:            h.ModTime,
+		Size:               int64(len(content)),
+		MD5:                h.MD5,
+		ETag:               etag(h),
+	}, nil
+}
+
+// ListPaged lists the blobs in key order, collapsing those sharing a
+// prefix up to the delimiter, if there is one, into directories.
+func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
+	if opts.BeforeList != nil {
+		err := opts.BeforeList(func(any) bool { return false })
+		if err != nil {
+			return nil, err
+		}
+	}
+	pageSize := opts.PageSize
+	if pageSize == 0 {
+		pageSize = 1000
+	}
+	after := string(opts.PageToken)
+	var objects []*driver.ListObject
+	stop := errors.New("page full")
+	var next string
+	err := b.store.Scan([]byte(b.prefix+opts.Prefix), func(k, value []byte) error {
+		key := string(k[len(b.prefix):])
+		if opts.Delimiter != "" {
+			if i := strings.Index(key[len(opts.Prefix):], opts.Delimiter); i >= 0 {
+				dir := key[:len(opts.Prefix)+i+len(opts.Delimiter)]
+				if dir <= after || (len(objects) > 0 && objects[len(objects)-1].Key == dir) {
+					return nil
+				}
+				if len(objects) == pageSize {
+					next = objects[len(objects)-1].Key
+					return stop
+				}
+				objects = append(objects, &driver.ListObject{Key: dir, IsDir: true})
+				return nil
+			}
+		}
+		if key <= after {
+			return nil
+		}
+		if len(objects) == pageSize {
+			next = objects[len(objects)-1].Key
+			return stop
+		}
+		h, content, err := decodeBlob(value)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, &driver.ListObject{
+			Key:     key,
+			ModTime: h.ModTime,
+			Size:    int64(len(content)),
+			MD5:     h.MD5,
+		})
+		return nil
+	})
+	if err != nil && err != stop {
+		return nil, err
+	}
+	page := &driver.ListPage{Objects: objects}
+	if next != "" {
+		page.NextPageToken = []byte(next)
+	}
+	return page, nil
+}
+
+// NewRangeReader returns a reader of length bytes of the blob stored under
+// key from offset, or to its end if length is negative.
+func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	if opts.BeforeRead != nil {
+		err := opts.BeforeRead(func(any) bool { return false })
+		if err != nil {
+			return nil, err
+		}
+	}
+	h, content, err := b.read(key)
+	if err != nil {
+		return nil, err
+	}
+	size := int64(len(content))
+	if offset > size {
+		offset = size
+	}
+	end := size
+	if length >= 0 && offset+length < size {
+		end = offset + length
+	}
+	return &reader{
+		r: bytes.NewReader(content[offset:end]),
+		attrs: &driver.ReaderAttributes{
+			ContentType: h.ContentType,
+			ModTime:     h.ModTime,
+			Size:        size,
+		},
+	}, nil
+}
+
+// reader reads a blob from memory.
+type reader struct {
+	r     *bytes.Reader
+	attrs *driver.ReaderAttributes
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	return r.r.Read(p)
+}
+
+func (r *reader) Close() error {
+	return nil
+}
+
+func (r *reader) Attributes() *driver.ReaderAttributes {
+	return r.attrs
+}
+
+func (r *reader) As(i any) bool {
+	return false
+}
+
+// NewTypedWriter returns a writer of the blob stored under key, which is
+// written when the writer is closed, unless ctx is done by then.
+func (b *bucket) NewTypedWriter(ctx context.Context, key, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	if opts.BeforeWrite != nil {
+		err := opts.BeforeWrite(func(any) bool { return false })
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &writer{
+		ctx:    ctx,
+		bucket: b,
+		key:    key,
+		header: header{
+			CacheControl:       opts.CacheControl,
+			ContentDisposition: opts.ContentDisposition,
+			ContentEncoding:    opts.ContentEncoding,
+			ContentLanguage:    opts.ContentLanguage,
+			ContentType:        contentType,
+			Metadata:           opts.Metadata,
+		},
+		md5:        opts.ContentMD5,
+		ifNotExist: opts.IfNotExist,
+	}, nil
+}
+
+// writer buffers a blob until it is closed.
+type writer struct {
+	ctx        context.Context
+	bucket     *bucket
+	key        string
+	header     header
+	md5        []byte // Expected, if set
+	ifNotExist bool
+	buf        bytes.Buffer
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+// Close writes the blob, unless the context of the writer is done.
+func (w *writer) Close() error {
+	err := w.ctx.Err()
+	if err != nil {
+		return err
+	}
+	sum := md5.Sum(w.buf.Bytes())
+	if len(w.md5) > 0 && !bytes.Equal(w.md5, sum[:]) {
+		return fmt.Errorf("content doesn't match the MD5 of the writer options")
+	}
+	w.header.MD5 = sum[:]
+	now := time.Now()
+	w.header.CreateTime, w.header.ModTime = now, now
+	old, _, err := w.bucket.read(w.key)
+	switch {
+	case err == nil && w.ifNotExist:
+		return errExists
+	case err == nil:
+		w.header.CreateTime = old.CreateTime
+	case !errors.Is(err, errNotFound):
+		return err
+	}
+	value, err := encodeBlob(&w.header, w.buf.Bytes())
+	if err != nil {
+		return err
+	}
+	return w.bucket.store.Set([]byte(w.bucket.prefix+w.key), value)
+}
+
+// Copy copies the blob stored under srcKey, with its attributes, to
+// dstKey.
+func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.CopyOptions) error {
+	if opts.BeforeCopy != nil {
+		err := opts.BeforeCopy(func(any) bool { return false })
+		if err != nil {
+			return err
+		}
+	}
+	h, content, err := b.read(srcKey)
+	if err != nil {
+		return err
+	}
+	h.ModTime = time.Now()
+	h.CreateTime = h.ModTime
+	value, err := encodeBlob(h, content)
+	if err != nil {
+		return err
+	}
+	return b.store.Set([]byte(b.prefix+dstKey), value)
+}
+
+// Delete deletes the blob stored under key.
+func (b *bucket) Delete(ctx context.Context, key string) error {
+	_, err := b.store.Get([]byte(b.prefix + key))
+	if err != nil {
+		if errors.Is(err, stone.ErrKeyNotFound) {
+			return errNotFound
+		}
+		return err
+	}
+	return b.store.Delete([]byte(b.prefix + key))
+}
+
+// SignedURL isn't supported: a store has no URLs to sign.
+func (b *bucket) SignedURL(ctx context.Context, key string, opts *driver.SignedURLOptions) (string, error) {
+	return "", errUnsupported
+}
+
+// Close closes the store, if the bucket was opened by URL.
+func (b *bucket) Close() error {
+	if b.closeStore {
+		return b.store.Close()
+	}
+	return nil
+}
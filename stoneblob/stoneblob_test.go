@@ -0,0 +1,124 @@
+package stoneblob
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/gcerrors"
+
+	"github.com/cryptrunner49/stonekv/stone"
+)
+
+func TestBucket(t *testing.T) {
+	store, err := stone.NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	b := newBucket(store, nil)
+	ctx := context.Background()
+	write := func(ctx context.Context, key, content string, opts *driver.WriterOptions) error {
+		t.Helper()
+		w, err := b.NewTypedWriter(ctx, key, "text/plain", opts)
+		if err != nil {
+			t.Fatalf("failed to create writer: %v", err)
+		}
+		w.Write([]byte(content))
+		return w.Close()
+	}
+	read := func(key string, offset, length int64) string {
+		t.Helper()
+		r, err := b.NewRangeReader(ctx, key, offset, length, &driver.ReaderOptions{})
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", key, err)
+		}
+		defer r.Close()
+		data, _ := io.ReadAll(r)
+		return string(data)
+	}
+
+	for _, key := range []string{"a/1", "a/2", "a0", "b"} {
+		err = write(ctx, key, "hello "+key, &driver.WriterOptions{Metadata: map[string]string{"k": key}})
+		if err != nil {
+			t.Fatalf("failed to write %s: %v", key, err)
+		}
+	}
+	if got := read("a0", 0, -1); got != "hello a0" {
+		t.Errorf("expected hello a0, got %q", got)
+	}
+	if got := read("a0", 2, 3); got != "llo" {
+		t.Errorf("expected llo, got %q", got)
+	}
+	attrs, err := b.Attributes(ctx, "a/1")
+	if err != nil || attrs.Size != 9 || attrs.ContentType != "text/plain" || attrs.Metadata["k"] != "a/1" || len(attrs.MD5) != 16 {
+		t.Errorf("expected the attributes of a/1, got %+v, %v", attrs, err)
+	}
+	_, err = b.Attributes(ctx, "missing")
+	if b.ErrorCode(err) != gcerrors.NotFound {
+		t.Errorf("expected a missing blob not to be found, got %v", err)
+	}
+
+	// Writes are abandoned if their context is done, and can require the
+	// blob not to exist
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if write(canceled, "c", "x", &driver.WriterOptions{}) == nil {
+		t.Errorf("expected a write with a canceled context to fail")
+	}
+	if err := write(ctx, "b", "x", &driver.WriterOptions{IfNotExist: true}); b.ErrorCode(err) != gcerrors.FailedPrecondition {
+		t.Errorf("expected overwriting b to fail, got %v", err)
+	}
+
+	list := func(opts *driver.ListOptions) []string {
+		t.Helper()
+		page, err := b.ListPaged(ctx, opts)
+		if err != nil {
+			t.Fatalf("list failed: %v", err)
+		}
+		var keys []string
+		for _, obj := range page.Objects {
+			keys = append(keys, obj.Key)
+		}
+		if page.NextPageToken != nil {
+			keys = append(keys, "next:"+string(page.NextPageToken))
+		}
+		return keys
+	}
+	for _, c := range []struct {
+		opts driver.ListOptions
+		want string
+	}{
+		{driver.ListOptions{}, "[a/1 a/2 a0 b]"},
+		{driver.ListOptions{Delimiter: "/"}, "[a/ a0 b]"},
+		{driver.ListOptions{Prefix: "a/"}, "[a/1 a/2]"},
+		{driver.ListOptions{PageSize: 2, Delimiter: "/"}, "[a/ a0 next:a0]"},
+		{driver.ListOptions{PageSize: 2, PageToken: []byte("a/1")}, "[a/2 a0 next:a0]"},
+	} {
+		if got := fmt.Sprint(list(&c.opts)); got != c.want {
+			t.Errorf("expected %+v to list %s, got %s", c.opts, c.want, got)
+		}
+	}
+
+	err = b.Copy(ctx, "c", "b", &driver.CopyOptions{})
+	if err != nil || read("c", 0, -1) != "hello b" {
+		t.Errorf("expected b copied to c, got %v", err)
+	}
+	err = b.Delete(ctx, "c")
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if err := b.Delete(ctx, "c"); b.ErrorCode(err) != gcerrors.NotFound {
+		t.Errorf("expected deleting a missing blob to fail, got %v", err)
+	}
+	if _, err := b.SignedURL(ctx, "b", nil); b.ErrorCode(err) != gcerrors.Unimplemented {
+		t.Errorf("expected signed URLs to be unimplemented, got %v", err)
+	}
+	var s *stone.Store
+	if !b.As(&s) || s != store {
+		t.Errorf("expected the bucket to expose its store")
+	}
+}
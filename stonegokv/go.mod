@@ -0,0 +1,12 @@
+module github.com/cryptrunner49/stonekv/stonegokv
+
+go 1.23.7
+
+require (
+	github.com/cryptrunner49/stonekv v0.0.0
+	github.com/philippgille/gokv v0.7.0
+	github.com/philippgille/gokv/encoding v0.7.0
+	github.com/philippgille/gokv/util v0.7.0
+)
+
+replace github.com/cryptrunner49/stonekv => ../
@@ -0,0 +1,98 @@
+// Package stonegokv implements the gokv.Store interface of
+// github.com/philippgille/gokv with a StoneKV store, so it plugs into
+// frameworks and libraries that accept a gokv backend:
+//
+//	var kv gokv.Store = stonegokv.New(store, stonegokv.DefaultOptions)
+//	err := kv.Set("user:1", user)
+//	found, err := kv.Get("user:1", &user)
+//
+// Values are marshaled with the codec of the options, JSON by default, and
+// stored under their key as is.
+//
+// It is a module of its own, so the stone package stays free of
+// dependencies for programs that don't use gokv.
+package stonegokv
+
+import (
+	"errors"
+
+	"github.com/philippgille/gokv"
+	"github.com/philippgille/gokv/encoding"
+	"github.com/philippgille/gokv/util"
+
+	"github.com/cryptrunner49/stonekv/stone"
+)
+
+var _ gokv.Store = Store{}
+
+// Options configures a Store.
+type Options struct {
+	// Codec marshals and unmarshals values. Defaults to encoding.JSON.
+	Codec encoding.Codec
+}
+
+// DefaultOptions is an Options value with the default values.
+var DefaultOptions = Options{
+	Codec: encoding.JSON,
+}
+
+// Store is a gokv.Store backed by a stone.Store.
+type Store struct {
+	store *stone.Store
+	codec encoding.Codec
+}
+
+// New returns a gokv.Store storing values in store. Closing it closes
+// store.
+func New(store *stone.Store, options Options) Store {
+	if options.Codec == nil {
+		options.Codec = DefaultOptions.Codec
+	}
+	return Store{store: store, codec: options.Codec}
+}
+
+// Set marshals v with the codec and stores it under k. Neither may be
+// empty or nil.
+func (s Store) Set(k string, v any) error {
+	err := util.CheckKeyAndValue(k, v)
+	if err != nil {
+		return err
+	}
+	data, err := s.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.store.Set([]byte(k), data)
+}
+
+// Get unmarshals the value stored under k into v, which must be a
+// pointer, and reports whether there was one. A missing key isn't an
+// error, and leaves v untouched.
+func (s Store) Get(k string, v any) (found bool, err error) {
+	err = util.CheckKeyAndValue(k, v)
+	if err != nil {
+		return false, err
+	}
+	data, err := s.store.Get([]byte(k))
+	if err != nil {
+		if errors.Is(err, stone.ErrKeyNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, s.codec.Unmarshal(data, v)
+}
+
+// Delete deletes the value stored under k, if any.
+func (s Store) Delete(k string) error {
+	err := util.CheckKey(k)
+	if err != nil {
+		return err
+	}
+	return s.store.Delete([]byte(k))
+}
+
+// Close closes the stone.Store.
+func (s Store) Close() error {
+	return s.store.Close()
+}
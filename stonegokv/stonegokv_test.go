@@ -0,0 +1,55 @@
+package stonegokv
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/philippgille/gokv/encoding"
+
+	"github.com/cryptrunner49/stonekv/stone"
+)
+
+type user struct {
+	Name string
+	Age  int
+}
+
+func TestStore(t *testing.T) {
+	for _, codec := range []encoding.Codec{nil, encoding.Gob} {
+		store, err := stone.NewStore(filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatalf("failed to create store: %v", err)
+		}
+		kv := New(store, Options{Codec: codec})
+
+		err = kv.Set("user:1", user{"ann", 42})
+		if err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+		var got user
+		found, err := kv.Get("user:1", &got)
+		if err != nil || !found || got != (user{"ann", 42}) {
+			t.Errorf("expected ann back, got %+v, %v, %v", got, found, err)
+		}
+		found, err = kv.Get("user:2", &got)
+		if err != nil || found {
+			t.Errorf("expected a missing key not to be found, got %v, %v", found, err)
+		}
+		if kv.Set("", got) == nil || kv.Set("user:3", nil) == nil {
+			t.Errorf("expected empty keys and nil values to be rejected")
+		}
+
+		err = kv.Delete("user:1")
+		if err != nil {
+			t.Fatalf("delete failed: %v", err)
+		}
+		found, _ = kv.Get("user:1", &got)
+		if found {
+			t.Errorf("expected a deleted key not to be found")
+		}
+		err = kv.Close()
+		if err != nil {
+			t.Errorf("close failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+// Package stonegrpc exposes a StoneKV store as a gRPC service, for typed,
+// low-overhead access from other services. The service is defined in
+// stonekvpb/stonekv.proto; Go clients use the generated
+// stonekvpb.NewStoneKVClient.
+//
+// Serve a store with:
+//
+//	srv := grpc.NewServer()
+//	stonekvpb.RegisterStoneKVServer(srv, stonegrpc.New(store))
+//	srv.Serve(ln)
+//
+// Setting Server.ACL limits the service to the users of a stoneserver.ACL,
+// who pass their token as "authorization: Bearer <token>" metadata.
+//
+// It is a module of its own, so the stone package stays free of
+// dependencies for programs that don't need gRPC.
+package stonegrpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/cryptrunner49/stonekv/stone"
+	pb "github.com/cryptrunner49/stonekv/stonegrpc/stonekvpb"
+	"github.com/cryptrunner49/stonekv/stoneserver"
+)
+
+// Server implements the StoneKV service for a store.
+type Server struct {
+	pb.UnimplementedStoneKVServer
+
+	// ACL, if set, limits the service to its users. Set it before serving
+	// calls.
+	ACL *stoneserver.ACL
+
+	store *stone.Store
+}
+
+// New returns a server for store. The store stays owned by the caller,
+// who must keep it open while the server is in use.
+func New(store *stone.Store) *Server {
+	return &Server{store: store}
+}
+
+// Get returns the value of a key.
+func (s *Server) Get(ctx context.Context, req *pb.GetRequest) (*pb.GetResponse, error) {
+	if len(req.Key) == 0 {
+		return nil, errEmptyKey
+	}
+	user, err := s.user(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed(user, req.Key, stoneserver.PermRead) {
+		return nil, errDenied
+	}
+	value, err := s.store.Get(req.Key)
+	if err != nil {
+		return nil, storeError(err)
+	}
+	return &pb.GetResponse{Value: value}, nil
+}
+
+// Set stores a value under a key.
+func (s *Server) Set(ctx context.Context, req *pb.SetRequest) (*pb.SetResponse, error) {
+	if len(req.Key) == 0 {
+		return nil, errEmptyKey
+	}
+	user, err := s.user(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed(user, req.Key, stoneserver.PermWrite) {
+		return nil, errDenied
+	}
+	err = s.store.Set(req.Key, req.Value)
+	if err != nil {
+		return nil, storeError(err)
+	}
+	return &pb.SetResponse{}, nil
+}
+
+// Delete removes a key.
+func (s *Server) Delete(ctx context.Context, req *pb.DeleteRequest) (*pb.DeleteResponse, error) {
+	if len(req.Key) == 0 {
+		return nil, errEmptyKey
+	}
+	user, err := s.user(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed(user, req.Key, stoneserver.PermWrite) {
+		return nil, errDenied
+	}
+	err = s.store.Delete(req.Key)
+	if err != nil {
+		return nil, storeError(err)
+	}
+	return &pb.DeleteResponse{}, nil
+}
+
+// Batch applies the operations of a batch in order. Invalid batches are
+// rejected before anything is applied; if an operation fails, the ones
+// before it stay applied and the error names the failed operation.
+func (s *Server) Batch(ctx context.Context, req *pb.BatchRequest) (*pb.BatchResponse, error) {
+	user, err := s.user(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i, op := range req.Ops {
+		var key []byte
+		perm := stoneserver.PermWrite
+		switch op := op.Op.(type) {
+		case *pb.Op_Get:
+			key = op.Get.GetKey()
+			perm = stoneserver.PermRead
+		case *pb.Op_Set:
+			key = op.Set.GetKey()
+		case *pb.Op_Delete:
+			key = op.Delete.GetKey()
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "op %d: no operation set", i)
+		}
+		if len(key) == 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "op %d: key must not be empty", i)
+		}
+		if !allowed(user, key, perm) {
+			return nil, status.Errorf(codes.PermissionDenied, "op %d: permission denied", i)
+		}
+	}
+
+	resp := &pb.BatchResponse{Results: make([]*pb.OpResult, len(req.Ops))}
+	for i, op := range req.Ops {
+		resp.Results[i] = &pb.OpResult{}
+		var err error
+		switch op := op.Op.(type) {
+		case *pb.Op_Get:
+			var value []byte
+			value, err = s.store.Get(op.Get.Key)
+			if err == nil {
+				resp.Results[i] = &pb.OpResult{Found: true, Value: value}
+			} else if isNotFound(err) {
+				err = nil
+			}
+		case *pb.Op_Set:
+			err = s.store.Set(op.Set.Key, op.Set.Value)
+		case *pb.Op_Delete:
+			err = s.store.Delete(op.Delete.Key)
+		}
+		if err != nil {
+			return nil, status.Errorf(statusCode(err), "op %d: %v", i, err)
+		}
+	}
+	return resp, nil
+}
+
+// errScanFull stops a scan once the limit is reached.
+var errScanFull = errors.New("scan limit reached")
+
+// Scan streams the live pairs whose key starts with the requested prefix.
+// Keys the caller may not read are skipped.
+func (s *Server) Scan(req *pb.ScanRequest, stream pb.StoneKV_ScanServer) error {
+	user, err := s.user(stream.Context())
+	if err != nil {
+		return err
+	}
+	var sent uint32
+	err = s.store.Scan(req.Prefix, func(key, value []byte) error {
+		if (len(req.After) > 0 && bytes.Compare(key, req.After) <= 0) || !allowed(user, key, stoneserver.PermRead) {
+			return nil
+		}
+		if req.Limit > 0 && sent == req.Limit {
+			return errScanFull
+		}
+		sent++
+		return stream.Send(&pb.KeyValue{Key: key, Value: value})
+	})
+	if err != nil && err != errScanFull {
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
+		return storeError(err)
+	}
+	return nil
+}
+
+// Watch streams the changes after the requested sequence number until the
+// client cancels the call. If the feed ends for another reason, e.g. the
+// store is closed or the client fell behind a compaction, the call fails
+// with UNAVAILABLE and the client must resume from the last sequence number
+// it received, or from 0 if that returns OUT_OF_RANGE. Changes to keys the
+// caller may not read are skipped.
+func (s *Server) Watch(req *pb.WatchRequest, stream pb.StoneKV_WatchServer) error {
+	ctx := stream.Context()
+	user, err := s.user(ctx)
+	if err != nil {
+		return err
+	}
+	changes, err := s.store.ChangesContext(ctx, req.SinceSeq)
+	if err != nil {
+		return status.Error(codes.OutOfRange, err.Error())
+	}
+	for rec := range changes {
+		if !bytes.HasPrefix(rec.Key, req.Prefix) || !allowed(user, rec.Key, stoneserver.PermRead) {
+			continue
+		}
+		change := &pb.Change{Seq: rec.Seq, Type: pb.ChangeType_CHANGE_TYPE_SET, Key: rec.Key, Value: rec.Value}
+		if rec.Type == stone.RecordDelete {
+			change.Type = pb.ChangeType_CHANGE_TYPE_DELETE
+		}
+		err = stream.Send(change)
+		if err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		return status.FromContextError(ctx.Err()).Err()
+	}
+	return status.Error(codes.Unavailable, "change feed ended")
+}
+
+// Publish sends a message to the subscribers of a channel, which needs write
+// permission on the channel name.
+func (s *Server) Publish(ctx context.Context, req *pb.PublishRequest) (*pb.PublishResponse, error) {
+	user, err := s.user(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed(user, req.Channel, stoneserver.PermWrite) {
+		return nil, errDenied
+	}
+	n, err := s.store.Publish(req.Channel, req.Message)
+	if err != nil {
+		return nil, storeError(err)
+	}
+	return &pb.PublishResponse{Receivers: uint32(n)}, nil
+}
+
+// Subscribe streams the messages published on the requested channels, which
+// need read permission on their names, until the client cancels the call.
+// If the subscription ends for another reason, e.g. the store is closed or
+// the client fell behind, the call fails with UNAVAILABLE.
+func (s *Server) Subscribe(req *pb.SubscribeRequest, stream pb.StoneKV_SubscribeServer) error {
+	ctx := stream.Context()
+	user, err := s.user(ctx)
+	if err != nil {
+		return err
+	}
+	if len(req.Channels) == 0 {
+		return status.Error(codes.InvalidArgument, "no channels to subscribe to")
+	}
+	for _, channel := range req.Channels {
+		if !allowed(user, channel, stoneserver.PermRead) {
+			return errDenied
+		}
+	}
+	messages, err := s.store.Subscribe(ctx, req.Channels...)
+	if err != nil {
+		return storeError(err)
+	}
+	for msg := range messages {
+		err = stream.Send(&pb.Message{Channel: msg.Channel, Payload: msg.Payload})
+		if err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		return status.FromContextError(ctx.Err()).Err()
+	}
+	return status.Error(codes.Unavailable, "subscription ended")
+}
+
+var (
+	errEmptyKey = status.Error(codes.InvalidArgument, "key must not be empty")
+	errDenied   = status.Error(codes.PermissionDenied, "permission denied")
+)
+
+// user authenticates the caller of ctx. The user is nil for a server
+// without an ACL.
+func (s *Server) user(ctx context.Context) (*stoneserver.User, error) {
+	if s.ACL == nil {
+		return nil, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, auth := range md.Get("authorization") {
+		token, ok := strings.CutPrefix(auth, "Bearer ")
+		if !ok {
+			continue
+		}
+		if user, ok := s.ACL.Authenticate(token); ok {
+			return user, nil
+		}
+	}
+	return nil, status.Error(codes.Unauthenticated, "authentication required")
+}
+
+// allowed reports whether user has perm on key. A nil user stands for a
+// caller of a server without an ACL, which may do anything.
+func allowed(user *stoneserver.User, key []byte, perm stoneserver.Permission) bool {
+	return user == nil || user.Allowed(key, perm)
+}
+
+// isNotFound reports whether err is the store's error for a missing key.
+func isNotFound(err error) bool {
+	return errors.Is(err, stone.ErrKeyNotFound)
+}
+
+func statusCode(err error) codes.Code {
+	switch {
+	case isNotFound(err):
+		return codes.NotFound
+	case errors.Is(err, stone.ErrClosed):
+		return codes.Unavailable
+	case errors.Is(err, stone.ErrReadOnly):
+		return codes.FailedPrecondition
+	case errors.Is(err, stone.ErrInvalidKey), errors.Is(err, stone.ErrValueTooLarge):
+		return codes.InvalidArgument
+	}
+	return codes.Internal
+}
+
+func storeError(err error) error {
+	return status.Error(statusCode(err), err.Error())
+}
@@ -0,0 +1,287 @@
+package stonegrpc
+
+import (
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/cryptrunner49/stonekv/stone"
+	pb "github.com/cryptrunner49/stonekv/stonegrpc/stonekvpb"
+	"github.com/cryptrunner49/stonekv/stoneserver"
+)
+
+func startServer(t *testing.T) (*stone.Store, pb.StoneKVClient) {
+	t.Helper()
+	store, err := stone.NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store, dial(t, New(store))
+}
+
+// dial serves srv over an in-memory connection and returns a client for it.
+func dial(t *testing.T, server *Server) pb.StoneKVClient {
+	t.Helper()
+	ln := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	pb.RegisterStoneKVServer(srv, server)
+	go srv.Serve(ln)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewStoneKVClient(conn)
+}
+
+func TestKeys(t *testing.T) {
+	store, client := startServer(t)
+	ctx := context.Background()
+
+	_, err := client.Set(ctx, &pb.SetRequest{Key: []byte("users/1"), Value: []byte("alice")})
+	if err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	value, err := store.Get([]byte("users/1"))
+	if err != nil || string(value) != "alice" {
+		t.Errorf("expected 'alice' in store, got %q (%v)", value, err)
+	}
+
+	resp, err := client.Get(ctx, &pb.GetRequest{Key: []byte("users/1")})
+	if err != nil || string(resp.GetValue()) != "alice" {
+		t.Errorf("expected 'alice', got %q (%v)", resp.GetValue(), err)
+	}
+
+	_, err = client.Delete(ctx, &pb.DeleteRequest{Key: []byte("users/1")})
+	if err != nil {
+		t.Errorf("Delete failed: %v", err)
+	}
+	_, err = client.Get(ctx, &pb.GetRequest{Key: []byte("users/1")})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound, got %v", err)
+	}
+
+	_, err = client.Set(ctx, &pb.SetRequest{Value: []byte("value")})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for empty key, got %v", err)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	store, client := startServer(t)
+	store.Set([]byte("old"), []byte("value"))
+
+	resp, err := client.Batch(context.Background(), &pb.BatchRequest{Ops: []*pb.Op{
+		{Op: &pb.Op_Set{Set: &pb.SetRequest{Key: []byte("new"), Value: []byte("value")}}},
+		{Op: &pb.Op_Delete{Delete: &pb.DeleteRequest{Key: []byte("old")}}},
+		{Op: &pb.Op_Get{Get: &pb.GetRequest{Key: []byte("new")}}},
+		{Op: &pb.Op_Get{Get: &pb.GetRequest{Key: []byte("old")}}},
+	}})
+	if err != nil {
+		t.Fatalf("Batch failed: %v", err)
+	}
+	results := resp.GetResults()
+	if len(results) != 4 || !results[2].Found || string(results[2].Value) != "value" || results[3].Found {
+		t.Errorf("unexpected results %v", results)
+	}
+
+	// Invalid batches are rejected before anything is applied
+	_, err = client.Batch(context.Background(), &pb.BatchRequest{Ops: []*pb.Op{
+		{Op: &pb.Op_Set{Set: &pb.SetRequest{Key: []byte("x")}}},
+		{},
+	}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for empty op, got %v", err)
+	}
+	if _, err = store.Get([]byte("x")); err == nil {
+		t.Error("expected invalid batch not to be applied")
+	}
+}
+
+func TestScan(t *testing.T) {
+	store, client := startServer(t)
+	for _, key := range []string{"a/1", "a/2", "a/3", "b/1"} {
+		store.Set([]byte(key), []byte("v"+key))
+	}
+
+	scan := func(req *pb.ScanRequest) []string {
+		t.Helper()
+		stream, err := client.Scan(context.Background(), req)
+		if err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		var keys []string
+		for {
+			kv, err := stream.Recv()
+			if err == io.EOF {
+				return keys
+			}
+			if err != nil {
+				t.Fatalf("Scan failed: %v", err)
+			}
+			if string(kv.Value) != "v"+string(kv.Key) {
+				t.Errorf("unexpected value %q for %s", kv.Value, kv.Key)
+			}
+			keys = append(keys, string(kv.Key))
+		}
+	}
+
+	if keys := scan(&pb.ScanRequest{Prefix: []byte("a/")}); strings.Join(keys, ",") != "a/1,a/2,a/3" {
+		t.Errorf("unexpected keys %v", keys)
+	}
+	if keys := scan(&pb.ScanRequest{After: []byte("a/1"), Limit: 2}); strings.Join(keys, ",") != "a/2,a/3" {
+		t.Errorf("unexpected keys with after and limit %v", keys)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	store, client := startServer(t)
+	store.Set([]byte("a/1"), []byte("one"))
+	store.Set([]byte("b/1"), []byte("skipped"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.Watch(ctx, &pb.WatchRequest{Prefix: []byte("a/")})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+
+	store.Delete([]byte("a/1"))
+	want := []struct {
+		kind pb.ChangeType
+		key  string
+	}{
+		{pb.ChangeType_CHANGE_TYPE_SET, "a/1"},
+		{pb.ChangeType_CHANGE_TYPE_DELETE, "a/1"},
+	}
+	for i, w := range want {
+		change, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Recv %d failed: %v", i, err)
+		}
+		if change.Type != w.kind || string(change.Key) != w.key {
+			t.Errorf("change %d: expected %v %s, got %v %s", i, w.kind, w.key, change.Type, change.Key)
+		}
+	}
+
+	// Resuming from before the last compaction is rejected
+	store.Set([]byte("a/2"), []byte("two"))
+	store.Polish()
+	store.Set([]byte("a/3"), []byte("three"))
+	stream, err = client.Watch(ctx, &pb.WatchRequest{SinceSeq: 1})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.OutOfRange {
+		t.Errorf("expected OutOfRange, got %v", err)
+	}
+}
+
+func TestPubSub(t *testing.T) {
+	store, client := startServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.Subscribe(ctx, &pb.SubscribeRequest{Channels: [][]byte{[]byte("news")}})
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	// The call returns before the server subscribes
+	for {
+		resp, err := client.Publish(ctx, &pb.PublishRequest{Channel: []byte("news"), Message: []byte("hello")})
+		if err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+		if resp.Receivers == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	msg, err := stream.Recv()
+	if err != nil || string(msg.Channel) != "news" || string(msg.Payload) != "hello" {
+		t.Fatalf("expected hello on news, got %v, %v", msg, err)
+	}
+
+	store.Close()
+	for err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("expected Unavailable once the store is closed, got %v", err)
+	}
+}
+
+func TestACL(t *testing.T) {
+	store, _ := startServer(t)
+	acl, err := stoneserver.NewACL([]stoneserver.User{{
+		Name:   "billing",
+		Token:  "b-token",
+		Grants: []stoneserver.Grant{{Prefix: "billing/", Perm: stoneserver.PermReadWrite}},
+	}})
+	if err != nil {
+		t.Fatalf("NewACL failed: %v", err)
+	}
+	server := New(store)
+	server.ACL = acl
+	client := dial(t, server)
+	store.Set([]byte("users/1"), []byte("alice"))
+
+	_, err = client.Get(context.Background(), &pb.GetRequest{Key: []byte("users/1")})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated without a token, got %v", err)
+	}
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer b-token")
+	_, err = client.Set(ctx, &pb.SetRequest{Key: []byte("billing/1"), Value: []byte("invoice")})
+	if err != nil {
+		t.Errorf("expected Set within the grant to succeed, got %v", err)
+	}
+	_, err = client.Get(ctx, &pb.GetRequest{Key: []byte("users/1")})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied outside the grant, got %v", err)
+	}
+
+	stream, err := client.Scan(ctx, &pb.ScanRequest{})
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	var keys []string
+	for {
+		kv, err := stream.Recv()
+		if err != nil {
+			break
+		}
+		keys = append(keys, string(kv.Key))
+	}
+	if strings.Join(keys, ",") != "billing/1" {
+		t.Errorf("expected scan to skip unreadable keys, got %v", keys)
+	}
+
+	_, err = client.Publish(ctx, &pb.PublishRequest{Channel: []byte("users/news")})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied publishing outside the grant, got %v", err)
+	}
+	sub, err := client.Subscribe(ctx, &pb.SubscribeRequest{Channels: [][]byte{[]byte("billing/news"), []byte("users/news")}})
+	if err == nil {
+		_, err = sub.Recv()
+	}
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied subscribing outside the grant, got %v", err)
+	}
+}
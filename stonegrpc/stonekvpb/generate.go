@@ -0,0 +1,5 @@
+// Package stonekvpb holds the protobuf messages and gRPC client and server
+// stubs generated from stonekv.proto.
+package stonekvpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative stonekv.proto
@@ -0,0 +1,135 @@
+// Package stoneotel traces the operations of a StoneKV store with
+// OpenTelemetry, so its I/O shows up in distributed traces. Wrap a store
+// and call it with the context of the request:
+//
+//	traced := stoneotel.New(store)
+//	value, err := traced.Get(ctx, key)
+//
+// Each Get, Set, Delete, Polish and Backup is a client span named after
+// the operation, a child of the span in ctx, with the sizes of the key and
+// value as attributes and the error it failed with, if any; a Get of a
+// missing key isn't an error, but has stonekv.found set to false. The span
+// starts and ends with the call, so it records its duration.
+//
+// It is a module of its own, so the stone package stays free of
+// dependencies for programs that don't trace.
+package stoneotel
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/cryptrunner49/stonekv/stone"
+)
+
+// instrumentation is the name of the tracer spans are started with.
+const instrumentation = "github.com/cryptrunner49/stonekv/stoneotel"
+
+// Store is a store whose Get, Set, Delete, Polish and Backup are traced.
+// Its other methods are those of the stone.Store it wraps, untraced.
+type Store struct {
+	*stone.Store
+
+	tracer trace.Tracer
+}
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithTracerProvider sets the provider of the tracer spans are started
+// with. Defaults to the global provider, otel.GetTracerProvider.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(s *Store) {
+		s.tracer = tp.Tracer(instrumentation)
+	}
+}
+
+// New returns store traced.
+func New(store *stone.Store, opts ...Option) *Store {
+	s := &Store{Store: store, tracer: otel.GetTracerProvider().Tracer(instrumentation)}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Get calls Get on the store in a span.
+func (s *Store) Get(ctx context.Context, key []byte) ([]byte, error) {
+	span := s.start(ctx, "get", attribute.Int("stonekv.key.size", len(key)))
+	value, err := s.Store.Get(key)
+	switch {
+	case err == nil:
+		span.SetAttributes(attribute.Bool("stonekv.found", true), attribute.Int("stonekv.value.size", len(value)))
+		end(span, nil)
+	case isNotFound(err):
+		// A miss is an answer, not a failure
+		span.SetAttributes(attribute.Bool("stonekv.found", false))
+		end(span, nil)
+	default:
+		end(span, err)
+	}
+	return value, err
+}
+
+// Set calls Set on the store in a span.
+func (s *Store) Set(ctx context.Context, key, value []byte) error {
+	span := s.start(ctx, "set",
+		attribute.Int("stonekv.key.size", len(key)),
+		attribute.Int("stonekv.value.size", len(value)))
+	err := s.Store.Set(key, value)
+	end(span, err)
+	return err
+}
+
+// Delete calls Delete on the store in a span.
+func (s *Store) Delete(ctx context.Context, key []byte) error {
+	span := s.start(ctx, "delete", attribute.Int("stonekv.key.size", len(key)))
+	err := s.Store.Delete(key)
+	end(span, err)
+	return err
+}
+
+// Polish calls Polish on the store in a span.
+func (s *Store) Polish(ctx context.Context) error {
+	span := s.start(ctx, "polish")
+	err := s.Store.Polish()
+	end(span, err)
+	return err
+}
+
+// Backup calls Backup on the store in a span.
+func (s *Store) Backup(ctx context.Context, path string, opts stone.BackupOptions) error {
+	span := s.start(ctx, "backup")
+	err := s.Store.Backup(path, opts)
+	end(span, err)
+	return err
+}
+
+// start starts the span of an operation.
+func (s *Store) start(ctx context.Context, op string, attrs ...attribute.KeyValue) trace.Span {
+	attrs = append(attrs,
+		attribute.String("db.system.name", "stonekv"),
+		attribute.String("db.operation.name", op))
+	_, span := s.tracer.Start(ctx, "stonekv."+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...))
+	return span
+}
+
+func isNotFound(err error) bool {
+	return errors.Is(err, stone.ErrKeyNotFound)
+}
+
+// end ends the span of an operation that failed with err, if not nil.
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,80 @@
+package stoneotel
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/cryptrunner49/stonekv/stone"
+)
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := stone.NewStore(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	traced := New(store, WithTracerProvider(tp))
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "request")
+	if err := traced.Set(ctx, []byte("greeting"), []byte("hello")); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if value, err := traced.Get(ctx, []byte("greeting")); err != nil || string(value) != "hello" {
+		t.Fatalf("expected hello, got %q, %v", value, err)
+	}
+	if _, err := traced.Get(ctx, []byte("missing")); err == nil {
+		t.Fatalf("expected missing key to fail")
+	}
+	if err := traced.Delete(ctx, []byte("greeting")); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if err := traced.Polish(ctx); err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	if err := traced.Backup(ctx, dir, stone.BackupOptions{}); err == nil {
+		t.Fatalf("expected backup over a directory to fail")
+	}
+	parent.End()
+
+	spans := exporter.GetSpans()
+	want := []struct {
+		name  string
+		attrs []attribute.KeyValue
+		code  codes.Code
+	}{
+		{"stonekv.set", []attribute.KeyValue{attribute.Int("stonekv.key.size", 8), attribute.Int("stonekv.value.size", 5)}, codes.Unset},
+		{"stonekv.get", []attribute.KeyValue{attribute.Bool("stonekv.found", true), attribute.Int("stonekv.value.size", 5)}, codes.Unset},
+		{"stonekv.get", []attribute.KeyValue{attribute.Bool("stonekv.found", false)}, codes.Unset},
+		{"stonekv.delete", []attribute.KeyValue{attribute.String("db.operation.name", "delete")}, codes.Unset},
+		{"stonekv.polish", []attribute.KeyValue{attribute.String("db.system.name", "stonekv")}, codes.Unset},
+		{"stonekv.backup", nil, codes.Error},
+		{"request", nil, codes.Unset},
+	}
+	if len(spans) != len(want) {
+		t.Fatalf("expected %d spans, got %d", len(want), len(spans))
+	}
+	for i, w := range want {
+		span := spans[i]
+		if span.Name != w.name || span.Status.Code != w.code {
+			t.Errorf("expected span %d to be %s with status %v, got %s with %v", i, w.name, w.code, span.Name, span.Status)
+		}
+		if w.name != "request" && span.Parent.SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("expected %s to be a child of the request span", span.Name)
+		}
+		attrs := attribute.NewSet(span.Attributes...)
+		for _, kv := range w.attrs {
+			if v, ok := attrs.Value(kv.Key); !ok || v != kv.Value {
+				t.Errorf("expected %s to have %s=%v, got %v", span.Name, kv.Key, kv.Value.Emit(), v.Emit())
+			}
+		}
+	}
+}
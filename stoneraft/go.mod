@@ -0,0 +1,22 @@
+module github.com/cryptrunner49/stonekv/stoneraft
+
+go 1.23.7
+
+require (
+	github.com/cryptrunner49/stonekv v0.0.0
+	github.com/hashicorp/raft v1.7.1
+)
+
+require (
+	github.com/armon/go-metrics v0.4.1 // indirect
+	github.com/fatih/color v1.13.0 // indirect
+	github.com/hashicorp/go-hclog v1.6.2 // indirect
+	github.com/hashicorp/go-immutable-radix v1.0.0 // indirect
+	github.com/hashicorp/go-msgpack/v2 v2.1.2 // indirect
+	github.com/hashicorp/golang-lru v0.5.0 // indirect
+	github.com/mattn/go-colorable v0.1.12 // indirect
+	github.com/mattn/go-isatty v0.0.14 // indirect
+	golang.org/x/sys v0.13.0 // indirect
+)
+
+replace github.com/cryptrunner49/stonekv => ../
@@ -0,0 +1,267 @@
+// Package stoneraft stores the log and state of hashicorp/raft in a
+// StoneKV store, so Raft-based applications can use it as their durable
+// storage layer:
+//
+//	s, err := stoneraft.New(store)
+//	r, err := raft.NewRaft(config, fsm, s, s, snapshots, transport)
+//
+// Store implements raft.LogStore and raft.StableStore. Log entries are
+// stored under keys made of a prefix, "raft/log/" by default, and their
+// big-endian index, and the stable state under "raft/stable/" and its key.
+// Raft requires them to be durable once stored, so StoreLogs and Set
+// return after a Sync of the store.
+//
+// It is a module of its own, so the stone package stays free of
+// dependencies for programs that don't use Raft.
+package stoneraft
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/cryptrunner49/stonekv/stone"
+)
+
+var (
+	_ raft.LogStore    = (*Store)(nil)
+	_ raft.StableStore = (*Store)(nil)
+)
+
+// ErrKeyNotFound is returned by Get and GetUint64 for keys that were never
+// set. Raft recognizes it by its message, like that of raft-boltdb.
+var ErrKeyNotFound = errors.New("not found")
+
+// Store is a raft.LogStore and raft.StableStore backed by a stone.Store.
+type Store struct {
+	store  *stone.Store
+	prefix string
+
+	mu          sync.Mutex // Serializes changes to the log and guards the indexes
+	first, last uint64     // Indexes of the first and last entries, 0 if none
+}
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithPrefix sets the prefix of the keys the log and stable state are
+// stored under, so several Raft groups, or other data, can share a store.
+// Defaults to "raft/".
+func WithPrefix(prefix string) Option {
+	return func(s *Store) {
+		s.prefix = prefix
+	}
+}
+
+// New returns a Store storing Raft data in store. It reads the keys of the
+// log once to find its first and last entries.
+func New(store *stone.Store, opts ...Option) (*Store, error) {
+	s := &Store{store: store, prefix: "raft/"}
+	for _, opt := range opts {
+		opt(s)
+	}
+	prefix := []byte(s.prefix + "log/")
+	err := store.Scan(prefix, func(key, _ []byte) error {
+		if len(key) != len(prefix)+8 {
+			return nil
+		}
+		index := binary.BigEndian.Uint64(key[len(prefix):])
+		if s.first == 0 {
+			s.first = index
+		}
+		s.last = index
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read log: %w", err)
+	}
+	return s, nil
+}
+
+// logKey returns the key of the log entry at index.
+func (s *Store) logKey(index uint64) []byte {
+	return binary.BigEndian.AppendUint64([]byte(s.prefix+"log/"), index)
+}
+
+// stableKey returns the key of key of the stable state.
+func (s *Store) stableKey(key []byte) []byte {
+	return append([]byte(s.prefix+"stable/"), key...)
+}
+
+// encodeLog encodes a log entry as
+//
+//	[index:8][term:8][type:1][appendedAt:8] uvarint(len(data)) data extensions
+//
+// where appendedAt is in Unix nanoseconds, 0 for the zero time.
+func encodeLog(log *raft.Log) []byte {
+	buf := make([]byte, 0, 25+binary.MaxVarintLen64+len(log.Data)+len(log.Extensions))
+	buf = binary.BigEndian.AppendUint64(buf, log.Index)
+	buf = binary.BigEndian.AppendUint64(buf, log.Term)
+	buf = append(buf, byte(log.Type))
+	var appendedAt int64
+	if !log.AppendedAt.IsZero() {
+		appendedAt = log.AppendedAt.UnixNano()
+	}
+	buf = binary.BigEndian.AppendUint64(buf, uint64(appendedAt))
+	buf = binary.AppendUvarint(buf, uint64(len(log.Data)))
+	buf = append(buf, log.Data...)
+	return append(buf, log.Extensions...)
+}
+
+// decodeLog reverses encodeLog.
+func decodeLog(buf []byte, log *raft.Log) error {
+	if len(buf) < 25 {
+		return fmt.Errorf("invalid log entry")
+	}
+	*log = raft.Log{
+		Index: binary.BigEndian.Uint64(buf),
+		Term:  binary.BigEndian.Uint64(buf[8:]),
+		Type:  raft.LogType(buf[16]),
+	}
+	if appendedAt := int64(binary.BigEndian.Uint64(buf[17:])); appendedAt != 0 {
+		log.AppendedAt = time.Unix(0, appendedAt)
+	}
+	n, size := binary.Uvarint(buf[25:])
+	if size <= 0 || n > uint64(len(buf)-25-size) {
+		return fmt.Errorf("invalid log entry")
+	}
+	data := buf[25+size:]
+	if n > 0 {
+		log.Data = data[:n]
+	}
+	if len(data) > int(n) {
+		log.Extensions = data[n:]
+	}
+	return nil
+}
+
+// FirstIndex returns the index of the first entry of the log, 0 if it is
+// empty.
+func (s *Store) FirstIndex() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.first, nil
+}
+
+// LastIndex returns the index of the last entry of the log, 0 if it is
+// empty.
+func (s *Store) LastIndex() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last, nil
+}
+
+// GetLog reads the log entry at index into log. It fails with
+// raft.ErrLogNotFound if there is none.
+func (s *Store) GetLog(index uint64, log *raft.Log) error {
+	buf, err := s.store.Get(s.logKey(index))
+	if err != nil {
+		if errors.Is(err, stone.ErrKeyNotFound) {
+			return raft.ErrLogNotFound
+		}
+		return err
+	}
+	return decodeLog(buf, log)
+}
+
+// StoreLog stores a log entry.
+func (s *Store) StoreLog(log *raft.Log) error {
+	return s.StoreLogs([]*raft.Log{log})
+}
+
+// StoreLogs stores log entries in one transaction, so either all of them
+// are stored or none are, and returns once they are synced to disk.
+func (s *Store) StoreLogs(logs []*raft.Log) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx := s.store.Begin()
+	defer tx.Rollback()
+	for _, log := range logs {
+		err := tx.Set(s.logKey(log.Index), encodeLog(log))
+		if err != nil {
+			return err
+		}
+	}
+	err := tx.Commit()
+	if err != nil {
+		return err
+	}
+	for _, log := range logs {
+		if s.first == 0 || log.Index < s.first {
+			s.first = log.Index
+		}
+		s.last = max(s.last, log.Index)
+	}
+	return s.store.Sync()
+}
+
+// DeleteRange deletes the log entries from index lo to hi, both included,
+// with a single record. Raft only deletes entries from the start or the end
+// of the log.
+func (s *Store) DeleteRange(lo, hi uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	from, to := max(lo, s.first), min(hi, s.last)
+	if s.first == 0 || from > to {
+		return nil
+	}
+	keys := make([][]byte, 0, to-from+1)
+	for index := from; index <= to && index >= from; index++ {
+		keys = append(keys, s.logKey(index))
+	}
+	err := s.store.DeleteMulti(keys)
+	if err != nil {
+		return err
+	}
+	switch {
+	case from == s.first && to == s.last:
+		s.first, s.last = 0, 0
+	case from == s.first:
+		s.first = to + 1
+	case to == s.last:
+		s.last = from - 1
+	}
+	return nil
+}
+
+// Set stores val under key in the stable state, and returns once it is
+// synced to disk.
+func (s *Store) Set(key, val []byte) error {
+	err := s.store.Set(s.stableKey(key), val)
+	if err != nil {
+		return err
+	}
+	return s.store.Sync()
+}
+
+// Get returns the value of key in the stable state. It fails with
+// ErrKeyNotFound if key was never set.
+func (s *Store) Get(key []byte) ([]byte, error) {
+	val, err := s.store.Get(s.stableKey(key))
+	if err != nil && errors.Is(err, stone.ErrKeyNotFound) {
+		return nil, ErrKeyNotFound
+	}
+	return val, err
+}
+
+// SetUint64 stores val under key in the stable state.
+func (s *Store) SetUint64(key []byte, val uint64) error {
+	return s.Set(key, binary.BigEndian.AppendUint64(nil, val))
+}
+
+// GetUint64 returns the value of key in the stable state, as stored by
+// SetUint64. It fails with ErrKeyNotFound if key was never set.
+func (s *Store) GetUint64(key []byte) (uint64, error) {
+	val, err := s.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	if len(val) != 8 {
+		return 0, fmt.Errorf("value of %q is not a uint64", key)
+	}
+	return binary.BigEndian.Uint64(val), nil
+}
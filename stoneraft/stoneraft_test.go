@@ -0,0 +1,103 @@
+package stoneraft
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/cryptrunner49/stonekv/stone"
+)
+
+func TestLogStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := stone.NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	s, err := New(store)
+	if err != nil {
+		t.Fatalf("failed to create raft store: %v", err)
+	}
+	checkIndexes := func(s *Store, first, last uint64) {
+		t.Helper()
+		gotFirst, _ := s.FirstIndex()
+		gotLast, _ := s.LastIndex()
+		if gotFirst != first || gotLast != last {
+			t.Errorf("expected indexes %d to %d, got %d to %d", first, last, gotFirst, gotLast)
+		}
+	}
+	checkIndexes(s, 0, 0)
+
+	now := time.Now()
+	var logs []*raft.Log
+	for i := uint64(1); i <= 5; i++ {
+		logs = append(logs, &raft.Log{Index: i, Term: 2, Type: raft.LogCommand, Data: []byte{byte(i)}, AppendedAt: now})
+	}
+	logs[2].Extensions = []byte("ext")
+	err = s.StoreLogs(logs)
+	if err != nil {
+		t.Fatalf("failed to store logs: %v", err)
+	}
+	checkIndexes(s, 1, 5)
+	var log raft.Log
+	err = s.GetLog(3, &log)
+	if err != nil || log.Index != 3 || log.Term != 2 || log.Type != raft.LogCommand ||
+		string(log.Data) != "\x03" || string(log.Extensions) != "ext" || !log.AppendedAt.Equal(now) {
+		t.Errorf("expected log 3 back, got %+v, %v", log, err)
+	}
+
+	// Raft compacts the start of the log and truncates conflicting ends
+	s.DeleteRange(0, 2)
+	s.DeleteRange(5, 9)
+	checkIndexes(s, 3, 4)
+	if err := s.GetLog(2, &log); !errors.Is(err, raft.ErrLogNotFound) {
+		t.Errorf("expected a deleted log to be missing, got %v", err)
+	}
+	store.Close()
+
+	store, err = stone.NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	s, err = New(store)
+	if err != nil {
+		t.Fatalf("failed to create raft store: %v", err)
+	}
+	checkIndexes(s, 3, 4)
+	s.DeleteRange(3, 4)
+	checkIndexes(s, 0, 0)
+}
+
+func TestStableStore(t *testing.T) {
+	store, err := stone.NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	s, err := New(store, WithPrefix("group1/"))
+	if err != nil {
+		t.Fatalf("failed to create raft store: %v", err)
+	}
+
+	if _, err := s.Get([]byte("LastVoteCand")); err == nil || err.Error() != "not found" {
+		t.Errorf("expected a missing key to be not found, got %v", err)
+	}
+	if _, err := s.GetUint64([]byte("CurrentTerm")); err == nil || err.Error() != "not found" {
+		t.Errorf("expected a missing key to be not found, got %v", err)
+	}
+	s.Set([]byte("LastVoteCand"), []byte("node2"))
+	s.SetUint64([]byte("CurrentTerm"), 7)
+	if val, err := s.Get([]byte("LastVoteCand")); err != nil || string(val) != "node2" {
+		t.Errorf("expected node2, got %q, %v", val, err)
+	}
+	if val, err := s.GetUint64([]byte("CurrentTerm")); err != nil || val != 7 {
+		t.Errorf("expected 7, got %d, %v", val, err)
+	}
+	if _, err := store.Get([]byte("group1/stable/CurrentTerm")); err != nil {
+		t.Errorf("expected the key under the prefix, got %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+package stoneserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Permission is a set of rights on keys.
+type Permission int
+
+const (
+	PermRead Permission = 1 << iota
+	PermWrite
+
+	PermReadWrite = PermRead | PermWrite
+)
+
+// String returns the permission as written in ACL files: "r", "w" or "rw".
+func (p Permission) String() string {
+	var s string
+	if p&PermRead != 0 {
+		s += "r"
+	}
+	if p&PermWrite != 0 {
+		s += "w"
+	}
+	return s
+}
+
+// MarshalText encodes the permission as returned by String.
+func (p Permission) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText decodes a permission written as "r", "w" or "rw".
+func (p *Permission) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "r":
+		*p = PermRead
+	case "w":
+		*p = PermWrite
+	case "rw":
+		*p = PermReadWrite
+	default:
+		return fmt.Errorf("invalid permission %q, expected r, w or rw", text)
+	}
+	return nil
+}
+
+// Grant gives a permission on every key starting with Prefix. An empty
+// prefix grants it on the whole store.
+type Grant struct {
+	Prefix string     `json:"prefix"`
+	Perm   Permission `json:"perm"`
+}
+
+// User is a client of a server, identified by its token. Clients pass the
+// token as a bearer token or password, depending on the protocol. Admin
+// users may also use the admin endpoints of the HTTP server, whatever their
+// grants.
+type User struct {
+	Name   string  `json:"name"`
+	Token  string  `json:"token"`
+	Grants []Grant `json:"grants"`
+	Admin  bool    `json:"admin,omitempty"`
+}
+
+// Allowed reports whether u has perm on key, through one or more grants.
+func (u *User) Allowed(key []byte, perm Permission) bool {
+	var granted Permission
+	for _, g := range u.Grants {
+		if bytes.HasPrefix(key, []byte(g.Prefix)) {
+			granted |= g.Perm
+		}
+	}
+	return granted&perm == perm
+}
+
+// ACL is the list of users allowed to use a server, so one server can host
+// several applications, each limited to its own keys. A server with an ACL
+// rejects clients that don't authenticate as one of its users.
+type ACL struct {
+	users map[[sha256.Size]byte]*User // By the hash of their token
+}
+
+// NewACL returns an ACL of users. Names and tokens must be unique and
+// non-empty.
+func NewACL(users []User) (*ACL, error) {
+	acl := &ACL{users: make(map[[sha256.Size]byte]*User, len(users))}
+	names := make(map[string]bool, len(users))
+	for i := range users {
+		u := users[i]
+		if u.Name == "" || u.Token == "" {
+			return nil, fmt.Errorf("user %d: name and token must not be empty", i)
+		}
+		if strings.ContainsAny(u.Name, " \r\n") {
+			return nil, fmt.Errorf("user %s: name must not contain spaces", u.Name)
+		}
+		if names[u.Name] {
+			return nil, fmt.Errorf("user %s: duplicate name", u.Name)
+		}
+		sum := sha256.Sum256([]byte(u.Token))
+		if _, ok := acl.users[sum]; ok {
+			return nil, fmt.Errorf("user %s: duplicate token", u.Name)
+		}
+		names[u.Name] = true
+		acl.users[sum] = &u
+	}
+	return acl, nil
+}
+
+// LoadACL reads an ACL from a JSON file of the form
+//
+//	{"users": [{"name": "billing", "token": "...", "grants": [{"prefix": "billing/", "perm": "rw"}]}]}
+func LoadACL(path string) (*ACL, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ACL: %w", err)
+	}
+	var file struct {
+		Users []User `json:"users"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ACL: %w", err)
+	}
+	return NewACL(file.Users)
+}
+
+// Authenticate returns the user with token. Tokens are looked up by hash,
+// so the time taken doesn't depend on how much of a token matches.
+func (a *ACL) Authenticate(token string) (*User, bool) {
+	u, ok := a.users[sha256.Sum256([]byte(token))]
+	return u, ok
+}
+
+// allowed reports whether a client authenticated as u has perm on key. A
+// nil user stands for a client of a server without an ACL, which may do
+// anything.
+func allowed(u *User, key []byte, perm Permission) bool {
+	return u == nil || u.Allowed(key, perm)
+}
+
+// session is the state of a connection.
+type session struct {
+	authed bool
+	user   *User     // Nil for a server without an ACL
+	subs   *respSubs // Channels subscribed to, for RESP connections
+}
@@ -0,0 +1,169 @@
+package stoneserver
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testACL = `{"users": [
+	{"name": "billing", "token": "b-token", "grants": [{"prefix": "billing/", "perm": "rw"}, {"prefix": "shared/", "perm": "r"}]},
+	{"name": "admin", "token": "a-token", "grants": [{"prefix": "", "perm": "rw"}]}
+]}`
+
+func loadTestACL(t *testing.T) *ACL {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "acl.json")
+	os.WriteFile(path, []byte(testACL), 0o600)
+	acl, err := LoadACL(path)
+	if err != nil {
+		t.Fatalf("LoadACL failed: %v", err)
+	}
+	return acl
+}
+
+func TestACL(t *testing.T) {
+	acl := loadTestACL(t)
+	billing, ok := acl.Authenticate("b-token")
+	if !ok || billing.Name != "billing" {
+		t.Fatalf("expected b-token to authenticate billing, got %v", billing)
+	}
+	if _, ok := acl.Authenticate("wrong"); ok {
+		t.Error("expected unknown token to fail")
+	}
+
+	tests := []struct {
+		key  string
+		perm Permission
+		want bool
+	}{
+		{"billing/1", PermReadWrite, true},
+		{"shared/config", PermRead, true},
+		{"shared/config", PermWrite, false},
+		{"users/1", PermRead, false},
+	}
+	for _, tt := range tests {
+		if got := billing.Allowed([]byte(tt.key), tt.perm); got != tt.want {
+			t.Errorf("Allowed(%s, %v) = %v, expected %v", tt.key, tt.perm, got, tt.want)
+		}
+	}
+
+	_, err := NewACL([]User{{Name: "a", Token: "t"}, {Name: "b", Token: "t"}})
+	if err == nil {
+		t.Error("expected error for duplicate tokens")
+	}
+	var perm Permission
+	if err = perm.UnmarshalText([]byte("x")); err == nil {
+		t.Error("expected error for invalid permission")
+	}
+}
+
+func TestHTTPACL(t *testing.T) {
+	store := newStore(t)
+	srv := New(store)
+	srv.ACL = loadTestACL(t)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	store.Set([]byte("users/1"), []byte("alice"))
+	store.Set([]byte("billing/1"), []byte("invoice"))
+
+	request := func(method, path, token string) (int, string) {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader("value"))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	steps := []struct {
+		method, path, token string
+		status              int
+	}{
+		{http.MethodGet, "/v1/keys/billing/1", "", http.StatusUnauthorized},
+		{http.MethodGet, "/v1/keys/billing/1", "wrong", http.StatusUnauthorized},
+		{http.MethodGet, "/v1/keys/billing/1", "b-token", http.StatusOK},
+		{http.MethodPut, "/v1/keys/billing/2", "b-token", http.StatusNoContent},
+		{http.MethodGet, "/v1/keys/users/1", "b-token", http.StatusForbidden},
+		{http.MethodPut, "/v1/keys/shared/x", "b-token", http.StatusForbidden},
+		{http.MethodDelete, "/v1/keys/users/1", "a-token", http.StatusNoContent},
+	}
+	for _, step := range steps {
+		if status, _ := request(step.method, step.path, step.token); status != step.status {
+			t.Errorf("%s %s with %q: expected %d, got %d", step.method, step.path, step.token, step.status, status)
+		}
+	}
+
+	// Scans skip keys the user may not read
+	status, body := request(http.MethodGet, "/v1/keys", "b-token")
+	if status != http.StatusOK || !strings.Contains(body, "billing/2") || strings.Contains(body, "users/") {
+		t.Errorf("unexpected scan result %d %s", status, body)
+	}
+}
+
+func TestRESPACL(t *testing.T) {
+	srv := NewRESP(newStore(t))
+	srv.ACL = loadTestACL(t)
+	c := dialRESP(t, srv)
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"GET", "billing/1"}, "ERR:NOAUTH Authentication required."},
+		{[]string{"AUTH", "wrong"}, "ERR:WRONGPASS invalid username-password pair or user is disabled."},
+		{[]string{"AUTH", "admin", "b-token"}, "ERR:WRONGPASS invalid username-password pair or user is disabled."},
+		{[]string{"AUTH", "billing", "b-token"}, "OK"},
+		{[]string{"SET", "billing/1", "x"}, "OK"},
+		{[]string{"SET", "users/1", "x"}, "ERR:NOPERM this user has no permissions to access one of the keys used as arguments"},
+		{[]string{"DEL", "billing/1", "shared/1"}, "ERR:NOPERM this user has no permissions to access one of the keys used as arguments"},
+		{[]string{"GET", "billing/1"}, `"x"`},
+		{[]string{"PUBLISH", "shared/news", "x"}, "ERR:NOPERM this user has no permissions to access one of the channels used as arguments"},
+		{[]string{"PUBLISH", "billing/news", "x"}, "0"},
+		{[]string{"SUBSCRIBE", "billing/news", "users/news"}, "ERR:NOPERM this user has no permissions to access one of the channels used as arguments"},
+	}
+	for _, tt := range tests {
+		if got := c.do(tt.args...); got != tt.want {
+			t.Errorf("%v: expected %s, got %s", tt.args, tt.want, got)
+		}
+	}
+}
+
+func TestMemcacheACL(t *testing.T) {
+	store := newStore(t)
+	srv := NewMemcache(store)
+	srv.ACL = loadTestACL(t)
+	conn, r := dialMemcache(t, srv)
+
+	steps := []struct{ send, want string }{
+		{"get billing/1\r\n", "CLIENT_ERROR unauthenticated"},
+		{"set auth 0 0 13\r\nbilling wrong\r\n", "CLIENT_ERROR authentication failure"},
+		{"set auth 0 0 15\r\nbilling b-token\r\n", "STORED"},
+		{"set billing/1 0 0 1\r\nx\r\n", "STORED"},
+		{"set users/1 0 0 1\r\nx\r\n", "CLIENT_ERROR permission denied"},
+		{"incr shared/counter 1\r\n", "CLIENT_ERROR permission denied"},
+	}
+	for _, step := range steps {
+		fmt.Fprint(conn, step.send)
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("%q: failed to read reply: %v", step.send, err)
+		}
+		if got := strings.TrimSuffix(line, "\r\n"); got != step.want {
+			t.Errorf("%q: expected %q, got %q", step.send, step.want, got)
+		}
+	}
+	if _, err := store.Get([]byte("auth")); err == nil {
+		t.Error("expected authentication not to store a value")
+	}
+}
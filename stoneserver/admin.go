@@ -0,0 +1,255 @@
+package stoneserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/cryptrunner49/stonekv/stone"
+)
+
+// maxAdminBytes is the largest admin request body.
+const maxAdminBytes = 1 << 20
+
+// Stats is the response of the stats endpoint.
+type Stats struct {
+	Seq       uint64 `json:"seq"`        // Sequence number of the last write
+	Keys      int    `json:"keys"`       // Live keys
+	LiveBytes int64  `json:"live_bytes"` // Size of the live keys and values
+}
+
+// CompactPlan is the response of the compact endpoint, see
+// stone.PolishPlan.
+type CompactPlan struct {
+	Size            int64   `json:"size"`             // Size of the log now
+	Polished        int64   `json:"polished"`         // Size of the log once polished
+	Reclaimed       int64   `json:"reclaimed"`        // Bytes a polish would free
+	Written         int64   `json:"written"`          // Bytes a polish would write
+	DurationSeconds float64 `json:"duration_seconds"` // Estimated time a polish would take, 0 if unknown
+}
+
+// BackupRequest is the body of the backup endpoint. Parent names the
+// backup an incremental backup builds on.
+type BackupRequest struct {
+	Name     string `json:"name"`
+	Polished bool   `json:"polished"`
+	Gzip     bool   `json:"gzip"`
+	Parent   string `json:"parent,omitempty"`
+}
+
+// VerifyRequest is the optional body of the verify endpoint. Without a
+// backup name the store itself is verified.
+type VerifyRequest struct {
+	Backup string `json:"backup,omitempty"`
+}
+
+// VerifyResponse is the response of the verify endpoint. If Error is set
+// verification failed, and the counts cover the records read before the
+// problem.
+type VerifyResponse struct {
+	Records int    `json:"records"`
+	Sets    int    `json:"sets"`
+	Deletes int    `json:"deletes"`
+	Keys    int    `json:"keys"`
+	Seq     uint64 `json:"seq"`
+	Error   string `json:"error,omitempty"`
+}
+
+func (s *Server) registerAdmin() {
+	s.mux.HandleFunc("GET /v1/admin/stats", s.admin(s.handleStats))
+	s.mux.HandleFunc("GET /v1/admin/compact", s.admin(s.handleCompactPlan))
+	s.mux.HandleFunc("POST /v1/admin/compact", s.admin(s.measure("compact", s.handleCompact)))
+	s.mux.HandleFunc("POST /v1/admin/backup", s.admin(s.measure("backup", s.handleBackup)))
+	s.mux.HandleFunc("GET /v1/admin/backups", s.admin(s.handleListBackups))
+	s.mux.HandleFunc("POST /v1/admin/verify", s.admin(s.measure("verify", s.handleVerify)))
+}
+
+// admin wraps an admin endpoint, rejecting users who aren't admins.
+func (s *Server) admin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := s.user(w, r)
+		if !ok {
+			return
+		}
+		if user != nil && !user.Admin {
+			writeError(w, http.StatusForbidden, "permission denied")
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	stats := Stats{Seq: s.store.Seq()}
+	err := s.store.Scan(nil, func(key, value []byte) error {
+		stats.Keys++
+		stats.LiveBytes += int64(len(key) + len(value))
+		return nil
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if s.Metrics == nil {
+		writeError(w, http.StatusNotFound, "metrics are not enabled")
+		return
+	}
+	s.Metrics.ServeHTTP(w, r)
+}
+
+func (s *Server) handleCompactPlan(w http.ResponseWriter, r *http.Request) {
+	plan := s.store.PolishPlan()
+	writeJSON(w, http.StatusOK, CompactPlan{
+		Size:            plan.Size,
+		Polished:        plan.Polished,
+		Reclaimed:       plan.Reclaimed,
+		Written:         plan.Written,
+		DurationSeconds: plan.Duration.Seconds(),
+	})
+}
+
+func (s *Server) handleCompact(w http.ResponseWriter, r *http.Request) {
+	err := s.store.Polish()
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleBackup(w http.ResponseWriter, r *http.Request) {
+	var req BackupRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	path, ok := s.backupPath(w, req.Name)
+	if !ok {
+		return
+	}
+	opts := stone.BackupOptions{Polished: req.Polished}
+	if req.Gzip {
+		opts.Compress = stone.CompressGzip
+	}
+	if req.Parent != "" {
+		parentPath, ok := s.backupPath(w, req.Parent)
+		if !ok {
+			return
+		}
+		parent, err := stone.ReadManifest(parentPath)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		opts.Parent = &parent
+	}
+	if _, err := os.Stat(path); err == nil {
+		writeError(w, http.StatusConflict, fmt.Sprintf("backup %s already exists", req.Name))
+		return
+	}
+
+	err := s.store.Backup(path, opts)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	manifest, err := stone.ReadManifest(path)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, manifest)
+}
+
+func (s *Server) handleListBackups(w http.ResponseWriter, r *http.Request) {
+	if s.BackupDir == "" {
+		writeError(w, http.StatusNotFound, "backups are not enabled")
+		return
+	}
+	manifests, err := stone.ListBackups(s.BackupDir)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if manifests == nil {
+		manifests = []stone.Manifest{}
+	}
+	writeJSON(w, http.StatusOK, manifests)
+}
+
+func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
+	var req VerifyRequest
+	if r.ContentLength != 0 && !readJSON(w, r, &req) {
+		return
+	}
+
+	var path string
+	if req.Backup != "" {
+		var ok bool
+		path, ok = s.backupPath(w, req.Backup)
+		if !ok {
+			return
+		}
+	} else {
+		// The log is verified through a full copy, so records are checked
+		// without holding up writers for the whole read
+		dir, err := os.MkdirTemp("", "stonekv-verify-")
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		defer os.RemoveAll(dir)
+		path = filepath.Join(dir, "store.db")
+		err = s.store.Backup(path, stone.BackupOptions{})
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+	}
+
+	report, err := stone.VerifyBackup(path, stone.VerifyOptions{})
+	resp := VerifyResponse{
+		Records: report.Records,
+		Sets:    report.Sets,
+		Deletes: report.Deletes,
+		Keys:    report.Keys,
+		Seq:     report.Seq,
+	}
+	status := http.StatusOK
+	if err != nil {
+		resp.Error = err.Error()
+		status = http.StatusUnprocessableEntity
+	}
+	writeJSON(w, status, resp)
+}
+
+// backupPath returns the path of the backup called name in BackupDir,
+// writing an error if backups are disabled or name isn't a plain file
+// name.
+func (s *Server) backupPath(w http.ResponseWriter, name string) (string, bool) {
+	if s.BackupDir == "" {
+		writeError(w, http.StatusNotFound, "backups are not enabled")
+		return "", false
+	}
+	if name == "" || name != filepath.Base(name) || name == "." || name == ".." {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid backup name %q", name))
+		return "", false
+	}
+	return filepath.Join(s.BackupDir, name), true
+}
+
+// readJSON decodes the JSON request body into v, writing an error if it
+// is invalid.
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminBytes)).Decode(v)
+	if err != nil {
+		writeBodyError(w, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,99 @@
+package stoneserver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cryptrunner49/stonekv/stone"
+)
+
+func TestAdmin(t *testing.T) {
+	store := newStore(t)
+	srv := New(store)
+	srv.BackupDir = t.TempDir()
+	acl, err := NewACL([]User{
+		{Name: "app", Token: "app-token", Grants: []Grant{{Prefix: "", Perm: PermReadWrite}}},
+		{Name: "ops", Token: "ops-token", Admin: true},
+	})
+	if err != nil {
+		t.Fatalf("NewACL failed: %v", err)
+	}
+	srv.ACL = acl
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	store.Set([]byte("a"), []byte("1"))
+	store.Set([]byte("a"), []byte("2"))
+	store.Set([]byte("bb"), []byte("22"))
+
+	request := func(method, path, token, body string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+
+	if status, _ := request(http.MethodGet, "/v1/admin/stats", "app-token", ""); status != http.StatusForbidden {
+		t.Errorf("expected 403 for a user who isn't an admin, got %d", status)
+	}
+	status, body := request(http.MethodGet, "/v1/admin/stats", "ops-token", "")
+	var stats Stats
+	if status != http.StatusOK || json.Unmarshal([]byte(body), &stats) != nil || stats != (Stats{Seq: 3, Keys: 2, LiveBytes: 6}) {
+		t.Errorf("unexpected stats %d %s", status, body)
+	}
+
+	status, body = request(http.MethodGet, "/v1/admin/compact", "ops-token", "")
+	var plan CompactPlan
+	if status != http.StatusOK || json.Unmarshal([]byte(body), &plan) != nil || plan.Reclaimed == 0 || plan.Written != plan.Size+plan.Polished {
+		t.Errorf("unexpected compact plan %d %s", status, body)
+	}
+	if status, body := request(http.MethodPost, "/v1/admin/compact", "ops-token", ""); status != http.StatusNoContent {
+		t.Errorf("compact failed: %d %s", status, body)
+	}
+
+	status, body = request(http.MethodPost, "/v1/admin/backup", "ops-token", `{"name": "nightly.db", "gzip": true}`)
+	var manifest stone.Manifest
+	if status != http.StatusCreated || json.Unmarshal([]byte(body), &manifest) != nil || manifest.Keys != 2 || manifest.Compression != "gzip" {
+		t.Errorf("unexpected backup response %d %s", status, body)
+	}
+	if status, _ := request(http.MethodPost, "/v1/admin/backup", "ops-token", `{"name": "nightly.db"}`); status != http.StatusConflict {
+		t.Errorf("expected 409 for an existing backup, got %d", status)
+	}
+	if status, _ := request(http.MethodPost, "/v1/admin/backup", "ops-token", `{"name": "../escape.db"}`); status != http.StatusBadRequest {
+		t.Errorf("expected 400 for a path outside the backup directory, got %d", status)
+	}
+	status, body = request(http.MethodGet, "/v1/admin/backups", "ops-token", "")
+	if status != http.StatusOK || !strings.Contains(body, `"name":"nightly.db"`) {
+		t.Errorf("unexpected backup list %d %s", status, body)
+	}
+
+	status, body = request(http.MethodPost, "/v1/admin/verify", "ops-token", "")
+	if status != http.StatusOK || !strings.Contains(body, `"keys":2`) {
+		t.Errorf("unexpected store verification %d %s", status, body)
+	}
+	status, body = request(http.MethodPost, "/v1/admin/verify", "ops-token", `{"backup": "nightly.db"}`)
+	if status != http.StatusOK {
+		t.Errorf("unexpected backup verification %d %s", status, body)
+	}
+
+	// Corrupting the backup fails verification
+	path := filepath.Join(srv.BackupDir, "nightly.db")
+	data, _ := os.ReadFile(path)
+	data[len(data)/2] ^= 0xFF
+	os.WriteFile(path, data, 0o600)
+	status, body = request(http.MethodPost, "/v1/admin/verify", "ops-token", `{"backup": "nightly.db"}`)
+	if status != http.StatusUnprocessableEntity || !strings.Contains(body, `"error"`) {
+		t.Errorf("expected corrupted backup to fail verification, got %d %s", status, body)
+	}
+}
@@ -0,0 +1,27 @@
+package stoneserver
+
+import "net/http"
+
+// handleHealthz answers liveness probes: the store must be open and its
+// last write must have succeeded.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, s.store.Health())
+}
+
+// handleReadyz answers readiness probes, which also check that the disk
+// of the store is writable.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	err := s.store.Health()
+	if err == nil {
+		err = s.store.CheckDisk()
+	}
+	writeHealth(w, err)
+}
+
+func writeHealth(w http.ResponseWriter, err error) {
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
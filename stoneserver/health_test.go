@@ -0,0 +1,37 @@
+package stoneserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	store := newStore(t)
+	srv := New(store)
+	srv.ACL = loadTestACL(t)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	probe := func(path string) int {
+		t.Helper()
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	// Probes don't need a token
+	for _, path := range []string{"/healthz", "/readyz"} {
+		if status := probe(path); status != http.StatusOK {
+			t.Errorf("expected %s to return 200, got %d", path, status)
+		}
+	}
+	store.Close()
+	for _, path := range []string{"/healthz", "/readyz"} {
+		if status := probe(path); status != http.StatusServiceUnavailable {
+			t.Errorf("expected %s to return 503 for a closed store, got %d", path, status)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package stoneserver
+
+import (
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// Limits bounds the load clients can put on a server, so a misbehaving
+// client can't starve the others of the store. Zero fields are unlimited.
+//
+// Requests are commands for the Redis and memcached protocols, whose
+// clients are slowed down to the limits, and requests for HTTP, where a
+// client over a limit gets 429 Too Many Requests. Bytes count what clients
+// send and receive. A client is a connection, except for HTTP, where it is
+// the remote IP address, as HTTP clients open connections at will.
+type Limits struct {
+	QPS               float64 // Requests per second of all clients together
+	ClientQPS         float64 // Requests per second of each client
+	BytesPerSec       float64 // Bytes per second of all clients together
+	ClientBytesPerSec float64 // Bytes per second of each client
+}
+
+// bucket is a token bucket refilled at rate tokens per second, holding at
+// most a second's worth. Tokens may be taken on credit, which later
+// requests repay by waiting. A nil bucket is unlimited.
+type bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// newBucket returns a full bucket for rate, or nil if rate is zero.
+func newBucket(rate float64) *bucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &bucket{rate: rate, tokens: burst(rate), last: time.Now()}
+}
+
+// burst is the number of tokens a bucket for rate holds when full. It is
+// at least 1, so a single request can always pass.
+func burst(rate float64) float64 {
+	return math.Max(rate, 1)
+}
+
+// refill adds the tokens earned since the last call. The caller must hold
+// b.mu.
+func (b *bucket) refill(now time.Time) {
+	b.tokens = math.Min(b.tokens+now.Sub(b.last).Seconds()*b.rate, burst(b.rate))
+	b.last = now
+}
+
+// take takes n tokens, on credit if needed, and returns how long to wait
+// before using them.
+func (b *bucket) take(n float64) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// allow takes n tokens if they are available. Otherwise it takes none and
+// returns how long until they will be.
+func (b *bucket) allow(n float64) (bool, time.Duration) {
+	if b == nil {
+		return true, 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	if b.tokens >= n {
+		b.tokens -= n
+		return true, 0
+	}
+	return false, time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+// full reports whether the bucket is full, meaning it behaves like a new
+// one and can be dropped.
+func (b *bucket) full() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	return b.tokens >= burst(b.rate)
+}
+
+// limiter applies Limits to the clients of a server.
+type limiter struct {
+	limits   Limits
+	requests *bucket // Shared by all clients
+	bytes    *bucket
+}
+
+func newLimiter(limits Limits) *limiter {
+	return &limiter{
+		limits:   limits,
+		requests: newBucket(limits.QPS),
+		bytes:    newBucket(limits.BytesPerSec),
+	}
+}
+
+// clientLimiter tracks the limits of one client.
+type clientLimiter struct {
+	shared   *limiter
+	requests *bucket
+	bytes    *bucket
+}
+
+func (l *limiter) client() *clientLimiter {
+	return &clientLimiter{
+		shared:   l,
+		requests: newBucket(l.limits.ClientQPS),
+		bytes:    newBucket(l.limits.ClientBytesPerSec),
+	}
+}
+
+// waitRequest blocks until the client may send another request. A nil
+// client is unlimited.
+func (c *clientLimiter) waitRequest() {
+	if c != nil {
+		time.Sleep(max(c.shared.requests.take(1), c.requests.take(1)))
+	}
+}
+
+// allowRequest reports whether the client may send another request now,
+// or else how long until it may. Bytes transferred on credit must have
+// been repaid first.
+func (c *clientLimiter) allowRequest() (bool, time.Duration) {
+	for _, b := range []*bucket{c.bytes, c.shared.bytes} {
+		if ok, retry := b.allow(0); !ok {
+			return false, retry
+		}
+	}
+	if ok, retry := c.requests.allow(1); !ok {
+		return false, retry
+	}
+	return c.shared.requests.allow(1)
+}
+
+// chargeBytes takes n bytes from the client's byte limits on credit.
+func (c *clientLimiter) chargeBytes(n int64) {
+	c.shared.bytes.take(float64(n))
+	c.bytes.take(float64(n))
+}
+
+// waitBytes blocks until the client may transfer n more bytes.
+func (c *clientLimiter) waitBytes(n int) {
+	if n > 0 {
+		time.Sleep(max(c.shared.bytes.take(float64(n)), c.bytes.take(float64(n))))
+	}
+}
+
+// idle reports whether the client's buckets are full, so dropping it
+// doesn't change how later requests are limited.
+func (c *clientLimiter) idle() bool {
+	return c.requests.full() && c.bytes.full()
+}
+
+// limitedConn is a connection whose reads and writes are throttled to the
+// byte limits of its client.
+type limitedConn struct {
+	net.Conn
+	limits *clientLimiter
+}
+
+func (c *limitedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.limits.waitBytes(n)
+	return n, err
+}
+
+func (c *limitedConn) Write(p []byte) (int, error) {
+	c.limits.waitBytes(len(p))
+	return c.Conn.Write(p)
+}
+
+// limitConn returns conn throttled to limits, with the client limiter its
+// requests must wait on. Without limits it returns conn and nil.
+func limitConn(l *limiter, conn net.Conn) (net.Conn, *clientLimiter) {
+	if l == nil || l.limits == (Limits{}) {
+		return conn, nil
+	}
+	client := l.client()
+	if l.limits.BytesPerSec == 0 && l.limits.ClientBytesPerSec == 0 {
+		return conn, client
+	}
+	return &limitedConn{Conn: conn, limits: client}, client
+}
@@ -0,0 +1,71 @@
+package stoneserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	b := newBucket(2)
+	for i := 0; i < 2; i++ {
+		if ok, _ := b.allow(1); !ok {
+			t.Fatalf("expected token %d of the burst to be allowed", i)
+		}
+	}
+	if ok, retry := b.allow(1); ok || retry <= 0 || retry > 500*time.Millisecond {
+		t.Errorf("expected empty bucket to refuse with a retry of at most 500ms, got %v %v", ok, retry)
+	}
+	if wait := b.take(1); wait <= 0 || wait > 500*time.Millisecond {
+		t.Errorf("expected take on credit to wait at most 500ms, got %v", wait)
+	}
+	if b.full() {
+		t.Error("expected bucket in debt not to be full")
+	}
+
+	var unlimited *bucket
+	if ok, _ := unlimited.allow(1e9); !ok || unlimited.take(1e9) != 0 || !unlimited.full() {
+		t.Error("expected nil bucket to be unlimited")
+	}
+}
+
+func TestRESPLimits(t *testing.T) {
+	srv := NewRESP(newStore(t))
+	srv.Limits = Limits{ClientQPS: 20}
+	c := dialRESP(t, srv)
+
+	// The first 20 commands are the burst, the next 10 take half a second
+	start := time.Now()
+	for i := 0; i < 30; i++ {
+		if got := c.do("PING"); got != "PONG" {
+			t.Fatalf("expected PONG, got %s", got)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("expected commands over the limit to be slowed down, took %v", elapsed)
+	}
+}
+
+func TestHTTPLimits(t *testing.T) {
+	srv := New(newStore(t))
+	srv.Limits = Limits{ClientQPS: 2}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	var statuses []int
+	for i := 0; i < 3; i++ {
+		resp, err := http.Get(ts.URL + "/v1/keys/missing")
+		if err != nil {
+			t.Fatalf("GET failed: %v", err)
+		}
+		resp.Body.Close()
+		statuses = append(statuses, resp.StatusCode)
+		if resp.StatusCode == http.StatusTooManyRequests && resp.Header.Get("Retry-After") != "1" {
+			t.Errorf("expected Retry-After 1, got %q", resp.Header.Get("Retry-After"))
+		}
+	}
+	if statuses[0] != http.StatusNotFound || statuses[1] != http.StatusNotFound || statuses[2] != http.StatusTooManyRequests {
+		t.Errorf("expected the third request to be rejected, got %v", statuses)
+	}
+}
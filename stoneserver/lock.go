@@ -0,0 +1,66 @@
+package stoneserver
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cryptrunner49/stonekv/stone"
+)
+
+// handleLock takes the advisory lock named by the path, for the duration
+// of the ttl parameter, or until it is unlocked if there is none. It
+// answers the token to unlock it with, as {"token": 42}, if the lock was
+// taken and 409 if it is held, without waiting for it.
+func (s *Server) handleLock(w http.ResponseWriter, r *http.Request) {
+	key, ok := s.authorize(w, r, PermWrite)
+	if !ok {
+		return
+	}
+	var ttl time.Duration
+	if v := r.URL.Query().Get("ttl"); v != "" {
+		var err error
+		ttl, err = time.ParseDuration(v)
+		if err != nil || ttl < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid ttl %q", v))
+			return
+		}
+	}
+	token, taken, err := s.store.TryLock(key, ttl)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if !taken {
+		writeError(w, http.StatusConflict, "lock is held")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]stone.LockToken{"token": token})
+}
+
+// handleUnlock releases the advisory lock named by the path, taken with
+// the token parameter, answering 409 if it isn't held with that token.
+func (s *Server) handleUnlock(w http.ResponseWriter, r *http.Request) {
+	key, ok := s.authorize(w, r, PermWrite)
+	if !ok {
+		return
+	}
+	v := r.URL.Query().Get("token")
+	token, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid token %q", v))
+		return
+	}
+	err = s.store.Unlock(key, stone.LockToken(token))
+	if errors.Is(err, stone.ErrNotLocked) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
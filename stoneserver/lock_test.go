@@ -0,0 +1,50 @@
+package stoneserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLocks(t *testing.T) {
+	store, ts := startServer(t)
+
+	status, body := do(t, http.MethodPost, ts.URL+"/v1/locks/jobs/report?ttl=1m", "")
+	if status != http.StatusOK {
+		t.Fatalf("expected 200 for taking the lock, got %d", status)
+	}
+	var taken struct{ Token uint64 }
+	if err := json.Unmarshal([]byte(body), &taken); err != nil || taken.Token == 0 {
+		t.Fatalf("expected the token of the lock, got %q (%v)", body, err)
+	}
+	status, _ = do(t, http.MethodPost, ts.URL+"/v1/locks/jobs/report", "")
+	if status != http.StatusConflict {
+		t.Errorf("expected 409 for a held lock, got %d", status)
+	}
+	if _, ok, err := store.TryLock([]byte("jobs/report"), time.Second); ok || err != nil {
+		t.Errorf("expected the lock to be held for the store too, got %v, %v", ok, err)
+	}
+	status, _ = do(t, http.MethodPost, ts.URL+"/v1/locks/jobs/report?ttl=soon", "")
+	if status != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid ttl, got %d", status)
+	}
+
+	unlock := func(token string) int {
+		status, _ := do(t, http.MethodDelete, ts.URL+"/v1/locks/jobs/report?token="+token, "")
+		return status
+	}
+	if status := unlock(""); status != http.StatusBadRequest {
+		t.Errorf("expected 400 for unlocking without a token, got %d", status)
+	}
+	if status := unlock(fmt.Sprint(taken.Token + 1)); status != http.StatusConflict {
+		t.Errorf("expected 409 for unlocking with another token, got %d", status)
+	}
+	if status := unlock(fmt.Sprint(taken.Token)); status != http.StatusNoContent {
+		t.Errorf("expected 204 for unlocking, got %d", status)
+	}
+	if status := unlock(fmt.Sprint(taken.Token)); status != http.StatusConflict {
+		t.Errorf("expected 409 for unlocking a free lock, got %d", status)
+	}
+}
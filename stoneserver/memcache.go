@@ -0,0 +1,350 @@
+package stoneserver
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cryptrunner49/stonekv/stone"
+)
+
+const maxMemcacheKey = 250 // Longest key memcached accepts
+
+// MemcacheServer serves a store over the memcached text protocol, so it
+// can replace a memcached server whose data should survive restarts. It
+// supports get, set, delete, incr and decr, along with version and quit.
+//
+// Keys never expire, so set rejects a non-zero expiration time. Flags are
+// accepted but not stored: get always returns 0, so clients that encode
+// the type of a value in its flags must not be pointed at it. incr and decr
+// are atomic with respect to each other, but not to other writes of the
+// same key.
+type MemcacheServer struct {
+	// ACL, if set, limits the server to its users. As with memcached's
+	// authfile, clients authenticate by sending a set of any key whose value
+	// is their name and token separated by a space, before any other
+	// command. Set it before serving connections.
+	ACL *ACL
+	// Limits bounds the commands and bytes of clients. Set it before
+	// serving connections.
+	Limits Limits
+	// Metrics, if set, records the commands served. Set it before serving
+	// connections.
+	Metrics *Metrics
+
+	store *stone.Store
+	track tracker
+	mu    sync.Mutex // Serializes incr and decr
+}
+
+// NewMemcache returns a memcached server for store. The store stays owned
+// by the caller, who must keep it open while the server is in use.
+func NewMemcache(store *stone.Store) *MemcacheServer {
+	return &MemcacheServer{store: store}
+}
+
+// Serve accepts connections on ln and serves each of them in its own
+// goroutine. It returns when ln is closed, or ErrServerClosed after
+// Shutdown.
+func (s *MemcacheServer) Serve(ln net.Listener) error {
+	if !s.track.addListener(ln) {
+		return ErrServerClosed
+	}
+	defer s.track.removeListener(ln)
+	limits := newLimiter(s.Limits)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if s.track.shuttingDown() {
+				return ErrServerClosed
+			}
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+		if !s.track.addConn(conn) {
+			conn.Close()
+			continue
+		}
+		go s.serveConn(conn, limits)
+	}
+}
+
+// Shutdown stops the server gracefully: it closes the listeners, lets
+// connections finish the commands they have sent, and closes them once
+// idle. If ctx is done first, the remaining connections are closed and
+// ctx.Err() is returned. The store is left open.
+func (s *MemcacheServer) Shutdown(ctx context.Context) error {
+	return s.track.shutdown(ctx)
+}
+
+// errBadFormat rejects a command line that can't be parsed.
+var errBadFormat = errors.New("CLIENT_ERROR bad command line format")
+
+// serveConn reads commands from conn until it is closed or sends quit.
+func (s *MemcacheServer) serveConn(conn net.Conn, limits *limiter) {
+	defer s.track.removeConn(conn)
+	defer conn.Close()
+	tracked := conn
+	conn, client := limitConn(limits, conn)
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	session := &session{authed: s.ACL == nil}
+	for {
+		// Between commands the connection is idle, and Shutdown may close it
+		if r.Buffered() == 0 && !s.track.setIdle(tracked, true) {
+			return
+		}
+		line, err := readLine(r)
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(string(line))
+		if len(fields) == 0 {
+			w.WriteString("ERROR\r\n")
+			w.Flush()
+			continue
+		}
+		if fields[0] == "quit" {
+			return
+		}
+
+		s.track.setIdle(tracked, false)
+		client.waitRequest()
+		start := time.Now()
+		err = s.exec(r, w, session, fields)
+		s.Metrics.observe("memcache", memcacheOp(fields[0]), start, err != nil && strings.HasPrefix(err.Error(), "SERVER_ERROR"))
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return
+			}
+			w.WriteString(err.Error() + "\r\n")
+		}
+		if r.Buffered() == 0 && w.Flush() != nil {
+			return
+		}
+	}
+}
+
+// memcacheOp returns the operation name metrics are kept under for a
+// command. Unknown commands share one name, so clients can't create
+// metrics at will.
+func memcacheOp(cmd string) string {
+	switch cmd {
+	case "get", "set", "delete", "incr", "decr", "version":
+		return cmd
+	}
+	return "unknown"
+}
+
+// exec runs a command and writes its reply. Errors returned are written as
+// the reply by the caller.
+func (s *MemcacheServer) exec(r *bufio.Reader, w *bufio.Writer, session *session, fields []string) error {
+	cmd, args := fields[0], fields[1:]
+	noreply := len(args) > 0 && args[len(args)-1] == "noreply"
+	if noreply && cmd != "get" {
+		args = args[:len(args)-1]
+		w = bufio.NewWriter(io.Discard)
+	}
+	// check returns an error unless the session has perm on every key
+	check := func(perm Permission, keys ...string) error {
+		for _, key := range keys {
+			if !allowed(session.user, []byte(key), perm) {
+				return errors.New("CLIENT_ERROR permission denied")
+			}
+		}
+		return nil
+	}
+
+	if !session.authed {
+		if cmd != "set" {
+			return errors.New("CLIENT_ERROR unauthenticated")
+		}
+		return s.auth(r, w, session, args)
+	}
+	switch cmd {
+	case "get":
+		if len(args) == 0 {
+			return errors.New("ERROR")
+		}
+		for _, key := range args {
+			if len(key) > maxMemcacheKey {
+				return errBadFormat
+			}
+		}
+		if err := check(PermRead, args...); err != nil {
+			return err
+		}
+		for _, key := range args {
+			value, err := s.store.Get([]byte(key))
+			if isNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return serverError(err)
+			}
+			fmt.Fprintf(w, "VALUE %s 0 %d\r\n", key, len(value))
+			w.Write(value)
+			w.WriteString("\r\n")
+		}
+		w.WriteString("END\r\n")
+	case "set":
+		key, exptime, value, err := readSet(r, args)
+		if err != nil {
+			return err
+		}
+		if exptime != 0 {
+			return errors.New("CLIENT_ERROR expiration is not supported")
+		}
+		if err := check(PermWrite, key); err != nil {
+			return err
+		}
+		err = s.store.Set([]byte(key), value)
+		if err != nil {
+			return serverError(err)
+		}
+		w.WriteString("STORED\r\n")
+	case "delete":
+		// A trailing 0 is the legacy delete time, which memcached accepts
+		if len(args) == 2 && args[1] == "0" {
+			args = args[:1]
+		}
+		if len(args) != 1 || len(args[0]) > maxMemcacheKey {
+			return errBadFormat
+		}
+		if err := check(PermWrite, args[0]); err != nil {
+			return err
+		}
+		_, err := s.store.Get([]byte(args[0]))
+		if isNotFound(err) {
+			w.WriteString("NOT_FOUND\r\n")
+			return nil
+		}
+		if err == nil {
+			err = s.store.Delete([]byte(args[0]))
+		}
+		if err != nil {
+			return serverError(err)
+		}
+		w.WriteString("DELETED\r\n")
+	case "incr", "decr":
+		if len(args) != 2 || len(args[0]) > maxMemcacheKey {
+			return errBadFormat
+		}
+		delta, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return errors.New("CLIENT_ERROR invalid numeric delta argument")
+		}
+		if err := check(PermReadWrite, args[0]); err != nil {
+			return err
+		}
+		value, found, err := s.add([]byte(args[0]), delta, cmd == "decr")
+		if err != nil {
+			return err
+		}
+		if !found {
+			w.WriteString("NOT_FOUND\r\n")
+			return nil
+		}
+		w.WriteString(strconv.FormatUint(value, 10) + "\r\n")
+	case "version":
+		w.WriteString("VERSION stonekv\r\n")
+	default:
+		return errors.New("ERROR")
+	}
+	return nil
+}
+
+// auth authenticates a session with the value of a set command, which
+// holds a user name and token separated by a space.
+func (s *MemcacheServer) auth(r *bufio.Reader, w *bufio.Writer, session *session, args []string) error {
+	_, _, value, err := readSet(r, args)
+	if err != nil {
+		return err
+	}
+	name, token, _ := strings.Cut(string(value), " ")
+	user, ok := s.ACL.Authenticate(token)
+	if !ok || user.Name != name {
+		return errors.New("CLIENT_ERROR authentication failure")
+	}
+	session.authed, session.user = true, user
+	w.WriteString("STORED\r\n")
+	return nil
+}
+
+// readSet parses the arguments of a set command and reads the value that
+// follows it.
+func readSet(r *bufio.Reader, args []string) (key string, exptime int64, value []byte, err error) {
+	if len(args) != 4 {
+		return "", 0, nil, errBadFormat
+	}
+	key = args[0]
+	_, flagsErr := strconv.ParseUint(args[1], 10, 32)
+	exptime, expErr := strconv.ParseInt(args[2], 10, 64)
+	size, sizeErr := strconv.Atoi(args[3])
+	if len(key) > maxMemcacheKey || flagsErr != nil || expErr != nil || sizeErr != nil || size < 0 {
+		return "", 0, nil, errBadFormat
+	}
+	if size > maxValueBytes {
+		// Skip the data block, so the next command is read correctly
+		_, err = io.CopyN(io.Discard, r, int64(size)+2)
+		if err != nil {
+			return "", 0, nil, err
+		}
+		return "", 0, nil, errors.New("SERVER_ERROR object too large for cache")
+	}
+	value = make([]byte, size+2)
+	_, err = io.ReadFull(r, value)
+	if err != nil {
+		return "", 0, nil, unexpectedEOF(err)
+	}
+	if value[size] != '\r' || value[size+1] != '\n' {
+		return "", 0, nil, errors.New("CLIENT_ERROR bad data chunk")
+	}
+	return key, exptime, value[:size], nil
+}
+
+// add adds delta to the decimal number stored under key, or subtracts it
+// for a decr. As in memcached, incr wraps around at 2^64 and decr stops at
+// 0. found is false if the key doesn't exist.
+func (s *MemcacheServer) add(key []byte, delta uint64, decr bool) (value uint64, found bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, err := s.store.Get(key)
+	if isNotFound(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, serverError(err)
+	}
+	value, err = strconv.ParseUint(strings.TrimSpace(string(current)), 10, 64)
+	if err != nil {
+		return 0, false, errors.New("CLIENT_ERROR cannot increment or decrement non-numeric value")
+	}
+	switch {
+	case !decr:
+		value += delta
+	case delta > value:
+		value = 0
+	default:
+		value -= delta
+	}
+	err = s.store.Set(key, []byte(strconv.FormatUint(value, 10)))
+	if err != nil {
+		return 0, false, serverError(err)
+	}
+	return value, true, nil
+}
+
+// serverError turns a store error into a reply. Line breaks would end the
+// reply early, so they are replaced by spaces.
+func serverError(err error) error {
+	msg := strings.NewReplacer("\r", " ", "\n", " ").Replace(err.Error())
+	return errors.New("SERVER_ERROR " + msg)
+}
@@ -0,0 +1,86 @@
+package stoneserver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/cryptrunner49/stonekv/stone"
+)
+
+func startMemcache(t *testing.T) (*stone.Store, net.Conn, *bufio.Reader) {
+	t.Helper()
+	store := newStore(t)
+	conn, r := dialMemcache(t, NewMemcache(store))
+	return store, conn, r
+}
+
+// dialMemcache serves srv on a local port and connects to it.
+func dialMemcache(t *testing.T, srv *MemcacheServer) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go srv.Serve(ln)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, bufio.NewReader(conn)
+}
+
+func TestMemcache(t *testing.T) {
+	store, conn, r := startMemcache(t)
+
+	steps := []struct {
+		send string
+		want string // Reply lines, joined by "|"
+	}{
+		{"set greeting 0 0 11\r\nhello world\r\n", "STORED"},
+		{"get greeting missing\r\n", "VALUE greeting 0 11|hello world|END"},
+		{"set counter 0 0 2\r\n41\r\n", "STORED"},
+		{"incr counter 1\r\n", "42"},
+		{"decr counter 50\r\n", "0"},
+		{"incr missing 1\r\n", "NOT_FOUND"},
+		{"incr greeting 1\r\n", "CLIENT_ERROR cannot increment or decrement non-numeric value"},
+		{"set quiet 0 0 1 noreply\r\nx\r\nget quiet\r\n", "VALUE quiet 0 1|x|END"},
+		{"set ttl 0 60 1\r\nx\r\n", "CLIENT_ERROR expiration is not supported"},
+		{"delete greeting\r\n", "DELETED"},
+		{"delete greeting\r\n", "NOT_FOUND"},
+		{"flush_all\r\n", "ERROR"},
+		{"version\r\n", "VERSION stonekv"},
+	}
+	for _, step := range steps {
+		fmt.Fprint(conn, step.send)
+		var lines []string
+		for len(lines) < strings.Count(step.want, "|")+1 {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("%q: failed to read reply: %v", step.send, err)
+			}
+			lines = append(lines, strings.TrimSuffix(line, "\r\n"))
+		}
+		if got := strings.Join(lines, "|"); got != step.want {
+			t.Errorf("%q: expected %q, got %q", step.send, step.want, got)
+		}
+	}
+
+	if value, err := store.Get([]byte("counter")); err != nil || string(value) != "0" {
+		t.Errorf("expected counter 0 in store, got %q (%v)", value, err)
+	}
+	if _, err := store.Get([]byte("ttl")); err == nil {
+		t.Error("expected set with expiration not to be applied")
+	}
+
+	fmt.Fprint(conn, "quit\r\n")
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Errorf("expected connection to be closed after quit, got %v", err)
+	}
+}
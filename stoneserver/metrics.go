@@ -0,0 +1,207 @@
+package stoneserver
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cryptrunner49/stonekv/stone"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the buckets of the
+// operation latency histogram.
+var latencyBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// Metrics counts the operations of one or more servers of a store and
+// serves them, along with the statistics of the store, in the Prometheus
+// text format. Set it as the Metrics field of every server to measure.
+type Metrics struct {
+	store *stone.Store
+
+	mu  sync.Mutex
+	ops map[opKey]*opMetrics
+}
+
+// opKey labels the metrics of an operation.
+type opKey struct {
+	protocol string
+	op       string
+}
+
+// opMetrics are the counters of an operation.
+type opMetrics struct {
+	count   uint64
+	errors  uint64
+	buckets []uint64 // Per bucket of latencyBuckets, not cumulative
+	sum     float64
+}
+
+// NewMetrics returns metrics for the servers of store.
+func NewMetrics(store *stone.Store) *Metrics {
+	return &Metrics{store: store, ops: make(map[opKey]*opMetrics)}
+}
+
+// observe records an operation that started at start. Failed operations
+// are those the server couldn't complete, not those the client got wrong.
+// A nil Metrics records nothing.
+func (m *Metrics) observe(protocol, op string, start time.Time, failed bool) {
+	if m == nil {
+		return
+	}
+	elapsed := time.Since(start).Seconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := opKey{protocol, op}
+	om, ok := m.ops[key]
+	if !ok {
+		om = &opMetrics{buckets: make([]uint64, len(latencyBuckets))}
+		m.ops[key] = om
+	}
+	om.count++
+	if failed {
+		om.errors++
+	}
+	om.sum += elapsed
+	if i := sort.SearchFloat64s(latencyBuckets, elapsed); i < len(latencyBuckets) {
+		om.buckets[i]++
+	}
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	m.write(bw)
+	bw.Flush()
+}
+
+func (m *Metrics) write(w *bufio.Writer) {
+	m.mu.Lock()
+	keys := make([]opKey, 0, len(m.ops))
+	ops := make(map[opKey]opMetrics, len(m.ops))
+	for key, om := range m.ops {
+		keys = append(keys, key)
+		ops[key] = opMetrics{om.count, om.errors, append([]uint64(nil), om.buckets...), om.sum}
+	}
+	m.mu.Unlock()
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].protocol != keys[j].protocol {
+			return keys[i].protocol < keys[j].protocol
+		}
+		return keys[i].op < keys[j].op
+	})
+
+	header := func(name, kind, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	labels := func(key opKey) string {
+		return fmt.Sprintf(`protocol="%s",op="%s"`, key.protocol, key.op)
+	}
+
+	header("stonekv_ops_total", "counter", "Operations served, by protocol and operation.")
+	for _, key := range keys {
+		fmt.Fprintf(w, "stonekv_ops_total{%s} %d\n", labels(key), ops[key].count)
+	}
+	header("stonekv_op_errors_total", "counter", "Operations that failed on the server side.")
+	for _, key := range keys {
+		fmt.Fprintf(w, "stonekv_op_errors_total{%s} %d\n", labels(key), ops[key].errors)
+	}
+	header("stonekv_op_duration_seconds", "histogram", "Time taken to serve operations.")
+	for _, key := range keys {
+		om := ops[key]
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += om.buckets[i]
+			fmt.Fprintf(w, "stonekv_op_duration_seconds_bucket{%s,le=\"%s\"} %d\n",
+				labels(key), strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "stonekv_op_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels(key), om.count)
+		fmt.Fprintf(w, "stonekv_op_duration_seconds_sum{%s} %g\n", labels(key), om.sum)
+		fmt.Fprintf(w, "stonekv_op_duration_seconds_count{%s} %d\n", labels(key), om.count)
+	}
+
+	stats := m.store.Stats()
+	header("stonekv_keys", "gauge", "Live keys in the store.")
+	fmt.Fprintf(w, "stonekv_keys %d\n", stats.Keys)
+	header("stonekv_seq", "counter", "Sequence number of the last record written.")
+	fmt.Fprintf(w, "stonekv_seq %d\n", stats.Seq)
+	header("stonekv_file_size_bytes", "gauge", "Size of the log file.")
+	fmt.Fprintf(w, "stonekv_file_size_bytes %d\n", stats.FileSize)
+	header("stonekv_live_bytes", "gauge", "Bytes of the log holding the records of live keys.")
+	fmt.Fprintf(w, "stonekv_live_bytes %d\n", stats.LiveBytes)
+	header("stonekv_dead_bytes", "gauge", "Bytes of the log a compaction would reclaim.")
+	fmt.Fprintf(w, "stonekv_dead_bytes %d\n", stats.DeadBytes)
+	header("stonekv_fragmentation_ratio", "gauge", "Share of the log that is dead, from 0 to 1.")
+	fmt.Fprintf(w, "stonekv_fragmentation_ratio %g\n", stats.Fragmentation)
+	header("stonekv_index_memory_bytes", "gauge", "Estimated memory taken by the index.")
+	fmt.Fprintf(w, "stonekv_index_memory_bytes %d\n", stats.IndexMemory)
+	header("stonekv_compactions_total", "counter", "Compactions since the store was opened.")
+	fmt.Fprintf(w, "stonekv_compactions_total %d\n", stats.Compactions)
+	header("stonekv_evictions_total", "counter", "Keys evicted since the store was opened.")
+	fmt.Fprintf(w, "stonekv_evictions_total %d\n", stats.Evictions)
+	header("stonekv_store_op_duration_seconds", "summary", "Time taken by store operations, whether served or called in process.")
+	names := make([]string, 0, len(stats.Latency))
+	for name := range stats.Latency {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		l := stats.Latency[name]
+		for _, q := range []struct {
+			quantile string
+			d        time.Duration
+		}{{"0.5", l.P50}, {"0.95", l.P95}, {"0.99", l.P99}} {
+			fmt.Fprintf(w, "stonekv_store_op_duration_seconds{op=\"%s\",quantile=\"%s\"} %g\n", name, q.quantile, q.d.Seconds())
+		}
+		fmt.Fprintf(w, "stonekv_store_op_duration_seconds_sum{op=\"%s\"} %g\n", name, l.Total.Seconds())
+		fmt.Fprintf(w, "stonekv_store_op_duration_seconds_count{op=\"%s\"} %d\n", name, l.Count)
+	}
+	if !stats.LastCompaction.IsZero() {
+		header("stonekv_last_compaction_timestamp_seconds", "gauge", "Unix time the last compaction finished.")
+		fmt.Fprintf(w, "stonekv_last_compaction_timestamp_seconds %d\n", stats.LastCompaction.Unix())
+	}
+}
+
+// statusWriter remembers the status code of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// measure wraps the handler of an HTTP operation to record it in Metrics.
+// Responses with a 5xx status count as failures.
+func (s *Server) measure(op string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.Metrics == nil {
+			h(w, r)
+			return
+		}
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		h(sw, r)
+		s.Metrics.observe("http", op, start, sw.status >= 500)
+	}
+}
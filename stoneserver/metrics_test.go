@@ -0,0 +1,66 @@
+package stoneserver
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	store := newStore(t)
+	metrics := NewMetrics(store)
+	srv := New(store)
+	srv.Metrics = metrics
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	resp := NewRESP(store)
+	resp.Metrics = metrics
+	c := dialRESP(t, resp)
+
+	req, _ := http.NewRequest(http.MethodPut, ts.URL+"/v1/keys/greeting", strings.NewReader("hello"))
+	if r, err := http.DefaultClient.Do(req); err == nil {
+		r.Body.Close()
+	}
+	for i := 0; i < 2; i++ {
+		if r, err := http.Get(ts.URL + "/v1/keys/missing"); err == nil {
+			r.Body.Close()
+		}
+	}
+	c.do("GET", "greeting")
+	c.do("FLUSHALL")
+
+	r, err := http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("GET /metrics failed: %v", err)
+	}
+	defer r.Body.Close()
+	data, _ := io.ReadAll(r.Body)
+	body := string(data)
+	for _, want := range []string{
+		`stonekv_ops_total{protocol="http",op="set"} 1`,
+		`stonekv_ops_total{protocol="http",op="get"} 2`,
+		`stonekv_op_errors_total{protocol="http",op="get"} 0`,
+		`stonekv_ops_total{protocol="resp",op="get"} 1`,
+		`stonekv_ops_total{protocol="resp",op="unknown"} 1`,
+		`stonekv_op_duration_seconds_bucket{protocol="http",op="get",le="+Inf"} 2`,
+		`stonekv_op_duration_seconds_count{protocol="resp",op="get"} 1`,
+		"stonekv_keys 1\n",
+		"stonekv_seq 1\n",
+		"stonekv_compactions_total 0\n",
+		"stonekv_evictions_total 0\n",
+		"stonekv_dead_bytes 0\n",
+		"stonekv_fragmentation_ratio 0\n",
+		`stonekv_store_op_duration_seconds_count{op="set"} 1`,
+		`stonekv_store_op_duration_seconds_count{op="get"} 3`,
+		`stonekv_store_op_duration_seconds{op="polish",quantile="0.99"} 0`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected metrics to contain %q, got:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "flushall") {
+		t.Error("expected unknown commands not to get their own metrics")
+	}
+}
@@ -0,0 +1,154 @@
+package stoneserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"slices"
+	"sync"
+
+	"github.com/cryptrunner49/stonekv/stone"
+)
+
+// respSubs are the channels a RESP connection is subscribed to. Messages
+// are pushed to the client by a goroutine per channel, so replies and
+// messages are written under mu.
+type respSubs struct {
+	conn net.Conn
+	w    *bufio.Writer
+
+	mu       sync.Mutex
+	channels map[string]context.CancelFunc
+}
+
+func newRESPSubs(conn net.Conn, w *bufio.Writer) *respSubs {
+	return &respSubs{conn: conn, w: w, channels: make(map[string]context.CancelFunc)}
+}
+
+// active reports whether the connection is in subscribed mode, in which it
+// only accepts the commands subscribedCommands lists. The caller must hold
+// mu.
+func (s *respSubs) active() bool {
+	return len(s.channels) > 0
+}
+
+// subscribedCommands are the commands allowed in subscribed mode.
+var subscribedCommands = map[string]bool{
+	"SUBSCRIBE": true, "UNSUBSCRIBE": true, "PING": true, "QUIT": true,
+}
+
+// closeAll cancels every subscription of a connection being closed.
+func (s *respSubs) closeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, cancel := range s.channels {
+		cancel()
+	}
+	clear(s.channels)
+}
+
+// publish implements PUBLISH channel message, replying with the number of
+// subscriptions of the store the message was sent to, over any protocol.
+// Publishing needs write permission on the channel name.
+func (s *RESPServer) publish(w *bufio.Writer, session *session, args [][]byte) error {
+	if !allowed(session.user, args[0], PermWrite) {
+		return respError("NOPERM this user has no permissions to access one of the channels used as arguments")
+	}
+	n, err := s.store.Publish(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	writeInt(w, n)
+	return nil
+}
+
+// subscribe implements SUBSCRIBE channel [channel ...], which needs read
+// permission on the channel names. Each channel is confirmed with the
+// number of channels the connection is subscribed to; its messages are
+// then pushed as they are published. A client too slow to keep up with
+// them is disconnected. The caller must hold the lock of session.subs.
+func (s *RESPServer) subscribe(w *bufio.Writer, session *session, args [][]byte) error {
+	for _, channel := range args {
+		if !allowed(session.user, channel, PermRead) {
+			return respError("NOPERM this user has no permissions to access one of the channels used as arguments")
+		}
+	}
+	subs := session.subs
+	for _, channel := range args {
+		if _, ok := subs.channels[string(channel)]; !ok {
+			ctx, cancel := context.WithCancel(context.Background())
+			messages, err := s.store.Subscribe(ctx, channel)
+			if err != nil {
+				cancel()
+				return err
+			}
+			subs.channels[string(channel)] = cancel
+			go subs.push(ctx, messages)
+		}
+		writeSubscription(w, "subscribe", channel, len(subs.channels))
+	}
+	return nil
+}
+
+// push writes the messages of a subscription to the client until it is
+// cancelled. If the store ends it first, e.g. because the client fell
+// behind, the connection is closed, as the client would otherwise wait
+// for messages in vain.
+func (s *respSubs) push(ctx context.Context, messages <-chan stone.Message) {
+	for msg := range messages {
+		s.mu.Lock()
+		if ctx.Err() != nil {
+			s.mu.Unlock()
+			continue // Unsubscribed while waiting for the lock
+		}
+		s.w.WriteString("*3\r\n")
+		writeBulk(s.w, []byte("message"))
+		writeBulk(s.w, msg.Channel)
+		writeBulk(s.w, msg.Payload)
+		err := s.w.Flush()
+		s.mu.Unlock()
+		if err != nil {
+			s.conn.Close()
+			return
+		}
+	}
+	if ctx.Err() == nil {
+		s.conn.Close()
+	}
+}
+
+// unsubscribe implements UNSUBSCRIBE [channel ...], every channel if none
+// is given. The caller must hold the lock of session.subs.
+func (s *RESPServer) unsubscribe(w *bufio.Writer, session *session, args [][]byte) error {
+	subs := session.subs
+	if len(args) == 0 {
+		for channel := range subs.channels {
+			args = append(args, []byte(channel))
+		}
+		slices.SortFunc(args, bytes.Compare)
+		if len(args) == 0 {
+			w.WriteString("*3\r\n")
+			writeBulk(w, []byte("unsubscribe"))
+			w.WriteString("$-1\r\n:0\r\n")
+			return nil
+		}
+	}
+	for _, channel := range args {
+		if cancel, ok := subs.channels[string(channel)]; ok {
+			cancel()
+			delete(subs.channels, string(channel))
+		}
+		writeSubscription(w, "unsubscribe", channel, len(subs.channels))
+	}
+	return nil
+}
+
+// writeSubscription confirms a change of subscription to channel, after
+// which the connection is subscribed to n channels.
+func writeSubscription(w *bufio.Writer, kind string, channel []byte, n int) {
+	w.WriteString("*3\r\n")
+	writeBulk(w, []byte(kind))
+	writeBulk(w, channel)
+	writeInt(w, n)
+}
@@ -0,0 +1,51 @@
+package stoneserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRESPPubSub(t *testing.T) {
+	store := newStore(t)
+	srv := NewRESP(store)
+	sub, pub := dialRESP(t, srv), dialRESP(t, srv)
+
+	if got := sub.do("SUBSCRIBE", "news", "alerts"); got != `["subscribe" "news" 1]` {
+		t.Fatalf("unexpected reply to SUBSCRIBE %s", got)
+	}
+	if got := sub.read(); got != `["subscribe" "alerts" 2]` {
+		t.Fatalf("unexpected confirmation %s", got)
+	}
+	if got := sub.do("GET", "a"); got != "ERR:ERR Can't execute 'get': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context" {
+		t.Errorf("expected commands to be refused in subscribed mode, got %s", got)
+	}
+	if got := sub.do("PING"); got != `["pong" ""]` {
+		t.Errorf("unexpected reply to PING in subscribed mode %s", got)
+	}
+
+	if got := pub.do("PUBLISH", "news", "hello"); got != "1" {
+		t.Errorf("expected 1 subscription, got %s", got)
+	}
+	sub.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if got := sub.read(); got != `["message" "news" "hello"]` {
+		t.Errorf("unexpected message %s", got)
+	}
+	// Messages published by the application reach RESP subscribers too
+	store.Publish([]byte("alerts"), []byte("disk full"))
+	if got := sub.read(); got != `["message" "alerts" "disk full"]` {
+		t.Errorf("unexpected message %s", got)
+	}
+
+	if got := sub.do("UNSUBSCRIBE", "news"); got != `["unsubscribe" "news" 1]` {
+		t.Errorf("unexpected reply to UNSUBSCRIBE %s", got)
+	}
+	if got := sub.do("UNSUBSCRIBE"); got != `["unsubscribe" "alerts" 0]` {
+		t.Errorf("unexpected reply to UNSUBSCRIBE %s", got)
+	}
+	if got := sub.do("GET", "a"); got != "nil" {
+		t.Errorf("expected commands to be accepted once unsubscribed, got %s", got)
+	}
+	if got := pub.do("PUBLISH", "news", "again"); got != "0" {
+		t.Errorf("expected no subscriptions left, got %s", got)
+	}
+}
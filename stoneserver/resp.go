@@ -0,0 +1,633 @@
+package stoneserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cryptrunner49/stonekv/stone"
+)
+
+const (
+	maxRESPArgs      = 1 << 20 // Most arguments accepted in one command
+	maxInlineBytes   = 64 << 10
+	defaultScanCount = 10
+	maxCursors       = 4096 // Open SCAN cursors kept before the oldest are dropped
+)
+
+// RESPServer serves a store over the Redis protocol (RESP2), so existing
+// Redis clients can use it unchanged. It supports the commands GET, SET,
+// DEL, EXISTS, SCAN, TTL and PTTL on database 0, PUBLISH, SUBSCRIBE and
+// UNSUBSCRIBE, along with AUTH, PING, ECHO, SELECT and QUIT. Keys never
+// expire, so SET rejects expiry options and TTL returns -1 for every
+// existing key.
+//
+// Channels are those of stone.Store.Publish, so RESP clients receive the
+// messages published over gRPC or by the application embedding the store,
+// and the other way around.
+//
+// SCAN cursors are remembered by the server, so a scan can continue on
+// another connection; only the most recent cursors are kept, and an expired
+// one returns an error.
+type RESPServer struct {
+	// ACL, if set, limits the server to its users. Clients authenticate
+	// with AUTH, passing their token as the password and optionally their
+	// name as the username. Set it before serving connections.
+	ACL *ACL
+	// Limits bounds the commands and bytes of clients. Set it before
+	// serving connections.
+	Limits Limits
+	// Metrics, if set, records the commands served. Set it before serving
+	// connections.
+	Metrics *Metrics
+
+	store *stone.Store
+	track tracker
+
+	mu         sync.Mutex
+	cursors    map[uint64]string // Last key returned, by cursor
+	order      []uint64          // Cursors from oldest to newest
+	nextCursor uint64
+}
+
+// NewRESP returns a RESP server for store. The store stays owned by the
+// caller, who must keep it open while the server is in use.
+func NewRESP(store *stone.Store) *RESPServer {
+	return &RESPServer{store: store, cursors: make(map[uint64]string)}
+}
+
+// Serve accepts connections on ln and serves each of them in its own
+// goroutine. It returns when ln is closed, or ErrServerClosed after
+// Shutdown.
+func (s *RESPServer) Serve(ln net.Listener) error {
+	if !s.track.addListener(ln) {
+		return ErrServerClosed
+	}
+	defer s.track.removeListener(ln)
+	limits := newLimiter(s.Limits)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if s.track.shuttingDown() {
+				return ErrServerClosed
+			}
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+		if !s.track.addConn(conn) {
+			conn.Close()
+			continue
+		}
+		go s.serveConn(conn, limits)
+	}
+}
+
+// Shutdown stops the server gracefully: it closes the listeners, lets
+// connections finish the commands they have sent, and closes them once
+// idle. If ctx is done first, the remaining connections are closed and
+// ctx.Err() is returned. The store is left open.
+func (s *RESPServer) Shutdown(ctx context.Context) error {
+	return s.track.shutdown(ctx)
+}
+
+// errQuit ends a connection after the reply to QUIT.
+var errQuit = errors.New("quit")
+
+// respError is an error reply to a command. The connection stays usable.
+type respError string
+
+func (e respError) Error() string { return string(e) }
+
+// serveConn reads commands from conn until it is closed. Replies are
+// flushed once no more pipelined commands are buffered.
+func (s *RESPServer) serveConn(conn net.Conn, limits *limiter) {
+	defer s.track.removeConn(conn)
+	defer conn.Close()
+	tracked := conn
+	conn, client := limitConn(limits, conn)
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	session := &session{authed: s.ACL == nil, subs: newRESPSubs(tracked, w)}
+	defer session.subs.closeAll()
+	for {
+		// Between commands the connection is idle, and Shutdown may close it
+		if r.Buffered() == 0 && !s.track.setIdle(tracked, true) {
+			return
+		}
+		args, err := readCommand(r)
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				writeRESPError(w, "ERR Protocol error: "+err.Error())
+				w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		s.track.setIdle(tracked, false)
+		client.waitRequest()
+		start := time.Now()
+		// Subscriptions push messages to w concurrently
+		session.subs.mu.Lock()
+		err = s.exec(w, session, args)
+		var reply respError
+		failed := false
+		if errors.As(err, &reply) {
+			writeRESPError(w, string(reply))
+		} else if err != nil && err != errQuit {
+			writeRESPError(w, "ERR "+err.Error())
+			failed = true
+		}
+		s.Metrics.observe("resp", respOp(args[0]), start, failed)
+		var flushErr error
+		flush := r.Buffered() == 0 || err == errQuit
+		if flush {
+			flushErr = w.Flush()
+		}
+		session.subs.mu.Unlock()
+		if flush && (flushErr != nil || err == errQuit) {
+			return
+		}
+	}
+}
+
+// respCommands are the commands exec implements.
+var respCommands = map[string]bool{
+	"auth": true, "ping": true, "echo": true, "quit": true, "select": true, "hello": true, "command": true,
+	"get": true, "set": true, "del": true, "exists": true, "ttl": true, "pttl": true, "scan": true,
+	"publish": true, "subscribe": true, "unsubscribe": true,
+}
+
+// respOp returns the operation name metrics are kept under for a command.
+// Unknown commands share one name, so clients can't create metrics at will.
+func respOp(name []byte) string {
+	op := strings.ToLower(string(name))
+	if !respCommands[op] {
+		return "unknown"
+	}
+	return op
+}
+
+// exec runs a command and writes its reply. Errors returned are written
+// as error replies by the caller.
+func (s *RESPServer) exec(w *bufio.Writer, session *session, args [][]byte) error {
+	name := strings.ToUpper(string(args[0]))
+	args = args[1:]
+	arity := func(min, max int) error {
+		if len(args) < min || (max >= 0 && len(args) > max) {
+			return respError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+		}
+		return nil
+	}
+	// check returns an error unless the session has perm on every key
+	check := func(perm Permission, keys ...[]byte) error {
+		for _, key := range keys {
+			if !allowed(session.user, key, perm) {
+				return respError("NOPERM this user has no permissions to access one of the keys used as arguments")
+			}
+		}
+		return nil
+	}
+
+	if !session.authed && name != "AUTH" && name != "HELLO" && name != "QUIT" {
+		return respError("NOAUTH Authentication required.")
+	}
+	if session.subs.active() && !subscribedCommands[name] {
+		return respError(fmt.Sprintf("ERR Can't execute '%.64s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context", strings.ToLower(name)))
+	}
+	switch name {
+	case "AUTH":
+		if err := arity(1, 2); err != nil {
+			return err
+		}
+		if s.ACL == nil {
+			return respError("ERR AUTH called without any password configured")
+		}
+		user, ok := s.ACL.Authenticate(string(args[len(args)-1]))
+		if !ok || (len(args) == 2 && string(args[0]) != user.Name) {
+			return respError("WRONGPASS invalid username-password pair or user is disabled.")
+		}
+		session.authed, session.user = true, user
+		w.WriteString("+OK\r\n")
+	case "PING":
+		if err := arity(0, 1); err != nil {
+			return err
+		}
+		switch {
+		case session.subs.active():
+			// Replies in subscribed mode are arrays, like messages
+			w.WriteString("*2\r\n")
+			writeBulk(w, []byte("pong"))
+			writeBulk(w, bytes.Join(args, nil))
+		case len(args) == 1:
+			writeBulk(w, args[0])
+		default:
+			w.WriteString("+PONG\r\n")
+		}
+	case "ECHO":
+		if err := arity(1, 1); err != nil {
+			return err
+		}
+		writeBulk(w, args[0])
+	case "QUIT":
+		w.WriteString("+OK\r\n")
+		return errQuit
+	case "SELECT":
+		if err := arity(1, 1); err != nil {
+			return err
+		}
+		if string(args[0]) != "0" {
+			return respError("ERR DB index is out of range")
+		}
+		w.WriteString("+OK\r\n")
+	case "HELLO":
+		return respError("NOPROTO sorry, this protocol version is not supported")
+	case "COMMAND":
+		w.WriteString("*0\r\n")
+	case "GET":
+		if err := arity(1, 1); err != nil {
+			return err
+		}
+		if err := check(PermRead, args[0]); err != nil {
+			return err
+		}
+		value, err := s.store.Get(args[0])
+		if isNotFound(err) {
+			w.WriteString("$-1\r\n")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		writeBulk(w, value)
+	case "SET":
+		if err := arity(2, -1); err != nil {
+			return err
+		}
+		if len(args) > 2 {
+			return respError("ERR SET options are not supported")
+		}
+		if err := check(PermWrite, args[0]); err != nil {
+			return err
+		}
+		err := s.store.Set(args[0], args[1])
+		if err != nil {
+			return err
+		}
+		w.WriteString("+OK\r\n")
+	case "DEL":
+		if err := arity(1, -1); err != nil {
+			return err
+		}
+		if err := check(PermWrite, args...); err != nil {
+			return err
+		}
+		// Delete doesn't report whether the key existed, so look it up
+		// first for the count
+		var n int
+		for _, key := range args {
+			_, err := s.store.Get(key)
+			if isNotFound(err) {
+				continue
+			}
+			if err == nil {
+				err = s.store.Delete(key)
+			}
+			if err != nil {
+				return err
+			}
+			n++
+		}
+		writeInt(w, n)
+	case "EXISTS":
+		if err := arity(1, -1); err != nil {
+			return err
+		}
+		if err := check(PermRead, args...); err != nil {
+			return err
+		}
+		var n int
+		for _, key := range args {
+			_, err := s.store.Get(key)
+			if err == nil {
+				n++
+			} else if !isNotFound(err) {
+				return err
+			}
+		}
+		writeInt(w, n)
+	case "TTL", "PTTL":
+		if err := arity(1, 1); err != nil {
+			return err
+		}
+		if err := check(PermRead, args[0]); err != nil {
+			return err
+		}
+		_, err := s.store.Get(args[0])
+		if isNotFound(err) {
+			writeInt(w, -2)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		writeInt(w, -1)
+	case "SCAN":
+		return s.scan(w, session, args)
+	case "PUBLISH":
+		if err := arity(2, 2); err != nil {
+			return err
+		}
+		return s.publish(w, session, args)
+	case "SUBSCRIBE":
+		if err := arity(1, -1); err != nil {
+			return err
+		}
+		return s.subscribe(w, session, args)
+	case "UNSUBSCRIBE":
+		return s.unsubscribe(w, session, args)
+	default:
+		return respError(fmt.Sprintf("ERR unknown command '%.64s'", strings.ToLower(name)))
+	}
+	return nil
+}
+
+// scan implements SCAN cursor [MATCH pattern] [COUNT count]. COUNT bounds
+// the keys examined per call, as in Redis, so a call may return fewer keys
+// than COUNT, or none, before the scan is complete. Keys the session may
+// not read are skipped.
+func (s *RESPServer) scan(w *bufio.Writer, session *session, args [][]byte) error {
+	if len(args) == 0 || len(args)%2 == 0 {
+		return respError("ERR wrong number of arguments for 'scan' command")
+	}
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 64)
+	if err != nil {
+		return respError("ERR invalid cursor")
+	}
+	var pattern []byte
+	count := defaultScanCount
+	for i := 1; i < len(args); i += 2 {
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			count, err = strconv.Atoi(string(args[i+1]))
+			if err != nil || count <= 0 {
+				return respError("ERR value is not an integer or out of range")
+			}
+		default:
+			return respError("ERR syntax error")
+		}
+	}
+
+	after := ""
+	if cursor != 0 {
+		var ok bool
+		after, ok = s.cursor(cursor)
+		if !ok {
+			return respError("ERR invalid cursor")
+		}
+	}
+
+	var keys [][]byte
+	var examined int
+	var last string
+	err = s.store.Scan(globPrefix(pattern), func(key, value []byte) error {
+		if string(key) <= after {
+			return nil
+		}
+		if examined == count {
+			return errScanFull
+		}
+		examined++
+		last = string(key)
+		if (pattern == nil || matchGlob(pattern, key)) && allowed(session.user, key, PermRead) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil && err != errScanFull {
+		return err
+	}
+
+	next := uint64(0)
+	if err == errScanFull {
+		next = s.newCursor(last)
+	}
+	w.WriteString("*2\r\n")
+	writeBulk(w, []byte(strconv.FormatUint(next, 10)))
+	fmt.Fprintf(w, "*%d\r\n", len(keys))
+	for _, key := range keys {
+		writeBulk(w, key)
+	}
+	return nil
+}
+
+// cursor returns the last key returned before cursor.
+func (s *RESPServer) cursor(cursor uint64) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.cursors[cursor]
+	return key, ok
+}
+
+// newCursor returns a cursor continuing a scan after key, dropping the
+// oldest cursor if too many are open.
+func (s *RESPServer) newCursor(key string) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.order) == maxCursors {
+		delete(s.cursors, s.order[0])
+		s.order = s.order[1:]
+	}
+	s.nextCursor++
+	s.cursors[s.nextCursor] = key
+	s.order = append(s.order, s.nextCursor)
+	return s.nextCursor
+}
+
+// readCommand reads a command sent as an array of bulk strings, or as an
+// inline command separated by spaces, as typed into telnet.
+func readCommand(r *bufio.Reader) ([][]byte, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		var args [][]byte
+		for _, field := range strings.Fields(string(line)) {
+			args = append(args, []byte(field))
+		}
+		return args, nil
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > maxRESPArgs {
+		return nil, fmt.Errorf("invalid multibulk length")
+	}
+	args := make([][]byte, 0, min(n, 64))
+	for i := 0; i < n; i++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("expected '$', got %q", line)
+		}
+		size, err := strconv.Atoi(string(line[1:]))
+		if err != nil || size < 0 || size > maxValueBytes {
+			return nil, fmt.Errorf("invalid bulk length")
+		}
+		arg := make([]byte, size+2)
+		_, err = io.ReadFull(r, arg)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if arg[size] != '\r' || arg[size+1] != '\n' {
+			return nil, fmt.Errorf("bulk string not terminated by CRLF")
+		}
+		args = append(args, arg[:size])
+	}
+	return args, nil
+}
+
+// readLine reads a line terminated by CRLF or LF, without the terminator.
+func readLine(r *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, chunk...)
+		if len(line) > maxInlineBytes {
+			return nil, fmt.Errorf("too big inline request")
+		}
+		if !isPrefix {
+			return line, nil
+		}
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func writeBulk(w *bufio.Writer, b []byte) {
+	fmt.Fprintf(w, "$%d\r\n", len(b))
+	w.Write(b)
+	w.WriteString("\r\n")
+}
+
+func writeInt(w *bufio.Writer, n int) {
+	fmt.Fprintf(w, ":%d\r\n", n)
+}
+
+// writeRESPError writes an error reply. Line breaks would end the reply
+// early, so they are replaced by spaces.
+func writeRESPError(w *bufio.Writer, msg string) {
+	msg = strings.NewReplacer("\r", " ", "\n", " ").Replace(msg)
+	w.WriteString("-" + msg + "\r\n")
+}
+
+// globPrefix returns the literal prefix of a Redis glob pattern, which
+// every key it matches starts with.
+func globPrefix(pattern []byte) []byte {
+	i := bytes.IndexAny(pattern, `*?[\`)
+	if i < 0 {
+		return pattern
+	}
+	return pattern[:i]
+}
+
+// matchGlob reports whether s matches a Redis glob pattern: * matches any
+// sequence, ? any single byte, [abc], [^abc] and [a-z] a byte in or out of
+// a set, and a backslash escapes the next byte.
+func matchGlob(pattern, s []byte) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if matchGlob(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			var ok bool
+			ok, pattern = matchClass(pattern[1:], s[0])
+			if !ok {
+				return false
+			}
+			s = s[1:]
+		default:
+			if pattern[0] == '\\' && len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		}
+	}
+	return len(s) == 0
+}
+
+// matchClass matches c against the character class at the start of
+// pattern, just after its '['. It returns the pattern after the class.
+func matchClass(pattern []byte, c byte) (bool, []byte) {
+	negate := len(pattern) > 0 && pattern[0] == '^'
+	if negate {
+		pattern = pattern[1:]
+	}
+	match := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		lo := pattern[0]
+		if lo == '\\' && len(pattern) > 1 {
+			pattern = pattern[1:]
+			lo = pattern[0]
+		}
+		hi := lo
+		if len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']' {
+			hi = pattern[2]
+			pattern = pattern[2:]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+		}
+		if lo <= c && c <= hi {
+			match = true
+		}
+		pattern = pattern[1:]
+	}
+	if len(pattern) > 0 {
+		pattern = pattern[1:] // Skip the closing ']'
+	}
+	return match != negate, pattern
+}
@@ -0,0 +1,188 @@
+package stoneserver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cryptrunner49/stonekv/stone"
+)
+
+// respClient sends commands to a RESP server and reads replies in a
+// readable form: simple strings and integers as is, bulk strings quoted,
+// errors prefixed by "ERR:" and arrays in brackets.
+type respClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func startRESP(t *testing.T) (*stone.Store, *respClient) {
+	t.Helper()
+	store := newStore(t)
+	return store, dialRESP(t, NewRESP(store))
+}
+
+// dialRESP serves srv on a local port and connects to it.
+func dialRESP(t *testing.T, srv *RESPServer) *respClient {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go srv.Serve(ln)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &respClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// do sends a command and returns the reply.
+func (c *respClient) do(args ...string) string {
+	c.t.Helper()
+	fmt.Fprintf(c.conn, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.conn, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return c.read()
+}
+
+func (c *respClient) read() string {
+	c.t.Helper()
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatalf("failed to read reply: %v", err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch line[0] {
+	case '+', ':':
+		return line[1:]
+	case '-':
+		return "ERR:" + line[1:]
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return "nil"
+		}
+		data := make([]byte, n+2)
+		io.ReadFull(c.r, data)
+		return strconv.Quote(string(data[:n]))
+	case '*':
+		n, _ := strconv.Atoi(line[1:])
+		items := make([]string, n)
+		for i := range items {
+			items[i] = c.read()
+		}
+		return "[" + strings.Join(items, " ") + "]"
+	}
+	c.t.Fatalf("invalid reply %q", line)
+	return ""
+}
+
+func TestRESPCommands(t *testing.T) {
+	store, c := startRESP(t)
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"PING"}, "PONG"},
+		{[]string{"set", "greeting", "hello world"}, "OK"},
+		{[]string{"GET", "greeting"}, `"hello world"`},
+		{[]string{"GET", "missing"}, "nil"},
+		{[]string{"EXISTS", "greeting", "missing", "greeting"}, "2"},
+		{[]string{"TTL", "greeting"}, "-1"},
+		{[]string{"TTL", "missing"}, "-2"},
+		{[]string{"SET", "a", "1"}, "OK"},
+		{[]string{"DEL", "a", "missing"}, "1"},
+		{[]string{"SET", "a", "1", "EX", "10"}, "ERR:ERR SET options are not supported"},
+		{[]string{"GET"}, "ERR:ERR wrong number of arguments for 'get' command"},
+		{[]string{"FLUSHALL"}, "ERR:ERR unknown command 'flushall'"},
+		{[]string{"SELECT", "1"}, "ERR:ERR DB index is out of range"},
+	}
+	for _, tt := range tests {
+		if got := c.do(tt.args...); got != tt.want {
+			t.Errorf("%v: expected %s, got %s", tt.args, tt.want, got)
+		}
+	}
+	if value, err := store.Get([]byte("greeting")); err != nil || string(value) != "hello world" {
+		t.Errorf("expected 'hello world' in store, got %q (%v)", value, err)
+	}
+
+	// Inline commands and pipelining
+	fmt.Fprint(c.conn, "PING\r\nECHO hi\r\n")
+	if got := c.read() + " " + c.read(); got != `PONG "hi"` {
+		t.Errorf("unexpected inline replies %s", got)
+	}
+
+	if got := c.do("QUIT"); got != "OK" {
+		t.Errorf("expected OK for QUIT, got %s", got)
+	}
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Errorf("expected connection to be closed after QUIT, got %v", err)
+	}
+}
+
+func TestRESPScan(t *testing.T) {
+	store, c := startRESP(t)
+	for i := 0; i < 25; i++ {
+		store.Set([]byte(fmt.Sprintf("user:%02d", i)), []byte("v"))
+		store.Set([]byte(fmt.Sprintf("order:%02d", i)), []byte("v"))
+	}
+
+	var keys []string
+	cursor := "0"
+	for calls := 0; calls < 10; calls++ {
+		reply := c.do("SCAN", cursor, "MATCH", "user:*", "COUNT", "10")
+		fields := strings.Fields(strings.Trim(reply, "[]"))
+		if len(fields) == 0 {
+			t.Fatalf("unexpected reply %s", reply)
+		}
+		cursor, _ = strconv.Unquote(fields[0])
+		for _, key := range fields[1:] {
+			keys = append(keys, strings.Trim(key, `"[]`))
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	if cursor != "0" || len(keys) != 25 || keys[0] != "user:00" || keys[24] != "user:24" {
+		t.Errorf("unexpected scan result, cursor %s, keys %v", cursor, keys)
+	}
+
+	if got := c.do("SCAN", "12345"); got != "ERR:ERR invalid cursor" {
+		t.Errorf("expected invalid cursor error, got %s", got)
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"*", "anything", true},
+		{"user:*", "user:1", true},
+		{"user:*", "order:1", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{`a\*b`, "a*b", true},
+		{`a\*b`, "axb", false},
+		{"*:*:end", "a:b:c:end", true},
+	}
+	for _, tt := range tests {
+		if got := matchGlob([]byte(tt.pattern), []byte(tt.s)); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, expected %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,588 @@
+// Package stoneserver exposes a StoneKV store over HTTP, so services written
+// in other languages can use it. RESPServer and MemcacheServer serve it over
+// the Redis and memcached protocols as well.
+//
+// Keys are addressed by path under /v1/keys/ and values are sent and
+// returned as raw request and response bodies:
+//
+//	GET    /v1/keys/{key}    Value of key, 404 if it doesn't exist
+//	PUT    /v1/keys/{key}    Set key to the request body, 507 over the quota
+//	DELETE /v1/keys/{key}    Delete key
+//	GET    /v1/keys?prefix=&after=&limit=    Scan keys in order, as JSON
+//	POST   /v1/batch         Apply several operations, as JSON
+//	POST   /v1/locks/{key}?ttl=    Take an advisory lock, as JSON its token, 409 if it is held
+//	DELETE /v1/locks/{key}?token=  Release an advisory lock, 409 if it isn't held with token
+//	GET    /v1/watch?prefix=&since=    Stream changes over a WebSocket
+//	GET    /metrics          Prometheus metrics, if Metrics is set
+//	GET    /healthz          Liveness probe, 503 if the store can't serve
+//	GET    /readyz           Readiness probe, 503 if the disk isn't writable either
+//
+// In JSON, keys are strings and values are base64-encoded. Errors are
+// returned as {"error": "..."} with a matching status code.
+//
+// GET returns the version of the key as its ETag. A PUT with If-Match set
+// to it fails with 412 if the key was written since, and one with
+// If-None-Match: * if the key exists, so clients can update keys without
+// overwriting each other.
+//
+// Admin endpoints let operators maintain the store without shell access:
+//
+//	GET  /v1/admin/stats      Sequence number, live keys and bytes, as JSON
+//	GET  /v1/admin/compact    What a Polish would reclaim and take, as JSON
+//	POST /v1/admin/compact    Polish the store
+//	POST /v1/admin/backup     Back the store up into BackupDir
+//	GET  /v1/admin/backups    List the backups in BackupDir
+//	POST /v1/admin/verify     Verify the store, or a backup in BackupDir
+//
+// With an ACL, only admin users may use them and /metrics. The probes need
+// no authentication.
+//
+// Every server can be limited to the users of an ACL, each allowed to read
+// or write the keys under some prefixes, and rate limited with Limits.
+package stoneserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cryptrunner49/stonekv/stone"
+)
+
+const (
+	maxValueBytes    = 32 << 20 // Largest value accepted by PUT
+	maxBatchBytes    = 64 << 20 // Largest batch request body
+	defaultScanLimit = 1000
+	maxScanLimit     = 10000
+)
+
+// Server is an http.Handler serving a store.
+type Server struct {
+	// ACL, if set, limits the server to its users. Clients authenticate
+	// with their token as a bearer token, or as the password of basic
+	// authentication. Set it before serving requests.
+	ACL *ACL
+	// Limits bounds the requests and bytes of clients, identified by their
+	// IP address. Set it before serving requests.
+	Limits Limits
+	// Metrics, if set, records the requests served and is served at
+	// /metrics. Set it before serving requests.
+	Metrics *Metrics
+	// BackupDir is the directory the admin endpoints write backups to and
+	// verify them in. Backups are disabled if it is empty.
+	BackupDir string
+
+	store *stone.Store
+	mux   *http.ServeMux
+
+	limitOnce sync.Once
+	limits    *limiter
+	clientsMu sync.Mutex
+	clients   map[string]*clientLimiter // By remote IP address
+
+	shutdownMu sync.Mutex
+	httpServer *http.Server   // Created by Serve
+	closing    chan struct{}  // Closed by Shutdown
+	watchers   sync.WaitGroup // WebSocket watches in progress
+}
+
+// New returns a server for store. The store stays owned by the caller,
+// who must keep it open while the server is in use.
+func New(store *stone.Store) *Server {
+	s := &Server{
+		store:   store,
+		mux:     http.NewServeMux(),
+		closing: make(chan struct{}),
+	}
+	s.mux.HandleFunc("GET /v1/keys/{key...}", s.measure("get", s.handleGet))
+	s.mux.HandleFunc("PUT /v1/keys/{key...}", s.measure("set", s.handlePut))
+	s.mux.HandleFunc("DELETE /v1/keys/{key...}", s.measure("delete", s.handleDelete))
+	s.mux.HandleFunc("GET /v1/keys", s.measure("scan", s.handleScan))
+	s.mux.HandleFunc("POST /v1/batch", s.measure("batch", s.handleBatch))
+	s.mux.HandleFunc("POST /v1/locks/{key...}", s.measure("lock", s.handleLock))
+	s.mux.HandleFunc("DELETE /v1/locks/{key...}", s.measure("unlock", s.handleUnlock))
+	s.mux.HandleFunc("GET /v1/watch", s.handleWatch)
+	s.mux.HandleFunc("GET /metrics", s.admin(s.handleMetrics))
+	s.mux.HandleFunc("GET /healthz", s.handleHealthz)
+	s.mux.HandleFunc("GET /readyz", s.handleReadyz)
+	s.registerAdmin()
+	return s
+}
+
+// Serve serves HTTP on ln. It is an alternative to passing the server to
+// an http.Server, which also lets Shutdown wait for the requests in
+// flight. It returns ErrServerClosed after Shutdown.
+func (s *Server) Serve(ln net.Listener) error {
+	s.shutdownMu.Lock()
+	select {
+	case <-s.closing:
+		s.shutdownMu.Unlock()
+		return ErrServerClosed
+	default:
+	}
+	if s.httpServer == nil {
+		s.httpServer = &http.Server{Handler: s}
+	}
+	srv := s.httpServer
+	s.shutdownMu.Unlock()
+
+	err := srv.Serve(ln)
+	if errors.Is(err, http.ErrServerClosed) {
+		return ErrServerClosed
+	}
+	return err
+}
+
+// Shutdown stops the server gracefully. WebSocket watches are closed with
+// code 1001 and new ones are refused. If the server was started with Serve,
+// its listeners are closed and Shutdown waits for the requests in flight,
+// as http.Server.Shutdown does; otherwise the caller shuts down its own
+// http.Server. If ctx is done first, ctx.Err() is returned. The store is
+// left open.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shutdownMu.Lock()
+	select {
+	case <-s.closing:
+	default:
+		close(s.closing)
+	}
+	srv := s.httpServer
+	s.shutdownMu.Unlock()
+
+	var err error
+	if srv != nil {
+		err = srv.Shutdown(ctx)
+	}
+	done := make(chan struct{})
+	go func() {
+		s.watchers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// maxHTTPClients is the number of clients whose limits are tracked before
+// idle ones are dropped.
+const maxHTTPClients = 1024
+
+// ServeHTTP dispatches a request to the endpoint it addresses.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.Limits == (Limits{}) {
+		s.mux.ServeHTTP(w, r)
+		return
+	}
+
+	client := s.client(r)
+	if ok, retry := client.allowRequest(); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+		writeError(w, http.StatusTooManyRequests, "rate limit exceeded")
+		return
+	}
+	body := &countingReader{ReadCloser: r.Body}
+	r.Body = body
+	cw := &countingWriter{ResponseWriter: w}
+	s.mux.ServeHTTP(cw, r)
+	client.chargeBytes(body.n + cw.n)
+}
+
+// client returns the limiter of the client sending r.
+func (s *Server) client(r *http.Request) *clientLimiter {
+	s.limitOnce.Do(func() {
+		s.limits = newLimiter(s.Limits)
+		s.clients = make(map[string]*clientLimiter)
+	})
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	client, ok := s.clients[ip]
+	if !ok {
+		if len(s.clients) >= maxHTTPClients {
+			for ip, c := range s.clients {
+				if c.idle() {
+					delete(s.clients, ip)
+				}
+			}
+		}
+		client = s.limits.client()
+		s.clients[ip] = client
+	}
+	return client
+}
+
+// countingReader counts the bytes read from a request body.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// countingWriter counts the bytes of a response body.
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to
+// hijack the connection of a WebSocket.
+func (w *countingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
+	key, ok := s.authorize(w, r, PermRead)
+	if !ok {
+		return
+	}
+	value, version, err := s.store.GetVersion(key)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("ETag", etag(version))
+	if r.Header.Get("If-None-Match") == etag(version) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(value)))
+	w.Write(value)
+}
+
+func (s *Server) handlePut(w http.ResponseWriter, r *http.Request) {
+	key, ok := s.authorize(w, r, PermWrite)
+	if !ok {
+		return
+	}
+	value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxValueBytes))
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	version, conditional, err := precondition(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if conditional {
+		version, err = s.store.SetIfVersion(key, value, version)
+		if err == nil {
+			w.Header().Set("ETag", etag(version))
+		}
+	} else {
+		err = s.store.Set(key, value)
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// etag returns the ETag header of a key with the given version.
+func etag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
+
+// precondition returns the version a PUT requires the key to have, from
+// an If-Match header with the ETag of a GET, or 0 for If-None-Match: *, so
+// that it only creates the key, and whether there is one.
+func precondition(r *http.Request) (uint64, bool, error) {
+	if match := r.Header.Get("If-Match"); match != "" {
+		version, err := strconv.ParseUint(strings.Trim(match, `"`), 10, 64)
+		if err != nil || version == 0 || match != etag(version) {
+			return 0, false, fmt.Errorf("invalid If-Match header %q, expected a single ETag", match)
+		}
+		return version, true, nil
+	}
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if match != "*" {
+			return 0, false, fmt.Errorf("invalid If-None-Match header %q, only * is supported", match)
+		}
+		return 0, true, nil
+	}
+	return 0, false, nil
+}
+
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
+	key, ok := s.authorize(w, r, PermWrite)
+	if !ok {
+		return
+	}
+	err := s.store.Delete(key)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Item is a key/value pair in JSON responses.
+type Item struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// ScanResponse is the response to a scan. If Next is set, more keys match
+// and can be fetched by passing Next as the after parameter.
+type ScanResponse struct {
+	Items []Item `json:"items"`
+	Next  string `json:"next,omitempty"`
+}
+
+// errScanFull stops a scan once a page is full.
+var errScanFull = errors.New("scan page full")
+
+func (s *Server) handleScan(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.user(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	after := query.Get("after")
+	limit := defaultScanLimit
+	if l := query.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 || n > maxScanLimit {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxScanLimit))
+			return
+		}
+		limit = n
+	}
+
+	resp := ScanResponse{Items: []Item{}}
+	err := s.store.Scan([]byte(prefix), func(key, value []byte) error {
+		if (after != "" && string(key) <= after) || !allowed(user, key, PermRead) {
+			return nil
+		}
+		if len(resp.Items) == limit {
+			resp.Next = resp.Items[limit-1].Key
+			return errScanFull
+		}
+		resp.Items = append(resp.Items, Item{Key: string(key), Value: value})
+		return nil
+	})
+	if err != nil && err != errScanFull {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// Op is an operation in a batch request: "get", "set" or "delete".
+type Op struct {
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Value []byte `json:"value,omitempty"`
+}
+
+// BatchRequest is the body of a batch request.
+type BatchRequest struct {
+	Ops []Op `json:"ops"`
+}
+
+// Result is the outcome of one operation of a batch. Found and Value are
+// only set for gets.
+type Result struct {
+	Found bool   `json:"found,omitempty"`
+	Value []byte `json:"value,omitempty"`
+}
+
+// BatchResponse is the response to a batch request, with one result per
+// operation.
+type BatchResponse struct {
+	Results []Result `json:"results"`
+}
+
+// handleBatch applies the operations of a batch in order. Operations are
+// not atomic: if one fails, the ones before it stay applied and the error
+// names the failed operation.
+func (s *Server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.user(w, r)
+	if !ok {
+		return
+	}
+	var req BatchRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBytes)).Decode(&req)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	for i, op := range req.Ops {
+		if op.Key == "" {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("op %d: key must not be empty", i))
+			return
+		}
+		perm := PermWrite
+		switch op.Op {
+		case "get":
+			perm = PermRead
+		case "set", "delete":
+		default:
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("op %d: unknown op %q", i, op.Op))
+			return
+		}
+		if !allowed(user, []byte(op.Key), perm) {
+			writeError(w, http.StatusForbidden, fmt.Sprintf("op %d: permission denied", i))
+			return
+		}
+	}
+
+	resp := BatchResponse{Results: make([]Result, len(req.Ops))}
+	for i, op := range req.Ops {
+		key := []byte(op.Key)
+		var err error
+		switch op.Op {
+		case "get":
+			var value []byte
+			value, err = s.store.Get(key)
+			if err == nil {
+				resp.Results[i] = Result{Found: true, Value: value}
+			} else if isNotFound(err) {
+				err = nil
+			}
+		case "set":
+			err = s.store.Set(key, op.Value)
+		case "delete":
+			err = s.store.Delete(key)
+		}
+		if err != nil {
+			writeStoreError(w, fmt.Errorf("op %d: %w", i, err))
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// user authenticates the client of a request, writing an error if the
+// server has an ACL and the client isn't one of its users. The user is nil
+// for a server without an ACL.
+func (s *Server) user(w http.ResponseWriter, r *http.Request) (*User, bool) {
+	if s.ACL == nil {
+		return nil, true
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		_, token, _ = r.BasicAuth()
+	}
+	user, ok := s.ACL.Authenticate(token)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="stonekv"`)
+		writeError(w, http.StatusUnauthorized, "authentication required")
+		return nil, false
+	}
+	return user, true
+}
+
+// authorize returns the key addressed by the request path, writing an
+// error if the client may not use it with perm.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, perm Permission) ([]byte, bool) {
+	user, ok := s.user(w, r)
+	if !ok {
+		return nil, false
+	}
+	key, ok := pathKey(w, r)
+	if !ok {
+		return nil, false
+	}
+	if !allowed(user, key, perm) {
+		writeError(w, http.StatusForbidden, "permission denied")
+		return nil, false
+	}
+	return key, true
+}
+
+// pathKey returns the key addressed by the request path, writing an error
+// if it is empty.
+func pathKey(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	key := r.PathValue("key")
+	if key == "" {
+		writeError(w, http.StatusBadRequest, "key must not be empty")
+		return nil, false
+	}
+	return []byte(key), true
+}
+
+// isNotFound reports whether err is the store's error for a missing key.
+func isNotFound(err error) bool {
+	return errors.Is(err, stone.ErrKeyNotFound)
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	if isNotFound(err) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if errors.Is(err, stone.ErrQuotaExceeded) {
+		writeError(w, http.StatusInsufficientStorage, err.Error())
+		return
+	}
+	if errors.Is(err, stone.ErrVersionMismatch) {
+		writeError(w, http.StatusPreconditionFailed, err.Error())
+		return
+	}
+	if errors.Is(err, stone.ErrInvalidKey) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if errors.Is(err, stone.ErrValueTooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	if errors.Is(err, stone.ErrReadOnly) {
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if errors.Is(err, stone.ErrClosed) {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err.Error())
+}
+
+func writeBodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit))
+		return
+	}
+	writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,234 @@
+package stoneserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cryptrunner49/stonekv/stone"
+)
+
+func newStore(t *testing.T) *stone.Store {
+	t.Helper()
+	store, err := stone.NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func startServer(t *testing.T) (*stone.Store, *httptest.Server) {
+	t.Helper()
+	store := newStore(t)
+	ts := httptest.NewServer(New(store))
+	t.Cleanup(ts.Close)
+	return store, ts
+}
+
+// do sends a request and returns the status and body of the response.
+func do(t *testing.T, method, url, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to build request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+func TestKeys(t *testing.T) {
+	store, ts := startServer(t)
+
+	status, _ := do(t, http.MethodPut, ts.URL+"/v1/keys/users/1", "alice")
+	if status != http.StatusNoContent {
+		t.Fatalf("expected 204 for PUT, got %d", status)
+	}
+	value, err := store.Get([]byte("users/1"))
+	if err != nil || string(value) != "alice" {
+		t.Errorf("expected 'alice' in store, got %q (%v)", value, err)
+	}
+
+	status, body := do(t, http.MethodGet, ts.URL+"/v1/keys/users/1", "")
+	if status != http.StatusOK || body != "alice" {
+		t.Errorf("expected 200 'alice', got %d %q", status, body)
+	}
+
+	status, _ = do(t, http.MethodDelete, ts.URL+"/v1/keys/users/1", "")
+	if status != http.StatusNoContent {
+		t.Errorf("expected 204 for DELETE, got %d", status)
+	}
+	status, body = do(t, http.MethodGet, ts.URL+"/v1/keys/users/1", "")
+	if status != http.StatusNotFound || !strings.Contains(body, `"error"`) {
+		t.Errorf("expected 404 with error, got %d %q", status, body)
+	}
+
+	status, _ = do(t, http.MethodPut, ts.URL+"/v1/keys/", "value")
+	if status != http.StatusBadRequest {
+		t.Errorf("expected 400 for empty key, got %d", status)
+	}
+}
+
+func TestETags(t *testing.T) {
+	_, ts := startServer(t)
+	url := ts.URL + "/v1/keys/doc"
+	put := func(value string, header ...string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPut, url, strings.NewReader(value))
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("PUT failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := put("v1", "If-None-Match", "*")
+	created := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusNoContent || created == "" {
+		t.Fatalf("expected 204 with an ETag creating the key, got %d %q", resp.StatusCode, created)
+	}
+	if resp := put("v1", "If-None-Match", "*"); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("expected 412 creating an existing key, got %d", resp.StatusCode)
+	}
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("ETag"); got != created {
+		t.Errorf("expected the ETag %s from GET, got %s", created, got)
+	}
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("If-None-Match", created)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("expected 304 for a matching ETag, got %d", resp.StatusCode)
+	}
+
+	resp = put("v2", "If-Match", created)
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("ETag") == created {
+		t.Errorf("expected 204 with a new ETag, got %d %q", resp.StatusCode, resp.Header.Get("ETag"))
+	}
+	if resp := put("stale", "If-Match", created); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("expected 412 for a stale ETag, got %d", resp.StatusCode)
+	}
+	if resp := put("v3", "If-Match", "W/\"1\""); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for a weak ETag, got %d", resp.StatusCode)
+	}
+	if status, body := do(t, http.MethodGet, url, ""); body != "v2" {
+		t.Errorf("expected v2, got %d %q", status, body)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	store, err := stone.NewStoreWithOptions(filepath.Join(t.TempDir(), "test.db"), stone.Options{MaxSize: 64})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	ts := httptest.NewServer(New(store))
+	t.Cleanup(ts.Close)
+
+	status, body := do(t, http.MethodPut, ts.URL+"/v1/keys/big", strings.Repeat("v", 100))
+	if status != http.StatusInsufficientStorage || !strings.Contains(body, "quota") {
+		t.Errorf("expected 507 with the quota error, got %d %q", status, body)
+	}
+}
+
+func TestScan(t *testing.T) {
+	store, ts := startServer(t)
+	for _, key := range []string{"a/1", "a/2", "a/3", "b/1"} {
+		store.Set([]byte(key), []byte("v"+key))
+	}
+
+	var keys []string
+	after := ""
+	for page := 0; page < 5; page++ {
+		status, body := do(t, http.MethodGet, ts.URL+"/v1/keys?prefix=a/&limit=2&after="+after, "")
+		if status != http.StatusOK {
+			t.Fatalf("expected 200, got %d %s", status, body)
+		}
+		var resp ScanResponse
+		err := json.Unmarshal([]byte(body), &resp)
+		if err != nil {
+			t.Fatalf("invalid response %q: %v", body, err)
+		}
+		for _, item := range resp.Items {
+			if string(item.Value) != "v"+item.Key {
+				t.Errorf("unexpected value %q for %s", item.Value, item.Key)
+			}
+			keys = append(keys, item.Key)
+		}
+		if resp.Next == "" {
+			break
+		}
+		after = resp.Next
+	}
+	if strings.Join(keys, ",") != "a/1,a/2,a/3" {
+		t.Errorf("unexpected keys %v", keys)
+	}
+
+	status, _ := do(t, http.MethodGet, ts.URL+"/v1/keys?limit=0", "")
+	if status != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid limit, got %d", status)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	store, ts := startServer(t)
+	store.Set([]byte("old"), []byte("value"))
+
+	req, _ := json.Marshal(BatchRequest{Ops: []Op{
+		{Op: "set", Key: "new", Value: []byte("value")},
+		{Op: "delete", Key: "old"},
+		{Op: "get", Key: "new"},
+		{Op: "get", Key: "old"},
+	}})
+	status, body := do(t, http.MethodPost, ts.URL+"/v1/batch", string(req))
+	if status != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", status, body)
+	}
+	var resp BatchResponse
+	err := json.Unmarshal([]byte(body), &resp)
+	if err != nil {
+		t.Fatalf("invalid response %q: %v", body, err)
+	}
+	if len(resp.Results) != 4 || !resp.Results[2].Found || !bytes.Equal(resp.Results[2].Value, []byte("value")) ||
+		resp.Results[3].Found {
+		t.Errorf("unexpected results %+v", resp.Results)
+	}
+
+	// Invalid batches are rejected before anything is applied
+	status, _ = do(t, http.MethodPost, ts.URL+"/v1/batch", `{"ops":[{"op":"set","key":"x"},{"op":"frob","key":"y"}]}`)
+	if status != http.StatusBadRequest {
+		t.Errorf("expected 400 for unknown op, got %d", status)
+	}
+	if _, err = store.Get([]byte("x")); err == nil {
+		t.Error("expected invalid batch not to be applied")
+	}
+
+	// Store errors map to statuses like single operations
+	status, body = do(t, http.MethodPost, ts.URL+"/v1/batch", `{"ops":[{"op":"set","key":"\u0000x"}]}`)
+	if status != http.StatusBadRequest || !strings.Contains(body, "op 0") {
+		t.Errorf("expected 400 naming the op for a reserved key, got %d %s", status, body)
+	}
+}
@@ -0,0 +1,126 @@
+package stoneserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrServerClosed is returned by Serve after Shutdown.
+var ErrServerClosed = errors.New("stoneserver: server closed")
+
+// shutdownPollInterval is how often Shutdown checks for connections that
+// became idle.
+const shutdownPollInterval = 10 * time.Millisecond
+
+// tracker tracks the listeners and connections of a server, so Shutdown
+// can stop accepting connections and wait for commands in flight.
+type tracker struct {
+	mu        sync.Mutex
+	closing   bool
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]bool // Whether the connection is idle
+}
+
+// addListener registers a listener, or returns false if the server is
+// shutting down.
+func (t *tracker) addListener(ln net.Listener) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closing {
+		return false
+	}
+	if t.listeners == nil {
+		t.listeners = make(map[net.Listener]struct{})
+	}
+	t.listeners[ln] = struct{}{}
+	return true
+}
+
+func (t *tracker) removeListener(ln net.Listener) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.listeners, ln)
+}
+
+// shuttingDown reports whether Shutdown has been called.
+func (t *tracker) shuttingDown() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.closing
+}
+
+// addConn registers an idle connection, or returns false if the server is
+// shutting down.
+func (t *tracker) addConn(conn net.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closing {
+		return false
+	}
+	if t.conns == nil {
+		t.conns = make(map[net.Conn]bool)
+	}
+	t.conns[conn] = true
+	return true
+}
+
+func (t *tracker) removeConn(conn net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.conns, conn)
+}
+
+// setIdle marks a connection as waiting for a command or running one. It
+// returns false if the connection became idle while the server is
+// shutting down, in which case the caller must close it.
+func (t *tracker) setIdle(conn net.Conn, idle bool) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.conns[conn] = idle
+	return !(idle && t.closing)
+}
+
+// shutdown closes the listeners, then closes connections as they become
+// idle until none are left. If ctx is done first, the remaining
+// connections are closed anyway and ctx.Err() is returned.
+func (t *tracker) shutdown(ctx context.Context) error {
+	t.mu.Lock()
+	t.closing = true
+	var err error
+	for ln := range t.listeners {
+		if cerr := ln.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	t.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if t.closeIdle(false) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			t.closeIdle(true)
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeIdle closes the idle connections, or all of them if force is set,
+// and reports whether none were left.
+func (t *tracker) closeIdle(force bool) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for conn, idle := range t.conns {
+		if idle || force {
+			conn.Close()
+		}
+	}
+	return len(t.conns) == 0
+}
@@ -0,0 +1,107 @@
+package stoneserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRESPShutdown(t *testing.T) {
+	srv := NewRESP(newStore(t))
+	// One command per second, so the second command is still in flight
+	// when Shutdown is called
+	srv.Limits = Limits{ClientQPS: 1}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ln) }()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	c := &respClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+	if got := c.do("PING"); got != "PONG" {
+		t.Fatalf("expected PONG, got %s", got)
+	}
+	fmt.Fprint(conn, "ECHO drained\r\n")
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = srv.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown failed: %v", err)
+	}
+	if got := c.read(); got != `"drained"` {
+		t.Errorf("expected the command in flight to be answered, got %s", got)
+	}
+	if _, err = c.r.ReadByte(); err != io.EOF {
+		t.Errorf("expected connection to be closed, got %v", err)
+	}
+	if err = <-served; err != ErrServerClosed {
+		t.Errorf("expected ErrServerClosed from Serve, got %v", err)
+	}
+	if _, err = net.Dial("tcp", ln.Addr().String()); err == nil {
+		t.Error("expected listener to be closed")
+	}
+}
+
+func TestMemcacheShutdownTimeout(t *testing.T) {
+	srv := NewMemcache(newStore(t))
+	srv.Limits = Limits{ClientQPS: 0.1}
+	conn, r := dialMemcache(t, srv)
+	fmt.Fprint(conn, "version\r\n")
+	if line, _ := r.ReadString('\n'); line != "VERSION stonekv\r\n" {
+		t.Fatalf("unexpected reply %q", line)
+	}
+
+	// The second command waits for ten seconds, longer than Shutdown may
+	fmt.Fprint(conn, "version\r\n")
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected DeadlineExceeded, got %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := r.ReadString('\n'); err != io.EOF {
+		t.Errorf("expected connection to be closed, got %v", err)
+	}
+}
+
+func TestHTTPShutdown(t *testing.T) {
+	store := newStore(t)
+	srv := New(store)
+	ts := httptest.NewUnstartedServer(nil)
+	ln := ts.Listener
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ln) }()
+	ts.URL = "http://" + ln.Addr().String()
+	ts.Listener = ln
+
+	_, r := dialWatch(t, ts, "/v1/watch")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown failed: %v", err)
+	}
+	if opcode, payload := readServerFrame(t, r); opcode != opClose || binary.BigEndian.Uint16(payload) != closeGoingAway {
+		t.Errorf("expected watch to be closed with 1001, got %d %q", opcode, payload)
+	}
+	if err := <-served; err != ErrServerClosed {
+		t.Errorf("expected ErrServerClosed from Serve, got %v", err)
+	}
+	if _, err := http.Get(ts.URL + "/healthz"); err == nil {
+		t.Error("expected listener to be closed")
+	}
+}
@@ -0,0 +1,39 @@
+package stoneserver
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// ListenUnix listens on a Unix domain socket at path whose file mode is
+// set to perm, so the file system decides which local users may connect,
+// e.g. 0o660 for the owner and group of the socket. A socket left at path
+// by a server that didn't shut down cleanly is replaced, but any other
+// file is an error. The socket file is removed when the listener is
+// closed.
+func ListenUnix(path string, perm os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != os.ModeSocket {
+			return nil, fmt.Errorf("failed to listen: %s exists and is not a socket", path)
+		}
+		// Only a stale socket refuses connections
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to listen: %s is in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+	if err := os.Chmod(path, perm); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	return ln, nil
+}
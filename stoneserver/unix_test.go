@@ -0,0 +1,59 @@
+package stoneserver
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stonekv.sock")
+	ln, err := ListenUnix(path, 0o600)
+	if err != nil {
+		t.Fatalf("ListenUnix failed: %v", err)
+	}
+	defer ln.Close()
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected socket with mode 0600, got %v (%v)", info.Mode(), err)
+	}
+	go NewRESP(newStore(t)).Serve(ln)
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	c := &respClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+	if got := c.do("PING"); got != "PONG" {
+		t.Errorf("expected PONG, got %s", got)
+	}
+
+	if _, err := ListenUnix(path, 0o600); err == nil {
+		t.Error("expected error for a socket in use")
+	}
+	ln.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected socket to be removed on close, got %v", err)
+	}
+
+	// A stale socket is replaced, other files are not
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatalf("failed to create socket: %v", err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+	ln, err = ListenUnix(path, 0o600)
+	if err != nil {
+		t.Fatalf("expected stale socket to be replaced, got %v", err)
+	}
+	ln.Close()
+
+	file := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(file, []byte("data"), 0o600)
+	if _, err := ListenUnix(file, 0o600); err == nil {
+		t.Error("expected error for a regular file")
+	}
+}
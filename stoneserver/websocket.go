@@ -0,0 +1,315 @@
+package stoneserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cryptrunner49/stonekv/stone"
+)
+
+// Change is a change to a key, sent as a JSON text message by the watch
+// endpoint.
+type Change struct {
+	Seq   uint64 `json:"seq"`
+	Type  string `json:"type"` // "set" or "delete"
+	Key   string `json:"key"`
+	Value []byte `json:"value,omitempty"`
+}
+
+const (
+	// websocketGUID is appended to the client's key to accept a handshake,
+	// as RFC 6455 specifies.
+	websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	maxClientMessage  = 64 << 10         // Largest message read from a watcher
+	watchWriteTimeout = 10 * time.Second // Time a watcher has to take a message
+)
+
+// WebSocket opcodes
+const (
+	opText  = 0x1
+	opClose = 0x8
+	opPing  = 0x9
+	opPong  = 0xA
+)
+
+// WebSocket close codes
+const (
+	closeNormal    = 1000
+	closeGoingAway = 1001
+	closeProtocol  = 1002
+	closeTooBig    = 1009
+	closeInternal  = 1011
+)
+
+// handleWatch streams the changes to keys starting with the prefix
+// parameter over a WebSocket, after the sequence number in the since
+// parameter. Browsers can't set headers on a WebSocket, so the token may
+// also be passed as the access_token parameter.
+func (s *Server) handleWatch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if token := query.Get("access_token"); token != "" && r.Header.Get("Authorization") == "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	user, ok := s.user(w, r)
+	if !ok {
+		return
+	}
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		writeError(w, http.StatusBadRequest, "expected a WebSocket upgrade")
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		writeError(w, http.StatusUpgradeRequired, "unsupported WebSocket version")
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		writeError(w, http.StatusBadRequest, "invalid Sec-WebSocket-Key")
+		return
+	}
+	prefix := []byte(query.Get("prefix"))
+	var since uint64
+	if v := query.Get("since"); v != "" {
+		var err error
+		since, err = strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid since")
+			return
+		}
+	}
+
+	// Watches are registered under shutdownMu, so Shutdown can't miss one
+	s.shutdownMu.Lock()
+	select {
+	case <-s.closing:
+		s.shutdownMu.Unlock()
+		writeError(w, http.StatusServiceUnavailable, "server is shutting down")
+		return
+	default:
+	}
+	s.watchers.Add(1)
+	s.shutdownMu.Unlock()
+	defer s.watchers.Done()
+
+	// The request context isn't canceled when a hijacked client goes away,
+	// so the read loop stops the feed when the client closes the socket
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	changes, err := s.store.ChangesContext(ctx, since)
+	if err != nil {
+		writeError(w, http.StatusGone, err.Error())
+		return
+	}
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to upgrade: %v", err))
+		return
+	}
+	defer conn.Close()
+
+	accept := sha1.Sum([]byte(key + websocketGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(accept[:]))
+	ws := &websocket{conn: conn, w: rw.Writer}
+	if err = rw.Flush(); err != nil {
+		return
+	}
+	go func() {
+		ws.readLoop(rw.Reader)
+		cancel()
+	}()
+
+	for {
+		var rec stone.Record
+		select {
+		case rec, ok = <-changes:
+		case <-s.closing:
+			ws.close(closeGoingAway, "server is shutting down")
+			return
+		}
+		if !ok {
+			break
+		}
+		if !bytes.HasPrefix(rec.Key, prefix) || !allowed(user, rec.Key, PermRead) {
+			continue
+		}
+		msg, err := json.Marshal(Change{Seq: rec.Seq, Type: rec.Type.String(), Key: string(rec.Key), Value: rec.Value})
+		if err != nil {
+			ws.close(closeInternal, err.Error())
+			return
+		}
+		if err = ws.write(opText, msg); err != nil {
+			return
+		}
+	}
+	if ctx.Err() == nil {
+		ws.close(closeGoingAway, "change feed ended")
+	}
+}
+
+// websocket is the server side of a WebSocket connection. Writes are
+// serialized, as control frames are answered while messages are sent.
+type websocket struct {
+	conn   net.Conn
+	mu     sync.Mutex
+	w      *bufio.Writer
+	closed bool // A close frame has been sent
+}
+
+// write sends payload as a single unmasked frame.
+func (ws *websocket) write(opcode byte, payload []byte) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.closed {
+		return net.ErrClosed
+	}
+	if opcode == opClose {
+		ws.closed = true
+	}
+
+	header := []byte{0x80 | opcode, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	n := 2
+	switch {
+	case len(payload) < 126:
+		header[1] = byte(len(payload))
+	case len(payload) <= 0xFFFF:
+		header[1] = 126
+		binary.BigEndian.PutUint16(header[2:], uint16(len(payload)))
+		n = 4
+	default:
+		header[1] = 127
+		binary.BigEndian.PutUint64(header[2:], uint64(len(payload)))
+		n = 10
+	}
+	ws.conn.SetWriteDeadline(time.Now().Add(watchWriteTimeout))
+	ws.w.Write(header[:n])
+	ws.w.Write(payload)
+	return ws.w.Flush()
+}
+
+// close sends a close frame with code and reason.
+func (ws *websocket) close(code uint16, reason string) {
+	payload := binary.BigEndian.AppendUint16(nil, code)
+	ws.write(opClose, append(payload, reason...))
+}
+
+// readLoop reads frames from the client until it closes the connection or
+// breaks the protocol, answering pings and discarding messages.
+func (ws *websocket) readLoop(r *bufio.Reader) {
+	for {
+		opcode, payload, err := readFrame(r)
+		if err != nil {
+			var protoErr *protocolError
+			if errors.As(err, &protoErr) {
+				ws.close(protoErr.code, protoErr.msg)
+			}
+			return
+		}
+		switch opcode {
+		case opPing:
+			ws.write(opPong, payload)
+		case opClose:
+			code := uint16(closeNormal)
+			if len(payload) >= 2 {
+				code = binary.BigEndian.Uint16(payload)
+			}
+			ws.close(code, "")
+			return
+		}
+	}
+}
+
+// protocolError is a client frame the connection must be closed for.
+type protocolError struct {
+	code uint16
+	msg  string
+}
+
+func (e *protocolError) Error() string {
+	return e.msg
+}
+
+// readFrame reads a frame sent by a client, which must be masked, and
+// returns its opcode and unmasked payload.
+func readFrame(r io.Reader) (byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := header[0] & 0x0F
+	if header[0]&0x70 != 0 {
+		return 0, nil, &protocolError{closeProtocol, "unexpected reserved bits"}
+	}
+	if header[1]&0x80 == 0 {
+		return 0, nil, &protocolError{closeProtocol, "client frames must be masked"}
+	}
+	isControl := opcode&0x8 != 0
+	if isControl && header[0]&0x80 == 0 {
+		return 0, nil, &protocolError{closeProtocol, "fragmented control frame"}
+	}
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if isControl && length > 125 {
+		return 0, nil, &protocolError{closeProtocol, "control frame too large"}
+	}
+	if length > maxClientMessage {
+		return 0, nil, &protocolError{closeTooBig, "message too large"}
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(r, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
+
+// headerHasToken reports whether the comma-separated header name contains
+// token, ignoring case.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
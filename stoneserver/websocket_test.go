@@ -0,0 +1,148 @@
+package stoneserver
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dialWatch opens a WebSocket to the watch endpoint of ts at path and
+// returns the connection after the handshake.
+func dialWatch(t *testing.T, ts *httptest.Server, path string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: stonekv\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n", path)
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatalf("failed to read handshake: %v", err)
+	}
+	// The accept value for this key is the example of RFC 6455
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("unexpected handshake response %d %v %s", resp.StatusCode, resp.Header, body)
+	}
+	return conn, r
+}
+
+// readServerFrame reads an unmasked frame sent by the server.
+func readServerFrame(t *testing.T, r *bufio.Reader) (byte, []byte) {
+	t.Helper()
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		t.Fatalf("failed to read frame: %v", err)
+	}
+	length := int(header[1] & 0x7F)
+	if length == 126 {
+		var ext [2]byte
+		io.ReadFull(r, ext[:])
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatalf("failed to read frame: %v", err)
+	}
+	return header[0] & 0x0F, payload
+}
+
+// writeClientFrame sends a masked frame, as clients must.
+func writeClientFrame(conn net.Conn, opcode byte, payload []byte) {
+	mask := [4]byte{1, 2, 3, 4}
+	frame := []byte{0x80 | opcode, 0x80 | byte(len(payload))}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	conn.Write(frame)
+}
+
+func TestWatch(t *testing.T) {
+	store := newStore(t)
+	ts := httptest.NewServer(New(store))
+	defer ts.Close()
+	store.Set([]byte("a/1"), []byte("one"))
+	store.Set([]byte("b/1"), []byte("skipped"))
+
+	conn, r := dialWatch(t, ts, "/v1/watch?prefix=a/")
+	store.Delete([]byte("a/1"))
+	want := []Change{
+		{Seq: 1, Type: "set", Key: "a/1", Value: []byte("one")},
+		{Seq: 3, Type: "delete", Key: "a/1"},
+	}
+	for i, w := range want {
+		opcode, payload := readServerFrame(t, r)
+		var change Change
+		if opcode != opText || json.Unmarshal(payload, &change) != nil {
+			t.Fatalf("change %d: unexpected frame %d %s", i, opcode, payload)
+		}
+		if change.Seq != w.Seq || change.Type != w.Type || change.Key != w.Key || string(change.Value) != string(w.Value) {
+			t.Errorf("change %d: expected %+v, got %+v", i, w, change)
+		}
+	}
+
+	writeClientFrame(conn, opPing, []byte("hi"))
+	if opcode, payload := readServerFrame(t, r); opcode != opPong || string(payload) != "hi" {
+		t.Errorf("expected pong with the ping payload, got %d %q", opcode, payload)
+	}
+	writeClientFrame(conn, opClose, binary.BigEndian.AppendUint16(nil, closeNormal))
+	if opcode, payload := readServerFrame(t, r); opcode != opClose || binary.BigEndian.Uint16(payload) != closeNormal {
+		t.Errorf("expected close to be echoed, got %d %q", opcode, payload)
+	}
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Errorf("expected connection to be closed, got %v", err)
+	}
+}
+
+func TestWatchErrors(t *testing.T) {
+	store := newStore(t)
+	srv := New(store)
+	srv.ACL = loadTestACL(t)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/v1/watch?access_token=b-token")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 without an upgrade, got %d", resp.StatusCode)
+	}
+	resp, err = http.Get(ts.URL + "/v1/watch")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a token, got %d", resp.StatusCode)
+	}
+
+	// Changes to keys the user may not read are skipped
+	conn, r := dialWatch(t, ts, "/v1/watch?access_token=b-token")
+	store.Set([]byte("users/1"), []byte("alice"))
+	store.Set([]byte("billing/1"), []byte("invoice"))
+	if _, payload := readServerFrame(t, r); !strings.Contains(string(payload), `"key":"billing/1"`) {
+		t.Errorf("expected only the change to billing/1, got %s", payload)
+	}
+
+	// Unmasked client frames break the protocol
+	conn.Write([]byte{0x80 | opText, 1, 'x'})
+	if opcode, payload := readServerFrame(t, r); opcode != opClose || binary.BigEndian.Uint16(payload) != closeProtocol {
+		t.Errorf("expected protocol error close, got %d %q", opcode, payload)
+	}
+}
@@ -0,0 +1,190 @@
+// Package stonesessions stores the sessions of alexedwards/scs, the HTTP
+// session manager, in a StoneKV store:
+//
+//	sessions := scs.New()
+//	sessions.Store = stonesessions.New(store)
+//
+// Store implements scs.Store and scs.IterableStore. Sessions are stored
+// under "scs/" followed by their token, with the time they expire, and
+// are treated as missing once it has passed. A goroutine deletes expired
+// sessions every cleanup interval, 5 minutes by default.
+//
+// It needs nothing from scs but its interfaces, which Go satisfies
+// implicitly, so it doesn't depend on it.
+package stonesessions
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cryptrunner49/stonekv/stone"
+)
+
+// prefix is the prefix of the keys of sessions.
+const prefix = "scs/"
+
+// Store is an scs.Store backed by a stone.Store.
+type Store struct {
+	store       *stone.Store
+	stopCleanup chan struct{} // Closed by StopCleanup, nil without cleanup
+	cleanupDone chan struct{} // Closed when cleanupLoop returns
+	stopOnce    sync.Once
+}
+
+// New returns a Store storing sessions in store, deleting expired ones
+// every 5 minutes.
+func New(store *stone.Store) *Store {
+	return NewWithCleanupInterval(store, 5*time.Minute)
+}
+
+// NewWithCleanupInterval returns a Store storing sessions in store,
+// deleting expired ones every interval, or never if it is 0.
+func NewWithCleanupInterval(store *stone.Store, interval time.Duration) *Store {
+	s := &Store{store: store}
+	if interval > 0 {
+		s.stopCleanup, s.cleanupDone = make(chan struct{}), make(chan struct{})
+		go s.cleanupLoop(interval)
+	}
+	return s
+}
+
+// encode encodes a session as [expiry:8] data, the expiry in Unix
+// nanoseconds.
+func encode(b []byte, expiry time.Time) []byte {
+	return append(binary.BigEndian.AppendUint64(nil, uint64(expiry.UnixNano())), b...)
+}
+
+// decode reverses encode, reporting whether the session is still valid
+// at now.
+func decode(value []byte, now time.Time) ([]byte, bool, error) {
+	if len(value) < 8 {
+		return nil, false, fmt.Errorf("invalid session")
+	}
+	expiry := int64(binary.BigEndian.Uint64(value))
+	return value[8:], now.UnixNano() < expiry, nil
+}
+
+// Find returns the data of the session with the given token, reporting
+// whether it exists and hasn't expired.
+func (s *Store) Find(token string) ([]byte, bool, error) {
+	value, err := s.store.Get([]byte(prefix + token))
+	if err != nil {
+		if errors.Is(err, stone.ErrKeyNotFound) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	b, ok, err := decode(value, time.Now())
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+// Commit stores the data of the session with the given token, replacing
+// it if it exists, until expiry.
+func (s *Store) Commit(token string, b []byte, expiry time.Time) error {
+	return s.store.Set([]byte(prefix+token), encode(b, expiry))
+}
+
+// Delete deletes the session with the given token, if it exists.
+func (s *Store) Delete(token string) error {
+	return s.store.Delete([]byte(prefix + token))
+}
+
+// All returns the data of every session that hasn't expired, by token.
+func (s *Store) All() (map[string][]byte, error) {
+	sessions := make(map[string][]byte)
+	now := time.Now()
+	err := s.store.Scan([]byte(prefix), func(key, value []byte) error {
+		b, ok, err := decode(value, now)
+		if err == nil && ok {
+			sessions[string(key[len(prefix):])] = b
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// deleteExpired deletes the sessions that have expired.
+func (s *Store) deleteExpired() error {
+	var expired [][]byte
+	now := time.Now()
+	err := s.store.Scan([]byte(prefix), func(key, value []byte) error {
+		if _, ok, err := decode(value, now); err != nil || !ok {
+			expired = append(expired, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range expired {
+		err = s.deleteIfExpired(key, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteIfExpired deletes the session stored under key unless it was
+// committed again since it expired, in a transaction, so a Commit landing
+// meanwhile makes it conflict rather than be deleted.
+func (s *Store) deleteIfExpired(key []byte, now time.Time) error {
+	tx := s.store.Begin()
+	defer tx.Rollback()
+	value, err := tx.Get(key)
+	if errors.Is(err, stone.ErrKeyNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, ok, _ := decode(value, now); ok {
+		return nil
+	}
+	err = tx.Delete(key)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if errors.Is(err, stone.ErrConflict) {
+		return nil // Committed again meanwhile
+	}
+	return err
+}
+
+// cleanupLoop calls deleteExpired every interval until StopCleanup.
+func (s *Store) cleanupLoop(interval time.Duration) {
+	defer close(s.cleanupDone)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// Failures, e.g. once the store is closed, have no one to be
+			// reported to; the next tick tries again
+			s.deleteExpired()
+		case <-s.stopCleanup:
+			return
+		}
+	}
+}
+
+// StopCleanup stops the goroutine deleting expired sessions and waits for
+// it to return. Call it before closing the store, unless the program is
+// exiting anyway.
+func (s *Store) StopCleanup() {
+	if s.stopCleanup == nil {
+		return
+	}
+	s.stopOnce.Do(func() { close(s.stopCleanup) })
+	<-s.cleanupDone
+}
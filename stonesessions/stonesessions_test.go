@@ -0,0 +1,94 @@
+package stonesessions
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cryptrunner49/stonekv/stone"
+)
+
+func TestStore(t *testing.T) {
+	store, err := stone.NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	sessions := NewWithCleanupInterval(store, 10*time.Millisecond)
+	defer sessions.StopCleanup()
+
+	err = sessions.Commit("live", []byte("data"), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("commit failed: %v", err)
+	}
+	sessions.Commit("stale", []byte("old"), time.Now().Add(-time.Second))
+	b, found, err := sessions.Find("live")
+	if err != nil || !found || string(b) != "data" {
+		t.Errorf("expected the live session, got %q, %v, %v", b, found, err)
+	}
+	if _, found, err := sessions.Find("stale"); err != nil || found {
+		t.Errorf("expected the expired session not to be found, got %v, %v", found, err)
+	}
+	if _, found, err := sessions.Find("missing"); err != nil || found {
+		t.Errorf("expected a missing session not to be found, got %v, %v", found, err)
+	}
+	all, err := sessions.All()
+	if err != nil || len(all) != 1 || string(all["live"]) != "data" {
+		t.Errorf("expected only the live session, got %q, %v", all, err)
+	}
+
+	// Expired sessions are deleted in the background
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := store.Get([]byte("scs/stale")); err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the expired session to be deleted")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, err := store.Get([]byte("scs/live")); err != nil {
+		t.Errorf("expected the live session to be kept, got %v", err)
+	}
+
+	err = sessions.Delete("live")
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if _, found, _ := sessions.Find("live"); found {
+		t.Errorf("expected a deleted session not to be found")
+	}
+}
+
+func TestDeleteExpiredRecommitted(t *testing.T) {
+	var sessions *Store
+	recommit := func(next stone.Handler) stone.Handler {
+		return func(op *stone.Operation) error {
+			err := next(op)
+			if op.Name == "scan" {
+				// Committed again between the scan and the delete
+				sessions.Commit("stale", []byte("new"), time.Now().Add(time.Hour))
+			}
+			return err
+		}
+	}
+	store, err := stone.NewStoreWithOptions(filepath.Join(t.TempDir(), "test.db"),
+		stone.Options{Interceptors: []stone.Interceptor{recommit}})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	sessions = NewWithCleanupInterval(store, time.Hour)
+	defer sessions.StopCleanup()
+
+	sessions.Commit("stale", []byte("old"), time.Now().Add(-time.Second))
+	err = sessions.deleteExpired()
+	if err != nil {
+		t.Fatalf("cleanup failed: %v", err)
+	}
+	b, found, err := sessions.Find("stale")
+	if err != nil || !found || string(b) != "new" {
+		t.Errorf("expected the session committed again to be kept, got %q, %v, %v", b, found, err)
+	}
+}